@@ -90,11 +90,15 @@ const config = (env: any): Configuration => {
   const baseConfig: Configuration = {
     mode: isProduction ? "production" : "development",
     target: "web",
-    entry: path.resolve(__dirname, "../../src/module.ts"),
+    // The app and the panel it bundles as a nested plugin
+    entry: {
+      module: path.resolve(__dirname, "../../src/module.ts"),
+      "panels/hover/module": path.resolve(__dirname, "../../src/panels/hover/module.ts"),
+    },
     devtool: isProduction ? "source-map" : "eval-source-map",
     output: {
       path: path.resolve(__dirname, "../../dist"),
-      filename: "module.js",
+      filename: "[name].js",
       library: {
         type: "amd",
       },
@@ -140,6 +144,8 @@ const config = (env: any): Configuration => {
         patterns: [
           { from: "src/plugin.json", to: "." },
           { from: "src/img", to: "./img", noErrorOnMissing: true },
+          { from: "src/panels/hover/plugin.json", to: "./panels/hover" },
+          { from: "src/img", to: "./panels/hover/img", noErrorOnMissing: true },
           { from: "README.md", to: "." },
           { from: "CHANGELOG.md", to: ".", noErrorOnMissing: true },
           { from: "LICENSE", to: ".", noErrorOnMissing: true },
@@ -154,7 +160,7 @@ const config = (env: any): Configuration => {
       new ReplaceInFileWebpackPlugin([
         {
           dir: "dist",
-          files: ["plugin.json"],
+          files: ["plugin.json", "panels/hover/plugin.json"],
           rules: [
             {
               search: "%VERSION%",
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/logging"
+)
+
+// fatal logs msg at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func main() {
+	direction := flag.String("direction", "up", "Migration direction: up, down or status")
+	steps := flag.Int("steps", 1, "Number of migrations to roll back with -direction=down")
+	target := flag.Int("to", -1, "Migrate to exactly this version (overrides -direction)")
+	dryRun := flag.Bool("dry-run", false, "Print the migrations that would run without executing them")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config", "error", err)
+	}
+	if err := logging.Setup(cfg.Logging, os.Stderr); err != nil {
+		slog.Warn("Invalid logging config, logging at info level as text", "error", err)
+	}
+
+	client, err := clickhouse.NewClient(&cfg.ClickHouse)
+	if err != nil {
+		fatal("Failed to connect to ClickHouse", "error", err)
+	}
+	defer client.Close()
+
+	migrator, err := client.Migrator()
+	if err != nil {
+		fatal("Failed to create migrator", "error", err)
+	}
+	migrator.DryRun = *dryRun
+
+	ctx := context.Background()
+
+	var applied []clickhouse.MigrationStep
+	switch {
+	case *target >= 0:
+		applied, err = migrator.MigrateTo(ctx, uint32(*target))
+	case *direction == "up":
+		applied, err = migrator.Up(ctx)
+	case *direction == "down":
+		applied, err = migrator.Down(ctx, *steps)
+	case *direction == "status":
+	default:
+		fatal("Unknown direction (expected up, down or status)", "direction", *direction)
+	}
+	if err != nil {
+		fatal("Migration failed", "steps_applied", len(applied), "error", err)
+	}
+
+	// The statements a dry run would execute are the command's output, ready to pipe to a client
+	if *dryRun {
+		for _, step := range applied {
+			for _, statement := range step.Statements {
+				fmt.Printf("-- %04d_%s\n%s;\n", step.Migration.Version, step.Migration.Name, statement)
+			}
+		}
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		fatal("Failed to read schema status", "error", err)
+	}
+	slog.Info("Schema status", "version", status.Version, "latest", status.Latest)
+}
@@ -1,20 +1,31 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/api"
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/logging"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/metrics"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/tracing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func main() {
-	log.Println("🚀 Starting Hover Plugin Standalone Server...")
+// shutdownTimeout bounds how long in-flight requests may take to finish on shutdown
+const shutdownTimeout = 10 * time.Second
 
+func main() {
 	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		log.Printf("⚠️  Failed to load config: %v. Using defaults.", err)
+	cfg, configErr := config.Load()
+	if configErr != nil {
 		cfg = &config.Config{
 			Server: config.ServerConfig{
 				Host: "127.0.0.1",
@@ -29,26 +40,85 @@ func main() {
 		}
 	}
 
-	log.Printf("📝 Config: Server=%s, ClickHouse=%s", cfg.Server.GetAddress(), cfg.ClickHouse.URL)
+	if err := logging.Setup(cfg.Logging, os.Stderr); err != nil {
+		slog.Warn("Invalid logging config, logging at info level as text", "error", err)
+	}
+
+	slog.Info("Starting Hover Plugin Standalone Server")
+	if configErr != nil {
+		slog.Warn("Failed to load config, using defaults", "error", configErr)
+	}
+	slog.Info("Loaded config", "server", cfg.Server.GetAddress(), "clickhouse", cfg.ClickHouse.URL)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Warn("Failed to set up tracing, spans will not be exported", "error", err)
+		shutdownTracing = func(context.Context) error { return nil }
+	} else if cfg.Tracing.Exporter == tracing.ExporterOTLP {
+		slog.Info("Exporting traces", "endpoint", cfg.Tracing.Endpoint)
+	}
 
 	// Create handler (it will create analyzer and connect to ClickHouse internally)
 	handler := api.NewHandler(cfg)
 
 	// Setup routes
-	http.HandleFunc("/analyze", handler.QueryLogs)
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	m := metrics.Default()
+	http.HandleFunc("/analyze", m.Instrument("/analyze", logging.Middleware(handler.QueryLogs)))
+	http.HandleFunc("/v2/query_logs", m.Instrument("/v2/query_logs", logging.Middleware(handler.QueryLogsV2)))
+	// Mapping changes and ingestion need an API token, unless no tokens are configured and
+	// only local clients can connect
+	authorize := func(next http.HandlerFunc) http.HandlerFunc {
+		return api.RequireToken(cfg.Server.Tokens, next)
+	}
+	switch {
+	case len(cfg.Server.Tokens) > 0:
+	case cfg.Server.Loopback():
+		slog.Warn("No server.tokens configured; mapping and ingestion endpoints accept any local client")
+		authorize = func(next http.HandlerFunc) http.HandlerFunc { return next }
+	default:
+		slog.Error("No server.tokens configured; mapping and ingestion endpoints refuse every request", "host", cfg.Server.Host)
+	}
+	for _, route := range append(handler.MappingRoutes(), handler.IngestRoutes()...) {
+		http.HandleFunc(route.Pattern, m.Instrument(route.Pattern, logging.Middleware(authorize(route.Handler))))
+	}
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/health", handler.Health)
 
 	// Start server
 	addr := cfg.Server.GetAddress()
-	log.Printf("🎯 Server listening on http://%s", addr)
-	log.Println("📊 Endpoints:")
-	log.Println("   POST /analyze - Analyze logs with KL divergence")
-	log.Println("   GET  /health  - Health check")
+	slog.Info("Server listening", "address", "http://"+addr, "endpoints", []string{
+		"POST /analyze - Analyze logs with KL divergence",
+		"POST /v2/query_logs - Analyze logs with template IDs, scores and counts",
+		"/mappings/... - Register metrics and attach log streams to them",
+		"POST /ingest - Ingest raw log lines, mining them into templates",
+		"POST /v1/logs - Ingest OTLP/HTTP log exports (protobuf or JSON)",
+		"POST /loki/api/v1/push - Ingest Loki pushes (snappy protobuf or JSON)",
+		"GET /health - Health report, 503 while ClickHouse is unusable",
+		"GET /metrics - Prometheus metrics",
+	})
+
+	server := &http.Server{Addr: addr}
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- server.ListenAndServe() }()
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatalf("❌ Server failed: %v", err)
+	// Stop on SIGINT or SIGTERM, flushing ingested lines before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		slog.Error("Server failed", "error", err)
+		exitCode = 1
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("Failed to finish in-flight requests", "error", err)
+		}
+		cancel()
 	}
+
+	handler.Close()
+	shutdownTracing(context.Background())
+	os.Exit(exitCode)
 }
@@ -0,0 +1,141 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// BaselineStrategy selects which historical windows the current window is compared against
+type BaselineStrategy string
+
+const (
+	// BaselinePreviousWindow compares against the equal-length window immediately before startTime
+	BaselinePreviousWindow BaselineStrategy = "previous_window"
+	// BaselineSameWindowYesterday compares against the same clock window 24 hours earlier
+	BaselineSameWindowYesterday BaselineStrategy = "same_window_yesterday"
+	// BaselineSameWindowLastWeek compares against the same clock window 7 days earlier
+	BaselineSameWindowLastWeek BaselineStrategy = "same_window_last_week"
+	// BaselineMedian compares against the per-template median of the last N equal-length windows
+	BaselineMedian BaselineStrategy = "median"
+)
+
+const (
+	DefaultMedianWindows = 5
+	MaxMedianWindows     = 24
+)
+
+// TimeWindow is a half-open [Start, End) interval that was queried
+type TimeWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// BaselineOptions configures the baseline used by AnalyzeLogs
+type BaselineOptions struct {
+	Strategy BaselineStrategy
+	// Windows is the number of windows for BaselineMedian, ignored otherwise
+	Windows int
+}
+
+// ParseBaselineStrategy converts a request value to a BaselineStrategy.
+// An empty string selects BaselinePreviousWindow.
+func ParseBaselineStrategy(s string) (BaselineStrategy, error) {
+	switch BaselineStrategy(s) {
+	case "":
+		return BaselinePreviousWindow, nil
+	case BaselinePreviousWindow, BaselineSameWindowYesterday, BaselineSameWindowLastWeek, BaselineMedian:
+		return BaselineStrategy(s), nil
+	}
+	return "", fmt.Errorf("unknown baseline strategy %q (expected %s, %s, %s or %s)",
+		s, BaselinePreviousWindow, BaselineSameWindowYesterday, BaselineSameWindowLastWeek, BaselineMedian)
+}
+
+// Validate checks the options and fills in defaults
+func (o *BaselineOptions) Validate() error {
+	strategy, err := ParseBaselineStrategy(string(o.Strategy))
+	if err != nil {
+		return err
+	}
+	o.Strategy = strategy
+
+	if o.Strategy != BaselineMedian {
+		o.Windows = 0
+		return nil
+	}
+
+	if o.Windows == 0 {
+		o.Windows = DefaultMedianWindows
+	}
+	if o.Windows < 2 || o.Windows > MaxMedianWindows {
+		return fmt.Errorf("median baseline needs between 2 and %d windows, got %d", MaxMedianWindows, o.Windows)
+	}
+	return nil
+}
+
+// BaselineWindows returns the windows to query for the given current window,
+// most recent first
+func (o BaselineOptions) BaselineWindows(startTime, endTime time.Time) []TimeWindow {
+	windowDuration := endTime.Sub(startTime)
+
+	switch o.Strategy {
+	case BaselineSameWindowYesterday:
+		return []TimeWindow{{Start: startTime.Add(-24 * time.Hour), End: endTime.Add(-24 * time.Hour)}}
+	case BaselineSameWindowLastWeek:
+		return []TimeWindow{{Start: startTime.Add(-7 * 24 * time.Hour), End: endTime.Add(-7 * 24 * time.Hour)}}
+	case BaselineMedian:
+		n := o.Windows
+		if n <= 0 {
+			n = DefaultMedianWindows
+		}
+		windows := make([]TimeWindow, n)
+		end := startTime
+		for i := range windows {
+			windows[i] = TimeWindow{Start: end.Add(-windowDuration), End: end}
+			end = windows[i].Start
+		}
+		return windows
+	default:
+		return []TimeWindow{{Start: startTime.Add(-windowDuration), End: startTime}}
+	}
+}
+
+// MedianCounts combines per-window template counts into a single baseline by
+// taking the median count of each template across all windows. A template
+// missing from a window counts as zero there, so a one-off burst in a single
+// window does not leak into the baseline.
+func MedianCounts(windowCounts []map[string]uint64) map[string]uint64 {
+	if len(windowCounts) == 1 {
+		return windowCounts[0]
+	}
+
+	allTemplates := make(map[string]bool)
+	for _, counts := range windowCounts {
+		for id := range counts {
+			allTemplates[id] = true
+		}
+	}
+
+	median := make(map[string]uint64)
+	values := make([]uint64, len(windowCounts))
+	for templateID := range allTemplates {
+		for i, counts := range windowCounts {
+			values[i] = counts[templateID]
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+		mid := len(values) / 2
+		var m uint64
+		if len(values)%2 == 1 {
+			m = values[mid]
+		} else {
+			// Round half up so a template present in exactly half the windows survives
+			m = (values[mid-1] + values[mid] + 1) / 2
+		}
+		if m > 0 {
+			median[templateID] = m
+		}
+	}
+
+	return median
+}
@@ -0,0 +1,178 @@
+package analyzer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+// windowStore returns counts keyed by window start and records every queried window
+type windowStore struct {
+	clickhouse.MockStore
+	counts  map[time.Time]map[string]uint64
+	queried []TimeWindow
+}
+
+func (s *windowStore) GetTemplateCounts(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time) (map[string]uint64, error) {
+	s.queried = append(s.queried, TimeWindow{Start: startTime, End: endTime})
+	if counts, ok := s.counts[startTime]; ok {
+		return counts, nil
+	}
+	return map[string]uint64{}, nil
+}
+
+func (s *windowStore) GetRepresentativeLogs(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs []string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	for _, id := range templateIDs {
+		result[id] = []string{"example for " + id}
+	}
+	return result, nil
+}
+
+func TestBaselineWindows(t *testing.T) {
+	end := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	start := end.Add(-30 * time.Minute)
+
+	tests := []struct {
+		name      string
+		opts      BaselineOptions
+		wantFirst TimeWindow
+		wantCount int
+	}{
+		{
+			name:      "previous window",
+			opts:      BaselineOptions{Strategy: BaselinePreviousWindow},
+			wantFirst: TimeWindow{Start: start.Add(-30 * time.Minute), End: start},
+			wantCount: 1,
+		},
+		{
+			name:      "same window yesterday",
+			opts:      BaselineOptions{Strategy: BaselineSameWindowYesterday},
+			wantFirst: TimeWindow{Start: start.Add(-24 * time.Hour), End: end.Add(-24 * time.Hour)},
+			wantCount: 1,
+		},
+		{
+			name:      "same window last week",
+			opts:      BaselineOptions{Strategy: BaselineSameWindowLastWeek},
+			wantFirst: TimeWindow{Start: start.Add(-7 * 24 * time.Hour), End: end.Add(-7 * 24 * time.Hour)},
+			wantCount: 1,
+		},
+		{
+			name:      "median of four windows",
+			opts:      BaselineOptions{Strategy: BaselineMedian, Windows: 4},
+			wantFirst: TimeWindow{Start: start.Add(-30 * time.Minute), End: start},
+			wantCount: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows := tt.opts.BaselineWindows(start, end)
+			if len(windows) != tt.wantCount {
+				t.Fatalf("Expected %d windows, got %d", tt.wantCount, len(windows))
+			}
+			if !windows[0].Start.Equal(tt.wantFirst.Start) || !windows[0].End.Equal(tt.wantFirst.End) {
+				t.Errorf("Expected first window %v, got %v", tt.wantFirst, windows[0])
+			}
+			for i := 1; i < len(windows); i++ {
+				if !windows[i].End.Equal(windows[i-1].Start) {
+					t.Errorf("Window %d should end where window %d starts", i, i-1)
+				}
+				if windows[i].End.Sub(windows[i].Start) != end.Sub(start) {
+					t.Errorf("Window %d has wrong duration %v", i, windows[i].End.Sub(windows[i].Start))
+				}
+			}
+		})
+	}
+}
+
+func TestBaselineOptionsValidate(t *testing.T) {
+	opts := BaselineOptions{}
+	if err := opts.Validate(); err != nil || opts.Strategy != BaselinePreviousWindow {
+		t.Errorf("Expected empty strategy to default to previous_window, got %q (%v)", opts.Strategy, err)
+	}
+
+	opts = BaselineOptions{Strategy: BaselineMedian}
+	if err := opts.Validate(); err != nil || opts.Windows != DefaultMedianWindows {
+		t.Errorf("Expected median to default to %d windows, got %d (%v)", DefaultMedianWindows, opts.Windows, err)
+	}
+
+	for _, invalid := range []BaselineOptions{
+		{Strategy: "last_tuesday"},
+		{Strategy: BaselineMedian, Windows: 1},
+		{Strategy: BaselineMedian, Windows: MaxMedianWindows + 1},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestMedianCounts(t *testing.T) {
+	median := MedianCounts([]map[string]uint64{
+		{"steady": 10, "burst": 500},
+		{"steady": 12},
+		{"steady": 11, "flaky": 4},
+		{"steady": 9, "flaky": 6},
+	})
+
+	if median["steady"] != 11 {
+		t.Errorf("Expected steady median 11, got %d", median["steady"])
+	}
+	if _, ok := median["burst"]; ok {
+		t.Errorf("Expected one-off burst to be excluded from the median, got %d", median["burst"])
+	}
+	if median["flaky"] != 2 {
+		t.Errorf("Expected flaky median 2, got %d", median["flaky"])
+	}
+}
+
+func TestAnalyzeLogsReportsBaselineWindows(t *testing.T) {
+	end := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	start := end.Add(-10 * time.Minute)
+
+	store := &windowStore{counts: map[time.Time]map[string]uint64{
+		start:                          {"cpu_high": 50, "ok": 10},
+		start.Add(-24 * time.Hour):     {"ok": 10},
+		start.Add(-10 * time.Minute):   {"cpu_high": 40, "ok": 10},
+		start.Add(-20 * time.Minute):   {"ok": 10},
+		start.Add(-30 * time.Minute):   {"ok": 10},
+		start.Add(-7 * 24 * time.Hour): {"ok": 10},
+	}}
+	la := NewLogAnalyzerWithStore(store)
+
+	result, err := la.AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+		AnalysisOptions{Baseline: BaselineOptions{Strategy: BaselineMedian, Windows: 3}})
+	if err != nil {
+		t.Fatalf("AnalyzeLogs failed: %v", err)
+	}
+
+	if result.BaselineStrategy != BaselineMedian {
+		t.Errorf("Expected median strategy in result, got %q", result.BaselineStrategy)
+	}
+	if len(result.BaselineWindows) != 3 {
+		t.Fatalf("Expected 3 baseline windows in result, got %d", len(result.BaselineWindows))
+	}
+	// 3 baseline queries plus the current window
+	if len(store.queried) != 4 {
+		t.Errorf("Expected 4 count queries, got %d", len(store.queried))
+	}
+	for i, window := range result.BaselineWindows {
+		if !window.Start.Equal(store.queried[i].Start) {
+			t.Errorf("Reported window %d (%v) does not match queried window (%v)", i, window.Start, store.queried[i].Start)
+		}
+	}
+
+	// The incident started in the previous window, so only the median keeps cpu_high out of the baseline
+	var top LogGroup
+	for _, group := range result.LogGroups {
+		if group.KLContribution > top.KLContribution {
+			top = group
+		}
+	}
+	if top.TemplateID != "cpu_high" {
+		t.Errorf("Expected cpu_high to rank first against the median baseline, got %q", top.TemplateID)
+	}
+}
@@ -0,0 +1,140 @@
+package analyzer
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+// MaxBreakdownEntries caps how many dimension combinations are returned per template
+const MaxBreakdownEntries = 10
+
+// BreakdownEntry attributes part of a template's change to one combination of dimension values
+type BreakdownEntry struct {
+	Dimensions    map[string]string `json:"dimensions"`
+	BaselineCount uint64            `json:"baseline_count"`
+	CurrentCount  uint64            `json:"current_count"`
+	Change        int64             `json:"change"`
+	// ShareOfChange is Change divided by the template's total change, so 0.9 means
+	// this combination accounts for 90% of it
+	ShareOfChange float64 `json:"share_of_change"`
+}
+
+// fetchBreakdowns queries per-dimension counts for the given templates in every
+// baseline window and the current window and attributes each template's change
+func (la *LogAnalyzer) fetchBreakdowns(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs, dimensions []string, baselineWindows []TimeWindow, startTime, endTime time.Time) (map[string][]BreakdownEntry, error) {
+	windowCounts := make([]map[string]map[string]uint64, len(baselineWindows))
+	for i, window := range baselineWindows {
+		counts, err := la.store.GetTemplateBreakdown(ctx, org, dashboard, panelTitle, metricName, templateIDs, dimensions, window.Start, window.End)
+		if err != nil {
+			return nil, err
+		}
+		windowCounts[i] = groupDimensionCounts(counts)
+	}
+
+	current, err := la.store.GetTemplateBreakdown(ctx, org, dashboard, panelTitle, metricName, templateIDs, dimensions, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	currentCounts := groupDimensionCounts(current)
+
+	breakdowns := make(map[string][]BreakdownEntry)
+	for _, templateID := range templateIDs {
+		// Combine baseline windows the same way template counts are combined
+		perWindow := make([]map[string]uint64, len(windowCounts))
+		for i, counts := range windowCounts {
+			perWindow[i] = counts[templateID]
+		}
+		baseline := MedianCounts(perWindow)
+
+		if entries := BuildBreakdown(dimensions, baseline, currentCounts[templateID]); len(entries) > 0 {
+			breakdowns[templateID] = entries
+		}
+	}
+	return breakdowns, nil
+}
+
+// groupDimensionCounts indexes rows by template, then by joined dimension values
+func groupDimensionCounts(rows []clickhouse.DimensionCount) map[string]map[string]uint64 {
+	grouped := make(map[string]map[string]uint64)
+	for _, row := range rows {
+		if grouped[row.TemplateID] == nil {
+			grouped[row.TemplateID] = make(map[string]uint64)
+		}
+		grouped[row.TemplateID][dimensionKey(row.Values)] += row.Count
+	}
+	return grouped
+}
+
+func dimensionKey(values []string) string {
+	return strings.Join(values, "\x00")
+}
+
+// BuildBreakdown attributes a template's change to dimension combinations, largest contribution first.
+// Counts are keyed by dimension values joined in the order of dimensions.
+func BuildBreakdown(dimensions []string, baselineCounts, currentCounts map[string]uint64) []BreakdownEntry {
+	keys := unionTemplates(currentCounts, baselineCounts)
+
+	var totalChange int64
+	for key := range keys {
+		totalChange += int64(currentCounts[key]) - int64(baselineCounts[key])
+	}
+
+	type keyedEntry struct {
+		key   string
+		entry BreakdownEntry
+	}
+
+	keyed := make([]keyedEntry, 0, len(keys))
+	for key := range keys {
+		values := strings.Split(key, "\x00")
+		dims := make(map[string]string, len(dimensions))
+		for i, dimension := range dimensions {
+			if i < len(values) {
+				dims[dimension] = values[i]
+			}
+		}
+
+		change := int64(currentCounts[key]) - int64(baselineCounts[key])
+		share := 0.0
+		if totalChange != 0 {
+			share = float64(change) / float64(totalChange)
+		}
+
+		keyed = append(keyed, keyedEntry{key, BreakdownEntry{
+			Dimensions:    dims,
+			BaselineCount: baselineCounts[key],
+			CurrentCount:  currentCounts[key],
+			Change:        change,
+			ShareOfChange: share,
+		}})
+	}
+
+	sort.Slice(keyed, func(i, j int) bool {
+		ci, cj := abs64(keyed[i].entry.Change), abs64(keyed[j].entry.Change)
+		if ci != cj {
+			return ci > cj
+		}
+		return keyed[i].key < keyed[j].key
+	})
+
+	if len(keyed) > MaxBreakdownEntries {
+		keyed = keyed[:MaxBreakdownEntries]
+	}
+
+	entries := make([]BreakdownEntry, len(keyed))
+	for i, k := range keyed {
+		entries[i] = k.entry
+	}
+	return entries
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,103 @@
+package analyzer
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+// breakdownStore serves per-window dimension rows on top of windowStore's template counts
+type breakdownStore struct {
+	windowStore
+	rows map[time.Time][]clickhouse.DimensionCount
+}
+
+func (s *breakdownStore) GetTemplateBreakdown(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs, dimensions []string, startTime, endTime time.Time) ([]clickhouse.DimensionCount, error) {
+	return s.rows[startTime], nil
+}
+
+func TestBuildBreakdown(t *testing.T) {
+	key := func(values ...string) string { return dimensionKey(values) }
+
+	entries := BuildBreakdown([]string{"service", "region"},
+		map[string]uint64{
+			key("api-server", "us-west-2"): 10,
+			key("api-server", "us-east-1"): 10,
+			key("worker", "us-east-1"):     20,
+		},
+		map[string]uint64{
+			key("api-server", "us-west-2"): 100,
+			key("api-server", "us-east-1"): 20,
+			key("worker", "us-east-1"):     20,
+		},
+	)
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 breakdown entries, got %d", len(entries))
+	}
+
+	top := entries[0]
+	if top.Dimensions["service"] != "api-server" || top.Dimensions["region"] != "us-west-2" {
+		t.Errorf("Expected api-server us-west-2 to contribute most, got %v", top.Dimensions)
+	}
+	if top.Change != 90 || math.Abs(top.ShareOfChange-0.9) > 1e-9 {
+		t.Errorf("Expected change 90 (90%% of total), got %d (%v)", top.Change, top.ShareOfChange)
+	}
+	if entries[2].Change != 0 || entries[2].ShareOfChange != 0 {
+		t.Errorf("Expected unchanged worker entry last with no share, got %+v", entries[2])
+	}
+}
+
+func TestAnalyzeLogsBreakdown(t *testing.T) {
+	end := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	start := end.Add(-10 * time.Minute)
+	baselineStart := start.Add(-10 * time.Minute)
+
+	store := &breakdownStore{
+		windowStore: windowStore{counts: map[time.Time]map[string]uint64{
+			start:         {"cpu_high_001": 50, "ok": 100},
+			baselineStart: {"cpu_high_001": 10, "ok": 100},
+		}},
+		rows: map[time.Time][]clickhouse.DimensionCount{
+			start: {
+				{TemplateID: "cpu_high_001", Values: []string{"api-server"}, Count: 42},
+				{TemplateID: "cpu_high_001", Values: []string{"worker"}, Count: 8},
+			},
+			baselineStart: {
+				{TemplateID: "cpu_high_001", Values: []string{"api-server"}, Count: 6},
+				{TemplateID: "cpu_high_001", Values: []string{"worker"}, Count: 4},
+			},
+		},
+	}
+
+	result, err := NewLogAnalyzerWithStore(store).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+		AnalysisOptions{GroupBy: []string{"service"}})
+	if err != nil {
+		t.Fatalf("AnalyzeLogs failed: %v", err)
+	}
+
+	var found bool
+	for _, group := range result.LogGroups {
+		if group.TemplateID != "cpu_high_001" {
+			continue
+		}
+		found = true
+		if len(group.Breakdown) != 2 {
+			t.Fatalf("Expected 2 breakdown entries, got %+v", group.Breakdown)
+		}
+		if group.Breakdown[0].Dimensions["service"] != "api-server" || group.Breakdown[0].ShareOfChange != 0.9 {
+			t.Errorf("Expected api-server to account for 90%% of the change, got %+v", group.Breakdown[0])
+		}
+	}
+	if !found {
+		t.Fatal("Expected cpu_high_001 in results")
+	}
+
+	if _, err := NewLogAnalyzerWithStore(store).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+		AnalysisOptions{GroupBy: []string{"message"}}); err == nil {
+		t.Error("Expected an unknown group-by dimension to be rejected")
+	}
+}
@@ -0,0 +1,49 @@
+package analyzer
+
+// ChangeKind classifies how a template's presence changed between windows
+type ChangeKind string
+
+const (
+	// ChangeNew templates never appeared in the baseline
+	ChangeNew ChangeKind = "new"
+	// ChangeVanished templates appeared in the baseline but not in the current window
+	ChangeVanished ChangeKind = "vanished"
+	// ChangeIncreased templates take up a larger share of the current window
+	ChangeIncreased ChangeKind = "increased"
+	// ChangeDecreased templates take up a smaller (or equal) share of the current window
+	ChangeDecreased ChangeKind = "decreased"
+)
+
+// rankBucket orders result groups: never-seen-before templates are ranked ahead
+// of everything else regardless of score
+func (c ChangeKind) rankBucket() int {
+	if c == ChangeNew {
+		return 0
+	}
+	return 1
+}
+
+// ClassifyChanges assigns a ChangeKind to every template in either window,
+// using raw counts so that appearing and vanishing aren't hidden by smoothing
+func ClassifyChanges(currentCounts, baselineCounts map[string]uint64) map[string]ChangeKind {
+	currentTotal := totalCount(currentCounts)
+	baselineTotal := totalCount(baselineCounts)
+
+	changes := make(map[string]ChangeKind)
+	for templateID := range unionTemplates(currentCounts, baselineCounts) {
+		current := currentCounts[templateID]
+		baseline := baselineCounts[templateID]
+
+		switch {
+		case baseline == 0 && current > 0:
+			changes[templateID] = ChangeNew
+		case current == 0 && baseline > 0:
+			changes[templateID] = ChangeVanished
+		case frequency(current, currentTotal) > frequency(baseline, baselineTotal):
+			changes[templateID] = ChangeIncreased
+		default:
+			changes[templateID] = ChangeDecreased
+		}
+	}
+	return changes
+}
@@ -0,0 +1,70 @@
+package analyzer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClassifyChanges(t *testing.T) {
+	changes := ClassifyChanges(
+		map[string]uint64{"steady": 100, "up": 50, "down": 5, "fresh": 3},
+		map[string]uint64{"steady": 100, "up": 10, "down": 40, "gone": 25},
+	)
+
+	expected := map[string]ChangeKind{
+		"fresh": ChangeNew,
+		"gone":  ChangeVanished,
+		"up":    ChangeIncreased,
+		"down":  ChangeDecreased,
+	}
+	for id, want := range expected {
+		if changes[id] != want {
+			t.Errorf("Template %s: expected %s, got %s", id, want, changes[id])
+		}
+	}
+	if len(changes) != 5 {
+		t.Errorf("Expected every template in either window to be classified, got %d", len(changes))
+	}
+}
+
+func TestAnalyzeLogsRanksNewTemplatesFirst(t *testing.T) {
+	end := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	start := end.Add(-10 * time.Minute)
+	store := &windowStore{counts: map[time.Time]map[string]uint64{
+		start:                        {"ok": 100, "surge": 300, "panic": 2},
+		start.Add(-10 * time.Minute): {"ok": 100, "surge": 10, "gone": 80},
+	}}
+
+	result, err := NewLogAnalyzerWithStore(store).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end, AnalysisOptions{})
+	if err != nil {
+		t.Fatalf("AnalyzeLogs failed: %v", err)
+	}
+
+	if len(result.LogGroups) != 4 {
+		t.Fatalf("Expected 4 log groups, got %d", len(result.LogGroups))
+	}
+
+	// panic has a tiny JS contribution next to surge, but was never seen before
+	first := result.LogGroups[0]
+	if first.TemplateID != "panic" || first.Change != ChangeNew {
+		t.Errorf("Expected new template panic to rank first, got %s (%s)", first.TemplateID, first.Change)
+	}
+	if first.Score >= result.LogGroups[1].Score {
+		t.Errorf("Test setup: expected panic to have a lower score than the next group")
+	}
+
+	for _, group := range result.LogGroups {
+		if group.TemplateID == "gone" {
+			if group.Change != ChangeVanished {
+				t.Errorf("Expected gone to be classified vanished, got %s", group.Change)
+			}
+			if group.RelativeChange != -1.0 {
+				t.Errorf("Expected vanished template to report a 100%% decrease, got %v", group.RelativeChange)
+			}
+		}
+		if group.TemplateID == "surge" && group.Change != ChangeIncreased {
+			t.Errorf("Expected surge to be classified increased, got %s", group.Change)
+		}
+	}
+}
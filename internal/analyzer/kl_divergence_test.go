@@ -5,7 +5,7 @@ import (
 	"testing"
 )
 
-func TestCalculateKLDivergence(t *testing.T) {
+func TestCalculateJSDivergence(t *testing.T) {
 	tests := []struct {
 		name            string
 		currentCounts   map[string]uint64
@@ -68,7 +68,7 @@ func TestCalculateKLDivergence(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := CalculateKLDivergence(tt.currentCounts, tt.baselineCounts)
+			result := CalculateJSDivergence(tt.currentCounts, tt.baselineCounts)
 
 			// Check that expected templates have non-zero KL divergence
 			for _, templateID := range tt.expectNonZero {
@@ -189,8 +189,8 @@ func TestCalculateKLDivergenceSymmetry(t *testing.T) {
 		"template_002": 10,
 	}
 
-	kl1 := CalculateKLDivergence(currentCounts, baselineCounts)
-	kl2 := CalculateKLDivergence(baselineCounts, currentCounts)
+	kl1 := CalculateJSDivergence(currentCounts, baselineCounts)
+	kl2 := CalculateJSDivergence(baselineCounts, currentCounts)
 
 	// KL divergence is not symmetric, but both should produce valid results
 	if len(kl1) == 0 || len(kl2) == 0 {
@@ -2,12 +2,15 @@ package analyzer
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"sort"
 	"time"
 
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type LogAnalyzer struct {
@@ -19,6 +22,61 @@ type LogGroup struct {
 	RelativeChange     float64  `json:"relative_change"`
 	KLContribution     float64  `json:"kl_contribution"`
 	TemplateID         string   `json:"template_id"`
+	BaselineCount      uint64   `json:"baseline_count"`
+	CurrentCount       uint64   `json:"current_count"`
+	// Change classifies the template as new, vanished, increased or decreased
+	Change ChangeKind `json:"change"`
+	// Frequencies are the template's unsmoothed share of its window's total volume
+	BaselineFrequency float64 `json:"baseline_frequency"`
+	CurrentFrequency  float64 `json:"current_frequency"`
+	// Score is the ranking score from the selected scorer
+	Score float64 `json:"score"`
+	// PValue is set when the scorer is a statistical test or significance testing is enabled
+	PValue *float64 `json:"p_value,omitempty"`
+	// AdjustedPValue and Significant are set when significance testing is enabled
+	AdjustedPValue *float64 `json:"adjusted_p_value,omitempty"`
+	Significant    *bool    `json:"significant,omitempty"`
+	// Breakdown attributes the change to dimension values when AnalysisOptions.GroupBy is set
+	Breakdown []BreakdownEntry `json:"breakdown,omitempty"`
+	// Series holds bucketed counts across the baseline and current windows when AnalysisOptions.BucketWidth is set
+	Series []SeriesPoint `json:"series,omitempty"`
+}
+
+// AnalysisOptions tune how AnalyzeLogs builds its baseline and ranks templates
+type AnalysisOptions struct {
+	Baseline BaselineOptions
+	// Scorer ranks templates, defaults to JSDivergenceScorer
+	Scorer       Scorer
+	Significance SignificanceOptions
+	// GroupBy lists logs columns (see clickhouse.BreakdownDimensions) to break each returned template down by
+	GroupBy []string
+	// BucketWidth returns a per-template time series with buckets this wide; zero disables series
+	BucketWidth time.Duration
+	// MetricSeries holds the hovered metric's values, which streams suggested for an unmapped
+	// metric are correlated with
+	MetricSeries []MetricPoint
+}
+
+// AnalysisResult is the outcome of AnalyzeLogs, including the baseline windows
+// that were actually queried
+type AnalysisResult struct {
+	LogGroups        []LogGroup
+	CurrentWindow    TimeWindow
+	Scorer           string
+	Significance     SignificanceOptions
+	GroupBy          []string
+	BucketWidth      time.Duration
+	BaselineStrategy BaselineStrategy
+	BaselineWindows  []TimeWindow
+	// BaselineWindowTotals holds the log volume of each entry in BaselineWindows
+	BaselineWindowTotals []uint64
+	// BaselineTotal is the volume of the combined baseline distribution
+	BaselineTotal uint64
+	CurrentTotal  uint64
+	// Unmapped is set when the metric has no active log streams, in which case Suggestions
+	// ranks the streams that may belong to it
+	Unmapped    bool
+	Suggestions []StreamSuggestion
 }
 
 func NewLogAnalyzer(cfg *config.ClickHouseConfig) (*LogAnalyzer, error) {
@@ -39,6 +97,12 @@ func NewLogAnalyzerWithStore(store clickhouse.Store) *LogAnalyzer {
 	}
 }
 
+// Store returns the store the analyzer queries, for work outside analysis such as managing
+// metric mappings
+func (la *LogAnalyzer) Store() clickhouse.Store {
+	return la.store
+}
+
 func (la *LogAnalyzer) Close() error {
 	return la.store.Close()
 }
@@ -47,35 +111,121 @@ func (la *LogAnalyzer) VerifyTables() error {
 	return la.store.VerifyTables()
 }
 
+func (la *LogAnalyzer) Ping(ctx context.Context) error {
+	return la.store.Ping(ctx)
+}
+
+func (la *LogAnalyzer) GetSchemaStatus(ctx context.Context) (clickhouse.SchemaStatus, error) {
+	return la.store.GetSchemaStatus(ctx)
+}
+
+func (la *LogAnalyzer) MissingTables(ctx context.Context) ([]string, error) {
+	return la.store.MissingTables(ctx)
+}
+
 // AnalyzeLogs analyzes logs for anomalies using KL divergence
 //
 // Algorithm:
-// 1. Query baseline window (same duration as current window, but before it)
+// 1. Query the baseline window(s) selected by opts.Baseline
 // 2. Query current window (the anomaly window from Grafana)
 // 3. Calculate template frequency distributions for both windows
-// 4. Compute KL divergence to find anomalous templates
-// 5. Fetch representative logs for top anomalous templates
-func (la *LogAnalyzer) AnalyzeLogs(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time) ([]LogGroup, error) {
-	// Calculate baseline window (same duration as current window, but before it)
-	windowDuration := endTime.Sub(startTime)
-	baselineEnd := startTime
-	baselineStart := baselineEnd.Add(-windowDuration)
-
-	log.Printf("Analyzing logs - org: %s, dashboard: %s, panel: %s, metric: %s, current: %v to %v, baseline: %v to %v",
-		org, dashboard, panelTitle, metricName, startTime, endTime, baselineStart, baselineEnd)
-
-	// Get template counts for both windows
-	baselineCounts, err := la.store.GetTemplateCounts(ctx, org, dashboard, panelTitle, metricName, baselineStart, baselineEnd)
-	if err != nil {
+// 4. Score every template with opts.Scorer (Jensen-Shannon by default) to find anomalous templates
+// 5. Fetch representative logs (and optionally breakdowns and time series) for top anomalous templates
+func (la *LogAnalyzer) AnalyzeLogs(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time, opts AnalysisOptions) (*AnalysisResult, error) {
+	ctx, span := tracing.Start(ctx, "LogAnalyzer.AnalyzeLogs",
+		append(tracing.QueryAttributes(org, dashboard, panelTitle, metricName, startTime, endTime),
+			attribute.String("hover.baseline.strategy", string(opts.Baseline.Strategy)))...)
+
+	result, err := la.analyzeLogs(ctx, org, dashboard, panelTitle, metricName, startTime, endTime, opts)
+	if err == nil {
+		span.SetAttributes(
+			tracing.TemplateCountKey.Int(len(result.LogGroups)),
+			attribute.Int("hover.baseline.windows", len(result.BaselineWindows)),
+			attribute.Int64("hover.baseline.total", int64(result.BaselineTotal)),
+			attribute.Int64("hover.current.total", int64(result.CurrentTotal)),
+			attribute.Bool("hover.unmapped", result.Unmapped),
+		)
+	}
+	tracing.End(span, err)
+	return result, err
+}
+
+func (la *LogAnalyzer) analyzeLogs(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time, opts AnalysisOptions) (*AnalysisResult, error) {
+	if err := opts.Baseline.Validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.Significance.Validate(); err != nil {
+		return nil, err
+	}
+	if err := clickhouse.ValidateDimensions(opts.GroupBy); err != nil {
 		return nil, err
 	}
+	if err := ValidateBucketWidth(opts.BucketWidth, endTime.Sub(startTime)); err != nil {
+		return nil, err
+	}
+	if err := ValidateMetricSeries(opts.MetricSeries); err != nil {
+		return nil, err
+	}
+	baselineWindows := opts.Baseline.BaselineWindows(startTime, endTime)
+	scorer := opts.Scorer
+	if scorer == nil {
+		scorer = JSDivergenceScorer{}
+	}
+
+	slog.DebugContext(ctx, "Analyzing logs", "dashboard", dashboard, "panel", panelTitle, "metric", metricName,
+		"start", startTime, "end", endTime, "baseline", opts.Baseline.Strategy, "baseline_windows", len(baselineWindows))
+
+	result := &AnalysisResult{
+		LogGroups:        []LogGroup{},
+		CurrentWindow:    TimeWindow{Start: startTime, End: endTime},
+		Scorer:           scorer.Name(),
+		Significance:     opts.Significance,
+		GroupBy:          opts.GroupBy,
+		BucketWidth:      opts.BucketWidth,
+		BaselineStrategy: opts.Baseline.Strategy,
+		BaselineWindows:  baselineWindows,
+	}
+
+	// Get template counts for every baseline window, then the current window
+	windowCounts := make([]map[string]uint64, len(baselineWindows))
+	result.BaselineWindowTotals = make([]uint64, len(baselineWindows))
+	baselineCtx := tracing.WithAttributes(ctx, tracing.WindowRoleKey.String(tracing.RoleBaseline))
+	for i, window := range baselineWindows {
+		counts, err := la.store.GetTemplateCounts(baselineCtx, org, dashboard, panelTitle, metricName, window.Start, window.End)
+		if err != nil {
+			return nil, err
+		}
+		windowCounts[i] = counts
+		result.BaselineWindowTotals[i] = totalCount(counts)
+	}
+	baselineCounts := MedianCounts(windowCounts)
 
-	currentCounts, err := la.store.GetTemplateCounts(ctx, org, dashboard, panelTitle, metricName, startTime, endTime)
+	currentCtx := tracing.WithAttributes(ctx, tracing.WindowRoleKey.String(tracing.RoleCurrent))
+	currentCounts, err := la.store.GetTemplateCounts(currentCtx, org, dashboard, panelTitle, metricName, startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("Found %d baseline templates, %d current templates", len(baselineCounts), len(currentCounts))
+	result.BaselineTotal = totalCount(baselineCounts)
+	result.CurrentTotal = totalCount(currentCounts)
+
+	slog.DebugContext(ctx, "Counted templates", "baseline_templates", len(baselineCounts), "current_templates", len(currentCounts))
+
+	// No logs in any window may just mean no log streams are mapped to the metric yet
+	if result.BaselineTotal == 0 && result.CurrentTotal == 0 {
+		active, err := la.store.CountActiveStreams(ctx, org, dashboard, panelTitle, metricName)
+		if err != nil {
+			return nil, err
+		}
+		if active == 0 {
+			result.Unmapped = true
+			if result.Suggestions, err = la.suggestStreams(ctx, org, baselineWindows, startTime, endTime, opts.MetricSeries); err != nil {
+				return nil, err
+			}
+			slog.DebugContext(ctx, "Metric has no active log streams", "suggestions", len(result.Suggestions))
+			return result, nil
+		}
+	}
 
 	// Calculate Jensen-Shannon Distance contributions for each template
 	jsContributions := CalculateJSDivergence(currentCounts, baselineCounts)
@@ -83,30 +233,59 @@ func (la *LogAnalyzer) AnalyzeLogs(ctx context.Context, org, dashboard, panelTit
 	// Calculate relative changes for each template (as percentages)
 	relativeChanges := CalculateRelativeChanges(currentCounts, baselineCounts)
 
-	// Sort templates by JS divergence contribution (highest first)
-	type templateJS struct {
+	// Classify templates so new and vanished ones aren't hidden by smoothing
+	changes := ClassifyChanges(currentCounts, baselineCounts)
+
+	// Score templates with the selected scorer
+	scoreInput := ScoreInput{
+		CurrentCounts:    currentCounts,
+		BaselineCounts:   baselineCounts,
+		CurrentDuration:  endTime.Sub(startTime),
+		BaselineDuration: endTime.Sub(startTime),
+	}
+	scores := scorer.Score(scoreInput)
+
+	// Test every template's change, controlling the false discovery rate across all of them
+	var pValues, adjustedPValues map[string]float64
+	if opts.Significance.Enabled() {
+		pValues = TemplatePValues(scores, scoreInput)
+		adjustedPValues = BenjaminiHochberg(pValues)
+	}
+
+	// Sort templates by score (highest first), with never-seen-before templates in their own leading bucket
+	type templateScore struct {
 		templateID string
-		jsValue    float64
+		bucket     int
+		score      float64
 	}
 
-	var sortedTemplates []templateJS
-	for templateID, jsValue := range jsContributions {
-		sortedTemplates = append(sortedTemplates, templateJS{templateID, jsValue})
+	var sortedTemplates []templateScore
+	for templateID, score := range scores {
+		if opts.Significance.Mode == SignificanceDrop && adjustedPValues[templateID] > opts.Significance.Level {
+			continue
+		}
+		sortedTemplates = append(sortedTemplates, templateScore{templateID, changes[templateID].rankBucket(), score.Score})
 	}
 
 	sort.Slice(sortedTemplates, func(i, j int) bool {
-		return sortedTemplates[i].jsValue > sortedTemplates[j].jsValue
+		if sortedTemplates[i].bucket != sortedTemplates[j].bucket {
+			return sortedTemplates[i].bucket < sortedTemplates[j].bucket
+		}
+		if sortedTemplates[i].score != sortedTemplates[j].score {
+			return sortedTemplates[i].score > sortedTemplates[j].score
+		}
+		return sortedTemplates[i].templateID < sortedTemplates[j].templateID
 	})
 
-	// Take top N templates with highest JS divergence
+	// Take top N templates with highest score
 	topN := 10
 	if len(sortedTemplates) > topN {
 		sortedTemplates = sortedTemplates[:topN]
 	}
 
 	if len(sortedTemplates) == 0 {
-		log.Println("No templates found with significant divergence")
-		return []LogGroup{}, nil
+		slog.DebugContext(ctx, "No templates found with significant divergence")
+		return result, nil
 	}
 
 	// Extract template IDs
@@ -121,23 +300,81 @@ func (la *LogAnalyzer) AnalyzeLogs(ctx context.Context, org, dashboard, panelTit
 		return nil, err
 	}
 
+	// Attribute each template's change to services, regions or streams if requested
+	var breakdowns map[string][]BreakdownEntry
+	if len(opts.GroupBy) > 0 {
+		breakdowns, err = la.fetchBreakdowns(ctx, org, dashboard, panelTitle, metricName, topTemplateIDs, opts.GroupBy, baselineWindows, startTime, endTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Bucket each template's counts so the panel can line them up with the metric
+	var series map[string][]SeriesPoint
+	if opts.BucketWidth > 0 {
+		series, err = la.fetchSeries(ctx, org, dashboard, panelTitle, metricName, topTemplateIDs, opts.BucketWidth, baselineWindows, startTime, endTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Build log groups
 	var logGroups []LogGroup
 	for _, templateID := range topTemplateIDs {
 		if logs, ok := representatives[templateID]; ok {
 			relativeChange := relativeChanges[templateID]
 			jsContribution := jsContributions[templateID]
+			if changes[templateID] == ChangeVanished {
+				// A template that disappeared completely is a 100% decrease, whatever the smoothing says
+				relativeChange = -1.0
+			}
 
 			logGroups = append(logGroups, LogGroup{
 				RepresentativeLogs: logs,
 				RelativeChange:     relativeChange,
 				KLContribution:     jsContribution, // Now contains JS divergence
 				TemplateID:         templateID,
+				BaselineCount:      baselineCounts[templateID],
+				CurrentCount:       currentCounts[templateID],
+				Change:             changes[templateID],
+				Breakdown:          breakdowns[templateID],
+				Series:             series[templateID],
+				BaselineFrequency:  frequency(baselineCounts[templateID], result.BaselineTotal),
+				CurrentFrequency:   frequency(currentCounts[templateID], result.CurrentTotal),
+				Score:              scores[templateID].Score,
 			})
+			group := &logGroups[len(logGroups)-1]
+			if opts.Significance.Enabled() {
+				pValue := pValues[templateID]
+				adjusted := adjustedPValues[templateID]
+				significant := adjusted <= opts.Significance.Level
+				group.PValue = &pValue
+				group.AdjustedPValue = &adjusted
+				group.Significant = &significant
+			} else if score := scores[templateID]; score.HasPValue() {
+				pValue := score.PValue
+				group.PValue = &pValue
+			}
 		}
 	}
 
-	log.Printf("Returning %d log groups", len(logGroups))
+	slog.DebugContext(ctx, "Analysis complete", "log_groups", len(logGroups))
+
+	result.LogGroups = logGroups
+	return result, nil
+}
 
-	return logGroups, nil
+func totalCount(counts map[string]uint64) uint64 {
+	var total uint64
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+func frequency(count, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) / float64(total)
 }
@@ -0,0 +1,201 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ScoreInput holds the template counts of both windows and how long each window was
+type ScoreInput struct {
+	CurrentCounts    map[string]uint64
+	BaselineCounts   map[string]uint64
+	CurrentDuration  time.Duration
+	BaselineDuration time.Duration
+}
+
+// TemplateScore is a scorer's verdict for a single template.
+// Higher scores rank first. PValue is NaN when the scorer is not a statistical test.
+type TemplateScore struct {
+	Score  float64
+	PValue float64
+}
+
+// HasPValue reports whether the scorer produced a p-value for this template
+func (s TemplateScore) HasPValue() bool {
+	return !math.IsNaN(s.PValue)
+}
+
+// Scorer ranks templates by how much their counts changed between windows
+type Scorer interface {
+	Name() string
+	Score(input ScoreInput) map[string]TemplateScore
+}
+
+const (
+	ScorerJSDivergence = "js_divergence"
+	ScorerGTest        = "g_test"
+	ScorerChiSquare    = "chi_square"
+	ScorerPoissonRate  = "poisson_rate"
+)
+
+// ParseScorer returns the scorer with the given name. An empty name selects
+// the Jensen-Shannon scorer.
+func ParseScorer(name string) (Scorer, error) {
+	switch name {
+	case "", ScorerJSDivergence:
+		return JSDivergenceScorer{}, nil
+	case ScorerGTest:
+		return GTestScorer{}, nil
+	case ScorerChiSquare:
+		return ChiSquareScorer{}, nil
+	case ScorerPoissonRate:
+		return PoissonRateScorer{}, nil
+	}
+	return nil, fmt.Errorf("unknown scorer %q (expected %s, %s, %s or %s)",
+		name, ScorerJSDivergence, ScorerGTest, ScorerChiSquare, ScorerPoissonRate)
+}
+
+// JSDivergenceScorer ranks by per-template Jensen-Shannon contribution, or by absolute
+// rate when a window is empty
+type JSDivergenceScorer struct{}
+
+func (JSDivergenceScorer) Name() string { return ScorerJSDivergence }
+
+func (JSDivergenceScorer) Score(input ScoreInput) map[string]TemplateScore {
+	if windowEmpty(input) {
+		return PoissonRateScorer{}.Score(input)
+	}
+	scores := make(map[string]TemplateScore)
+	for templateID, js := range CalculateJSDivergence(input.CurrentCounts, input.BaselineCounts) {
+		scores[templateID] = TemplateScore{Score: js, PValue: math.NaN()}
+	}
+	return scores
+}
+
+// GTestScorer ranks by the log-likelihood ratio (G) statistic of each template's
+// 2x2 table: this template vs. all others, current vs. baseline window
+type GTestScorer struct{}
+
+func (GTestScorer) Name() string { return ScorerGTest }
+
+func (GTestScorer) Score(input ScoreInput) map[string]TemplateScore {
+	return scoreContingency(input, func(observed, expected [4]float64) float64 {
+		g := 0.0
+		for i := range observed {
+			if observed[i] > 0 {
+				g += observed[i] * math.Log(observed[i]/expected[i])
+			}
+		}
+		return 2 * g
+	})
+}
+
+// ChiSquareScorer ranks by Pearson's chi-square statistic of each template's
+// 2x2 table and reports its p-value
+type ChiSquareScorer struct{}
+
+func (ChiSquareScorer) Name() string { return ScorerChiSquare }
+
+func (ChiSquareScorer) Score(input ScoreInput) map[string]TemplateScore {
+	return scoreContingency(input, func(observed, expected [4]float64) float64 {
+		chi2 := 0.0
+		for i := range observed {
+			d := observed[i] - expected[i]
+			chi2 += d * d / expected[i]
+		}
+		return chi2
+	})
+}
+
+// scoreContingency builds the 2x2 table for every template and scores it with
+// a statistic that is chi-square distributed with one degree of freedom
+func scoreContingency(input ScoreInput, statistic func(observed, expected [4]float64) float64) map[string]TemplateScore {
+	if windowEmpty(input) {
+		return PoissonRateScorer{}.Score(input)
+	}
+	currentTotal := float64(totalCount(input.CurrentCounts))
+	baselineTotal := float64(totalCount(input.BaselineCounts))
+
+	scores := make(map[string]TemplateScore)
+	grandTotal := currentTotal + baselineTotal
+
+	for templateID := range unionTemplates(input.CurrentCounts, input.BaselineCounts) {
+		a := float64(input.CurrentCounts[templateID])
+		b := float64(input.BaselineCounts[templateID])
+		rowTemplate := a + b
+		rowOther := grandTotal - rowTemplate
+		if rowOther == 0 {
+			// The only template in both windows: nothing to compare against
+			scores[templateID] = TemplateScore{Score: 0, PValue: 1}
+			continue
+		}
+
+		observed := [4]float64{a, b, currentTotal - a, baselineTotal - b}
+		expected := [4]float64{
+			rowTemplate * currentTotal / grandTotal,
+			rowTemplate * baselineTotal / grandTotal,
+			rowOther * currentTotal / grandTotal,
+			rowOther * baselineTotal / grandTotal,
+		}
+
+		stat := statistic(observed, expected)
+		scores[templateID] = TemplateScore{Score: stat, PValue: chiSquarePValue1(stat)}
+	}
+	return scores
+}
+
+// PoissonRateScorer tests whether a template's absolute rate (logs per unit time)
+// changed, rather than its share of the total. Conditional on the combined count n,
+// the current count is Binomial(n, t1/(t1+t2)) under equal rates.
+type PoissonRateScorer struct{}
+
+func (PoissonRateScorer) Name() string { return ScorerPoissonRate }
+
+func (PoissonRateScorer) Score(input ScoreInput) map[string]TemplateScore {
+	t1 := input.CurrentDuration.Seconds()
+	t2 := input.BaselineDuration.Seconds()
+	if t1 <= 0 || t2 <= 0 {
+		// Fall back to equal exposure when durations are unknown
+		t1, t2 = 1, 1
+	}
+	p0 := t1 / (t1 + t2)
+
+	scores := make(map[string]TemplateScore)
+	for templateID := range unionTemplates(input.CurrentCounts, input.BaselineCounts) {
+		a := float64(input.CurrentCounts[templateID])
+		n := a + float64(input.BaselineCounts[templateID])
+
+		// Normal approximation with continuity correction
+		deviation := math.Max(math.Abs(a-n*p0)-0.5, 0)
+		z := deviation / math.Sqrt(n*p0*(1-p0))
+		scores[templateID] = TemplateScore{Score: z * z, PValue: math.Erfc(z / math.Sqrt2)}
+	}
+	return scores
+}
+
+// windowEmpty reports whether either window has no logs. Templates then have no share of
+// that window to compare, and smoothing would only measure how far each strays from an even
+// split, so the share-based scorers rank and test templates on their absolute rates instead.
+func windowEmpty(input ScoreInput) bool {
+	return totalCount(input.CurrentCounts) == 0 || totalCount(input.BaselineCounts) == 0
+}
+
+// chiSquarePValue1 is the upper-tail p-value of a chi-square statistic with one degree of freedom
+func chiSquarePValue1(stat float64) float64 {
+	if stat <= 0 {
+		return 1
+	}
+	return math.Erfc(math.Sqrt(stat / 2))
+}
+
+func unionTemplates(a, b map[string]uint64) map[string]bool {
+	all := make(map[string]bool, len(a)+len(b))
+	for id := range a {
+		all[id] = true
+	}
+	for id := range b {
+		all[id] = true
+	}
+	return all
+}
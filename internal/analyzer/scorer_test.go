@@ -0,0 +1,195 @@
+package analyzer
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestScorersRankSpikeFirst(t *testing.T) {
+	input := ScoreInput{
+		CurrentCounts: map[string]uint64{
+			"steady": 100,
+			"spike":  80,
+			"quiet":  10,
+		},
+		BaselineCounts: map[string]uint64{
+			"steady": 100,
+			"spike":  5,
+			"quiet":  12,
+		},
+		CurrentDuration:  time.Hour,
+		BaselineDuration: time.Hour,
+	}
+
+	for _, name := range []string{ScorerJSDivergence, ScorerGTest, ScorerChiSquare, ScorerPoissonRate} {
+		t.Run(name, func(t *testing.T) {
+			scorer, err := ParseScorer(name)
+			if err != nil {
+				t.Fatalf("ParseScorer(%q) failed: %v", name, err)
+			}
+			if scorer.Name() != name {
+				t.Errorf("Expected scorer name %q, got %q", name, scorer.Name())
+			}
+
+			scores := scorer.Score(input)
+			if len(scores) != 3 {
+				t.Fatalf("Expected 3 scored templates, got %d", len(scores))
+			}
+
+			top := ""
+			for id, score := range scores {
+				if top == "" || score.Score > scores[top].Score {
+					top = id
+				}
+				if math.IsNaN(score.Score) || math.IsInf(score.Score, 0) {
+					t.Errorf("Score for %s is not finite: %v", id, score.Score)
+				}
+				if score.HasPValue() && (score.PValue < 0 || score.PValue > 1) {
+					t.Errorf("p-value for %s out of range: %v", id, score.PValue)
+				}
+			}
+			if top != "spike" {
+				t.Errorf("Expected spike to rank first, got %s", top)
+			}
+
+			if name != ScorerJSDivergence && !scores["spike"].HasPValue() {
+				t.Errorf("Expected %s to report a p-value", name)
+			}
+			if scores["spike"].HasPValue() && scores["spike"].PValue > 0.001 {
+				t.Errorf("Expected spike to be highly significant, got p=%v", scores["spike"].PValue)
+			}
+		})
+	}
+}
+
+func TestScorersHandleEmptyWindow(t *testing.T) {
+	counts := map[string]uint64{"oom": 40, "panic": 25, "rare": 1}
+	inputs := map[string]ScoreInput{
+		"empty baseline": {CurrentCounts: counts, BaselineCounts: map[string]uint64{}},
+		"empty current":  {CurrentCounts: map[string]uint64{}, BaselineCounts: counts},
+	}
+
+	for _, name := range []string{ScorerJSDivergence, ScorerGTest, ScorerChiSquare, ScorerPoissonRate} {
+		scorer, err := ParseScorer(name)
+		if err != nil {
+			t.Fatalf("ParseScorer(%q) failed: %v", name, err)
+		}
+		for window, input := range inputs {
+			t.Run(name+"/"+window, func(t *testing.T) {
+				scores := scorer.Score(input)
+				if len(scores) != 3 {
+					t.Fatalf("Expected 3 scored templates, got %d", len(scores))
+				}
+				for id, score := range scores {
+					if math.IsNaN(score.Score) || math.IsInf(score.Score, 0) {
+						t.Errorf("Score for %s is not finite: %v", id, score.Score)
+					}
+				}
+				if scores["oom"].Score <= scores["panic"].Score {
+					t.Errorf("Expected oom to outrank panic, got %v <= %v", scores["oom"].Score, scores["panic"].Score)
+				}
+
+				if p := scores["oom"].PValue; p > 0.001 {
+					t.Errorf("Expected oom to be highly significant, got p=%v", p)
+				}
+				if p := scores["rare"].PValue; p < 0.5 {
+					t.Errorf("Expected a single log to be insignificant, got p=%v", p)
+				}
+			})
+		}
+	}
+}
+
+func TestChiSquarePValue(t *testing.T) {
+	// 3.841 is the 95th percentile of chi-square with one degree of freedom
+	if p := chiSquarePValue1(3.841); math.Abs(p-0.05) > 0.001 {
+		t.Errorf("Expected p≈0.05 for chi2=3.841, got %v", p)
+	}
+	if p := chiSquarePValue1(0); p != 1 {
+		t.Errorf("Expected p=1 for chi2=0, got %v", p)
+	}
+}
+
+func TestPoissonRateScorerUsesAbsoluteVolume(t *testing.T) {
+	// Every template doubled, so shares are unchanged but absolute rates are not
+	input := ScoreInput{
+		CurrentCounts:    map[string]uint64{"a": 200, "b": 400},
+		BaselineCounts:   map[string]uint64{"a": 100, "b": 200},
+		CurrentDuration:  time.Hour,
+		BaselineDuration: time.Hour,
+	}
+
+	chi := ChiSquareScorer{}.Score(input)
+	if chi["a"].PValue < 0.5 {
+		t.Errorf("Expected chi-square to see no change in shares, got p=%v", chi["a"].PValue)
+	}
+
+	poisson := PoissonRateScorer{}.Score(input)
+	if poisson["a"].PValue > 0.001 {
+		t.Errorf("Expected Poisson rate test to flag doubled volume, got p=%v", poisson["a"].PValue)
+	}
+
+	// Doubling the rate over a twice-as-long current window is no change at all
+	input.CurrentDuration = 2 * time.Hour
+	poisson = PoissonRateScorer{}.Score(input)
+	if poisson["a"].PValue < 0.5 {
+		t.Errorf("Expected no rate change after accounting for exposure, got p=%v", poisson["a"].PValue)
+	}
+}
+
+func TestParseScorerRejectsUnknown(t *testing.T) {
+	if _, err := ParseScorer("entropy"); err == nil {
+		t.Error("Expected unknown scorer to be rejected")
+	}
+	scorer, err := ParseScorer("")
+	if err != nil || scorer.Name() != ScorerJSDivergence {
+		t.Errorf("Expected empty scorer to default to %s", ScorerJSDivergence)
+	}
+}
+
+func TestAnalyzeLogsReportsScorer(t *testing.T) {
+	end := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	start := end.Add(-10 * time.Minute)
+	store := &windowStore{counts: map[time.Time]map[string]uint64{
+		start:                        {"spike": 60, "ok": 100, "other": 50},
+		start.Add(-10 * time.Minute): {"spike": 2, "ok": 100, "other": 50},
+	}}
+
+	result, err := NewLogAnalyzerWithStore(store).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+		AnalysisOptions{Scorer: GTestScorer{}})
+	if err != nil {
+		t.Fatalf("AnalyzeLogs failed: %v", err)
+	}
+
+	if result.Scorer != ScorerGTest {
+		t.Errorf("Expected result scorer %q, got %q", ScorerGTest, result.Scorer)
+	}
+	if len(result.LogGroups) == 0 || result.LogGroups[0].TemplateID != "spike" {
+		t.Fatalf("Expected spike to rank first, got %+v", result.LogGroups)
+	}
+	if result.LogGroups[0].PValue == nil {
+		t.Error("Expected G-test results to carry a p-value")
+	}
+	for i := 1; i < len(result.LogGroups); i++ {
+		if result.LogGroups[i].Score > result.LogGroups[i-1].Score {
+			t.Errorf("Log groups not sorted by score at index %d", i)
+		}
+	}
+
+	// The selected scorer still ranks templates when the baseline has no logs
+	emptyBaseline := &windowStore{counts: map[time.Time]map[string]uint64{
+		start: {"spike": 60, "ok": 5},
+	}}
+	for _, scorer := range []Scorer{JSDivergenceScorer{}, GTestScorer{}, ChiSquareScorer{}} {
+		result, err := NewLogAnalyzerWithStore(emptyBaseline).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+			AnalysisOptions{Scorer: scorer})
+		if err != nil {
+			t.Fatalf("AnalyzeLogs failed: %v", err)
+		}
+		if len(result.LogGroups) != 2 || result.LogGroups[0].TemplateID != "spike" {
+			t.Errorf("%s: expected spike to rank first against an empty baseline, got %+v", scorer.Name(), result.LogGroups)
+		}
+	}
+}
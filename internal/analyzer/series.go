@@ -0,0 +1,80 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+// MaxSeriesBuckets caps the number of buckets per queried window, so a small
+// bucket width can't turn a long window into an enormous series
+const MaxSeriesBuckets = 500
+
+// SeriesPoint is a template's log count in one time bucket
+type SeriesPoint struct {
+	Start time.Time `json:"start"`
+	Count uint64    `json:"count"`
+	// Baseline marks buckets from a baseline window rather than the current one
+	Baseline bool `json:"baseline"`
+}
+
+// ValidateBucketWidth checks a series bucket width against the length of the queried window.
+// A zero width disables series.
+func ValidateBucketWidth(width, window time.Duration) error {
+	if width == 0 {
+		return nil
+	}
+	if width < time.Second || width%time.Second != 0 {
+		return fmt.Errorf("bucket width must be a whole number of seconds, got %v", width)
+	}
+	if buckets := int64(window/width) + 1; buckets > MaxSeriesBuckets {
+		return fmt.Errorf("bucket width %v gives %d buckets per window, more than the maximum of %d", width, buckets, MaxSeriesBuckets)
+	}
+	return nil
+}
+
+// fetchSeries queries bucketed counts for the given templates in every baseline
+// window and the current window. Each template's points are in chronological order
+// with empty buckets filled in; a bucket straddling the boundary between two windows
+// appears once per window, holding that window's part of its logs.
+func (la *LogAnalyzer) fetchSeries(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs []string, bucketWidth time.Duration, baselineWindows []TimeWindow, startTime, endTime time.Time) (map[string][]SeriesPoint, error) {
+	series := make(map[string][]SeriesPoint, len(templateIDs))
+
+	// Baseline windows are ordered most recent first
+	windows := make([]TimeWindow, 0, len(baselineWindows)+1)
+	for i := len(baselineWindows) - 1; i >= 0; i-- {
+		windows = append(windows, baselineWindows[i])
+	}
+	windows = append(windows, TimeWindow{Start: startTime, End: endTime})
+
+	for i, window := range windows {
+		baseline := i < len(windows)-1
+
+		counts, err := la.store.GetTemplateTimeSeries(ctx, org, dashboard, panelTitle, metricName, templateIDs, bucketWidth, window.Start, window.End)
+		if err != nil {
+			return nil, err
+		}
+
+		byTemplate := make(map[string]map[int64]uint64)
+		for _, bc := range counts {
+			if byTemplate[bc.TemplateID] == nil {
+				byTemplate[bc.TemplateID] = make(map[int64]uint64)
+			}
+			byTemplate[bc.TemplateID][bc.BucketStart.Unix()] += bc.Count
+		}
+
+		for _, templateID := range templateIDs {
+			for bucket := clickhouse.BucketStart(window.Start, bucketWidth); bucket.Before(window.End); bucket = bucket.Add(bucketWidth) {
+				series[templateID] = append(series[templateID], SeriesPoint{
+					Start:    bucket,
+					Count:    byTemplate[templateID][bucket.Unix()],
+					Baseline: baseline,
+				})
+			}
+		}
+	}
+
+	return series, nil
+}
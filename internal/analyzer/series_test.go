@@ -0,0 +1,91 @@
+package analyzer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+func TestValidateBucketWidth(t *testing.T) {
+	tests := []struct {
+		width   time.Duration
+		window  time.Duration
+		wantErr bool
+	}{
+		{0, time.Hour, false},
+		{time.Minute, time.Hour, false},
+		{time.Second, 5 * time.Minute, false},
+		{1500 * time.Millisecond, time.Hour, true},
+		{-time.Minute, time.Hour, true},
+		{time.Second, time.Hour, true}, // 3601 buckets
+	}
+
+	for _, tt := range tests {
+		err := ValidateBucketWidth(tt.width, tt.window)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateBucketWidth(%v, %v) error = %v, wantErr %v", tt.width, tt.window, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAnalyzeLogsSeries(t *testing.T) {
+	end := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	start := end.Add(-time.Hour)
+	store := clickhouse.NewMockStore()
+
+	result, err := NewLogAnalyzerWithStore(store).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+		AnalysisOptions{BucketWidth: 10 * time.Minute})
+	if err != nil {
+		t.Fatalf("AnalyzeLogs failed: %v", err)
+	}
+	if len(result.LogGroups) == 0 {
+		t.Fatal("Expected log groups from the mock store")
+	}
+
+	for _, group := range result.LogGroups {
+		// 6 buckets for the previous window, then 6 for the current one
+		if len(group.Series) != 12 {
+			t.Fatalf("Expected 12 series points for %s, got %d", group.TemplateID, len(group.Series))
+		}
+
+		var baseline, current uint64
+		for i, point := range group.Series {
+			if want := start.Add(-time.Hour + time.Duration(i)*10*time.Minute); !point.Start.Equal(want) {
+				t.Errorf("Point %d of %s starts at %v, want %v", i, group.TemplateID, point.Start, want)
+			}
+			if point.Baseline != (i < 6) {
+				t.Errorf("Point %d of %s has baseline=%v", i, group.TemplateID, point.Baseline)
+			}
+			if point.Baseline {
+				baseline += point.Count
+			} else {
+				current += point.Count
+			}
+		}
+		if baseline != group.BaselineCount || current != group.CurrentCount {
+			t.Errorf("Series for %s sums to %d/%d, want %d/%d", group.TemplateID, baseline, current, group.BaselineCount, group.CurrentCount)
+		}
+	}
+}
+
+func TestAnalyzeLogsSeriesAlignment(t *testing.T) {
+	// A window that doesn't start on a bucket boundary gets a leading partial bucket
+	end := time.Date(2025, 1, 15, 12, 5, 0, 0, time.UTC)
+	start := end.Add(-30 * time.Minute)
+
+	result, err := NewLogAnalyzerWithStore(clickhouse.NewMockStore()).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+		AnalysisOptions{BucketWidth: 10 * time.Minute})
+	if err != nil {
+		t.Fatalf("AnalyzeLogs failed: %v", err)
+	}
+
+	series := result.LogGroups[0].Series
+	if len(series) != 8 {
+		t.Fatalf("Expected 4 buckets per window, got %d points", len(series))
+	}
+	if first := series[4].Start; !first.Equal(time.Date(2025, 1, 15, 11, 30, 0, 0, time.UTC)) {
+		t.Errorf("Expected the current window's first bucket to be aligned to 11:30, got %v", first)
+	}
+}
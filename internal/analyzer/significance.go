@@ -0,0 +1,109 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SignificanceMode controls what happens to templates whose change is not significant
+type SignificanceMode string
+
+const (
+	// SignificanceOff ranks templates without significance testing
+	SignificanceOff SignificanceMode = "off"
+	// SignificanceFlag keeps every template but marks whether it is significant
+	SignificanceFlag SignificanceMode = "flag"
+	// SignificanceDrop removes templates that are not significant, so a quiet window returns nothing
+	SignificanceDrop SignificanceMode = "drop"
+)
+
+const DefaultSignificanceLevel = 0.05
+
+// SignificanceOptions configures false-discovery-rate control across templates
+type SignificanceOptions struct {
+	Mode SignificanceMode
+	// Level is the false discovery rate that Benjamini-Hochberg adjusted p-values are compared against
+	Level float64
+}
+
+// Validate checks the options and fills in defaults. An empty mode disables testing.
+func (o *SignificanceOptions) Validate() error {
+	switch o.Mode {
+	case "":
+		o.Mode = SignificanceOff
+	case SignificanceOff, SignificanceFlag, SignificanceDrop:
+	default:
+		return fmt.Errorf("unknown significance mode %q (expected %s, %s or %s)",
+			o.Mode, SignificanceOff, SignificanceFlag, SignificanceDrop)
+	}
+
+	if o.Level == 0 {
+		o.Level = DefaultSignificanceLevel
+	}
+	if o.Level <= 0 || o.Level >= 1 {
+		return fmt.Errorf("significance level must be between 0 and 1, got %v", o.Level)
+	}
+	return nil
+}
+
+// Enabled reports whether templates should be tested at all
+func (o SignificanceOptions) Enabled() bool {
+	return o.Mode == SignificanceFlag || o.Mode == SignificanceDrop
+}
+
+// TemplatePValues returns a p-value for the count change of every scored template.
+// Scorers that are statistical tests provide their own; otherwise the G-test is used.
+func TemplatePValues(scores map[string]TemplateScore, input ScoreInput) map[string]float64 {
+	var fallback map[string]TemplateScore
+
+	pValues := make(map[string]float64, len(scores))
+	for templateID, score := range scores {
+		if score.HasPValue() {
+			pValues[templateID] = score.PValue
+			continue
+		}
+		if fallback == nil {
+			fallback = GTestScorer{}.Score(input)
+		}
+		if fb, ok := fallback[templateID]; ok {
+			pValues[templateID] = fb.PValue
+		} else {
+			pValues[templateID] = 1
+		}
+	}
+	return pValues
+}
+
+// BenjaminiHochberg adjusts p-values for the number of templates tested, so that
+// comparing the adjusted values against a level controls the false discovery rate
+func BenjaminiHochberg(pValues map[string]float64) map[string]float64 {
+	type entry struct {
+		templateID string
+		pValue     float64
+	}
+
+	entries := make([]entry, 0, len(pValues))
+	for templateID, p := range pValues {
+		entries = append(entries, entry{templateID, p})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].pValue != entries[j].pValue {
+			return entries[i].pValue < entries[j].pValue
+		}
+		return entries[i].templateID < entries[j].templateID
+	})
+
+	m := float64(len(entries))
+	adjusted := make(map[string]float64, len(entries))
+
+	// Walk from the largest p-value down, keeping the running minimum so adjusted values stay monotonic
+	running := 1.0
+	for i := len(entries) - 1; i >= 0; i-- {
+		q := entries[i].pValue * m / float64(i+1)
+		if q < running {
+			running = q
+		}
+		adjusted[entries[i].templateID] = running
+	}
+	return adjusted
+}
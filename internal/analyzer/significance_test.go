@@ -0,0 +1,154 @@
+package analyzer
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBenjaminiHochberg(t *testing.T) {
+	// Worked example: m=5, adjusted q_i = min over j>=i of p_j * m / j
+	adjusted := BenjaminiHochberg(map[string]float64{
+		"a": 0.01,
+		"b": 0.04,
+		"c": 0.03,
+		"d": 0.005,
+		"e": 0.5,
+	})
+
+	expected := map[string]float64{
+		"d": 0.025,
+		"a": 0.025,
+		"c": 0.05,
+		"b": 0.05,
+		"e": 0.5,
+	}
+	for id, want := range expected {
+		if math.Abs(adjusted[id]-want) > 1e-12 {
+			t.Errorf("Template %s: expected adjusted p-value %v, got %v", id, want, adjusted[id])
+		}
+	}
+
+	if len(BenjaminiHochberg(map[string]float64{})) != 0 {
+		t.Error("Expected no adjusted p-values for no templates")
+	}
+}
+
+func TestSignificanceOptionsValidate(t *testing.T) {
+	opts := SignificanceOptions{}
+	if err := opts.Validate(); err != nil || opts.Mode != SignificanceOff || opts.Level != DefaultSignificanceLevel {
+		t.Errorf("Expected defaults off/%v, got %+v (%v)", DefaultSignificanceLevel, opts, err)
+	}
+
+	for _, invalid := range []SignificanceOptions{
+		{Mode: "maybe"},
+		{Mode: SignificanceDrop, Level: 1.5},
+		{Mode: SignificanceFlag, Level: -0.1},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestAnalyzeLogsSignificance(t *testing.T) {
+	end := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	start := end.Add(-10 * time.Minute)
+
+	quiet := &windowStore{counts: map[time.Time]map[string]uint64{
+		start:                        {"a": 100, "b": 52, "c": 30},
+		start.Add(-10 * time.Minute): {"a": 98, "b": 50, "c": 31},
+	}}
+	spiking := &windowStore{counts: map[time.Time]map[string]uint64{
+		start:                        {"a": 100, "b": 52, "c": 30, "oom": 40},
+		start.Add(-10 * time.Minute): {"a": 98, "b": 50, "c": 31, "oom": 1},
+	}}
+
+	t.Run("drop returns nothing for a quiet window", func(t *testing.T) {
+		result, err := NewLogAnalyzerWithStore(quiet).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+			AnalysisOptions{Significance: SignificanceOptions{Mode: SignificanceDrop}})
+		if err != nil {
+			t.Fatalf("AnalyzeLogs failed: %v", err)
+		}
+		if len(result.LogGroups) != 0 {
+			t.Errorf("Expected no log groups for a quiet window, got %d", len(result.LogGroups))
+		}
+	})
+
+	t.Run("drop keeps only the real change", func(t *testing.T) {
+		result, err := NewLogAnalyzerWithStore(spiking).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+			AnalysisOptions{Significance: SignificanceOptions{Mode: SignificanceDrop}})
+		if err != nil {
+			t.Fatalf("AnalyzeLogs failed: %v", err)
+		}
+		if len(result.LogGroups) == 0 || result.LogGroups[0].TemplateID != "oom" {
+			t.Fatalf("Expected oom to be returned first, got %+v", result.LogGroups)
+		}
+		for _, group := range result.LogGroups {
+			if group.AdjustedPValue == nil || *group.AdjustedPValue > DefaultSignificanceLevel {
+				t.Errorf("Template %s returned without a significant adjusted p-value", group.TemplateID)
+			}
+		}
+	})
+
+	t.Run("drop tests new and vanished templates when a window is empty", func(t *testing.T) {
+		stores := map[string]*windowStore{
+			"empty baseline": {counts: map[time.Time]map[string]uint64{
+				start: {"oom": 40, "panic": 25, "rare": 1},
+			}},
+			"empty current": {counts: map[time.Time]map[string]uint64{
+				start.Add(-10 * time.Minute): {"oom": 40, "panic": 25, "rare": 1},
+			}},
+		}
+		for name, store := range stores {
+			result, err := NewLogAnalyzerWithStore(store).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+				AnalysisOptions{Significance: SignificanceOptions{Mode: SignificanceDrop}})
+			if err != nil {
+				t.Fatalf("%s: AnalyzeLogs failed: %v", name, err)
+			}
+			var kept []string
+			for _, group := range result.LogGroups {
+				kept = append(kept, group.TemplateID)
+			}
+			if len(kept) != 2 || kept[0] != "oom" || kept[1] != "panic" {
+				t.Errorf("%s: expected oom and panic kept and the single rare log dropped, got %v", name, kept)
+			}
+		}
+	})
+
+	t.Run("flag keeps everything and marks significance", func(t *testing.T) {
+		result, err := NewLogAnalyzerWithStore(spiking).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+			AnalysisOptions{Significance: SignificanceOptions{Mode: SignificanceFlag, Level: 0.01}})
+		if err != nil {
+			t.Fatalf("AnalyzeLogs failed: %v", err)
+		}
+		if len(result.LogGroups) != 4 {
+			t.Fatalf("Expected all 4 templates when flagging, got %d", len(result.LogGroups))
+		}
+		for _, group := range result.LogGroups {
+			if group.Significant == nil || group.PValue == nil {
+				t.Fatalf("Template %s missing significance fields", group.TemplateID)
+			}
+			if *group.Significant != (group.TemplateID == "oom") {
+				t.Errorf("Template %s: unexpected significant=%v (q=%v)", group.TemplateID, *group.Significant, *group.AdjustedPValue)
+			}
+		}
+		if result.Significance.Level != 0.01 {
+			t.Errorf("Expected result to report level 0.01, got %v", result.Significance.Level)
+		}
+	})
+
+	t.Run("off leaves JS results without p-values", func(t *testing.T) {
+		result, err := NewLogAnalyzerWithStore(spiking).AnalyzeLogs(context.Background(), "1", "d", "p", "m", start, end,
+			AnalysisOptions{})
+		if err != nil {
+			t.Fatalf("AnalyzeLogs failed: %v", err)
+		}
+		for _, group := range result.LogGroups {
+			if group.PValue != nil || group.Significant != nil {
+				t.Errorf("Template %s: expected no significance fields when testing is off", group.TemplateID)
+			}
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+// MaxSuggestions caps how many log streams are suggested for an unmapped metric
+const MaxSuggestions = 5
+
+// MaxSuggestionCandidates caps how many of the org's log streams are compared when suggesting
+// streams, most recently updated first, so a hover never counts the logs of every stream
+const MaxSuggestionCandidates = 100
+
+// MaxMetricSeriesPoints caps how many of the metric's points a request may send for
+// correlating streams with it
+const MaxMetricSeriesPoints = 200
+
+// minCorrelationPoints is the fewest metric points in the hovered window that streams are
+// correlated over; with fewer they are ranked by how much they changed instead
+const minCorrelationPoints = 3
+
+// MetricPoint is one value of the hovered metric
+type MetricPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// ValidateMetricSeries checks that a metric series is short enough, in time order and finite
+func ValidateMetricSeries(points []MetricPoint) error {
+	if len(points) > MaxMetricSeriesPoints {
+		return fmt.Errorf("metric series has %d points, more than the maximum of %d", len(points), MaxMetricSeriesPoints)
+	}
+	for i, point := range points {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			return fmt.Errorf("metric series value at %s is not finite", point.Time.Format(time.RFC3339))
+		}
+		if i > 0 && !point.Time.After(points[i-1].Time) {
+			return fmt.Errorf("metric series must be in increasing time order, got %s after %s",
+				point.Time.Format(time.RFC3339), points[i-1].Time.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// StreamSuggestion is a log stream that may belong to a metric with no active mappings.
+// When the request carries the metric's series, streams are ranked by how closely their logs
+// follow it over the hovered window; otherwise by how much their logs changed in it, a
+// heuristic that ranks streams that changed for unrelated reasons just as high.
+type StreamSuggestion struct {
+	LogStreamID string `json:"log_stream_id"`
+	Service     string `json:"service,omitempty"`
+	Region      string `json:"region,omitempty"`
+	Name        string `json:"log_stream_name,omitempty"`
+	// Score is the strongest absolute correlation with the metric, or without a metric series
+	// the mean of the squashed volume change and the divergence, in [0, 1)
+	Score float64 `json:"score"`
+	// Correlation is the Pearson correlation between the metric and the stream's log volume,
+	// absent without a metric series or when the volume is flat
+	Correlation *float64 `json:"correlation,omitempty"`
+	// TemplateID is the stream's template whose counts correlate most strongly with the
+	// metric, and TemplateCorrelation that correlation
+	TemplateID          string   `json:"template_id,omitempty"`
+	TemplateCorrelation *float64 `json:"template_correlation,omitempty"`
+	// VolumeChange is log2 of the stream's current volume over its baseline volume, with
+	// one added to both so empty windows compare cleanly
+	VolumeChange float64 `json:"volume_change"`
+	// Divergence is the Jensen-Shannon divergence between the stream's baseline and current
+	// template distributions
+	Divergence    float64 `json:"divergence"`
+	BaselineCount uint64  `json:"baseline_count"`
+	CurrentCount  uint64  `json:"current_count"`
+}
+
+// suggestStreams ranks the org's registered log streams as possible sources of the metric.
+// With enough of the metric's points in the current window, the window is split into one
+// bucket per point and streams are ranked by the correlation of their bucketed volume and
+// template counts with the metric's values. Otherwise they are ranked by how strongly their
+// volume and template mix changed between the baseline windows and the current window. Only
+// the MaxSuggestionCandidates most recently updated streams are counted, all windows and
+// buckets in one query.
+func (la *LogAnalyzer) suggestStreams(ctx context.Context, org string, baselineWindows []TimeWindow, startTime, endTime time.Time, metricSeries []MetricPoint) ([]StreamSuggestion, error) {
+	streams, err := la.store.ListLogStreams(ctx, org)
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	streams = suggestionCandidates(streams)
+
+	streamIDs := make([]string, len(streams))
+	for i, stream := range streams {
+		streamIDs[i] = stream.ID
+	}
+	windows := make([]clickhouse.Window, 0, len(baselineWindows)+1)
+	for _, window := range baselineWindows {
+		windows = append(windows, clickhouse.Window{Start: window.Start, End: window.End})
+	}
+	buckets, metricValues := metricBuckets(metricSeries, startTime, endTime)
+	if buckets == nil {
+		buckets = []clickhouse.Window{{Start: startTime, End: endTime}}
+	}
+	windows = append(windows, buckets...)
+
+	counts, err := la.store.GetStreamTemplateCounts(ctx, org, streamIDs, windows)
+	if err != nil {
+		return nil, err
+	}
+	if len(counts) != len(windows) {
+		return nil, fmt.Errorf("expected stream counts for %d windows, got %d", len(windows), len(counts))
+	}
+	windowCounts, bucketCounts := counts[:len(baselineWindows)], counts[len(baselineWindows):]
+
+	var suggestions []StreamSuggestion
+	for _, stream := range streams {
+		// Combine baseline windows the same way template counts are combined
+		perWindow := make([]map[string]uint64, len(windowCounts))
+		for i, counts := range windowCounts {
+			perWindow[i] = counts[stream.ID]
+		}
+		perBucket := make([]map[string]uint64, len(bucketCounts))
+		for i, counts := range bucketCounts {
+			perBucket[i] = counts[stream.ID]
+		}
+		suggestion, ok := ScoreStreamChange(MedianCounts(perWindow), sumCounts(perBucket))
+		if metricValues != nil {
+			ok = correlateStream(&suggestion, metricValues, perBucket)
+		}
+		if !ok {
+			continue
+		}
+		suggestion.LogStreamID = stream.ID
+		suggestion.Service = stream.Service
+		suggestion.Region = stream.Region
+		suggestion.Name = stream.Name
+		suggestions = append(suggestions, suggestion)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].LogStreamID < suggestions[j].LogStreamID
+	})
+	if len(suggestions) > MaxSuggestions {
+		suggestions = suggestions[:MaxSuggestions]
+	}
+	return suggestions, nil
+}
+
+// metricBuckets splits the current window into one bucket per metric point inside it, each
+// log falling in the bucket of the nearest point, and returns the buckets with their points'
+// values. It returns nil when fewer than minCorrelationPoints points are in the window.
+func metricBuckets(points []MetricPoint, startTime, endTime time.Time) ([]clickhouse.Window, []float64) {
+	var inside []MetricPoint
+	for _, point := range points {
+		if !point.Time.Before(startTime) && point.Time.Before(endTime) {
+			inside = append(inside, point)
+		}
+	}
+	if len(inside) < minCorrelationPoints {
+		return nil, nil
+	}
+
+	buckets := make([]clickhouse.Window, len(inside))
+	values := make([]float64, len(inside))
+	start := startTime
+	for i, point := range inside {
+		end := endTime
+		if i+1 < len(inside) {
+			end = point.Time.Add(inside[i+1].Time.Sub(point.Time) / 2)
+		}
+		buckets[i] = clickhouse.Window{Start: start, End: end}
+		values[i] = point.Value
+		start = end
+	}
+	return buckets, values
+}
+
+// correlateStream scores a stream by the strongest absolute Pearson correlation of the metric
+// with either the stream's volume or one of its templates' counts, bucket by bucket. It
+// returns false when nothing in the stream varies along with the metric.
+func correlateStream(suggestion *StreamSuggestion, metricValues []float64, perBucket []map[string]uint64) bool {
+	volume := make([]float64, len(perBucket))
+	templates := make(map[string]bool)
+	for i, counts := range perBucket {
+		volume[i] = float64(totalCount(counts))
+		for templateID := range counts {
+			templates[templateID] = true
+		}
+	}
+
+	suggestion.Score = 0
+	if r, ok := pearson(metricValues, volume); ok {
+		suggestion.Correlation = &r
+		suggestion.Score = math.Abs(r)
+	}
+
+	templateIDs := make([]string, 0, len(templates))
+	for templateID := range templates {
+		templateIDs = append(templateIDs, templateID)
+	}
+	sort.Strings(templateIDs)
+	for _, templateID := range templateIDs {
+		series := make([]float64, len(perBucket))
+		for i, counts := range perBucket {
+			series[i] = float64(counts[templateID])
+		}
+		r, ok := pearson(metricValues, series)
+		if !ok || (suggestion.TemplateCorrelation != nil && math.Abs(r) <= math.Abs(*suggestion.TemplateCorrelation)) {
+			continue
+		}
+		suggestion.TemplateID = templateID
+		suggestion.TemplateCorrelation = &r
+		suggestion.Score = math.Max(suggestion.Score, math.Abs(r))
+	}
+	return suggestion.Score > 0
+}
+
+// pearson returns the Pearson correlation of x and y, or false when either doesn't vary
+func pearson(x, y []float64) (float64, bool) {
+	if len(x) != len(y) || len(x) < 2 {
+		return 0, false
+	}
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= float64(len(x))
+	meanY /= float64(len(y))
+
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0, false
+	}
+	return cov / math.Sqrt(varX*varY), true
+}
+
+// sumCounts adds up template counts across windows
+func sumCounts(windows []map[string]uint64) map[string]uint64 {
+	sum := make(map[string]uint64)
+	for _, counts := range windows {
+		for templateID, count := range counts {
+			sum[templateID] += count
+		}
+	}
+	return sum
+}
+
+// suggestionCandidates returns the MaxSuggestionCandidates most recently registered or
+// changed streams, when the org has more than that
+func suggestionCandidates(streams []clickhouse.LogStream) []clickhouse.LogStream {
+	if len(streams) <= MaxSuggestionCandidates {
+		return streams
+	}
+	candidates := append([]clickhouse.LogStream(nil), streams...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].UpdatedAt.After(candidates[j].UpdatedAt)
+	})
+	return candidates[:MaxSuggestionCandidates]
+}
+
+// ScoreStreamChange scores how much a stream changed between its baseline and current
+// template counts. It returns false for a stream with no logs in either window, or, with its
+// counts still filled in, for one that didn't change at all.
+func ScoreStreamChange(baselineCounts, currentCounts map[string]uint64) (StreamSuggestion, bool) {
+	baselineTotal := totalCount(baselineCounts)
+	currentTotal := totalCount(currentCounts)
+	if baselineTotal == 0 && currentTotal == 0 {
+		return StreamSuggestion{}, false
+	}
+
+	volumeChange := math.Log2(float64(currentTotal+1) / float64(baselineTotal+1))
+	var divergence float64
+	for _, contribution := range CalculateJSDivergence(currentCounts, baselineCounts) {
+		divergence += contribution
+	}
+
+	// Squash the unbounded volume change into [0, 1) so neither signal dominates
+	shift := math.Abs(volumeChange)
+	score := (shift/(1+shift) + divergence) / 2
+	return StreamSuggestion{
+		Score:         score,
+		VolumeChange:  volumeChange,
+		Divergence:    divergence,
+		BaselineCount: baselineTotal,
+		CurrentCount:  currentTotal,
+	}, score > 0
+}
@@ -0,0 +1,239 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+// unmappedStore has no logs for any metric, and per-stream counts keyed by window start
+type unmappedStore struct {
+	clickhouse.MockStore
+	streamCounts map[time.Time]map[string]map[string]uint64
+	// countQueries is how many times stream counts were queried
+	countQueries int
+}
+
+func (s *unmappedStore) GetTemplateCounts(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time) (map[string]uint64, error) {
+	return map[string]uint64{}, nil
+}
+
+func (s *unmappedStore) GetStreamTemplateCounts(ctx context.Context, org string, streamIDs []string, windows []clickhouse.Window) ([]map[string]map[string]uint64, error) {
+	s.countQueries++
+	counts := make([]map[string]map[string]uint64, len(windows))
+	for i, window := range windows {
+		counts[i] = make(map[string]map[string]uint64)
+		for _, id := range streamIDs {
+			if streamCounts, ok := s.streamCounts[window.Start][id]; ok {
+				counts[i][id] = streamCounts
+			}
+		}
+	}
+	return counts, nil
+}
+
+func TestScoreStreamChange(t *testing.T) {
+	if _, ok := ScoreStreamChange(nil, nil); ok {
+		t.Error("Expected a stream without logs not to be scored")
+	}
+	steady := map[string]uint64{"a": 50, "b": 50}
+	if _, ok := ScoreStreamChange(steady, steady); ok {
+		t.Error("Expected an unchanged stream not to be scored")
+	}
+
+	louder, ok := ScoreStreamChange(steady, map[string]uint64{"a": 200, "b": 200})
+	if !ok || louder.VolumeChange <= 1.9 || louder.Divergence > 1e-9 {
+		t.Errorf("Expected a fourfold volume change with the same mix, got %+v", louder)
+	}
+	shifted, ok := ScoreStreamChange(steady, map[string]uint64{"a": 5, "b": 5, "c": 90})
+	if !ok || shifted.Divergence <= 0 {
+		t.Errorf("Expected a new template to diverge, got %+v", shifted)
+	}
+	if shifted.Score >= 1 || louder.Score >= 1 {
+		t.Errorf("Expected scores below 1, got %v and %v", shifted.Score, louder.Score)
+	}
+}
+
+func TestAnalyzeLogsSuggestsStreamsForUnmappedMetric(t *testing.T) {
+	end := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	start := end.Add(-30 * time.Minute)
+	baselineStart := start.Add(-30 * time.Minute)
+
+	// The mock store lists stream_api_east, stream_api_west and stream_worker_east
+	store := &unmappedStore{streamCounts: map[time.Time]map[string]map[string]uint64{
+		baselineStart: {
+			"stream_api_east":    {"request": 100},
+			"stream_worker_east": {"job": 20},
+			"unregistered":       {"job": 1},
+		},
+		start: {
+			"stream_api_east":    {"request": 110},
+			"stream_worker_east": {"job": 20, "oom": 200},
+			"unregistered":       {"job": 1000},
+		},
+	}}
+	analyzer := NewLogAnalyzerWithStore(store)
+
+	result, err := analyzer.AnalyzeLogs(context.Background(), "acme", "d", "p", "m", start, end, AnalysisOptions{})
+	if err != nil {
+		t.Fatalf("AnalyzeLogs failed: %v", err)
+	}
+	if !result.Unmapped {
+		t.Fatal("Expected the metric to be reported as unmapped")
+	}
+	if len(result.Suggestions) != 2 {
+		t.Fatalf("Expected the 2 registered streams with logs to be suggested, got %+v", result.Suggestions)
+	}
+	top := result.Suggestions[0]
+	if top.LogStreamID != "stream_worker_east" || top.Service != "worker" {
+		t.Errorf("Expected the stream that changed most to be suggested first, got %+v", top)
+	}
+	if top.BaselineCount != 20 || top.CurrentCount != 220 {
+		t.Errorf("Expected counts 20 -> 220, got %d -> %d", top.BaselineCount, top.CurrentCount)
+	}
+	if store.countQueries != 1 {
+		t.Errorf("Expected every window counted in one query, got %d", store.countQueries)
+	}
+
+	// Once a stream is attached the metric is no longer unmapped, even without logs
+	metricID := clickhouse.NewMetricID("acme", "d", "p", "m")
+	store.SaveMapping(context.Background(), clickhouse.MetricMapping{OrgID: "acme", MetricID: metricID, LogStreamID: "stream_worker_east", Active: true})
+	result, err = analyzer.AnalyzeLogs(context.Background(), "acme", "d", "p", "m", start, end, AnalysisOptions{})
+	if err != nil {
+		t.Fatalf("AnalyzeLogs failed: %v", err)
+	}
+	if result.Unmapped || len(result.Suggestions) != 0 {
+		t.Errorf("Expected no suggestions for a mapped metric, got %+v", result.Suggestions)
+	}
+}
+
+func TestAnalyzeLogsCorrelatesStreamsWithMetric(t *testing.T) {
+	end := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	start := end.Add(-30 * time.Minute)
+	baselineStart := start.Add(-30 * time.Minute)
+
+	// One point every 10 minutes: each log falls in the bucket of the nearest point
+	series := []MetricPoint{
+		{Time: start.Add(5 * time.Minute), Value: 1},
+		{Time: start.Add(15 * time.Minute), Value: 5},
+		{Time: start.Add(25 * time.Minute), Value: 1},
+	}
+	store := &unmappedStore{streamCounts: map[time.Time]map[string]map[string]uint64{
+		// The API volume follows the metric without changing from the baseline overall
+		baselineStart: {"stream_api_east": {"request": 700}, "stream_worker_east": {"job": 10}},
+		start: {
+			"stream_api_east":    {"request": 100},
+			"stream_api_west":    {"spike": 0, "steady": 100},
+			"stream_worker_east": {"job": 300},
+		},
+		start.Add(10 * time.Minute): {
+			"stream_api_east":    {"request": 500},
+			"stream_api_west":    {"spike": 40, "steady": 60},
+			"stream_worker_east": {"job": 200},
+		},
+		start.Add(20 * time.Minute): {
+			"stream_api_east":    {"request": 100},
+			"stream_api_west":    {"spike": 0, "steady": 100},
+			"stream_worker_east": {"job": 100},
+		},
+	}}
+
+	result, err := NewLogAnalyzerWithStore(store).AnalyzeLogs(context.Background(), "acme", "d", "p", "m", start, end,
+		AnalysisOptions{MetricSeries: series})
+	if err != nil {
+		t.Fatalf("AnalyzeLogs failed: %v", err)
+	}
+	if store.countQueries != 1 {
+		t.Errorf("Expected windows and buckets counted in one query, got %d", store.countQueries)
+	}
+	// The worker changed most but doesn't follow the metric, so it isn't suggested
+	if len(result.Suggestions) != 2 {
+		t.Fatalf("Expected the 2 streams following the metric to be suggested, got %+v", result.Suggestions)
+	}
+
+	byID := make(map[string]StreamSuggestion)
+	for _, suggestion := range result.Suggestions {
+		byID[suggestion.LogStreamID] = suggestion
+	}
+	east, west := byID["stream_api_east"], byID["stream_api_west"]
+	if east.Correlation == nil || *east.Correlation < 0.999 || east.Score < 0.999 {
+		t.Errorf("Expected stream_api_east to follow the metric's volume, got %+v", east)
+	}
+	if east.BaselineCount != 700 || east.CurrentCount != 700 {
+		t.Errorf("Expected counts 700 -> 700, got %d -> %d", east.BaselineCount, east.CurrentCount)
+	}
+	if west.Correlation != nil || west.TemplateID != "spike" || west.TemplateCorrelation == nil || *west.TemplateCorrelation < 0.999 {
+		t.Errorf("Expected stream_api_west's spike template to follow the metric at flat volume, got %+v", west)
+	}
+
+	// Too few points in the window fall back to ranking by change
+	result, err = NewLogAnalyzerWithStore(store).AnalyzeLogs(context.Background(), "acme", "d", "p", "m", start, end,
+		AnalysisOptions{MetricSeries: series[:2]})
+	if err != nil {
+		t.Fatalf("AnalyzeLogs failed: %v", err)
+	}
+	if len(result.Suggestions) == 0 {
+		t.Fatal("Expected streams ranked by change")
+	}
+	for _, suggestion := range result.Suggestions {
+		if suggestion.Correlation != nil || suggestion.TemplateCorrelation != nil {
+			t.Errorf("Expected no correlation without enough metric points, got %+v", suggestion)
+		}
+	}
+}
+
+func TestPearson(t *testing.T) {
+	if r, ok := pearson([]float64{1, 2, 3}, []float64{10, 20, 30}); !ok || math.Abs(r-1) > 1e-12 {
+		t.Errorf("Expected perfect correlation, got %v (%v)", r, ok)
+	}
+	if r, ok := pearson([]float64{1, 2, 3}, []float64{3, 2, 1}); !ok || math.Abs(r+1) > 1e-12 {
+		t.Errorf("Expected perfect anti-correlation, got %v (%v)", r, ok)
+	}
+	if _, ok := pearson([]float64{1, 2, 3}, []float64{5, 5, 5}); ok {
+		t.Error("Expected no correlation with a flat series")
+	}
+}
+
+func TestValidateMetricSeries(t *testing.T) {
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	if err := ValidateMetricSeries([]MetricPoint{{Time: now, Value: 1}, {Time: now.Add(time.Minute), Value: 2}}); err != nil {
+		t.Errorf("Expected an ordered series to be valid, got %v", err)
+	}
+	for name, invalid := range map[string][]MetricPoint{
+		"unordered":  {{Time: now.Add(time.Minute)}, {Time: now}},
+		"duplicate":  {{Time: now}, {Time: now}},
+		"not finite": {{Time: now, Value: math.NaN()}},
+	} {
+		if err := ValidateMetricSeries(invalid); err == nil {
+			t.Errorf("Expected a %s series to be rejected", name)
+		}
+	}
+}
+
+func TestSuggestionCandidates(t *testing.T) {
+	updated := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	streams := make([]clickhouse.LogStream, MaxSuggestionCandidates+10)
+	for i := range streams {
+		streams[i] = clickhouse.LogStream{ID: fmt.Sprintf("stream_%03d", i), UpdatedAt: updated.Add(time.Duration(i) * time.Minute)}
+	}
+
+	candidates := suggestionCandidates(streams)
+	if len(candidates) != MaxSuggestionCandidates {
+		t.Fatalf("Expected %d candidates, got %d", MaxSuggestionCandidates, len(candidates))
+	}
+	if newest := streams[len(streams)-1].ID; candidates[0].ID != newest {
+		t.Errorf("Expected the most recently updated stream first, got %s", candidates[0].ID)
+	}
+	for _, stream := range candidates {
+		if stream.ID < "stream_010" {
+			t.Errorf("Expected the oldest streams left out, got %s", stream.ID)
+		}
+	}
+	if streams[0].ID != "stream_000" {
+		t.Error("Expected the listed streams to be left in order")
+	}
+}
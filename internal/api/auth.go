@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+)
+
+type orgKey struct{}
+
+// WithOrg returns a context whose requests are scoped to org, taking precedence over any
+// org named in the request itself
+func WithOrg(ctx context.Context, org string) context.Context {
+	return context.WithValue(ctx, orgKey{}, org)
+}
+
+// contextOrg returns the org a request was scoped to by WithOrg, if any
+func contextOrg(ctx context.Context) (string, bool) {
+	org, ok := ctx.Value(orgKey{}).(string)
+	return org, ok && org != ""
+}
+
+// WriteError writes an error response in the API's JSON format, for middleware that
+// wraps these handlers
+func WriteError(w http.ResponseWriter, status int, title, message string) {
+	writeJSONError(w, status, title, message)
+}
+
+// RequireToken wraps next so it only serves requests carrying one of tokens, either as a
+// bearer token or as a basic auth password, which is how Promtail and Alloy send one.
+// Changes made with a token are audited as made by its name. With no tokens configured
+// every request is refused.
+func RequireToken(tokens []config.TokenConfig, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := tokenName(tokens, requestToken(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hover"`)
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized", "A valid API token is required")
+			return
+		}
+		next(w, r.WithContext(WithActor(r.Context(), name)))
+	}
+}
+
+// requestToken returns the token a request carries, or "" if it has none
+func requestToken(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// tokenName returns the name of the configured token matching token. Every token is
+// compared, in constant time, so the response time doesn't reveal which one nearly matched.
+func tokenName(tokens []config.TokenConfig, token string) (string, bool) {
+	name, found := "", false
+	for _, t := range tokens {
+		if t.Token != "" && subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 && !found {
+			name, found = t.Name, true
+		}
+	}
+	if found && name == "" {
+		name = "token"
+	}
+	return name, found
+}
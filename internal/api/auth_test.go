@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+)
+
+func TestRequireToken(t *testing.T) {
+	tokens := []config.TokenConfig{{Name: "ops", Token: "s3cret"}, {Token: "unnamed"}}
+	var served string
+	handler := RequireToken(tokens, func(w http.ResponseWriter, r *http.Request) {
+		served = actor(r)
+	})
+
+	tests := []struct {
+		name      string
+		authorize func(r *http.Request)
+		want      string
+	}{
+		{"bearer token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, "ops"},
+		{"basic auth password", func(r *http.Request) { r.SetBasicAuth("promtail", "s3cret") }, "ops"},
+		{"unnamed token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer unnamed") }, "token"},
+		{"wrong token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cre") }, ""},
+		{"empty token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer ") }, ""},
+		{"no token", func(r *http.Request) {}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served = ""
+			r := httptest.NewRequest(http.MethodPost, "/mappings/metrics?org=acme", nil)
+			tt.authorize(r)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if tt.want == "" {
+				if w.Code != http.StatusUnauthorized || served != "" {
+					t.Errorf("Expected status 401 without serving, got %d", w.Code)
+				}
+				return
+			}
+			if served != tt.want {
+				t.Errorf("Expected the request served as %q, got %q (status %d)", tt.want, served, w.Code)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/mappings/audit?org=acme", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	RequireToken(nil, func(http.ResponseWriter, *http.Request) {})(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected every request refused without configured tokens, got %d", w.Code)
+	}
+}
+
+func TestWithOrgOverridesRequestedOrg(t *testing.T) {
+	mux := newMappingMux(newMockMappingHandler())
+
+	body := `{"dashboard":"API","panel_title":"Errors","metric_name":"error_rate"}`
+	r := httptest.NewRequest(http.MethodPost, "/mappings/metrics?org=globex", strings.NewReader(body))
+	r = r.WithContext(WithOrg(r.Context(), "acme"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if metric := decodeBody[clickhouse.Metric](t, w); metric.OrgID != "acme" {
+		t.Errorf("Expected the metric registered in the scoped org, got %q", metric.OrgID)
+	}
+}
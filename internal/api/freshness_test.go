@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/cache"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+// gatedStore counts template count queries and blocks them while gate is set
+type gatedStore struct {
+	clickhouse.MockStore
+	calls atomic.Int32
+	gate  atomic.Pointer[chan struct{}]
+}
+
+func (s *gatedStore) GetTemplateCounts(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time) (map[string]uint64, error) {
+	s.calls.Add(1)
+	if gate := s.gate.Load(); gate != nil {
+		<-*gate
+	}
+	return s.MockStore.GetTemplateCounts(ctx, org, dashboard, panelTitle, metricName, startTime, endTime)
+}
+
+func queryWindow(handler *Handler, start, end time.Time) *httptest.ResponseRecorder {
+	bodyBytes, _ := json.Marshal(QueryLogsRequest{
+		Org:        "test-org",
+		Dashboard:  "test-dashboard",
+		PanelTitle: "test-panel",
+		MetricName: "test-metric",
+		StartTime:  start,
+		EndTime:    end,
+	})
+	w := httptest.NewRecorder()
+	handler.QueryLogs(w, httptest.NewRequest(http.MethodPost, "/query_logs", bytes.NewReader(bodyBytes)))
+	return w
+}
+
+func TestEntryTTLs(t *testing.T) {
+	handler := &Handler{
+		cacheTTL:        10 * time.Second,
+		staleTTL:        time.Minute,
+		historicalAfter: 5 * time.Minute,
+		historicalTTL:   24 * time.Hour,
+	}
+
+	tests := []struct {
+		name      string
+		end       time.Time
+		wantFresh time.Duration
+		wantStale time.Duration
+	}{
+		{"live window", time.Now(), 10 * time.Second, time.Minute},
+		{"recently ended", time.Now().Add(-time.Minute), 10 * time.Second, time.Minute},
+		{"historical window", time.Now().Add(-3 * time.Hour), 24 * time.Hour, 0},
+		{"unknown end", time.Time{}, 10 * time.Second, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fresh, stale := handler.entryTTLs(tt.end)
+			if fresh != tt.wantFresh || stale != tt.wantStale {
+				t.Errorf("Expected fresh=%v stale=%v, got fresh=%v stale=%v", tt.wantFresh, tt.wantStale, fresh, stale)
+			}
+		})
+	}
+
+	// Without a historical TTL every window is treated as live
+	handler.historicalTTL = 0
+	if fresh, _ := handler.entryTTLs(time.Now().Add(-3 * time.Hour)); fresh != 10*time.Second {
+		t.Errorf("Expected live TTL when historical caching is disabled, got %v", fresh)
+	}
+}
+
+func TestHistoricalWindowCachedLonger(t *testing.T) {
+	store := &gatedStore{}
+	handler := &Handler{
+		analyzer:        analyzer.NewLogAnalyzerWithStore(store),
+		cache:           cache.NewLRU(10),
+		cacheTTL:        20 * time.Millisecond,
+		historicalAfter: 5 * time.Minute,
+		historicalTTL:   time.Hour,
+	}
+
+	end := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	if w := queryWindow(handler, end.Add(-time.Hour), end); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	calls := store.calls.Load()
+
+	// Well past the live TTL, the historical result is still fresh
+	time.Sleep(50 * time.Millisecond)
+	if w := queryWindow(handler, end.Add(-time.Hour), end); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if store.calls.Load() != calls {
+		t.Errorf("Expected historical window to be served from cache, got %d more queries", store.calls.Load()-calls)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	store := &gatedStore{}
+	handler := &Handler{
+		analyzer: analyzer.NewLogAnalyzerWithStore(store),
+		cache:    cache.NewLRU(10),
+		cacheTTL: 20 * time.Millisecond,
+		staleTTL: time.Minute,
+	}
+
+	end := time.Now()
+	start := end.Add(-time.Hour)
+	if w := queryWindow(handler, start, end); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	calls := store.calls.Load()
+	time.Sleep(40 * time.Millisecond)
+
+	// Block the refresh; the stale result must still be served without waiting for it
+	gate := make(chan struct{})
+	store.gate.Store(&gate)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- queryWindow(handler, start, end) }()
+	select {
+	case w := <-done:
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stale request blocked on the background refresh")
+	}
+
+	// Further requests keep getting the stale result without starting another refresh
+	for i := 0; i < 3; i++ {
+		if w := queryWindow(handler, start, end); w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+	}
+
+	store.gate.Store(nil)
+	close(gate)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if handler.inFlight.len() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Background refresh did not complete")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// One refresh ran, issuing the same queries as the original analysis
+	if got := store.calls.Load(); got != 2*calls {
+		t.Errorf("Expected a single refresh (%d queries), got %d", calls, got-calls)
+	}
+}
@@ -1,42 +1,67 @@
 package api
 
 import (
-	"container/list"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/cache"
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
-)
-
-type cacheEntry struct {
-	key       string
-	logGroups []analyzer.LogGroup
-	expiresAt time.Time
-}
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/ingest"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/logging"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/metrics"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/tracing"
 
-type inFlightRequest struct {
-	done       chan struct{}
-	result     []analyzer.LogGroup
-	err        error
-	resultOnce sync.Once
-}
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
 
 type Handler struct {
-	analyzer     *analyzer.LogAnalyzer
-	cache        map[string]*list.Element // map key to list element
-	cacheList    *list.List                // doubly-linked list for LRU order
-	cacheMu      sync.Mutex
-	cacheTTL     time.Duration
-	cacheMaxSize int
-	inFlight     map[string]*inFlightRequest
-	inFlightMu   sync.Mutex
+	// analyzerMu guards analyzer and mock, which change when ClickHouse recovers
+	analyzerMu sync.RWMutex
+	analyzer   *analyzer.LogAnalyzer
+	// mock is true while analyzer is backed by clickhouse.MockStore
+	mock bool
+	// resilient is the circuit-breaking ClickHouse store, nil in mock mode
+	resilient     *clickhouse.ResilientStore
+	mode          config.StoreMode
+	clickhouseURL string
+
+	cache cache.Cache
+	// cacheScope names the ClickHouse database results come from. It is part of every cache
+	// key, because a disk cache outlives the handler and is shared by every instance using
+	// its path, whichever database they query.
+	cacheScope string
+	cacheTTL   time.Duration
+	// staleTTL is how long a live entry may be served past cacheTTL while it is refreshed
+	// in the background, zero disables it
+	staleTTL time.Duration
+	// historicalTTL applies to windows that ended at least historicalAfter ago, zero disables it
+	historicalAfter time.Duration
+	historicalTTL   time.Duration
+	// quantization snaps request windows outward before keying and querying, zero disables it
+	quantization time.Duration
+	inFlight     inFlightGroup
+	// significance is applied when a request doesn't choose its own mode or level
+	significance analyzer.SignificanceOptions
+	// metrics is nil when the handler isn't instrumented
+	metrics *metrics.Metrics
+	// ingester mines and buffers the lines received by the ingestion endpoints
+	ingester *ingest.Ingester
+	// stop ends background goroutines when the handler is closed
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
 type QueryLogsRequest struct {
@@ -46,6 +71,22 @@ type QueryLogsRequest struct {
 	MetricName string    `json:"metric_name"`
 	StartTime  time.Time `json:"start_time"`
 	EndTime    time.Time `json:"end_time"`
+	// Baseline selects the baseline strategy, defaults to previous_window
+	Baseline string `json:"baseline,omitempty"`
+	// BaselineWindowCount is the number of windows for the median strategy
+	BaselineWindowCount int `json:"baseline_window_count,omitempty"`
+	// Scorer selects the ranking method, defaults to js_divergence
+	Scorer string `json:"scorer,omitempty"`
+	// SignificanceMode ("off", "flag" or "drop") and SignificanceLevel override the server defaults
+	SignificanceMode  string  `json:"significance_mode,omitempty"`
+	SignificanceLevel float64 `json:"significance_level,omitempty"`
+	// GroupBy breaks each returned template down by service, region and/or log_stream_id
+	GroupBy []string `json:"group_by,omitempty"`
+	// BucketWidth is a duration such as "1m"; when set each template includes a bucketed time series
+	BucketWidth string `json:"bucket_width,omitempty"`
+	// MetricSeries holds the hovered metric's points; for an unmapped metric, suggested streams
+	// are ranked by how closely their logs follow it
+	MetricSeries []analyzer.MetricPoint `json:"metric_series,omitempty"`
 }
 
 type LogGroup struct {
@@ -54,39 +95,117 @@ type LogGroup struct {
 }
 
 type QueryLogsResponse struct {
-	LogGroups []LogGroup `json:"log_groups"`
+	LogGroups        []LogGroup            `json:"log_groups"`
+	BaselineStrategy string                `json:"baseline_strategy,omitempty"`
+	BaselineWindows  []analyzer.TimeWindow `json:"baseline_windows,omitempty"`
+	// Unmapped is set when the metric has no active log streams; Suggestions then lists
+	// streams that may belong to it, best first
+	Unmapped    bool                        `json:"unmapped,omitempty"`
+	Suggestions []analyzer.StreamSuggestion `json:"suggestions,omitempty"`
+	// Mock is set when the log groups are sample data rather than ClickHouse results
+	Mock bool `json:"mock,omitempty"`
 }
 
+// MockDataHeader is set to "true" on every response from a handler serving mock data
+const MockDataHeader = "X-Hover-Mock-Data"
+
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message"`
 	Code    *int   `json:"code,omitempty"`
 }
 
+// Cache settings used when the configuration leaves them unset
+const (
+	defaultCacheTTL        = 10 * time.Second
+	defaultCacheMaxSize    = 10
+	defaultHistoricalAfter = 5 * time.Minute
+	// defaultSignificanceMode drops templates whose change isn't significant, so quiet windows
+	// return nothing, matching the analysis.significance_mode default of config.Load
+	defaultSignificanceMode = analyzer.SignificanceDrop
+)
+
+// revalidateTimeout bounds a background refresh of a stale cache entry
+const revalidateTimeout = 30 * time.Second
+
 func NewHandler(cfg *config.Config) *Handler {
-	var logAnalyzer *analyzer.LogAnalyzer
+	h := &Handler{
+		clickhouseURL:   cfg.ClickHouse.URL,
+		cacheScope:      cacheScope(cfg.ClickHouse),
+		cacheTTL:        defaultCacheTTL,
+		staleTTL:        cfg.Cache.StaleTTL,
+		historicalAfter: defaultHistoricalAfter,
+		historicalTTL:   cfg.Cache.HistoricalTTL,
+		quantization:    cfg.Cache.Quantization,
+		metrics:         metrics.Default(),
+		stop:            make(chan struct{}),
+		significance: analyzer.SignificanceOptions{
+			Mode:  analyzer.SignificanceMode(cfg.Analysis.SignificanceMode),
+			Level: cfg.Analysis.SignificanceLevel,
+		},
+	}
 
-	// Try to connect to real ClickHouse
-	realAnalyzer, err := analyzer.NewLogAnalyzer(&cfg.ClickHouse)
+	if cfg.Cache.TTL > 0 {
+		h.cacheTTL = cfg.Cache.TTL
+	}
+	if cfg.Cache.HistoricalAfter > 0 {
+		h.historicalAfter = cfg.Cache.HistoricalAfter
+	}
+	if h.significance.Mode == "" {
+		h.significance.Mode = defaultSignificanceMode
+	}
+	if h.staleTTL < 0 {
+		h.staleTTL = 0
+	}
+	h.cache = newCache(cfg.Cache)
+	h.cache.OnEvict(h.metrics.CacheEvicted)
+	if h.quantization < 0 {
+		h.quantization = 0
+	}
+
+	mode, err := config.ParseStoreMode(string(cfg.ClickHouse.Mode))
 	if err != nil {
-		log.Printf("Warning: Failed to connect to ClickHouse: %v", err)
-		log.Printf("Using mock ClickHouse with sample data")
+		// Never serve mock data by accident because of a typo
+		slog.Error("Invalid store mode, failing closed", "error", err)
+		mode = config.StoreModeFailClosed
+	}
+	h.mode = mode
 
-		// Use mock ClickHouse store
-		mockStore := clickhouse.NewMockStore()
-		logAnalyzer = analyzer.NewLogAnalyzerWithStore(mockStore)
+	if mode == config.StoreModeMock {
+		slog.Info("Serving mock ClickHouse data", "mode", mode)
+		h.analyzer = analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore())
+		h.mock = true
 	} else {
-		logAnalyzer = realAnalyzer
+		// Connect to real ClickHouse through a circuit breaker that keeps reconnecting in the background
+		clickhouseCfg := cfg.ClickHouse
+		resilient, err := clickhouse.NewResilientStore(func() (clickhouse.Store, error) {
+			client, err := clickhouse.NewClient(&clickhouseCfg)
+			if err != nil {
+				return nil, err
+			}
+			return clickhouse.NewObservedStore(client, h.metrics), nil
+		}, clickhouse.ResilienceOptions{
+			FailureThreshold: cfg.ClickHouse.FailureThreshold,
+			InitialBackoff:   cfg.ClickHouse.RetryInterval,
+			MaxBackoff:       cfg.ClickHouse.MaxRetryInterval,
+		})
+		h.resilient = resilient
+		h.analyzer = analyzer.NewLogAnalyzerWithStore(resilient)
+
+		if err != nil {
+			slog.Warn("Failed to connect to ClickHouse", "url", cfg.ClickHouse.URL, "error", err)
+			if mode == config.StoreModeClickHouse {
+				slog.Warn("Using mock ClickHouse with sample data until the connection succeeds")
+				h.analyzer = analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore())
+				h.mock = true
+				resilient.OnRecover(h.useClickHouse)
+			} else {
+				slog.Warn("Queries will fail until the ClickHouse connection succeeds", "mode", mode)
+			}
+		}
 	}
 
-	h := &Handler{
-		analyzer:     logAnalyzer,
-		cache:        make(map[string]*list.Element),
-		cacheList:    list.New(),
-		cacheTTL:     10 * time.Second,
-		cacheMaxSize: 10,
-		inFlight:     make(map[string]*inFlightRequest),
-	}
+	h.ingester = h.newIngester(cfg.Ingest)
 
 	// Start background cleanup goroutine
 	go h.cleanupExpiredCache()
@@ -94,20 +213,154 @@ func NewHandler(cfg *config.Config) *Handler {
 	return h
 }
 
+// newCache opens the configured cache backend, falling back to memory if the disk cache
+// can't be opened so that a bad path never stops the plugin from serving
+func newCache(cfg config.CacheConfig) cache.Cache {
+	maxSize := defaultCacheMaxSize
+	if cfg.MaxSize > 0 {
+		maxSize = cfg.MaxSize
+	}
+
+	switch cfg.Backend {
+	case "", cache.BackendMemory:
+	case cache.BackendDisk:
+		path := cfg.Path
+		if path == "" {
+			path = filepath.Join(os.TempDir(), "hover-hover-panel", "cache.db")
+		}
+		disk, err := cache.OpenDisk(path, cfg.MaxBytes)
+		if err == nil {
+			slog.Info("Using disk cache", "path", path, "entries", disk.Len())
+			return disk
+		}
+		slog.Error("Failed to open disk cache, using the in-memory cache instead", "error", err)
+	default:
+		slog.Error("Unknown cache backend, using the in-memory cache instead",
+			"backend", cfg.Backend, "expected", []string{cache.BackendMemory, cache.BackendDisk})
+	}
+	return cache.NewLRU(maxSize)
+}
+
+// useClickHouse replaces mock data with the ClickHouse store once it has recovered
+func (h *Handler) useClickHouse() {
+	if !h.UsingMockData() {
+		return
+	}
+	if err := h.resilient.VerifyTables(); err != nil {
+		slog.Warn("Failed to verify ClickHouse tables after reconnecting", "error", err)
+	}
+
+	h.analyzerMu.Lock()
+	defer h.analyzerMu.Unlock()
+	if !h.mock {
+		return
+	}
+	h.analyzer = analyzer.NewLogAnalyzerWithStore(h.resilient)
+	h.mock = false
+	slog.Info("Connected to ClickHouse, no longer serving mock data", "url", h.clickhouseURL)
+}
+
+// currentAnalyzer returns the analyzer to query and whether it serves mock data
+func (h *Handler) currentAnalyzer() (*analyzer.LogAnalyzer, bool) {
+	h.analyzerMu.RLock()
+	defer h.analyzerMu.RUnlock()
+	return h.analyzer, h.mock
+}
+
+// UsingMockData reports whether responses are currently mock data
+func (h *Handler) UsingMockData() bool {
+	_, mock := h.currentAnalyzer()
+	return mock
+}
+
 func (h *Handler) VerifyTables() error {
-	return h.analyzer.VerifyTables()
+	an, _ := h.currentAnalyzer()
+	return an.VerifyTables()
+}
+
+// unavailableError explains why ClickHouse can't be queried
+func (h *Handler) unavailableError() error {
+	if h.resilient == nil {
+		return fmt.Errorf("ClickHouse at %s is not configured", h.clickhouseURL)
+	}
+	status := h.resilient.Status()
+	if status.LastError != "" {
+		return fmt.Errorf("ClickHouse at %s is unavailable: %s", h.clickhouseURL, status.LastError)
+	}
+	return fmt.Errorf("ClickHouse at %s is unavailable", h.clickhouseURL)
+}
+
+// Close stops background goroutines, flushes ingested lines and closes the underlying store
+func (h *Handler) Close() error {
+	h.closeOnce.Do(func() {
+		if h.stop != nil {
+			close(h.stop)
+		}
+	})
+	if h.ingester != nil {
+		if err := h.ingester.Close(); err != nil {
+			slog.Error("Failed to flush ingested logs", "error", err)
+		}
+	}
+	if h.cache != nil {
+		if err := h.cache.Close(); err != nil {
+			slog.Warn("Failed to close cache", "error", err)
+		}
+	}
+	an, mock := h.currentAnalyzer()
+	if mock && h.resilient != nil {
+		h.resilient.Close()
+	}
+	return an.Close()
+}
+
+// quantizeWindow widens [start, end) to multiples of granularity since the Unix epoch
+func quantizeWindow(start, end time.Time, granularity time.Duration) (time.Time, time.Time) {
+	if granularity <= 0 {
+		return start, end
+	}
+	floor := func(t time.Time) time.Time {
+		ns := t.UnixNano()
+		snapped := ns - ns%int64(granularity)
+		if ns < 0 && ns%int64(granularity) != 0 {
+			snapped -= int64(granularity)
+		}
+		return time.Unix(0, snapped).In(t.Location())
+	}
+
+	snappedEnd := floor(end)
+	if snappedEnd.Before(end) {
+		snappedEnd = snappedEnd.Add(granularity)
+	}
+	return floor(start), snappedEnd
 }
 
-// generateCacheKey creates a unique cache key from request parameters
+// cacheScope identifies the ClickHouse database a handler reads from, by its address,
+// database and user
+func cacheScope(cfg config.ClickHouseConfig) string {
+	return strings.Join([]string{cfg.URL, cfg.Database, cfg.User}, "|")
+}
+
+// generateCacheKey creates a unique cache key from request parameters and the database
+// they are answered from
 func (h *Handler) generateCacheKey(req *QueryLogsRequest) string {
 	// Create a deterministic key from all request parameters
-	key := fmt.Sprintf("%s|%s|%s|%s|%d|%d",
+	key := fmt.Sprintf("%s|%s|%s|%s|%s|%d|%d|%s|%d|%s|%s|%g|%s|%s|%s",
+		h.cacheScope,
 		req.Org,
 		req.Dashboard,
 		req.PanelTitle,
 		req.MetricName,
 		req.StartTime.Unix(),
 		req.EndTime.Unix(),
+		req.Baseline,
+		req.BaselineWindowCount,
+		req.Scorer,
+		req.SignificanceMode,
+		req.SignificanceLevel,
+		strings.Join(req.GroupBy, ","),
+		req.BucketWidth,
+		metricSeriesKey(req.MetricSeries),
 	)
 
 	// Hash the key to keep it compact
@@ -115,59 +368,57 @@ func (h *Handler) generateCacheKey(req *QueryLogsRequest) string {
 	return fmt.Sprintf("%x", hash)
 }
 
-// getCachedResultOrWait attempts to retrieve a cached result or waits for an in-flight request
-func (h *Handler) getCachedResultOrWait(key string) ([]analyzer.LogGroup, error, bool) {
-	// First check cache
-	h.cacheMu.Lock()
-	elem, exists := h.cache[key]
-	if exists {
-		entry := elem.Value.(*cacheEntry)
-		if time.Now().Before(entry.expiresAt) {
-			// Move to front (most recently used) - O(1)
-			h.cacheList.MoveToFront(elem)
-			logGroups := entry.logGroups
-			h.cacheMu.Unlock()
-			log.Printf("Cache HIT for key: %s", truncateKey(key))
-			return logGroups, nil, true
-		}
-		// Expired, remove it
-		h.cacheList.Remove(elem)
-		delete(h.cache, key)
-	}
-	h.cacheMu.Unlock()
-
-	// Check if there's an in-flight request
-	h.inFlightMu.Lock()
-	inflight, inProgress := h.inFlight[key]
-	h.inFlightMu.Unlock()
-
-	if inProgress {
-		log.Printf("Waiting for in-flight request: %s", truncateKey(key))
-		// Wait for the result from the in-flight request
-		<-inflight.done
-		if inflight.err != nil {
-			log.Printf("Received error from in-flight request: %s", truncateKey(key))
-			return nil, inflight.err, true
-		}
-		log.Printf("Received result from in-flight request: %s", truncateKey(key))
-		return inflight.result, nil, true
+// metricSeriesKey encodes a metric series for the cache key
+func metricSeriesKey(points []analyzer.MetricPoint) string {
+	parts := make([]string, len(points))
+	for i, point := range points {
+		parts[i] = fmt.Sprintf("%d:%g", point.Time.UnixMilli(), point.Value)
 	}
-
-	return nil, nil, false
+	return strings.Join(parts, ",")
 }
 
-// startInFlightRequest registers a new in-flight request
-func (h *Handler) startInFlightRequest(key string) *inFlightRequest {
-	h.inFlightMu.Lock()
-	defer h.inFlightMu.Unlock()
+// revalidate refreshes a stale entry in the background unless a request for key is already
+// running. The refresh is traced on its own, linked to the request that found the entry stale.
+func (h *Handler) revalidate(requestCtx context.Context, key string, analyze analyzeFunc) {
+	link := trace.LinkFromContext(requestCtx)
+	h.inFlight.startBackground(key, func(ctx context.Context) (result *analyzer.AnalysisResult, err error) {
+		// Not tied to the request that found the entry stale, which has already been answered
+		ctx, cancel := context.WithTimeout(ctx, revalidateTimeout)
+		defer cancel()
+		ctx, span := tracing.Start(ctx, "Handler.revalidate")
+		span.AddLink(link)
+		defer func() { tracing.End(span, err) }()
+
+		result, err = analyze(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to revalidate, keeping the stale entry", "key", truncateKey(key), "error", err)
+			return nil, err
+		}
+		h.storeResult(key, result)
+		return result, nil
+	})
+}
 
-	req := &inFlightRequest{
-		done: make(chan struct{}),
+// entryTTLs returns how long a result for a window ending at end stays fresh, and how much
+// longer it may be served stale. Windows that ended long ago won't change, so they stay fresh
+// for much longer.
+func (h *Handler) entryTTLs(end time.Time) (fresh, stale time.Duration) {
+	if h.historicalTTL > 0 && !end.IsZero() && time.Since(end) >= h.historicalAfter {
+		return h.historicalTTL, 0
 	}
-	h.inFlight[key] = req
+	return h.cacheTTL, h.staleTTL
+}
 
-	log.Printf("Started in-flight request for key: %s", truncateKey(key))
-	return req
+// cacheResult names the outcome of a cache lookup for tracing
+func cacheResult(entry cache.Entry, ok bool) string {
+	switch {
+	case !ok:
+		return "miss"
+	case entry.Stale():
+		return "stale"
+	default:
+		return "hit"
+	}
 }
 
 func truncateKey(key string) string {
@@ -177,67 +428,35 @@ func truncateKey(key string) string {
 	return key
 }
 
-// evictLRUEntry removes the least recently used cache entry - O(1)
-func (h *Handler) evictLRUEntry() {
-	// Remove from back of list (least recently used)
-	elem := h.cacheList.Back()
-	if elem != nil {
-		h.cacheList.Remove(elem)
-		entry := elem.Value.(*cacheEntry)
-		delete(h.cache, entry.key)
-		log.Printf("Cache LRU eviction: removed key %s", truncateKey(entry.key))
-	}
+// storeResult caches result with a TTL based on how long ago its window ended
+func (h *Handler) storeResult(key string, result *analyzer.AnalysisResult) {
+	fresh, stale := h.entryTTLs(result.CurrentWindow.End)
+	h.cache.Set(key, cache.Entry{Result: result, StaleAt: time.Now().Add(fresh)}, fresh+stale)
+	slog.Debug("Cache set", "key", truncateKey(key), "ttl", fresh, "stale_for", stale, "size", h.cache.Len())
 }
 
-// completeInFlightRequest broadcasts the result to all waiters and stores in cache
-func (h *Handler) completeInFlightRequest(key string, logGroups []analyzer.LogGroup, err error) {
-	h.inFlightMu.Lock()
-	req, exists := h.inFlight[key]
-	delete(h.inFlight, key)
-	h.inFlightMu.Unlock()
-
-	if !exists {
-		return
-	}
-
-	// Store result in the request object
-	req.resultOnce.Do(func() {
-		req.result = logGroups
-		req.err = err
+// QueryLogs handles the original query_logs/analyze API
+func (h *Handler) QueryLogs(w http.ResponseWriter, r *http.Request) {
+	h.serveQuery(w, r, "Handler.QueryLogs", func(result *analyzer.AnalysisResult, mock bool) interface{} {
+		resp := newQueryLogsResponse(result)
+		resp.Mock = mock
+		return resp
 	})
+}
 
-	// Store in cache if successful
-	if err == nil {
-		h.cacheMu.Lock()
-
-		// Evict LRU entry if cache is at max size - O(1)
-		if h.cacheList.Len() >= h.cacheMaxSize {
-			h.evictLRUEntry()
-		}
-
-		// Add to front of list (most recently used) - O(1)
-		entry := &cacheEntry{
-			key:       key,
-			logGroups: logGroups,
-			expiresAt: time.Now().Add(h.cacheTTL),
-		}
-		elem := h.cacheList.PushFront(entry)
-		h.cache[key] = elem
-
-		h.cacheMu.Unlock()
-		log.Printf("Cache SET for key: %s (TTL: %v, size: %d/%d)", truncateKey(key), h.cacheTTL, h.cacheList.Len(), h.cacheMaxSize)
-	}
-
-	// Broadcast to all waiters by closing the done channel
-	if err != nil {
-		log.Printf("Broadcasted error for key: %s", truncateKey(key))
-	} else {
-		log.Printf("Broadcasted result for key: %s", truncateKey(key))
+// serveQuery validates a query request, runs the (cached, coalesced) analysis
+// and writes the result using the given response renderer
+func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request, spanName string, render func(result *analyzer.AnalysisResult, mock bool) interface{}) {
+	r, span := tracing.StartRequest(r, spanName)
+	defer span.End()
+
+	logAnalyzer, mock := h.currentAnalyzer()
+	span.SetAttributes(attribute.Bool("hover.mock", mock))
+	if mock {
+		w.Header().Set(MockDataHeader, "true")
+		h.metrics.MockResponse()
 	}
-	close(req.done)
-}
 
-func (h *Handler) QueryLogs(w http.ResponseWriter, r *http.Request) {
 	// Only allow POST
 	if r.Method != http.MethodPost {
 		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST is allowed")
@@ -249,6 +468,9 @@ func (h *Handler) QueryLogs(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
 		return
 	}
+	if org, ok := contextOrg(r.Context()); ok {
+		req.Org = org
+	}
 
 	// Validate required fields
 	if req.Org == "" || req.Dashboard == "" || req.PanelTitle == "" || req.MetricName == "" {
@@ -262,72 +484,167 @@ func (h *Handler) QueryLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Processing log query - org: %s, dashboard: %s, panel: %s, metric: %s, time range: %v to %v",
-		req.Org, req.Dashboard, req.PanelTitle, req.MetricName, req.StartTime, req.EndTime)
+	// Snap the window so nearby hovers share a cache entry and query the same range
+	req.StartTime, req.EndTime = quantizeWindow(req.StartTime, req.EndTime, h.quantization)
 
-	// Generate cache key
-	cacheKey := h.generateCacheKey(&req)
+	opts := analyzer.AnalysisOptions{
+		Baseline: analyzer.BaselineOptions{
+			Strategy: analyzer.BaselineStrategy(req.Baseline),
+			Windows:  req.BaselineWindowCount,
+		},
+	}
+	if err := opts.Baseline.Validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid baseline", err.Error())
+		return
+	}
+
+	scorer, err := analyzer.ParseScorer(req.Scorer)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid scorer", err.Error())
+		return
+	}
+	opts.Scorer = scorer
+
+	opts.Significance = h.significance
+	if req.SignificanceMode != "" {
+		opts.Significance.Mode = analyzer.SignificanceMode(req.SignificanceMode)
+	}
+	if req.SignificanceLevel != 0 {
+		opts.Significance.Level = req.SignificanceLevel
+	}
+	if err := opts.Significance.Validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid significance", err.Error())
+		return
+	}
+
+	if err := clickhouse.ValidateDimensions(req.GroupBy); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid group_by", err.Error())
+		return
+	}
+	opts.GroupBy = req.GroupBy
 
-	// Check cache or wait for in-flight request
-	if cachedLogGroups, cachedErr, found := h.getCachedResultOrWait(cacheKey); found {
-		// If we got an error from a waiter, return error response
-		if cachedErr != nil {
-			log.Printf("Using cached error result: %v", cachedErr)
-			writeJSONError(w, http.StatusInternalServerError, "Query failed", cachedErr.Error())
+	if req.BucketWidth != "" {
+		width, err := time.ParseDuration(req.BucketWidth)
+		if err == nil {
+			err = analyzer.ValidateBucketWidth(width, req.EndTime.Sub(req.StartTime))
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid bucket_width", err.Error())
 			return
 		}
+		opts.BucketWidth = width
+	}
 
-		// Convert to API response format
-		apiLogGroups := make([]LogGroup, len(cachedLogGroups))
-		for i, group := range cachedLogGroups {
-			apiLogGroups[i] = LogGroup{
-				RepresentativeLogs: group.RepresentativeLogs,
-				RelativeChange:     group.RelativeChange,
-			}
+	if err := analyzer.ValidateMetricSeries(req.MetricSeries); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid metric_series", err.Error())
+		return
+	}
+	opts.MetricSeries = req.MetricSeries
+
+	r = r.WithContext(logging.With(r.Context(), "org", req.Org))
+	slog.DebugContext(r.Context(), "Processing log query", "dashboard", req.Dashboard, "panel", req.PanelTitle,
+		"metric", req.MetricName, "start", req.StartTime, "end", req.EndTime)
+	span.SetAttributes(tracing.QueryAttributes(req.Org, req.Dashboard, req.PanelTitle, req.MetricName, req.StartTime, req.EndTime)...)
+
+	// Generate cache key
+	cacheKey := h.generateCacheKey(&req)
+	if mock {
+		// Keep mock results apart so they are never served once ClickHouse is connected
+		cacheKey = "mock:" + cacheKey
+	}
+
+	analyze := func(ctx context.Context) (*analyzer.AnalysisResult, error) {
+		result, err := logAnalyzer.AnalyzeLogs(
+			ctx,
+			req.Org,
+			req.Dashboard,
+			req.PanelTitle,
+			req.MetricName,
+			req.StartTime,
+			req.EndTime,
+			opts,
+		)
+		if err == nil {
+			h.metrics.AnalysisCompleted(len(result.LogGroups))
 		}
+		return result, err
+	}
 
-		writeJSON(w, http.StatusOK, QueryLogsResponse{
-			LogGroups: apiLogGroups,
-		})
+	// Serve a stale live result right away and refresh it in the background
+	_, cacheSpan := tracing.Start(r.Context(), "Handler.cacheLookup")
+	entry, ok := h.cache.Get(cacheKey)
+	cacheSpan.SetAttributes(attribute.String("hover.cache.result", cacheResult(entry, ok)))
+	cacheSpan.End()
+	if ok {
+		h.metrics.CacheHit(entry.Stale())
+		span.SetAttributes(tracing.TemplateCountKey.Int(len(entry.Result.LogGroups)))
+		if entry.Stale() {
+			slog.DebugContext(r.Context(), "Cache stale, revalidating", "key", truncateKey(cacheKey))
+			h.revalidate(r.Context(), cacheKey, analyze)
+		} else {
+			slog.DebugContext(r.Context(), "Cache hit", "key", truncateKey(cacheKey))
+		}
+		writeJSON(w, http.StatusOK, render(entry.Result, mock))
 		return
 	}
 
-	// Start in-flight request tracking
-	h.startInFlightRequest(cacheKey)
+	h.metrics.CacheMiss()
 
-	// Analyze logs using KL divergence
-	logGroups, err := h.analyzer.AnalyzeLogs(
-		r.Context(),
-		req.Org,
-		req.Dashboard,
-		req.PanelTitle,
-		req.MetricName,
-		req.StartTime,
-		req.EndTime,
-	)
+	// Join the analysis already running for this key or start it. It runs under a shared
+	// context, so this request going away doesn't fail everyone else waiting on the same key.
+	flightCtx, flightSpan := tracing.Start(r.Context(), "Handler.singleFlight")
+	result, err, shared := h.inFlight.do(flightCtx, cacheKey, func(ctx context.Context) (*analyzer.AnalysisResult, error) {
+		// The shared context carries no span; trace the analysis under the request that started it
+		ctx = trace.ContextWithSpanContext(ctx, flightSpan.SpanContext())
 
-	// Complete the in-flight request (broadcasts to waiters and stores in cache)
-	h.completeInFlightRequest(cacheKey, logGroups, err)
+		// An analysis that finished since the cache check above has already been stored
+		if entry, ok := h.cache.Get(cacheKey); ok {
+			return entry.Result, nil
+		}
+		result, err := analyze(ctx)
+		if err != nil {
+			return nil, err
+		}
+		h.storeResult(cacheKey, result)
+		return result, nil
+	})
+	flightSpan.SetAttributes(attribute.Bool("hover.singleflight.shared", shared))
+	tracing.End(flightSpan, err)
+	if shared {
+		h.metrics.SingleFlightJoined()
+		slog.DebugContext(r.Context(), "Received shared result from in-flight request", "key", truncateKey(cacheKey))
+	}
 
 	// If error occurred, return error response
 	if err != nil {
-		log.Printf("Error analyzing logs: %v", err)
-		writeJSONError(w, http.StatusInternalServerError, "Query failed", err.Error())
+		slog.ErrorContext(r.Context(), "Failed to analyze logs", "error", err)
+		tracing.Fail(span, err)
+		writeQueryError(w, err)
 		return
 	}
 
-	// Convert to API response format
-	apiLogGroups := make([]LogGroup, len(logGroups))
-	for i, group := range logGroups {
+	span.SetAttributes(tracing.TemplateCountKey.Int(len(result.LogGroups)))
+
+	writeJSON(w, http.StatusOK, render(result, mock))
+}
+
+// newQueryLogsResponse converts an analysis result to the API response format
+func newQueryLogsResponse(result *analyzer.AnalysisResult) QueryLogsResponse {
+	apiLogGroups := make([]LogGroup, len(result.LogGroups))
+	for i, group := range result.LogGroups {
 		apiLogGroups[i] = LogGroup{
 			RepresentativeLogs: group.RepresentativeLogs,
 			RelativeChange:     group.RelativeChange,
 		}
 	}
 
-	writeJSON(w, http.StatusOK, QueryLogsResponse{
-		LogGroups: apiLogGroups,
-	})
+	return QueryLogsResponse{
+		LogGroups:        apiLogGroups,
+		BaselineStrategy: string(result.BaselineStrategy),
+		BaselineWindows:  result.BaselineWindows,
+		Unmapped:         result.Unmapped,
+		Suggestions:      result.Suggestions,
+	}
 }
 
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -344,6 +661,15 @@ func writeJSONError(w http.ResponseWriter, status int, error, message string) {
 	})
 }
 
+// writeQueryError reports a failed analysis, answering fast with 503 while the circuit breaker is open
+func writeQueryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, clickhouse.ErrCircuitOpen) {
+		writeJSONError(w, http.StatusServiceUnavailable, "ClickHouse unavailable", err.Error())
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, "Query failed", err.Error())
+}
+
 func intPtr(i int) *int {
 	return &i
 }
@@ -353,27 +679,17 @@ func (h *Handler) cleanupExpiredCache() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		h.cacheMu.Lock()
-		now := time.Now()
-		expired := 0
-
-		// Walk the list and remove expired entries - O(n) but only runs periodically
-		var next *list.Element
-		for elem := h.cacheList.Front(); elem != nil; elem = next {
-			next = elem.Next()
-			entry := elem.Value.(*cacheEntry)
-			if now.After(entry.expiresAt) {
-				h.cacheList.Remove(elem)
-				delete(h.cache, entry.key)
-				expired++
-			}
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.stop:
+			return
 		}
 
+		expired := h.cache.RemoveExpired()
+		h.metrics.CacheExpired(expired)
 		if expired > 0 {
-			log.Printf("Cache cleanup: removed %d expired entries, %d remaining", expired, h.cacheList.Len())
+			slog.Debug("Removed expired cache entries", "expired", expired, "remaining", h.cache.Len())
 		}
-		h.cacheMu.Unlock()
 	}
 }
-
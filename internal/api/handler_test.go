@@ -2,17 +2,16 @@ package api
 
 import (
 	"bytes"
-	"container/list"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"sync"
 	"testing"
 	"time"
 
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/cache"
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
 )
@@ -40,6 +39,14 @@ func createTestLogGroups() []analyzer.LogGroup {
 	}
 }
 
+// Helper function for tests to wrap mock log groups in an analysis result
+func createTestResult() *analyzer.AnalysisResult {
+	return &analyzer.AnalysisResult{
+		LogGroups:        createTestLogGroups(),
+		BaselineStrategy: analyzer.BaselinePreviousWindow,
+	}
+}
+
 func TestQueryLogsValidation(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -99,12 +106,9 @@ func TestQueryLogsValidation(t *testing.T) {
 			mockStore := clickhouse.NewMockStore()
 			mockAnalyzer := analyzer.NewLogAnalyzerWithStore(mockStore)
 			mockHandler := &Handler{
-				analyzer:     mockAnalyzer,
-				cache:        make(map[string]*list.Element),
-				cacheList:    list.New(),
-				cacheTTL:     10 * time.Second,
-				cacheMaxSize: 10,
-				inFlight:     make(map[string]*inFlightRequest),
+				analyzer: mockAnalyzer,
+				cache:    cache.NewLRU(10),
+				cacheTTL: 10 * time.Second,
 			}
 
 			// Marshal request body
@@ -283,11 +287,14 @@ func TestQueryLogsWithoutClickHouse(t *testing.T) {
 	// Create handler without ClickHouse
 	cfg := &config.Config{
 		ClickHouse: config.ClickHouseConfig{
+			Mode:     config.StoreModeClickHouse,
 			URL:      "localhost:9999", // Invalid port
 			User:     "default",
 			Password: "",
 			Database: "default",
 		},
+		// The mock data is quiet, so keep templates the default mode would drop
+		Analysis: config.AnalysisConfig{SignificanceMode: string(analyzer.SignificanceOff)},
 	}
 
 	handler := NewHandler(cfg)
@@ -329,10 +336,47 @@ func TestQueryLogsWithoutClickHouse(t *testing.T) {
 	}
 }
 
+func TestQueryLogsQuietWindowWithDefaultConfig(t *testing.T) {
+	// No config file is found from the test's directory, so Load returns the defaults
+	loaded, err := config.Load()
+	if err != nil {
+		t.Fatalf("Failed to load default config: %v", err)
+	}
+	configs := map[string]*config.Config{
+		"loaded":  loaded,
+		"literal": {}, // As built when the config fails to load
+	}
+
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.ClickHouse.Mode = config.StoreModeMock
+			handler := NewHandler(cfg)
+			defer handler.Close()
+
+			// The mock store returns identical counts for every window, so nothing changed and the
+			// default significance mode drops every template
+			w := queryLogs(handler)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+			}
+			var resp struct {
+				LogGroups json.RawMessage `json:"log_groups"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if string(resp.LogGroups) != "[]" {
+				t.Errorf("Expected a quiet window to return no log groups, got %s", resp.LogGroups)
+			}
+		})
+	}
+}
+
 func TestVerifyTablesWithoutClickHouse(t *testing.T) {
 	// Create handler without ClickHouse (should use mock store)
 	cfg := &config.Config{
 		ClickHouse: config.ClickHouseConfig{
+			Mode:     config.StoreModeClickHouse,
 			URL:      "localhost:9999", // Invalid port
 			User:     "default",
 			Password: "",
@@ -354,12 +398,9 @@ func TestHandlerWithMockAnalyzer(t *testing.T) {
 	mockStore := clickhouse.NewMockStore()
 	mockAnalyzer := analyzer.NewLogAnalyzerWithStore(mockStore)
 	handler := &Handler{
-		analyzer:     mockAnalyzer,
-		cache:        make(map[string]*list.Element),
-		cacheList:    list.New(),
-		cacheTTL:     10 * time.Second,
-		cacheMaxSize: 10,
-		inFlight:     make(map[string]*inFlightRequest),
+		analyzer: mockAnalyzer,
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
 	}
 
 	reqBody := QueryLogsRequest{
@@ -447,6 +488,18 @@ func TestCacheKeyGeneration(t *testing.T) {
 	if key1 != key1Again {
 		t.Error("Expected cache key to be deterministic")
 	}
+
+	// A handler reading another database must not share cached results, even through
+	// the same disk cache
+	for _, other := range []config.ClickHouseConfig{
+		{URL: "other:9000", User: "default", Database: "default"},
+		{URL: "localhost:9999", User: "default", Database: "staging"},
+		{URL: "localhost:9999", User: "readonly", Database: "default"},
+	} {
+		if key := (&Handler{cacheScope: cacheScope(other)}).generateCacheKey(req1); key == key1 {
+			t.Errorf("Expected a different cache key when reading %+v", other)
+		}
+	}
 }
 
 func TestCacheBasicHitMiss(t *testing.T) {
@@ -463,26 +516,23 @@ func TestCacheBasicHitMiss(t *testing.T) {
 	key := "test-key"
 
 	// Initially should be a miss
-	_, _, found := handler.getCachedResultOrWait(key)
+	_, found := handler.cache.Get(key)
 	if found {
 		t.Error("Expected cache miss for new key")
 	}
 
-	// Complete an in-flight request to populate cache
-	handler.startInFlightRequest(key)
-	testData := createTestLogGroups()
-	handler.completeInFlightRequest(key, testData, nil)
+	// Store a result to populate cache
+	testData := createTestResult()
+	handler.storeResult(key, testData)
 
 	// Now should be a hit
-	result, err, found := handler.getCachedResultOrWait(key)
+	entry, found := handler.cache.Get(key)
 	if !found {
-		t.Error("Expected cache hit after insertion")
-	}
-	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
+		t.Fatal("Expected cache hit after insertion")
 	}
-	if len(result) != len(testData) {
-		t.Errorf("Expected %d log groups, got %d", len(testData), len(result))
+	result := entry.Result
+	if len(result.LogGroups) != len(testData.LogGroups) {
+		t.Errorf("Expected %d log groups, got %d", len(testData.LogGroups), len(result.LogGroups))
 	}
 }
 
@@ -498,34 +548,31 @@ func TestCacheLRUEviction(t *testing.T) {
 	handler := NewHandler(cfg)
 
 	// Cache max is 10, so insert 11 items
-	testData := createTestLogGroups()
+	testData := createTestResult()
 
 	keys := make([]string, 11)
 	for i := 0; i < 11; i++ {
 		keys[i] = fmt.Sprintf("key-%d", i)
-		handler.startInFlightRequest(keys[i])
-		handler.completeInFlightRequest(keys[i], testData, nil)
+		handler.storeResult(keys[i], testData)
 	}
 
 	// First key should be evicted (LRU)
-	_, _, found := handler.getCachedResultOrWait(keys[0])
+	_, found := handler.cache.Get(keys[0])
 	if found {
 		t.Error("Expected first key to be evicted after exceeding cache size")
 	}
 
 	// Last key should still be in cache
-	_, _, found = handler.getCachedResultOrWait(keys[10])
+	_, found = handler.cache.Get(keys[10])
 	if !found {
 		t.Error("Expected last key to still be in cache")
 	}
 
 	// Cache size should be at max
-	handler.cacheMu.Lock()
-	size := handler.cacheList.Len()
-	handler.cacheMu.Unlock()
+	size := handler.cache.Len()
 
-	if size != handler.cacheMaxSize {
-		t.Errorf("Expected cache size to be %d, got %d", handler.cacheMaxSize, size)
+	if size != handler.cache.(*cache.LRU).MaxSize() {
+		t.Errorf("Expected cache size to be %d, got %d", handler.cache.(*cache.LRU).MaxSize(), size)
 	}
 }
 
@@ -541,139 +588,91 @@ func TestCacheLRUAccessOrder(t *testing.T) {
 	handler := NewHandler(cfg)
 
 	// Insert max entries
-	testData := createTestLogGroups()
+	testData := createTestResult()
 	keys := make([]string, 10)
 	for i := 0; i < 10; i++ {
 		keys[i] = fmt.Sprintf("key-%d", i)
-		handler.startInFlightRequest(keys[i])
-		handler.completeInFlightRequest(keys[i], testData, nil)
+		handler.storeResult(keys[i], testData)
 	}
 
 	// Access the first key to move it to front
-	handler.getCachedResultOrWait(keys[0])
+	handler.cache.Get(keys[0])
 
 	// Insert one more item, should evict key-1 (not key-0)
 	newKey := "key-new"
-	handler.startInFlightRequest(newKey)
-	handler.completeInFlightRequest(newKey, testData, nil)
+	handler.storeResult(newKey, testData)
 
 	// key-0 should still be in cache (recently accessed)
-	_, _, found := handler.getCachedResultOrWait(keys[0])
+	_, found := handler.cache.Get(keys[0])
 	if !found {
 		t.Error("Expected recently accessed key-0 to still be in cache")
 	}
 
 	// key-1 should be evicted (LRU)
-	_, _, found = handler.getCachedResultOrWait(keys[1])
+	_, found = handler.cache.Get(keys[1])
 	if found {
 		t.Error("Expected key-1 to be evicted as LRU")
 	}
 }
 
-func TestRequestCoalescing(t *testing.T) {
-	cfg := &config.Config{
-		ClickHouse: config.ClickHouseConfig{
-			URL:      "localhost:9999",
-			User:     "default",
-			Password: "",
-			Database: "default",
-		},
-	}
-	handler := NewHandler(cfg)
-
-	key := "test-key"
-	testData := createTestLogGroups()
-
-	// Start in-flight request
-	handler.startInFlightRequest(key)
-
-	var wg sync.WaitGroup
-	results := make([][]byte, 5)
-	errors := make([]error, 5)
-
-	// Launch 5 concurrent requests waiting on the same key
-	for i := 0; i < 5; i++ {
-		wg.Add(1)
-		go func(idx int) {
-			defer wg.Done()
-			result, err, found := handler.getCachedResultOrWait(key)
-			if !found {
-				t.Errorf("Goroutine %d: expected to find result from in-flight request", idx)
-			}
-			errors[idx] = err
-			if err == nil && len(result) > 0 {
-				results[idx] = []byte(result[0].RepresentativeLogs[0])
-			}
-		}(i)
+func TestQueryLogsFollowerSurvivesLeaderCancellation(t *testing.T) {
+	store := &gatedStore{}
+	gate := make(chan struct{})
+	store.gate.Store(&gate)
+	handler := &Handler{
+		analyzer: analyzer.NewLogAnalyzerWithStore(store),
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
 	}
 
-	// Give goroutines time to start waiting
-	time.Sleep(50 * time.Millisecond)
-
-	// Complete the request - should broadcast to all waiters
-	handler.completeInFlightRequest(key, testData, nil)
-
-	// Wait for all goroutines to complete
-	wg.Wait()
-
-	// All goroutines should have received the result
-	for i, result := range results {
-		if len(result) == 0 {
-			t.Errorf("Goroutine %d did not receive result", i)
-		}
-		if errors[i] != nil {
-			t.Errorf("Goroutine %d received error: %v", i, errors[i])
+	bodyBytes, _ := json.Marshal(QueryLogsRequest{
+		Org:        "test-org",
+		Dashboard:  "test-dashboard",
+		PanelTitle: "test-panel",
+		MetricName: "test-metric",
+		StartTime:  time.Now().Add(-1 * time.Hour),
+		EndTime:    time.Now(),
+	})
+	query := func(ctx context.Context) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/query_logs", bytes.NewReader(bodyBytes)).WithContext(ctx)
+		handler.QueryLogs(w, req)
+		return w
+	}
+	waiters := func() int {
+		handler.inFlight.mu.Lock()
+		defer handler.inFlight.mu.Unlock()
+		for _, req := range handler.inFlight.requests {
+			return req.waiters
 		}
+		return 0
 	}
-}
 
-func TestRequestCoalescingWithError(t *testing.T) {
-	cfg := &config.Config{
-		ClickHouse: config.ClickHouseConfig{
-			URL:      "localhost:9999",
-			User:     "default",
-			Password: "",
-			Database: "default",
-		},
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		query(leaderCtx)
+		close(leaderDone)
+	}()
+	for waiters() != 1 {
+		time.Sleep(5 * time.Millisecond)
 	}
-	handler := NewHandler(cfg)
 
-	key := "test-key"
-	testError := errors.New("test database error")
-
-	// Start in-flight request
-	handler.startInFlightRequest(key)
-
-	var wg sync.WaitGroup
-	errors := make([]error, 3)
-
-	// Launch 3 concurrent requests waiting on the same key
-	for i := 0; i < 3; i++ {
-		wg.Add(1)
-		go func(idx int) {
-			defer wg.Done()
-			_, err, found := handler.getCachedResultOrWait(key)
-			if !found {
-				t.Errorf("Goroutine %d: expected to find result from in-flight request", idx)
-			}
-			errors[idx] = err
-		}(i)
+	follower := make(chan *httptest.ResponseRecorder, 1)
+	go func() { follower <- query(context.Background()) }()
+	for waiters() != 2 {
+		time.Sleep(5 * time.Millisecond)
 	}
 
-	// Give goroutines time to start waiting
-	time.Sleep(50 * time.Millisecond)
-
-	// Complete with error - should broadcast error to all waiters
-	handler.completeInFlightRequest(key, nil, testError)
+	// The user who triggered the query moves the mouse away
+	cancelLeader()
+	<-leaderDone
 
-	// Wait for all goroutines to complete
-	wg.Wait()
+	store.gate.Store(nil)
+	close(gate)
 
-	// All goroutines should have received the error
-	for i, err := range errors {
-		if err == nil {
-			t.Errorf("Goroutine %d did not receive error", i)
-		}
+	if w := <-follower; w.Code != http.StatusOK {
+		t.Errorf("Expected the follower to get its result, got %d: %s", w.Code, w.Body.String())
 	}
 }
 
@@ -692,14 +691,13 @@ func TestCacheExpiration(t *testing.T) {
 	handler.cacheTTL = 100 * time.Millisecond
 
 	key := "test-key"
-	testData := createTestLogGroups()
+	testData := createTestResult()
 
 	// Add to cache
-	handler.startInFlightRequest(key)
-	handler.completeInFlightRequest(key, testData, nil)
+	handler.storeResult(key, testData)
 
 	// Should be cached immediately
-	_, _, found := handler.getCachedResultOrWait(key)
+	_, found := handler.cache.Get(key)
 	if !found {
 		t.Error("Expected cache hit immediately after insertion")
 	}
@@ -708,15 +706,13 @@ func TestCacheExpiration(t *testing.T) {
 	time.Sleep(150 * time.Millisecond)
 
 	// Should be expired now
-	_, _, found = handler.getCachedResultOrWait(key)
+	_, found = handler.cache.Get(key)
 	if found {
 		t.Error("Expected cache miss after TTL expiration")
 	}
 
 	// Cache should have cleaned up the entry
-	handler.cacheMu.Lock()
-	size := handler.cacheList.Len()
-	handler.cacheMu.Unlock()
+	size := handler.cache.Len()
 
 	if size != 0 {
 		t.Errorf("Expected cache to be empty after expiration check, got size %d", size)
@@ -738,17 +734,14 @@ func TestCacheExpirationCleanup(t *testing.T) {
 	handler.cacheTTL = 50 * time.Millisecond
 
 	// Add multiple entries
-	testData := createTestLogGroups()
+	testData := createTestResult()
 	for i := 0; i < 5; i++ {
 		key := fmt.Sprintf("key-%d", i)
-		handler.startInFlightRequest(key)
-		handler.completeInFlightRequest(key, testData, nil)
+		handler.storeResult(key, testData)
 	}
 
 	// All should be cached
-	handler.cacheMu.Lock()
-	initialSize := handler.cacheList.Len()
-	handler.cacheMu.Unlock()
+	initialSize := handler.cache.Len()
 
 	if initialSize != 5 {
 		t.Errorf("Expected 5 entries in cache, got %d", initialSize)
@@ -758,34 +751,95 @@ func TestCacheExpirationCleanup(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// Manually trigger cleanup (simulating the background goroutine)
-	handler.cacheMu.Lock()
-	now := time.Now()
-	expired := 0
-	var next *interface{}
-	for elem := handler.cacheList.Front(); elem != nil; {
-		entry := elem.Value.(*cacheEntry)
-		nextElem := elem.Next()
-		if now.After(entry.expiresAt) {
-			handler.cacheList.Remove(elem)
-			delete(handler.cache, entry.key)
-			expired++
-		}
-		elem = nextElem
-		next = nil // Suppress unused warning
-		_ = next
-	}
-	handler.cacheMu.Unlock()
+	expired := handler.cache.RemoveExpired()
 
 	if expired != 5 {
 		t.Errorf("Expected 5 expired entries to be cleaned up, got %d", expired)
 	}
 
 	// Cache should be empty
-	handler.cacheMu.Lock()
-	finalSize := handler.cacheList.Len()
-	handler.cacheMu.Unlock()
+	finalSize := handler.cache.Len()
 
 	if finalSize != 0 {
 		t.Errorf("Expected cache to be empty after cleanup, got size %d", finalSize)
 	}
 }
+
+func TestNewHandlerCacheConfig(t *testing.T) {
+	cfg := &config.Config{
+		ClickHouse: config.ClickHouseConfig{Mode: config.StoreModeMock},
+		Cache: config.CacheConfig{
+			TTL:          time.Minute,
+			MaxSize:      100,
+			Quantization: 30 * time.Second,
+		},
+	}
+	handler := NewHandler(cfg)
+	defer handler.Close()
+
+	if handler.cacheTTL != time.Minute || handler.cache.(*cache.LRU).MaxSize() != 100 || handler.quantization != 30*time.Second {
+		t.Errorf("Expected configured cache settings, got ttl=%v size=%d quantization=%v",
+			handler.cacheTTL, handler.cache.(*cache.LRU).MaxSize(), handler.quantization)
+	}
+
+	// Unset values fall back to the defaults
+	handler = NewHandler(&config.Config{ClickHouse: config.ClickHouseConfig{Mode: config.StoreModeMock}})
+	defer handler.Close()
+
+	if handler.cacheTTL != defaultCacheTTL || handler.cache.(*cache.LRU).MaxSize() != defaultCacheMaxSize || handler.quantization != 0 {
+		t.Errorf("Expected default cache settings, got ttl=%v size=%d quantization=%v",
+			handler.cacheTTL, handler.cache.(*cache.LRU).MaxSize(), handler.quantization)
+	}
+}
+
+func TestQuantizeWindow(t *testing.T) {
+	start := time.Date(2025, 1, 15, 10, 0, 7, 0, time.UTC)
+	end := time.Date(2025, 1, 15, 11, 0, 7, 0, time.UTC)
+
+	gotStart, gotEnd := quantizeWindow(start, end, time.Minute)
+	if !gotStart.Equal(time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected start snapped down to 10:00:00, got %v", gotStart)
+	}
+	if !gotEnd.Equal(time.Date(2025, 1, 15, 11, 1, 0, 0, time.UTC)) {
+		t.Errorf("Expected end snapped up to 11:01:00, got %v", gotEnd)
+	}
+
+	// Aligned times and disabled quantization are left alone
+	aligned := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	if s, e := quantizeWindow(aligned, aligned.Add(time.Hour), time.Minute); !s.Equal(aligned) || !e.Equal(aligned.Add(time.Hour)) {
+		t.Errorf("Expected aligned window unchanged, got %v to %v", s, e)
+	}
+	if s, e := quantizeWindow(start, end, 0); !s.Equal(start) || !e.Equal(end) {
+		t.Errorf("Expected no quantization, got %v to %v", s, e)
+	}
+}
+
+func TestCacheSharedAcrossNearbyHovers(t *testing.T) {
+	handler := &Handler{
+		analyzer:     analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore()),
+		cache:        cache.NewLRU(10),
+		cacheTTL:     10 * time.Second,
+		quantization: time.Minute,
+	}
+
+	hover := time.Date(2025, 1, 15, 10, 30, 10, 0, time.UTC)
+	for _, offset := range []time.Duration{0, time.Second, 20 * time.Second} {
+		bodyBytes, _ := json.Marshal(QueryLogsRequest{
+			Org:        "test-org",
+			Dashboard:  "test-dashboard",
+			PanelTitle: "test-panel",
+			MetricName: "test-metric",
+			StartTime:  hover.Add(offset).Add(-1 * time.Hour),
+			EndTime:    hover.Add(offset),
+		})
+		w := httptest.NewRecorder()
+		handler.QueryLogs(w, httptest.NewRequest(http.MethodPost, "/query_logs", bytes.NewReader(bodyBytes)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+	}
+
+	if handler.cache.Len() != 1 {
+		t.Errorf("Expected hovers within the same minute to share one cache entry, got %d", handler.cache.Len())
+	}
+}
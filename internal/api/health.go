@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+)
+
+// HealthReport describes whether the handler can serve real log data
+type HealthReport struct {
+	// Mock is true when the handler is serving sample data instead of querying ClickHouse
+	Mock          bool     `json:"mock"`
+	Connected     bool     `json:"connected"`
+	SchemaVersion uint32   `json:"schemaVersion"`
+	LatestSchema  uint32   `json:"latestSchema"`
+	MissingTables []string `json:"missingTables,omitempty"`
+	// Circuit is the ClickHouse circuit breaker state, absent in mock mode
+	Circuit *clickhouse.ResilienceStatus `json:"circuit,omitempty"`
+	// Problems lists what is wrong and how to fix it, empty when healthy
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Healthy reports whether no problems were found
+func (r HealthReport) Healthy() bool {
+	return len(r.Problems) == 0
+}
+
+// Message summarizes the report in one line
+func (r HealthReport) Message() string {
+	if !r.Healthy() {
+		return strings.Join(r.Problems, "; ")
+	}
+	if r.Mock {
+		return `Serving mock data because the store mode is "mock"; ClickHouse is not queried`
+	}
+	return fmt.Sprintf("Connected to ClickHouse, schema at version %d", r.SchemaVersion)
+}
+
+// CheckHealth pings ClickHouse and checks the schema version and required tables
+func (h *Handler) CheckHealth(ctx context.Context) HealthReport {
+	logAnalyzer, mock := h.currentAnalyzer()
+
+	var circuit *clickhouse.ResilienceStatus
+	if h.resilient != nil {
+		status := h.resilient.Status()
+		circuit = &status
+	}
+
+	switch {
+	case mock && h.mode == config.StoreModeMock:
+		return HealthReport{Mock: true}
+	case mock:
+		return HealthReport{
+			Mock:    true,
+			Circuit: circuit,
+			Problems: []string{fmt.Sprintf(
+				"Serving mock data because ClickHouse at %q is unreachable (%s). Check the url, user and password in the plugin settings, or set the mode to fail-closed to never serve mock data",
+				h.clickhouseURL, circuit.LastError)},
+		}
+	case circuit != nil && circuit.State == clickhouse.CircuitOpen:
+		// Report the breaker instead of pinging, which would fail fast anyway
+		return HealthReport{
+			Connected: circuit.Connected,
+			Circuit:   circuit,
+			Problems: []string{fmt.Sprintf(
+				"Queries are failing fast because ClickHouse at %q failed %d times in a row (%s); reconnecting in the background, next attempt at %s. Check that the server is running and reachable from Grafana",
+				h.clickhouseURL, circuit.ConsecutiveFailures, circuit.LastError, circuit.RetryAt.Format(time.RFC3339))},
+		}
+	}
+
+	report := HealthReport{Circuit: circuit}
+	if err := logAnalyzer.Ping(ctx); err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf(
+			"ClickHouse at %q is not responding (%v). Check that the server is running and reachable from Grafana", h.clickhouseURL, err))
+		return report
+	}
+	report.Connected = true
+
+	status, err := logAnalyzer.GetSchemaStatus(ctx)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("Failed to read the schema version (%v). Check that the user can read schema_migrations", err))
+		return report
+	}
+	report.SchemaVersion = status.Version
+	report.LatestSchema = status.Latest
+	if !status.UpToDate() {
+		report.Problems = append(report.Problems, fmt.Sprintf(
+			"Schema is at version %d but version %d is required. Run `migrate -direction up` or give the user permission to create tables and reload the plugin",
+			status.Version, status.Latest))
+	}
+
+	missing, err := logAnalyzer.MissingTables(ctx)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("Failed to check required tables (%v)", err))
+		return report
+	}
+	if len(missing) > 0 {
+		report.MissingTables = missing
+		report.Problems = append(report.Problems, fmt.Sprintf(
+			"Required tables are missing: %s. Apply the schema migrations with `migrate -direction up`", strings.Join(missing, ", ")))
+	}
+
+	return report
+}
+
+// Health serves the health report as JSON for the standalone server, with status 503 while
+// there are problems so load balancers and probes can act on it
+func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
+	report := h.CheckHealth(r.Context())
+
+	status := http.StatusOK
+	if !report.Healthy() {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, struct {
+		Message string `json:"message"`
+		HealthReport
+	}{report.Message(), report})
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+)
+
+// unhealthyStore reports an outdated schema with missing tables
+type unhealthyStore struct {
+	clickhouse.MockStore
+	pingErr error
+}
+
+func (s *unhealthyStore) Ping(ctx context.Context) error {
+	return s.pingErr
+}
+
+func (s *unhealthyStore) GetSchemaStatus(ctx context.Context) (clickhouse.SchemaStatus, error) {
+	return clickhouse.SchemaStatus{Version: 1, Latest: 2}, nil
+}
+
+func (s *unhealthyStore) MissingTables(ctx context.Context) ([]string, error) {
+	return []string{"metric_log_hover_mv"}, nil
+}
+
+func TestCheckHealthMockFallback(t *testing.T) {
+	handler := NewHandler(&config.Config{
+		ClickHouse: config.ClickHouseConfig{
+			Mode:     config.StoreModeClickHouse,
+			URL:      "localhost:9999", // Invalid port
+			User:     "default",
+			Database: "default",
+		},
+	})
+	defer handler.Close()
+
+	report := handler.CheckHealth(context.Background())
+	if !report.Mock {
+		t.Error("Expected report to flag mock data")
+	}
+	if report.Healthy() {
+		t.Error("Expected a mock-backed handler to be unhealthy")
+	}
+	if !strings.Contains(report.Message(), "localhost:9999") {
+		t.Errorf("Expected message to name the ClickHouse address, got %q", report.Message())
+	}
+}
+
+func TestCheckHealthHealthy(t *testing.T) {
+	handler := &Handler{analyzer: analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore())}
+
+	report := handler.CheckHealth(context.Background())
+	if !report.Healthy() || !report.Connected || report.Mock {
+		t.Errorf("Expected a healthy report, got %+v", report)
+	}
+	if report.SchemaVersion == 0 || report.SchemaVersion != report.LatestSchema {
+		t.Errorf("Expected the latest schema version, got %d of %d", report.SchemaVersion, report.LatestSchema)
+	}
+}
+
+func TestCheckHealthProblems(t *testing.T) {
+	handler := &Handler{analyzer: analyzer.NewLogAnalyzerWithStore(&unhealthyStore{})}
+
+	report := handler.CheckHealth(context.Background())
+	if len(report.Problems) != 2 {
+		t.Fatalf("Expected schema and table problems, got %v", report.Problems)
+	}
+	if len(report.MissingTables) != 1 || report.MissingTables[0] != "metric_log_hover_mv" {
+		t.Errorf("Expected metric_log_hover_mv to be missing, got %v", report.MissingTables)
+	}
+
+	handler = &Handler{analyzer: analyzer.NewLogAnalyzerWithStore(&unhealthyStore{pingErr: errors.New("connection refused")})}
+	report = handler.CheckHealth(context.Background())
+	if report.Connected || len(report.Problems) != 1 || !strings.Contains(report.Problems[0], "connection refused") {
+		t.Errorf("Expected a single connection problem, got %+v", report)
+	}
+}
+
+func TestHealthEndpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		store      clickhouse.Store
+		wantStatus int
+	}{
+		{"healthy", clickhouse.NewMockStore(), http.StatusOK},
+		{"unreachable", &unhealthyStore{pingErr: errors.New("connection refused")}, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &Handler{analyzer: analyzer.NewLogAnalyzerWithStore(tt.store)}
+			w := httptest.NewRecorder()
+			handler.Health(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			var body struct {
+				Message string `json:"message"`
+				HealthReport
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode health report: %v", err)
+			}
+			if body.Connected != (tt.wantStatus == http.StatusOK) || body.Message == "" {
+				t.Errorf("Unexpected health report %+v", body)
+			}
+		})
+	}
+
+	// An open circuit is reported with its state and last error
+	resilient, _ := clickhouse.NewResilientStore(func() (clickhouse.Store, error) {
+		return nil, errors.New("connection refused")
+	}, clickhouse.ResilienceOptions{InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+	defer resilient.Close()
+	handler := &Handler{analyzer: analyzer.NewLogAnalyzerWithStore(resilient), resilient: resilient}
+	w := httptest.NewRecorder()
+	handler.Health(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"state":"open"`) ||
+		!strings.Contains(w.Body.String(), "connection refused") {
+		t.Errorf("Expected 503 with the open circuit and its error, got %d %s", w.Code, w.Body.String())
+	}
+}
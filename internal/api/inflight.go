@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+)
+
+// analyzeFunc computes the result for an in-flight request
+type analyzeFunc func(ctx context.Context) (*analyzer.AnalysisResult, error)
+
+type inFlightRequest struct {
+	done   chan struct{}
+	result *analyzer.AnalysisResult
+	err    error
+	// ctx is the shared computation's context, detached from any single request and canceled
+	// once every waiter has given up
+	ctx    context.Context
+	cancel context.CancelFunc
+	// waiters counts the callers still waiting for the result, guarded by inFlightGroup.mu
+	waiters int
+}
+
+// inFlightGroup deduplicates concurrent analyses of the same key, so a burst of identical
+// hovers runs one query. The zero value is ready to use.
+type inFlightGroup struct {
+	mu       sync.Mutex
+	requests map[string]*inFlightRequest
+}
+
+// do returns the result of fn for key, joining the computation already running for key if
+// there is one. fn runs in its own goroutine under a context that outlives ctx and is only
+// canceled once every caller waiting on it has returned; if ctx is done first, do returns
+// ctx.Err() without waiting. shared reports whether another caller started the computation.
+func (g *inFlightGroup) do(ctx context.Context, key string, fn analyzeFunc) (result *analyzer.AnalysisResult, err error, shared bool) {
+	req, leader := g.join(key)
+	if leader {
+		go g.run(key, req, fn)
+	}
+	result, err = g.wait(ctx, key, req)
+	return result, err, !leader
+}
+
+// startBackground runs fn for key with nobody waiting on it, unless key is already in flight.
+// Callers that join it later can't cancel it.
+func (g *inFlightGroup) startBackground(key string, fn analyzeFunc) bool {
+	g.mu.Lock()
+	if _, inProgress := g.requests[key]; inProgress {
+		g.mu.Unlock()
+		return false
+	}
+	// The background caller counts as a waiter that never leaves
+	req := g.register(key)
+	g.mu.Unlock()
+
+	go g.run(key, req, fn)
+	return true
+}
+
+// join returns the request in flight for key, registering a new one if there is none, and
+// counts the caller as a waiter. leader is true when the caller must start the computation.
+// Looking up and registering under one lock ensures there is only ever one leader per key.
+func (g *inFlightGroup) join(key string) (req *inFlightRequest, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if req, inProgress := g.requests[key]; inProgress {
+		req.waiters++
+		return req, false
+	}
+	return g.register(key), true
+}
+
+// register adds a request for key with one waiter; callers must hold mu
+func (g *inFlightGroup) register(key string) *inFlightRequest {
+	if g.requests == nil {
+		g.requests = make(map[string]*inFlightRequest)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	req := &inFlightRequest{
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		waiters: 1,
+	}
+	g.requests[key] = req
+	slog.Debug("Started in-flight request", "key", truncateKey(key))
+	return req
+}
+
+// run computes req's result and always completes it, even if fn panics, so that waiters are
+// released and the key is unregistered
+func (g *inFlightGroup) run(key string, req *inFlightRequest, fn analyzeFunc) {
+	var result *analyzer.AnalysisResult
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Recovered panic in in-flight request", "key", truncateKey(key), "panic", r, "stack", string(debug.Stack()))
+			result, err = nil, fmt.Errorf("analysis failed unexpectedly: %v", r)
+		}
+		g.complete(key, req, result, err)
+	}()
+
+	result, err = fn(req.ctx)
+}
+
+// complete unregisters req and broadcasts its result to all waiters
+func (g *inFlightGroup) complete(key string, req *inFlightRequest, result *analyzer.AnalysisResult, err error) {
+	g.mu.Lock()
+	// A request abandoned by all its waiters may already have been replaced
+	if g.requests[key] == req {
+		delete(g.requests, key)
+	}
+	g.mu.Unlock()
+
+	req.result = result
+	req.err = err
+	// Broadcast to all waiters by closing the done channel
+	close(req.done)
+	req.cancel()
+
+	slog.Debug("Broadcasted in-flight result", "key", truncateKey(key), "failed", err != nil)
+}
+
+// wait waits for req to complete. If ctx is done first the caller stops waiting, and the
+// shared computation is canceled when no other waiter remains.
+func (g *inFlightGroup) wait(ctx context.Context, key string, req *inFlightRequest) (*analyzer.AnalysisResult, error) {
+	select {
+	case <-req.done:
+		return req.result, req.err
+	case <-ctx.Done():
+		g.leave(key, req)
+		return nil, ctx.Err()
+	}
+}
+
+// leave drops a waiter, canceling the computation once nobody needs its result
+func (g *inFlightGroup) leave(key string, req *inFlightRequest) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	req.waiters--
+	if req.waiters > 0 {
+		return
+	}
+
+	// Unregister first so that later callers start afresh instead of joining a canceled computation
+	if g.requests[key] == req {
+		delete(g.requests, key)
+	}
+	req.cancel()
+	slog.Debug("Canceled in-flight request with no remaining waiters", "key", truncateKey(key))
+}
+
+// len returns the number of keys in flight
+func (g *inFlightGroup) len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.requests)
+}
@@ -0,0 +1,395 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+)
+
+// blockingAnalysis returns an analyzeFunc that counts its calls and blocks until release is closed
+func blockingAnalysis(calls *atomic.Int32, release <-chan struct{}, result *analyzer.AnalysisResult, err error) analyzeFunc {
+	return func(ctx context.Context) (*analyzer.AnalysisResult, error) {
+		calls.Add(1)
+		select {
+		case <-release:
+			return result, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// waitForWaiters polls until key has n waiters
+func waitForWaiters(t *testing.T, g *inFlightGroup, key string, n int) *inFlightRequest {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		g.mu.Lock()
+		req := g.requests[key]
+		waiters := 0
+		if req != nil {
+			waiters = req.waiters
+		}
+		g.mu.Unlock()
+		if waiters == n {
+			return req
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d waiters on %s, have %d", n, key, waiters)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestInFlightCoalescing(t *testing.T) {
+	var g inFlightGroup
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := blockingAnalysis(&calls, release, createTestResult(), nil)
+
+	var wg sync.WaitGroup
+	results := make([]*analyzer.AnalysisResult, 5)
+	errs := make([]error, 5)
+	shared := make([]bool, 5)
+
+	// Launch 5 concurrent requests for the same key
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			results[idx], errs[idx], shared[idx] = g.do(context.Background(), "test-key", fn)
+		}(i)
+	}
+
+	waitForWaiters(t, &g, "test-key", 5)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected one analysis, got %d", calls.Load())
+	}
+	leaders := 0
+	for i := range results {
+		if errs[i] != nil || results[i] == nil {
+			t.Errorf("Request %d: expected the shared result, got %v, %v", i, results[i], errs[i])
+		}
+		if !shared[i] {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("Expected exactly one leader, got %d", leaders)
+	}
+	if g.len() != 0 {
+		t.Errorf("Expected the key to be unregistered after completion, %d remain", g.len())
+	}
+}
+
+func TestInFlightCoalescingWithError(t *testing.T) {
+	var g inFlightGroup
+	var calls atomic.Int32
+	release := make(chan struct{})
+	testError := errors.New("test database error")
+	fn := blockingAnalysis(&calls, release, nil, testError)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			_, errs[idx], _ = g.do(context.Background(), "test-key", fn)
+		}(i)
+	}
+
+	waitForWaiters(t, &g, "test-key", 3)
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if !errors.Is(err, testError) {
+			t.Errorf("Request %d: expected the shared error, got %v", i, err)
+		}
+	}
+	if g.len() != 0 {
+		t.Errorf("Expected failed requests to be unregistered, %d remain", g.len())
+	}
+}
+
+func TestInFlightWaiterCancellationIsIndependent(t *testing.T) {
+	var g inFlightGroup
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := blockingAnalysis(&calls, release, createTestResult(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err, _ := g.do(ctx, "test-key", fn)
+		canceled <- err
+	}()
+	patient := make(chan *analyzer.AnalysisResult, 1)
+	go func() {
+		result, _, _ := g.do(context.Background(), "test-key", fn)
+		patient <- result
+	}()
+
+	req := waitForWaiters(t, &g, "test-key", 2)
+	cancel()
+
+	select {
+	case err := <-canceled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the canceled waiter to get context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Canceled waiter kept blocking on the in-flight request")
+	}
+	if req.ctx.Err() != nil {
+		t.Fatal("Expected the shared computation to continue while others are waiting")
+	}
+
+	close(release)
+	if result := <-patient; result == nil {
+		t.Error("Expected the remaining waiter to receive the result")
+	}
+}
+
+func TestInFlightCanceledWhenAllWaitersLeave(t *testing.T) {
+	var g inFlightGroup
+	var calls atomic.Int32
+	never := make(chan struct{})
+	fn := blockingAnalysis(&calls, never, createTestResult(), nil)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			g.do(ctx, "test-key", fn)
+		}(ctx)
+	}
+
+	req := waitForWaiters(t, &g, "test-key", 2)
+	cancel1()
+	waitForWaiters(t, &g, "test-key", 1)
+	if req.ctx.Err() != nil {
+		t.Fatal("Expected the computation to continue while a waiter remains")
+	}
+
+	cancel2()
+	wg.Wait()
+	if req.ctx.Err() == nil {
+		t.Error("Expected the computation to be canceled once every waiter left")
+	}
+	// The canceled computation unwinds and is never joined again
+	<-req.done
+	if g.len() != 0 {
+		t.Errorf("Expected the abandoned request to be unregistered, %d remain", g.len())
+	}
+}
+
+func TestInFlightLateCompletionKeepsNewerRequest(t *testing.T) {
+	var g inFlightGroup
+	old, _ := g.join("test-key")
+	g.leave("test-key", old)
+
+	newer, leader := g.join("test-key")
+	if !leader || newer == old {
+		t.Fatal("Expected a fresh request after the old one was abandoned")
+	}
+
+	g.complete("test-key", old, nil, context.Canceled)
+	g.mu.Lock()
+	current := g.requests["test-key"]
+	g.mu.Unlock()
+	if current != newer {
+		t.Error("Expected the newer in-flight request to stay registered")
+	}
+}
+
+func TestInFlightPanicCleansUp(t *testing.T) {
+	var g inFlightGroup
+
+	_, err, _ := g.do(context.Background(), "test-key", func(ctx context.Context) (*analyzer.AnalysisResult, error) {
+		panic("boom")
+	})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected the panic to be reported as an error, got %v", err)
+	}
+	if g.len() != 0 {
+		t.Fatalf("Expected the key to be unregistered after a panic, %d remain", g.len())
+	}
+
+	// The key is usable again
+	result, err, _ := g.do(context.Background(), "test-key", func(ctx context.Context) (*analyzer.AnalysisResult, error) {
+		return createTestResult(), nil
+	})
+	if err != nil || result == nil {
+		t.Errorf("Expected a later request to succeed, got %v, %v", result, err)
+	}
+}
+
+func TestInFlightStartBackground(t *testing.T) {
+	var g inFlightGroup
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := blockingAnalysis(&calls, release, createTestResult(), nil)
+
+	if !g.startBackground("test-key", fn) {
+		t.Fatal("Expected the background refresh to start")
+	}
+	if g.startBackground("test-key", fn) {
+		t.Error("Expected a second refresh of the same key to be skipped")
+	}
+
+	// A caller that joins and gives up must not cancel the refresh
+	req := waitForWaiters(t, &g, "test-key", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g.do(ctx, "test-key", fn)
+	if req.ctx.Err() != nil {
+		t.Fatal("Expected the refresh to continue after a joined caller gave up")
+	}
+
+	close(release)
+	<-req.done
+	if req.err != nil || req.result == nil {
+		t.Errorf("Expected the refresh result, got %v, %v", req.result, req.err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected one analysis, got %d", calls.Load())
+	}
+	if g.len() != 0 {
+		t.Errorf("Expected the refresh to be unregistered, %d remain", g.len())
+	}
+}
+
+// TestInFlightConcurrentKeys hammers the group with identical and distinct keys; run it
+// with -race. Every key must run exactly once per round and nothing may be left registered.
+func TestInFlightConcurrentKeys(t *testing.T) {
+	const (
+		keys          = 2000
+		callersPerKey = 8
+	)
+
+	var g inFlightGroup
+	counts := make([]atomic.Int32, keys)
+	start := make(chan struct{})
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	var failures atomic.Int32
+	for k := 0; k < keys; k++ {
+		key := fmt.Sprintf("key-%d", k)
+		fn := func(ctx context.Context) (*analyzer.AnalysisResult, error) {
+			counts[k].Add(1)
+			<-release
+			return &analyzer.AnalysisResult{Scorer: key}, nil
+		}
+		for c := 0; c < callersPerKey; c++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				result, err, _ := g.do(context.Background(), key, fn)
+				if err != nil || result == nil || result.Scorer != key {
+					failures.Add(1)
+				}
+			}()
+		}
+	}
+
+	close(start)
+	// Hold every computation open until all callers have joined, so each key has one leader
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		g.mu.Lock()
+		joined := 0
+		for _, req := range g.requests {
+			joined += req.waiters
+		}
+		g.mu.Unlock()
+		if joined == keys*callersPerKey {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out with %d of %d callers joined", joined, keys*callersPerKey)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if failures.Load() != 0 {
+		t.Errorf("%d callers got a wrong result", failures.Load())
+	}
+	for k := range counts {
+		if n := counts[k].Load(); n != 1 {
+			t.Errorf("key-%d ran %d times, expected once", k, n)
+		}
+	}
+	if g.len() != 0 {
+		t.Errorf("Expected every key to be unregistered, %d remain", g.len())
+	}
+}
+
+// TestInFlightConcurrentChurn mixes completions, cancellations and panics on a small key
+// space so that requests are constantly registered, joined, abandoned and replaced.
+func TestInFlightConcurrentChurn(t *testing.T) {
+	const (
+		workers    = 64
+		iterations = 200
+		keySpace   = 16
+	)
+
+	var g inFlightGroup
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				key := fmt.Sprintf("key-%d", (w+i)%keySpace)
+				ctx, cancel := context.WithCancel(context.Background())
+				if i%3 == 0 {
+					cancel()
+				}
+				g.do(ctx, key, func(ctx context.Context) (*analyzer.AnalysisResult, error) {
+					switch i % 5 {
+					case 0:
+						panic("boom")
+					case 1:
+						return nil, errors.New("query failed")
+					}
+					select {
+					case <-time.After(time.Duration(i%3) * time.Millisecond):
+						return createTestResult(), nil
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					}
+				})
+				cancel()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Abandoned computations unwind asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for g.len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected every key to be unregistered, %d remain", g.len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
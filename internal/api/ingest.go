@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/ingest"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/logging"
+)
+
+// Limits on ingestion input
+const (
+	maxIngestBytes = 32 << 20
+	maxIngestLines = 10000
+)
+
+// IngestRequest is the body of POST /ingest: raw log lines from one log stream
+type IngestRequest struct {
+	Org         string `json:"org"`
+	LogStreamID string `json:"log_stream_id"`
+	Service     string `json:"service,omitempty"`
+	Region      string `json:"region,omitempty"`
+	// LogStreamName defaults to the log stream ID
+	LogStreamName string       `json:"log_stream_name,omitempty"`
+	Lines         []IngestLine `json:"lines"`
+}
+
+// IngestLine is one raw log line
+type IngestLine struct {
+	// Timestamp defaults to when the line is received
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// IngestResponse reports the lines accepted for insertion
+type IngestResponse struct {
+	Accepted int `json:"accepted"`
+	// TemplateIDs are the templates the lines were mined into, in the order of the lines
+	TemplateIDs []string `json:"template_ids"`
+}
+
+func (req IngestRequest) validate() error {
+	if err := validateName("org", req.Org); err != nil {
+		return err
+	}
+	if !idPattern.MatchString(req.LogStreamID) {
+		return errors.New("log_stream_id must be 1 to 128 letters, digits or any of _.:-")
+	}
+	for _, field := range []struct{ name, value string }{
+		{"service", req.Service},
+		{"region", req.Region},
+		{"log_stream_name", req.LogStreamName},
+	} {
+		if field.value == "" {
+			continue
+		}
+		if err := validateName(field.name, field.value); err != nil {
+			return err
+		}
+	}
+	if len(req.Lines) == 0 || len(req.Lines) > maxIngestLines {
+		return fmt.Errorf("lines must hold 1 to %d lines", maxIngestLines)
+	}
+	for i, line := range req.Lines {
+		if line.Message == "" {
+			return fmt.Errorf("lines[%d].message is required", i)
+		}
+		if len(line.Message) > ingest.MaxMessageLength {
+			return fmt.Errorf("lines[%d].message must be at most %d bytes", i, ingest.MaxMessageLength)
+		}
+	}
+	return nil
+}
+
+// newIngester creates the ingester that mines and buffers lines for insertion
+func (h *Handler) newIngester(cfg config.IngestConfig) *ingest.Ingester {
+	return ingest.NewIngester(h.ingestStore, ingest.Options{
+		BatchSize:           cfg.BatchSize,
+		FlushInterval:       cfg.FlushInterval,
+		MaxBuffered:         cfg.MaxBuffered,
+		ExamplesPerTemplate: cfg.ExamplesPerTemplate,
+		Drain: ingest.DrainOptions{
+			Depth:               cfg.TreeDepth,
+			SimilarityThreshold: cfg.SimilarityThreshold,
+			MaxChildren:         cfg.MaxChildren,
+		},
+	})
+}
+
+// ingestStore returns the store ingested lines are written to. Lines are refused while mock
+// data stands in for an unreachable ClickHouse, since they would be lost on reconnecting.
+func (h *Handler) ingestStore() (clickhouse.Store, error) {
+	logAnalyzer, mock := h.currentAnalyzer()
+	if mock && h.resilient != nil {
+		return nil, fmt.Errorf("%w: %v", ingest.ErrUnavailable, h.unavailableError())
+	}
+	return logAnalyzer.Store(), nil
+}
+
+// IngestRoutes returns the endpoints that accept raw log lines
+func (h *Handler) IngestRoutes() []Route {
+	return []Route{
+		{"POST /ingest", h.Ingest},
+		{"POST " + otlpLogsPath, h.ExportOTLPLogs},
+		{"POST " + lokiPushPath, h.PushLokiLogs},
+	}
+}
+
+// Ingest mines a batch of raw log lines into templates and queues them, with sampled
+// examples of each template, for insertion into ClickHouse
+func (h *Handler) Ingest(w http.ResponseWriter, r *http.Request) {
+	if h.UsingMockData() {
+		w.Header().Set(MockDataHeader, "true")
+	}
+
+	var req IngestRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIngestBytes)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	lines := make([]ingest.Line, len(req.Lines))
+	for i, line := range req.Lines {
+		lines[i] = ingest.Line{Timestamp: line.Timestamp, Message: line.Message}
+	}
+	stream := ingest.Stream{
+		Org:     req.Org,
+		ID:      req.LogStreamID,
+		Service: req.Service,
+		Region:  req.Region,
+		Name:    req.LogStreamName,
+	}
+	h.ingest(w, r, stream, lines)
+}
+
+// ingest hands lines to the ingester and answers with the templates they were mined into
+func (h *Handler) ingest(w http.ResponseWriter, r *http.Request, stream ingest.Stream, lines []ingest.Line) {
+	ctx := logging.With(r.Context(), "org", stream.Org, "log_stream_id", stream.ID)
+	templateIDs, err := h.ingester.Ingest(ctx, stream, lines)
+	if err != nil {
+		status, title := ingestErrorStatus(ctx, err, len(lines))
+		writeJSONError(w, status, title, err.Error())
+		return
+	}
+
+	slog.DebugContext(ctx, "Ingested log lines", "lines", len(lines))
+	writeJSON(w, http.StatusAccepted, IngestResponse{Accepted: len(lines), TemplateIDs: templateIDs})
+}
+
+// ingestErrorStatus returns the HTTP status and title for lines that couldn't be ingested,
+// logging lines refused for a full buffer since they are lost unless the sender retries
+func ingestErrorStatus(ctx context.Context, err error, lines int) (int, string) {
+	switch {
+	case errors.Is(err, ingest.ErrUnavailable), errors.Is(err, clickhouse.ErrCircuitOpen):
+		return http.StatusServiceUnavailable, "ClickHouse unavailable"
+	case errors.Is(err, ingest.ErrBufferFull):
+		slog.WarnContext(ctx, "Refusing ingested lines", "lines", lines, "error", err)
+		return http.StatusServiceUnavailable, "Ingestion backlog full"
+	default:
+		return http.StatusInternalServerError, "Ingestion failed"
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/ingest"
+)
+
+func newIngestMux(h *Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range h.IngestRoutes() {
+		mux.HandleFunc(route.Pattern, route.Handler)
+	}
+	return mux
+}
+
+func TestIngest(t *testing.T) {
+	handler := newMockMappingHandler()
+	handler.ingester = handler.newIngester(config.IngestConfig{})
+	defer handler.ingester.Close()
+	mux := newIngestMux(handler)
+
+	body := `{"org":"acme","log_stream_id":"stream_api_east","service":"api-server","region":"us-east-1","lines":[
+		{"timestamp":"2025-01-15T12:00:00Z","message":"user alice logged in from 10.0.0.1"},
+		{"message":"user bob logged in from 10.0.0.2"},
+		{"message":"cache warmed in 35ms"}]}`
+	w := serveMapping(mux, http.MethodPost, "/ingest", body)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := decodeBody[IngestResponse](t, w)
+	if resp.Accepted != 3 || len(resp.TemplateIDs) != 3 {
+		t.Fatalf("Expected 3 lines accepted, got %+v", resp)
+	}
+	if resp.TemplateIDs[0] != resp.TemplateIDs[1] || resp.TemplateIDs[0] == resp.TemplateIDs[2] {
+		t.Errorf("Expected the login lines to share a template, got %v", resp.TemplateIDs)
+	}
+
+	ctx := context.Background()
+	if err := handler.ingester.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	examples, _ := handler.analyzer.Store().ListTemplateExamples(ctx, "acme")
+	if len(examples) != 3 || examples[0].Service != "api-server" {
+		t.Errorf("Expected an example of each line from api-server, got %+v", examples)
+	}
+}
+
+func TestIngestValidation(t *testing.T) {
+	handler := newMockMappingHandler()
+	handler.ingester = handler.newIngester(config.IngestConfig{})
+	defer handler.ingester.Close()
+	mux := newIngestMux(handler)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"malformed", `{"org":`},
+		{"missing org", `{"log_stream_id":"s","lines":[{"message":"m"}]}`},
+		{"invalid stream id", `{"org":"acme","log_stream_id":"a b","lines":[{"message":"m"}]}`},
+		{"control character in service", `{"org":"acme","log_stream_id":"s","service":"api\n","lines":[{"message":"m"}]}`},
+		{"no lines", `{"org":"acme","log_stream_id":"s","lines":[]}`},
+		{"empty message", `{"org":"acme","log_stream_id":"s","lines":[{"message":"m"},{"message":""}]}`},
+		{"message too long", `{"org":"acme","log_stream_id":"s","lines":[{"message":"` + strings.Repeat("a", ingest.MaxMessageLength+1) + `"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serveMapping(mux, http.MethodPost, "/ingest", tt.body); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIngestRefusedWhileServingMockFallback(t *testing.T) {
+	handler := NewHandler(unreachableConfig(config.StoreModeClickHouse))
+	defer handler.Close()
+
+	w := serveMapping(newIngestMux(handler), http.MethodPost, "/ingest",
+		`{"org":"acme","log_stream_id":"stream_api_east","lines":[{"message":"hello"}]}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while ClickHouse is unreachable, got %d: %s", w.Code, w.Body.String())
+	}
+}
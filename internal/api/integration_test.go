@@ -2,7 +2,6 @@ package api
 
 import (
 	"bytes"
-	"container/list"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +10,7 @@ import (
 	"time"
 
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/cache"
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
 )
 
@@ -20,12 +20,9 @@ func TestIntegrationFullWorkflow(t *testing.T) {
 	mockStore := clickhouse.NewMockStore()
 	mockAnalyzer := analyzer.NewLogAnalyzerWithStore(mockStore)
 	handler := &Handler{
-		analyzer:     mockAnalyzer,
-		cache:        make(map[string]*list.Element),
-		cacheList:    list.New(),
-		cacheTTL:     10 * time.Second,
-		cacheMaxSize: 10,
-		inFlight:     make(map[string]*inFlightRequest),
+		analyzer: mockAnalyzer,
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
 	}
 
 	// Create a test request
@@ -76,12 +73,9 @@ func TestIntegrationConcurrentRequests(t *testing.T) {
 	mockStore := clickhouse.NewMockStore()
 	mockAnalyzer := analyzer.NewLogAnalyzerWithStore(mockStore)
 	handler := &Handler{
-		analyzer:     mockAnalyzer,
-		cache:        make(map[string]*list.Element),
-		cacheList:    list.New(),
-		cacheTTL:     10 * time.Second,
-		cacheMaxSize: 10,
-		inFlight:     make(map[string]*inFlightRequest),
+		analyzer: mockAnalyzer,
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
 	}
 
 	// Simulate 5 panels requesting the same data concurrently
@@ -152,12 +146,9 @@ func TestIntegrationCacheHitMiss(t *testing.T) {
 	mockStore := clickhouse.NewMockStore()
 	mockAnalyzer := analyzer.NewLogAnalyzerWithStore(mockStore)
 	handler := &Handler{
-		analyzer:     mockAnalyzer,
-		cache:        make(map[string]*list.Element),
-		cacheList:    list.New(),
-		cacheTTL:     2 * time.Second, // Short TTL for testing
-		cacheMaxSize: 10,
-		inFlight:     make(map[string]*inFlightRequest),
+		analyzer: mockAnalyzer,
+		cache:    cache.NewLRU(10),
+		cacheTTL: 2 * time.Second, // Short TTL for testing
 	}
 
 	reqBody := QueryLogsRequest{
@@ -230,12 +221,9 @@ func TestIntegrationLRUEviction(t *testing.T) {
 	mockStore := clickhouse.NewMockStore()
 	mockAnalyzer := analyzer.NewLogAnalyzerWithStore(mockStore)
 	handler := &Handler{
-		analyzer:     mockAnalyzer,
-		cache:        make(map[string]*list.Element),
-		cacheList:    list.New(),
-		cacheTTL:     10 * time.Second,
-		cacheMaxSize: 3, // Small cache for testing eviction
-		inFlight:     make(map[string]*inFlightRequest),
+		analyzer: mockAnalyzer,
+		cache:    cache.NewLRU(3), // Small cache for testing eviction
+		cacheTTL: 10 * time.Second,
 	}
 
 	// Create 5 different queries (more than cache size)
@@ -261,23 +249,19 @@ func TestIntegrationLRUEviction(t *testing.T) {
 			t.Fatalf("Query %d failed: %d", i+1, w.Code)
 		}
 
-		handler.cacheMu.Lock()
-		cacheSize := handler.cacheList.Len()
-		handler.cacheMu.Unlock()
+		cacheSize := handler.cache.Len()
 
-		t.Logf("  Query %d: cache size = %d/%d", i+1, cacheSize, handler.cacheMaxSize)
+		t.Logf("  Query %d: cache size = %d/%d", i+1, cacheSize, handler.cache.(*cache.LRU).MaxSize())
 	}
 
 	// Verify cache size is at max
-	handler.cacheMu.Lock()
-	finalSize := handler.cacheList.Len()
-	handler.cacheMu.Unlock()
+	finalSize := handler.cache.Len()
 
-	if finalSize != handler.cacheMaxSize {
-		t.Errorf("Expected cache size to be %d, got %d", handler.cacheMaxSize, finalSize)
+	if finalSize != handler.cache.(*cache.LRU).MaxSize() {
+		t.Errorf("Expected cache size to be %d, got %d", handler.cache.(*cache.LRU).MaxSize(), finalSize)
 	}
 
-	t.Logf("✓ Cache correctly maintained max size of %d (LRU eviction working)", handler.cacheMaxSize)
+	t.Logf("✓ Cache correctly maintained max size of %d (LRU eviction working)", handler.cache.(*cache.LRU).MaxSize())
 
 	// Query the first one again - should be evicted (cache miss)
 	t.Log("Re-querying first item (should be evicted)...")
@@ -299,12 +283,9 @@ func TestIntegrationDifferentTimeRanges(t *testing.T) {
 	mockStore := clickhouse.NewMockStore()
 	mockAnalyzer := analyzer.NewLogAnalyzerWithStore(mockStore)
 	handler := &Handler{
-		analyzer:     mockAnalyzer,
-		cache:        make(map[string]*list.Element),
-		cacheList:    list.New(),
-		cacheTTL:     10 * time.Second,
-		cacheMaxSize: 10,
-		inFlight:     make(map[string]*inFlightRequest),
+		analyzer: mockAnalyzer,
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
 	}
 
 	now := time.Now()
@@ -358,12 +339,9 @@ func TestIntegrationMockStoreData(t *testing.T) {
 	mockStore := clickhouse.NewMockStore()
 	mockAnalyzer := analyzer.NewLogAnalyzerWithStore(mockStore)
 	handler := &Handler{
-		analyzer:     mockAnalyzer,
-		cache:        make(map[string]*list.Element),
-		cacheList:    list.New(),
-		cacheTTL:     10 * time.Second,
-		cacheMaxSize: 10,
-		inFlight:     make(map[string]*inFlightRequest),
+		analyzer: mockAnalyzer,
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
 	}
 
 	reqBody := QueryLogsRequest{
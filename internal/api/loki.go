@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/ingest"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/logging"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// lokiPushPath is where Promtail, Grafana Alloy and other Loki clients push logs
+const lokiPushPath = "/loki/api/v1/push"
+
+// PushLokiLogs receives a Loki push request and ingests its entries, so Loki clients can
+// ship logs straight to the server. Protobuf pushes are snappy-compressed, as Loki clients
+// send them, and JSON pushes may be gzipped. Each set of stream labels is a log stream, see
+// ingest.LokiBatches. Like Loki, it answers HTTP 204 once the entries are accepted.
+func (h *Handler) PushLokiLogs(w http.ResponseWriter, r *http.Request) {
+	if h.UsingMockData() {
+		w.Header().Set(MockDataHeader, "true")
+	}
+
+	// Loki takes a push without a content type to be protobuf
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "" && mediaType != contentTypeProtobuf && mediaType != contentTypeJSON {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "Unsupported content type",
+			fmt.Sprintf("Loki pushes must be sent as %s or %s", contentTypeProtobuf, contentTypeJSON))
+		return
+	}
+
+	org := requestOrg(r)
+	if err := validateName("org", org); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request",
+			fmt.Sprintf("%v: set the %s header or the org query parameter", err, OrgHeader))
+		return
+	}
+
+	body, err := readBody(w, r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	var streams []ingest.LokiStream
+	if mediaType == contentTypeJSON {
+		streams, err = ingest.ParseLokiPushJSON(body)
+	} else {
+		streams, err = decodeLokiPushProto(body)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	ctx := logging.With(r.Context(), "org", org)
+	batches, rejected := ingest.LokiBatches(org, streams)
+	lines := 0
+	for _, b := range batches {
+		lines += len(b.Lines)
+	}
+	if len(batches) > 0 {
+		if _, err := h.ingester.IngestBatches(ctx, batches); err != nil {
+			status, title := ingestErrorStatus(ctx, err, lines)
+			writeJSONError(w, status, title, err.Error())
+			return
+		}
+	}
+
+	slog.DebugContext(ctx, "Ingested Loki push", "streams", len(batches), "entries", lines, "rejected", rejected)
+	if rejected > 0 {
+		// Loki also answers 400 for lines over its limit, after accepting the rest, so
+		// clients don't retry a push that would be rejected again
+		writeJSONError(w, http.StatusBadRequest, "Entries rejected",
+			fmt.Sprintf("%d entries are longer than %d bytes; the other %d were accepted", rejected, ingest.MaxMessageLength, lines))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeLokiPushProto decompresses and decodes a protobuf Loki push, refusing bodies that
+// would decompress to more than maxIngestBytes
+func decodeLokiPushProto(body []byte) ([]ingest.LokiStream, error) {
+	size, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("body must be snappy-compressed: %w", err)
+	}
+	if size > maxIngestBytes {
+		return nil, fmt.Errorf("request body must decompress to at most %d bytes", maxIngestBytes)
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("body must be snappy-compressed: %w", err)
+	}
+	return ingest.ParseLokiPushProto(data)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/ingest"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const lokiJSONPush = `{"streams":[{
+	"stream":{"service_name":"api-server","region":"us-east-1","env":"prod"},
+	"values":[
+		["1736942400000000000","user alice logged in from 10.0.0.1"],
+		["1736942401000000000","user bob logged in from 10.0.0.2"]]}]}`
+
+// lokiProtoPush encodes lokiJSONPush as a snappy-compressed logproto.PushRequest
+func lokiProtoPush() []byte {
+	var stream []byte
+	stream = protowire.AppendTag(stream, 1, protowire.BytesType)
+	stream = protowire.AppendString(stream, `{service_name="api-server", region="us-east-1", env="prod"}`)
+	for i, line := range []string{"user alice logged in from 10.0.0.1", "user bob logged in from 10.0.0.2"} {
+		var timestamp []byte
+		timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+		timestamp = protowire.AppendVarint(timestamp, uint64(1736942400+i))
+
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, timestamp)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, line)
+		stream = protowire.AppendTag(stream, 2, protowire.BytesType)
+		stream = protowire.AppendBytes(stream, entry)
+	}
+
+	var push []byte
+	push = protowire.AppendTag(push, 1, protowire.BytesType)
+	push = protowire.AppendBytes(push, stream)
+	return snappy.Encode(nil, push)
+}
+
+func newLokiRequest(t *testing.T, contentType string, body []byte, gzipped bool) *http.Request {
+	t.Helper()
+	req := newOTLPRequest(t, contentType, body, gzipped)
+	req.URL.Path = lokiPushPath
+	return req
+}
+
+func TestPushLokiLogs(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		gzipped     bool
+	}{
+		{"snappy protobuf", contentTypeProtobuf, lokiProtoPush(), false},
+		{"protobuf without content type", "", lokiProtoPush(), false},
+		{"json", contentTypeJSON, []byte(lokiJSONPush), false},
+		{"gzipped json", contentTypeJSON, []byte(lokiJSONPush), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newMockMappingHandler()
+			handler.ingester = handler.newIngester(config.IngestConfig{})
+			defer handler.ingester.Close()
+
+			w := httptest.NewRecorder()
+			newIngestMux(handler).ServeHTTP(w, newLokiRequest(t, tt.contentType, tt.body, tt.gzipped))
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+			}
+
+			ctx := context.Background()
+			if err := handler.ingester.Flush(ctx); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+			examples, _ := handler.analyzer.Store().ListTemplateExamples(ctx, "acme")
+			if len(examples) != 2 || examples[0].Service != "api-server" || examples[0].Region != "us-east-1" {
+				t.Fatalf("Expected both entries stored as examples from api-server in us-east-1, got %+v", examples)
+			}
+			if examples[0].TemplateID != examples[1].TemplateID {
+				t.Errorf("Expected the login entries to share a template, got %+v", examples)
+			}
+		})
+	}
+}
+
+func TestPushLokiLogsRejectsBadRequests(t *testing.T) {
+	handler := newMockMappingHandler()
+	handler.ingester = handler.newIngester(config.IngestConfig{})
+	defer handler.ingester.Close()
+	mux := newIngestMux(handler)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		modify      func(*http.Request)
+		want        int
+	}{
+		{"unsupported content type", "text/plain", []byte(lokiJSONPush), func(*http.Request) {}, http.StatusUnsupportedMediaType},
+		{"missing org", contentTypeJSON, []byte(lokiJSONPush), func(r *http.Request) { r.Header.Del(OrgHeader) }, http.StatusBadRequest},
+		{"uncompressed protobuf", contentTypeProtobuf, []byte("\x0a\x05hello"), func(*http.Request) {}, http.StatusBadRequest},
+		{"malformed json", contentTypeJSON, []byte(`{"streams":`), func(*http.Request) {}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newLokiRequest(t, tt.contentType, tt.body, false)
+			tt.modify(req)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+	if got := handler.ingester.Buffered(); got != 0 {
+		t.Errorf("Expected nothing buffered from rejected pushes, got %d lines", got)
+	}
+}
+
+func TestPushLokiLogsOverlongEntry(t *testing.T) {
+	handler := newMockMappingHandler()
+	handler.ingester = handler.newIngester(config.IngestConfig{})
+	defer handler.ingester.Close()
+
+	body := `{"streams":[{"stream":{"app":"api"},"values":[
+		["1736942400000000000","cache warmed in 35ms"],
+		["1736942400000000000","` + strings.Repeat("a", ingest.MaxMessageLength+1) + `"]]}]}`
+	w := httptest.NewRecorder()
+	newIngestMux(handler).ServeHTTP(w, newLokiRequest(t, contentTypeJSON, []byte(body), false))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for the overlong entry, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := handler.ingester.Buffered(); got != 1 {
+		t.Errorf("Expected the other entry still ingested, got %d lines", got)
+	}
+}
+
+func TestPushLokiLogsRefusedWhileServingMockFallback(t *testing.T) {
+	handler := NewHandler(unreachableConfig(config.StoreModeClickHouse))
+	defer handler.Close()
+
+	req := httptest.NewRequest(http.MethodPost, lokiPushPath, bytes.NewReader([]byte(lokiJSONPush)))
+	req.Header.Set("Content-Type", contentTypeJSON)
+	req.Header.Set(OrgHeader, "acme")
+	w := httptest.NewRecorder()
+	newIngestMux(handler).ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while ClickHouse is unreachable, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,528 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/logging"
+)
+
+// Route is an endpoint to register on a mux, with a method-qualified pattern
+type Route struct {
+	Pattern string
+	Handler http.HandlerFunc
+}
+
+// Limits on mapping API input
+const (
+	maxNameLength     = 256
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// idPattern matches metric and log stream IDs
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+
+// Audit entities and actions
+const (
+	entityMetric  = "metric"
+	entityMapping = "mapping"
+
+	actionRegister = "register"
+	actionAttach   = "attach"
+	actionToggle   = "toggle"
+	actionDetach   = "detach"
+	actionAccept   = "accept_suggestion"
+)
+
+// RegisterMetricRequest is the body of POST /mappings/metrics
+type RegisterMetricRequest struct {
+	Dashboard  string `json:"dashboard"`
+	PanelTitle string `json:"panel_title"`
+	MetricName string `json:"metric_name"`
+}
+
+func (req RegisterMetricRequest) validate() error {
+	for _, field := range []struct{ name, value string }{
+		{"dashboard", req.Dashboard},
+		{"panel_title", req.PanelTitle},
+		{"metric_name", req.MetricName},
+	} {
+		if err := validateName(field.name, field.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (req RegisterMetricRequest) metric(org string) clickhouse.Metric {
+	return clickhouse.Metric{
+		ID:         clickhouse.NewMetricID(org, req.Dashboard, req.PanelTitle, req.MetricName),
+		OrgID:      org,
+		Dashboard:  req.Dashboard,
+		PanelTitle: req.PanelTitle,
+		MetricName: req.MetricName,
+		UpdatedAt:  time.Now().UTC(),
+	}
+}
+
+// AcceptSuggestionRequest is the body of POST /mappings/suggestions/accept: the hovered
+// metric and one of the log streams suggested for it
+type AcceptSuggestionRequest struct {
+	RegisterMetricRequest
+	LogStreamID string `json:"log_stream_id"`
+}
+
+// MappingRequest is the body of PUT and PATCH /mappings/metrics/{metric_id}/streams/{stream_id}
+type MappingRequest struct {
+	// Active defaults to true when attaching and is required when toggling
+	Active *bool `json:"is_active"`
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose mapping changes are audited as made by actor. Only
+// authenticated callers are named; see RequireToken.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actor names who is making a request for the audit log
+func actor(r *http.Request) string {
+	if actor, ok := r.Context().Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// MappingRoutes returns the endpoints that register metrics and attach log streams to them.
+// Every route takes the org as the org query parameter unless the request was scoped to
+// one with WithOrg. None of them authorize the caller, so callers must wrap them.
+func (h *Handler) MappingRoutes() []Route {
+	return []Route{
+		{"GET /mappings/metrics", h.ListMetrics},
+		{"POST /mappings/metrics", h.RegisterMetric},
+		{"GET /mappings/log_streams", h.ListLogStreams},
+		{"GET /mappings/metrics/{metric_id}/streams", h.ListMappings},
+		{"PUT /mappings/metrics/{metric_id}/streams/{stream_id}", h.AttachStream},
+		{"PATCH /mappings/metrics/{metric_id}/streams/{stream_id}", h.ToggleMapping},
+		{"DELETE /mappings/metrics/{metric_id}/streams/{stream_id}", h.DetachStream},
+		{"POST /mappings/suggestions/accept", h.AcceptSuggestion},
+		{"GET /mappings/audit", h.ListAudit},
+	}
+}
+
+// mappingOrg returns the org a mapping request is scoped to, falling back to the org query
+// parameter
+func mappingOrg(r *http.Request) string {
+	if org, ok := contextOrg(r.Context()); ok {
+		return org
+	}
+	return r.URL.Query().Get("org")
+}
+
+// mappingStore returns the store to read and change mappings in, writing an error and
+// returning false if the request can't be served. Changes are refused while mock data
+// stands in for an unreachable ClickHouse, since they would be lost on reconnecting.
+func (h *Handler) mappingStore(w http.ResponseWriter, r *http.Request, change bool) (clickhouse.Store, string, bool) {
+	logAnalyzer, mock := h.currentAnalyzer()
+	if mock {
+		w.Header().Set(MockDataHeader, "true")
+		if change && h.resilient != nil {
+			writeJSONError(w, http.StatusServiceUnavailable, "ClickHouse unavailable", h.unavailableError().Error())
+			return nil, "", false
+		}
+	}
+
+	org := mappingOrg(r)
+	if err := validateName("org", org); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return nil, "", false
+	}
+	return logAnalyzer.Store(), org, true
+}
+
+// pathID returns a validated ID from the request path, writing an error if it is invalid
+func pathID(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	id := r.PathValue(name)
+	if !idPattern.MatchString(id) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request",
+			fmt.Sprintf("%s must be 1 to 128 letters, digits or any of _.:-", name))
+		return "", false
+	}
+	return id, true
+}
+
+// validateName checks a required free-text field
+func validateName(field, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	if len(value) > maxNameLength {
+		return fmt.Errorf("%s must be at most %d bytes", field, maxNameLength)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%s must not contain control characters", field)
+		}
+	}
+	return nil
+}
+
+// ListMetrics lists the org's registered metrics
+func (h *Handler) ListMetrics(w http.ResponseWriter, r *http.Request) {
+	store, org, ok := h.mappingStore(w, r, false)
+	if !ok {
+		return
+	}
+	metrics, err := store.ListMetrics(r.Context(), org)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"metrics": nonNil(metrics)})
+}
+
+// RegisterMetric registers a (dashboard, panel, metric) so log streams can be attached to it.
+// Registering the same metric again keeps its ID.
+func (h *Handler) RegisterMetric(w http.ResponseWriter, r *http.Request) {
+	store, org, ok := h.mappingStore(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req RegisterMetricRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	metric := req.metric(org)
+	if err := store.SaveMetric(r.Context(), metric); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	h.audit(r, store, actionRegister, entityMetric, metric.ID, metric)
+	writeJSON(w, http.StatusCreated, metric)
+}
+
+// ListLogStreams lists the org's log streams that can be attached to metrics
+func (h *Handler) ListLogStreams(w http.ResponseWriter, r *http.Request) {
+	store, org, ok := h.mappingStore(w, r, false)
+	if !ok {
+		return
+	}
+	streams, err := store.ListLogStreams(r.Context(), org)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"log_streams": nonNil(streams)})
+}
+
+// ListMappings lists the log streams attached to a metric, active or not
+func (h *Handler) ListMappings(w http.ResponseWriter, r *http.Request) {
+	store, org, ok := h.mappingStore(w, r, false)
+	if !ok {
+		return
+	}
+	metricID, ok := pathID(w, r, "metric_id")
+	if !ok {
+		return
+	}
+	if !h.requireMetric(w, r, store, org, metricID) {
+		return
+	}
+	mappings, err := store.ListMappings(r.Context(), org, metricID)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"mappings": nonNil(mappings)})
+}
+
+// AttachStream attaches a log stream to a metric, active unless the body says otherwise
+func (h *Handler) AttachStream(w http.ResponseWriter, r *http.Request) {
+	store, org, ok := h.mappingStore(w, r, true)
+	if !ok {
+		return
+	}
+	metricID, ok := pathID(w, r, "metric_id")
+	if !ok {
+		return
+	}
+	streamID, ok := pathID(w, r, "stream_id")
+	if !ok {
+		return
+	}
+
+	// The body is optional
+	var req MappingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	active := req.Active == nil || *req.Active
+
+	if !h.requireMetric(w, r, store, org, metricID) {
+		return
+	}
+	_, found, err := store.GetLogStream(r.Context(), org, streamID)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "Not found", fmt.Sprintf("log stream %q is not registered", streamID))
+		return
+	}
+
+	h.saveMapping(w, r, store, actionAttach, clickhouse.MetricMapping{
+		ID:          clickhouse.NewMappingID(metricID, streamID),
+		OrgID:       org,
+		MetricID:    metricID,
+		LogStreamID: streamID,
+		Active:      active,
+		UpdatedAt:   time.Now().UTC(),
+	})
+}
+
+// ToggleMapping turns an existing mapping on or off
+func (h *Handler) ToggleMapping(w http.ResponseWriter, r *http.Request) {
+	store, org, ok := h.mappingStore(w, r, true)
+	if !ok {
+		return
+	}
+	metricID, ok := pathID(w, r, "metric_id")
+	if !ok {
+		return
+	}
+	streamID, ok := pathID(w, r, "stream_id")
+	if !ok {
+		return
+	}
+
+	var req MappingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	if req.Active == nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", "is_active is required")
+		return
+	}
+
+	mapping, ok := h.findMapping(w, r, store, org, metricID, streamID)
+	if !ok {
+		return
+	}
+	mapping.Active = *req.Active
+	mapping.UpdatedAt = time.Now().UTC()
+	h.saveMapping(w, r, store, actionToggle, mapping)
+}
+
+// DetachStream removes a log stream from a metric
+func (h *Handler) DetachStream(w http.ResponseWriter, r *http.Request) {
+	store, org, ok := h.mappingStore(w, r, true)
+	if !ok {
+		return
+	}
+	metricID, ok := pathID(w, r, "metric_id")
+	if !ok {
+		return
+	}
+	streamID, ok := pathID(w, r, "stream_id")
+	if !ok {
+		return
+	}
+
+	mapping, ok := h.findMapping(w, r, store, org, metricID, streamID)
+	if !ok {
+		return
+	}
+	if err := store.DeleteMapping(r.Context(), org, metricID, streamID); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	h.mappingsChanged()
+	h.audit(r, store, actionDetach, entityMapping, mapping.ID, mapping)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptSuggestion attaches a log stream suggested for an unmapped metric, registering the
+// metric first if needed
+func (h *Handler) AcceptSuggestion(w http.ResponseWriter, r *http.Request) {
+	store, org, ok := h.mappingStore(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req AcceptSuggestionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	if !idPattern.MatchString(req.LogStreamID) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request", "log_stream_id must be 1 to 128 letters, digits or any of _.:-")
+		return
+	}
+
+	_, found, err := store.GetLogStream(r.Context(), org, req.LogStreamID)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "Not found", fmt.Sprintf("log stream %q is not registered", req.LogStreamID))
+		return
+	}
+
+	metric := req.metric(org)
+	_, registered, err := store.GetMetric(r.Context(), org, metric.ID)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if !registered {
+		if err := store.SaveMetric(r.Context(), metric); err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		h.audit(r, store, actionRegister, entityMetric, metric.ID, metric)
+	}
+
+	h.saveMapping(w, r, store, actionAccept, clickhouse.MetricMapping{
+		ID:          clickhouse.NewMappingID(metric.ID, req.LogStreamID),
+		OrgID:       org,
+		MetricID:    metric.ID,
+		LogStreamID: req.LogStreamID,
+		Active:      true,
+		UpdatedAt:   time.Now().UTC(),
+	})
+}
+
+// ListAudit lists the org's most recent mapping changes, newest first, up to the limit
+// query parameter
+func (h *Handler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	store, org, ok := h.mappingStore(w, r, false)
+	if !ok {
+		return
+	}
+
+	limit := defaultAuditLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request",
+				fmt.Sprintf("limit must be a number from 1 to %d", maxAuditLimit))
+			return
+		}
+		limit = n
+	}
+
+	entries, err := store.ListAudit(r.Context(), org, limit)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": nonNil(entries)})
+}
+
+// requireMetric checks that a metric is registered, writing 404 if it isn't
+func (h *Handler) requireMetric(w http.ResponseWriter, r *http.Request, store clickhouse.Store, org, metricID string) bool {
+	_, found, err := store.GetMetric(r.Context(), org, metricID)
+	if err != nil {
+		writeQueryError(w, err)
+		return false
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "Not found", fmt.Sprintf("metric %q is not registered", metricID))
+		return false
+	}
+	return true
+}
+
+// findMapping looks up the mapping between a metric and stream, writing 404 if there is none
+func (h *Handler) findMapping(w http.ResponseWriter, r *http.Request, store clickhouse.Store, org, metricID, streamID string) (clickhouse.MetricMapping, bool) {
+	mappings, err := store.ListMappings(r.Context(), org, metricID)
+	if err != nil {
+		writeQueryError(w, err)
+		return clickhouse.MetricMapping{}, false
+	}
+	for _, mapping := range mappings {
+		if mapping.LogStreamID == streamID {
+			return mapping, true
+		}
+	}
+	writeJSONError(w, http.StatusNotFound, "Not found",
+		fmt.Sprintf("log stream %q is not attached to metric %q", streamID, metricID))
+	return clickhouse.MetricMapping{}, false
+}
+
+// saveMapping stores a new or changed mapping and answers with it
+func (h *Handler) saveMapping(w http.ResponseWriter, r *http.Request, store clickhouse.Store, action string, mapping clickhouse.MetricMapping) {
+	if err := store.SaveMapping(r.Context(), mapping); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	h.mappingsChanged()
+	h.audit(r, store, action, entityMapping, mapping.ID, mapping)
+	writeJSON(w, http.StatusOK, mapping)
+}
+
+// mappingsChanged drops cached analyses, which may have been computed from other streams
+func (h *Handler) mappingsChanged() {
+	if h.cache != nil {
+		h.cache.Clear()
+	}
+}
+
+// audit records a change that has been made. The change stands even if it can't be
+// audited, so a failure is logged rather than returned.
+func (h *Handler) audit(r *http.Request, store clickhouse.Store, action, entity, id string, state interface{}) {
+	details, err := json.Marshal(state)
+	if err != nil {
+		details = []byte("{}")
+	}
+	entry := clickhouse.AuditEntry{
+		Time:     time.Now().UTC(),
+		OrgID:    mappingOrg(r),
+		Actor:    actor(r),
+		Action:   action,
+		Entity:   entity,
+		EntityID: id,
+		Details:  string(details),
+	}
+
+	ctx := logging.With(r.Context(), "org", entry.OrgID, "actor", entry.Actor)
+	slog.InfoContext(ctx, "Mapping changed", "action", action, "entity", entity, "id", id)
+	if err := store.RecordAudit(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "Failed to record mapping change in the audit log", "action", action, "id", id, "error", err)
+	}
+}
+
+// nonNil returns an empty slice for nil, so lists encode as [] rather than null
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/cache"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+)
+
+func newMappingMux(h *Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range h.MappingRoutes() {
+		mux.HandleFunc(route.Pattern, route.Handler)
+	}
+	return mux
+}
+
+func serveMapping(mux *http.ServeMux, method, target, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, reader)
+	r = r.WithContext(WithActor(r.Context(), "alice"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func decodeBody[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("Failed to unmarshal response %q: %v", w.Body.String(), err)
+	}
+	return v
+}
+
+func newMockMappingHandler() *Handler {
+	return &Handler{
+		analyzer: analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore()),
+		mock:     true,
+		mode:     config.StoreModeMock,
+		cache:    cache.NewLRU(10),
+	}
+}
+
+func TestMappingLifecycle(t *testing.T) {
+	handler := newMockMappingHandler()
+	mux := newMappingMux(handler)
+
+	body := `{"dashboard":"API","panel_title":"Errors","metric_name":"error_rate"}`
+	w := serveMapping(mux, http.MethodPost, "/mappings/metrics?org=acme", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	metric := decodeBody[clickhouse.Metric](t, w)
+	if metric.ID == "" || metric.OrgID != "acme" {
+		t.Fatalf("Unexpected metric %+v", metric)
+	}
+
+	// Registering it again keeps the same metric
+	w = serveMapping(mux, http.MethodPost, "/mappings/metrics?org=acme", body)
+	if again := decodeBody[clickhouse.Metric](t, w); again.ID != metric.ID {
+		t.Errorf("Expected re-registering to keep ID %s, got %s", metric.ID, again.ID)
+	}
+	metrics := decodeBody[struct{ Metrics []clickhouse.Metric }](t, serveMapping(mux, http.MethodGet, "/mappings/metrics?org=acme", ""))
+	if len(metrics.Metrics) != 1 {
+		t.Errorf("Expected 1 registered metric, got %d", len(metrics.Metrics))
+	}
+
+	streams := decodeBody[struct {
+		LogStreams []clickhouse.LogStream `json:"log_streams"`
+	}](t, serveMapping(mux, http.MethodGet, "/mappings/log_streams?org=acme", ""))
+	if len(streams.LogStreams) == 0 {
+		t.Fatal("Expected the mock store to list log streams")
+	}
+	streamID := streams.LogStreams[0].ID
+
+	// Changing a mapping invalidates cached analyses
+	handler.cache.Set("key", cache.Entry{Result: &analyzer.AnalysisResult{}}, time.Minute)
+	path := "/mappings/metrics/" + metric.ID + "/streams/" + streamID + "?org=acme"
+	w = serveMapping(mux, http.MethodPut, path, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 attaching, got %d: %s", w.Code, w.Body.String())
+	}
+	if mapping := decodeBody[clickhouse.MetricMapping](t, w); !mapping.Active || mapping.LogStreamID != streamID {
+		t.Errorf("Expected an active mapping to %s, got %+v", streamID, mapping)
+	}
+	if handler.cache.Len() != 0 {
+		t.Error("Expected attaching a stream to clear the cache")
+	}
+
+	w = serveMapping(mux, http.MethodPatch, path, `{"is_active":false}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 toggling, got %d: %s", w.Code, w.Body.String())
+	}
+	mappings := decodeBody[struct{ Mappings []clickhouse.MetricMapping }](t,
+		serveMapping(mux, http.MethodGet, "/mappings/metrics/"+metric.ID+"/streams?org=acme", ""))
+	if len(mappings.Mappings) != 1 || mappings.Mappings[0].Active {
+		t.Errorf("Expected one inactive mapping, got %+v", mappings.Mappings)
+	}
+
+	if w = serveMapping(mux, http.MethodDelete, path, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204 detaching, got %d: %s", w.Code, w.Body.String())
+	}
+	if w = serveMapping(mux, http.MethodDelete, path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 detaching twice, got %d", w.Code)
+	}
+
+	audit := decodeBody[struct{ Entries []clickhouse.AuditEntry }](t, serveMapping(mux, http.MethodGet, "/mappings/audit?org=acme", ""))
+	var actions []string
+	for _, entry := range audit.Entries {
+		actions = append(actions, entry.Action)
+		if entry.Actor != "alice" {
+			t.Errorf("Expected changes audited as alice, got %q", entry.Actor)
+		}
+	}
+	if got := strings.Join(actions, ","); got != "detach,toggle,attach,register,register" {
+		t.Errorf("Expected the changes newest first, got %s", got)
+	}
+	if other := decodeBody[struct{ Entries []clickhouse.AuditEntry }](t, serveMapping(mux, http.MethodGet, "/mappings/audit?org=other", "")); len(other.Entries) != 0 {
+		t.Errorf("Expected no audit entries for another org, got %d", len(other.Entries))
+	}
+}
+
+func TestMappingValidation(t *testing.T) {
+	mux := newMappingMux(newMockMappingHandler())
+	metricID := decodeBody[clickhouse.Metric](t, serveMapping(mux, http.MethodPost, "/mappings/metrics?org=acme",
+		`{"dashboard":"API","panel_title":"Errors","metric_name":"error_rate"}`)).ID
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"missing org", http.MethodGet, "/mappings/metrics", "", http.StatusBadRequest},
+		{"missing field", http.MethodPost, "/mappings/metrics?org=acme", `{"dashboard":"API","panel_title":"Errors"}`, http.StatusBadRequest},
+		{"control character", http.MethodPost, "/mappings/metrics?org=acme", `{"dashboard":"API\n","panel_title":"Errors","metric_name":"m"}`, http.StatusBadRequest},
+		{"too long", http.MethodPost, "/mappings/metrics?org=acme", `{"dashboard":"` + strings.Repeat("a", 300) + `","panel_title":"Errors","metric_name":"m"}`, http.StatusBadRequest},
+		{"invalid id", http.MethodPut, "/mappings/metrics/bad%20id/streams/stream_api_east?org=acme", "", http.StatusBadRequest},
+		{"unknown metric", http.MethodPut, "/mappings/metrics/metric_missing/streams/stream_api_east?org=acme", "", http.StatusNotFound},
+		{"unknown stream", http.MethodPut, "/mappings/metrics/" + metricID + "/streams/stream_missing?org=acme", "", http.StatusNotFound},
+		{"toggle without is_active", http.MethodPatch, "/mappings/metrics/" + metricID + "/streams/stream_api_east?org=acme", `{}`, http.StatusBadRequest},
+		{"toggle unattached", http.MethodPatch, "/mappings/metrics/" + metricID + "/streams/stream_api_east?org=acme", `{"is_active":true}`, http.StatusNotFound},
+		{"invalid limit", http.MethodGet, "/mappings/audit?org=acme&limit=0", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveMapping(mux, tt.method, tt.target, tt.body)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMappingChangesRefusedWhileServingMockFallback(t *testing.T) {
+	handler := NewHandler(unreachableConfig(config.StoreModeClickHouse))
+	defer handler.Close()
+	mux := newMappingMux(handler)
+
+	w := serveMapping(mux, http.MethodPost, "/mappings/metrics?org=acme",
+		`{"dashboard":"API","panel_title":"Errors","metric_name":"error_rate"}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 for a change while ClickHouse is unreachable, got %d", w.Code)
+	}
+
+	w = serveMapping(mux, http.MethodGet, "/mappings/log_streams?org=acme", "")
+	if w.Code != http.StatusOK || w.Header().Get(MockDataHeader) != "true" {
+		t.Errorf("Expected reads to be served from mock data, got %d with header %q", w.Code, w.Header().Get(MockDataHeader))
+	}
+}
+
+func TestAcceptSuggestion(t *testing.T) {
+	handler := newMockMappingHandler()
+	mux := newMappingMux(handler)
+
+	body := `{"dashboard":"API","panel_title":"Errors","metric_name":"error_rate","log_stream_id":"stream_worker_east"}`
+	w := serveMapping(mux, http.MethodPost, "/mappings/suggestions/accept?org=acme", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	mapping := decodeBody[clickhouse.MetricMapping](t, w)
+	if mapping.MetricID != clickhouse.NewMetricID("acme", "API", "Errors", "error_rate") || !mapping.Active {
+		t.Errorf("Expected an active mapping for the hovered metric, got %+v", mapping)
+	}
+
+	store := handler.analyzer.Store()
+	if _, found, _ := store.GetMetric(context.Background(), "acme", mapping.MetricID); !found {
+		t.Error("Expected accepting a suggestion to register the metric")
+	}
+	if active, _ := store.CountActiveStreams(context.Background(), "acme", "API", "Errors", "error_rate"); active != 1 {
+		t.Errorf("Expected 1 active stream after accepting, got %d", active)
+	}
+
+	// Accepting a second stream for the now registered metric doesn't register it again
+	serveMapping(mux, http.MethodPost, "/mappings/suggestions/accept?org=acme",
+		strings.Replace(body, "stream_worker_east", "stream_api_east", 1))
+	audit := decodeBody[struct{ Entries []clickhouse.AuditEntry }](t, serveMapping(mux, http.MethodGet, "/mappings/audit?org=acme", ""))
+	var actions []string
+	for _, entry := range audit.Entries {
+		actions = append(actions, entry.Action)
+	}
+	if got := strings.Join(actions, ","); got != "accept_suggestion,accept_suggestion,register" {
+		t.Errorf("Unexpected audit trail %s", got)
+	}
+
+	if w = serveMapping(mux, http.MethodPost, "/mappings/suggestions/accept?org=acme",
+		strings.Replace(body, "stream_worker_east", "stream_missing", 1)); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown stream, got %d", w.Code)
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/cache"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+)
+
+func unreachableConfig(mode config.StoreMode) *config.Config {
+	return &config.Config{
+		ClickHouse: config.ClickHouseConfig{
+			Mode:     mode,
+			URL:      "localhost:9999", // Invalid port
+			User:     "default",
+			Database: "default",
+		},
+	}
+}
+
+func queryLogs(handler *Handler) *httptest.ResponseRecorder {
+	bodyBytes, _ := json.Marshal(QueryLogsRequest{
+		Org:        "test-org",
+		Dashboard:  "test-dashboard",
+		PanelTitle: "test-panel",
+		MetricName: "test-metric",
+		StartTime:  time.Now().Add(-1 * time.Hour),
+		EndTime:    time.Now(),
+	})
+	w := httptest.NewRecorder()
+	handler.QueryLogs(w, httptest.NewRequest(http.MethodPost, "/query_logs", bytes.NewReader(bodyBytes)))
+	return w
+}
+
+func assertMockResponse(t *testing.T, w *httptest.ResponseRecorder, wantMock bool) {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get(MockDataHeader) == "true"; got != wantMock {
+		t.Errorf("Expected %s header present=%v", MockDataHeader, wantMock)
+	}
+	var resp QueryLogsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.Mock != wantMock {
+		t.Errorf("Expected mock=%v in response body", wantMock)
+	}
+}
+
+func TestStoreModeMock(t *testing.T) {
+	handler := NewHandler(unreachableConfig(config.StoreModeMock))
+	defer handler.Close()
+
+	assertMockResponse(t, queryLogs(handler), true)
+
+	report := handler.CheckHealth(context.Background())
+	if !report.Mock || !report.Healthy() {
+		t.Errorf("Expected an explicitly mocked handler to be healthy and flagged, got %+v", report)
+	}
+}
+
+func TestStoreModeClickHouseFallsBackVisibly(t *testing.T) {
+	handler := NewHandler(unreachableConfig(config.StoreModeClickHouse))
+	defer handler.Close()
+
+	assertMockResponse(t, queryLogs(handler), true)
+}
+
+func TestStoreModeFailClosed(t *testing.T) {
+	for _, mode := range []config.StoreMode{config.StoreModeFailClosed, "", "typo"} {
+		handler := NewHandler(unreachableConfig(mode))
+
+		w := queryLogs(handler)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Mode %q: expected status 503, got %d", mode, w.Code)
+		}
+		if w.Header().Get(MockDataHeader) != "" {
+			t.Errorf("Mode %q: expected no mock marker", mode)
+		}
+		if handler.UsingMockData() {
+			t.Errorf("Mode %q: expected no mock data", mode)
+		}
+		if report := handler.CheckHealth(context.Background()); report.Healthy() {
+			t.Errorf("Mode %q: expected an unhealthy report", mode)
+		}
+		handler.Close()
+	}
+}
+
+func TestReconnectReplacesMockData(t *testing.T) {
+	var attempts atomic.Int32
+	resilient, err := clickhouse.NewResilientStore(func() (clickhouse.Store, error) {
+		if attempts.Add(1) < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return clickhouse.NewMockStore(), nil
+	}, clickhouse.ResilienceOptions{InitialBackoff: 5 * time.Millisecond})
+	if err == nil {
+		t.Fatal("Expected the first connection attempt to fail")
+	}
+
+	handler := &Handler{
+		analyzer:  analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore()),
+		mock:      true,
+		resilient: resilient,
+		mode:      config.StoreModeClickHouse,
+		cache:     cache.NewLRU(10),
+		cacheTTL:  10 * time.Second,
+		stop:      make(chan struct{}),
+	}
+	defer handler.Close()
+
+	// Served and cached while mocked, before the recovery hook is registered
+	assertMockResponse(t, queryLogs(handler), true)
+	if report := handler.CheckHealth(context.Background()); !report.Mock || report.Circuit == nil {
+		t.Errorf("Expected a mocked report with circuit state, got %+v", report)
+	}
+	resilient.OnRecover(handler.useClickHouse)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for handler.UsingMockData() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for reconnect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The cached mock result must not be served as real data
+	assertMockResponse(t, queryLogs(handler), false)
+
+	report := handler.CheckHealth(context.Background())
+	if !report.Healthy() || report.Circuit.State != clickhouse.CircuitClosed {
+		t.Errorf("Expected a healthy report with a closed circuit, got %+v", report)
+	}
+}
+
+func TestOpenCircuitReturns503(t *testing.T) {
+	resilient, _ := clickhouse.NewResilientStore(func() (clickhouse.Store, error) {
+		return nil, errors.New("connection refused")
+	}, clickhouse.ResilienceOptions{InitialBackoff: time.Hour})
+
+	handler := &Handler{
+		analyzer:  analyzer.NewLogAnalyzerWithStore(resilient),
+		resilient: resilient,
+		mode:      config.StoreModeFailClosed,
+		cache:     cache.NewLRU(10),
+		cacheTTL:  10 * time.Second,
+		stop:      make(chan struct{}),
+	}
+	defer handler.Close()
+
+	if w := queryLogs(handler); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while the circuit is open, got %d", w.Code)
+	}
+
+	report := handler.CheckHealth(context.Background())
+	if report.Healthy() || report.Circuit == nil || report.Circuit.State != clickhouse.CircuitOpen {
+		t.Errorf("Expected an unhealthy report with an open circuit, got %+v", report)
+	}
+}
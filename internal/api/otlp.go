@@ -0,0 +1,160 @@
+package api
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/ingest"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/logging"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpLogsPath is where OTLP/HTTP exporters send logs by default
+const otlpLogsPath = "/v1/logs"
+
+// OrgHeader names the org that pushed logs belong to, as Loki and Mimir do; the org query
+// parameter is used when it is absent
+const OrgHeader = "X-Scope-OrgID"
+
+// OTLP/HTTP content types
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// ExportOTLPLogs receives an OTLP/HTTP logs export, encoded as protobuf or JSON and
+// optionally gzipped, and ingests its records. Each resource is a log stream, see
+// ingest.OTLPBatches. Records without a usable body are reported as rejected in a partial
+// success response rather than failing the export.
+func (h *Handler) ExportOTLPLogs(w http.ResponseWriter, r *http.Request) {
+	if h.UsingMockData() {
+		w.Header().Set(MockDataHeader, "true")
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != contentTypeProtobuf && mediaType != contentTypeJSON {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "Unsupported content type",
+			fmt.Sprintf("OTLP logs must be sent as %s or %s", contentTypeProtobuf, contentTypeJSON))
+		return
+	}
+
+	org := requestOrg(r)
+	if err := validateName("org", org); err != nil {
+		writeOTLPError(w, mediaType, http.StatusBadRequest, code.Code_INVALID_ARGUMENT,
+			fmt.Sprintf("%v: set the %s header or the org query parameter", err, OrgHeader))
+		return
+	}
+
+	body, err := readBody(w, r)
+	if err != nil {
+		writeOTLPError(w, mediaType, http.StatusBadRequest, code.Code_INVALID_ARGUMENT, err.Error())
+		return
+	}
+	var req collogspb.ExportLogsServiceRequest
+	if mediaType == contentTypeProtobuf {
+		err = proto.Unmarshal(body, &req)
+	} else {
+		// OTLP/JSON encodes trace and span IDs as hex rather than base64, which still decodes
+		// without error; they aren't used
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, &req)
+	}
+	if err != nil {
+		writeOTLPError(w, mediaType, http.StatusBadRequest, code.Code_INVALID_ARGUMENT, err.Error())
+		return
+	}
+
+	ctx := logging.With(r.Context(), "org", org)
+	batches, rejected := ingest.OTLPBatches(org, &req)
+	lines := 0
+	for _, b := range batches {
+		lines += len(b.Lines)
+	}
+	if len(batches) > 0 {
+		if _, err := h.ingester.IngestBatches(ctx, batches); err != nil {
+			httpStatus, _ := ingestErrorStatus(ctx, err, lines)
+			rpcCode := code.Code_INTERNAL
+			if httpStatus == http.StatusServiceUnavailable {
+				rpcCode = code.Code_UNAVAILABLE
+			}
+			writeOTLPError(w, mediaType, httpStatus, rpcCode, err.Error())
+			return
+		}
+	}
+
+	slog.DebugContext(ctx, "Ingested OTLP log records", "streams", len(batches), "records", lines, "rejected", rejected)
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if rejected > 0 {
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{
+			RejectedLogRecords: rejected,
+			ErrorMessage:       fmt.Sprintf("log records need a body of 1 to %d bytes", ingest.MaxMessageLength),
+		}
+	}
+	writeOTLP(w, mediaType, http.StatusOK, resp)
+}
+
+// requestOrg returns the org a push request names in OrgHeader or the org query parameter
+func requestOrg(r *http.Request) string {
+	if org := r.Header.Get(OrgHeader); org != "" {
+		return org
+	}
+	return r.URL.Query().Get("org")
+}
+
+// readBody reads a request body of at most maxIngestBytes, gunzipping it if needed
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxIngestBytes)
+	switch encoding := r.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		// Bound the decompressed size too
+		body = io.LimitReader(gz, maxIngestBytes+1)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxIngestBytes {
+		return nil, fmt.Errorf("request body must be at most %d bytes", maxIngestBytes)
+	}
+	return data, nil
+}
+
+// writeOTLP writes an OTLP message in the encoding the request used
+func writeOTLP(w http.ResponseWriter, mediaType string, httpStatus int, msg proto.Message) {
+	var data []byte
+	var err error
+	if mediaType == contentTypeProtobuf {
+		data, err = proto.Marshal(msg)
+	} else {
+		data, err = protojson.Marshal(msg)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Encoding failed", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(httpStatus)
+	w.Write(data)
+}
+
+// writeOTLPError writes a google.rpc.Status, as OTLP/HTTP requires of failed exports
+func writeOTLPError(w http.ResponseWriter, mediaType string, httpStatus int, rpcCode code.Code, message string) {
+	writeOTLP(w, mediaType, httpStatus, &status.Status{Code: int32(rpcCode), Message: message})
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const otlpJSONExport = `{"resourceLogs":[{
+	"resource":{"attributes":[
+		{"key":"service.name","value":{"stringValue":"api-server"}},
+		{"key":"cloud.region","value":{"stringValue":"us-east-1"}}]},
+	"scopeLogs":[{"logRecords":[
+		{"timeUnixNano":"1736942400000000000","body":{"stringValue":"user alice logged in from 10.0.0.1"},"traceId":"5b8efff798038103d269b633813fc60c"},
+		{"body":{"stringValue":"user bob logged in from 10.0.0.2"}},
+		{"body":{}}]}]}]}`
+
+func newOTLPRequest(t *testing.T, contentType string, body []byte, gzipped bool) *http.Request {
+	t.Helper()
+	if gzipped {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		gz.Close()
+		body = buf.Bytes()
+	}
+	req := httptest.NewRequest(http.MethodPost, otlpLogsPath, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(OrgHeader, "acme")
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return req
+}
+
+func TestExportOTLPLogs(t *testing.T) {
+	var export collogspb.ExportLogsServiceRequest
+	if err := protojson.Unmarshal([]byte(otlpJSONExport), &export); err != nil {
+		t.Fatalf("Invalid test export: %v", err)
+	}
+	encoded, err := proto.Marshal(&export)
+	if err != nil {
+		t.Fatalf("Failed to encode test export: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		gzipped     bool
+	}{
+		{"protobuf", contentTypeProtobuf, encoded, false},
+		{"json", contentTypeJSON, []byte(otlpJSONExport), false},
+		{"gzipped json", contentTypeJSON + "; charset=utf-8", []byte(otlpJSONExport), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newMockMappingHandler()
+			handler.ingester = handler.newIngester(config.IngestConfig{})
+			defer handler.ingester.Close()
+
+			w := httptest.NewRecorder()
+			newIngestMux(handler).ServeHTTP(w, newOTLPRequest(t, tt.contentType, tt.body, tt.gzipped))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp collogspb.ExportLogsServiceResponse
+			mediaType := contentTypeProtobuf
+			if tt.contentType != contentTypeProtobuf {
+				mediaType = contentTypeJSON
+			}
+			if got := w.Header().Get("Content-Type"); got != mediaType {
+				t.Errorf("Expected a %s response, got %q", mediaType, got)
+			}
+			if mediaType == contentTypeProtobuf {
+				err = proto.Unmarshal(w.Body.Bytes(), &resp)
+			} else {
+				err = protojson.Unmarshal(w.Body.Bytes(), &resp)
+			}
+			if err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if got := resp.GetPartialSuccess().GetRejectedLogRecords(); got != 1 {
+				t.Errorf("Expected the record without a body rejected, got %d rejected", got)
+			}
+
+			ctx := context.Background()
+			if err := handler.ingester.Flush(ctx); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+			examples, _ := handler.analyzer.Store().ListTemplateExamples(ctx, "acme")
+			if len(examples) != 2 || examples[0].Service != "api-server" || examples[0].Region != "us-east-1" {
+				t.Fatalf("Expected both records stored as examples from api-server in us-east-1, got %+v", examples)
+			}
+			if examples[0].TemplateID != examples[1].TemplateID {
+				t.Errorf("Expected the login records to share a template, got %+v", examples)
+			}
+		})
+	}
+}
+
+func TestExportOTLPLogsRejectsBadRequests(t *testing.T) {
+	handler := newMockMappingHandler()
+	handler.ingester = handler.newIngester(config.IngestConfig{})
+	defer handler.ingester.Close()
+	mux := newIngestMux(handler)
+
+	tests := []struct {
+		name   string
+		modify func(*http.Request)
+		body   string
+		want   int
+	}{
+		{"unsupported content type", func(r *http.Request) { r.Header.Set("Content-Type", "text/plain") }, otlpJSONExport, http.StatusUnsupportedMediaType},
+		{"missing org", func(r *http.Request) { r.Header.Del(OrgHeader) }, otlpJSONExport, http.StatusBadRequest},
+		{"unsupported encoding", func(r *http.Request) { r.Header.Set("Content-Encoding", "br") }, otlpJSONExport, http.StatusBadRequest},
+		{"malformed", func(*http.Request) {}, `{"resourceLogs":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOTLPRequest(t, contentTypeJSON, []byte(tt.body), false)
+			tt.modify(req)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestExportOTLPLogsOrgQueryParameter(t *testing.T) {
+	handler := newMockMappingHandler()
+	handler.ingester = handler.newIngester(config.IngestConfig{})
+	defer handler.ingester.Close()
+
+	req := newOTLPRequest(t, contentTypeJSON, []byte(otlpJSONExport), false)
+	req.Header.Del(OrgHeader)
+	req.URL.RawQuery = "org=globex"
+	w := httptest.NewRecorder()
+	newIngestMux(handler).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	ctx := context.Background()
+	if err := handler.ingester.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if examples, _ := handler.analyzer.Store().ListTemplateExamples(ctx, "globex"); len(examples) != 2 {
+		t.Errorf("Expected the records ingested for the org in the query, got %+v", examples)
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/cache"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in memory
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func spansNamed(spans tracetest.SpanStubs, name string) []tracetest.SpanStub {
+	var named []tracetest.SpanStub
+	for _, span := range spans {
+		if span.Name == name {
+			named = append(named, span)
+		}
+	}
+	return named
+}
+
+func TestQueryLogsTracing(t *testing.T) {
+	exporter := recordSpans(t)
+	handler := &Handler{
+		analyzer: analyzer.NewLogAnalyzerWithStore(clickhouse.NewObservedStore(clickhouse.NewMockStore(), nil)),
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
+	}
+
+	end := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	bodyBytes, _ := json.Marshal(QueryLogsRequest{
+		Org:        "test-org",
+		Dashboard:  "test-dashboard",
+		PanelTitle: "test-panel",
+		MetricName: "test-metric",
+		StartTime:  end.Add(-1 * time.Hour),
+		EndTime:    end,
+	})
+	w := httptest.NewRecorder()
+	handler.QueryLogs(w, httptest.NewRequest(http.MethodPost, "/query_logs", bytes.NewReader(bodyBytes)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	spans := exporter.GetSpans()
+	requests := spansNamed(spans, "Handler.QueryLogs")
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 request span, got %d", len(requests))
+	}
+	request := requests[0]
+	if got := spanAttribute(request, tracing.OrgKey).AsString(); got != "test-org" {
+		t.Errorf("Expected org test-org on the request span, got %q", got)
+	}
+	if got := spanAttribute(request, tracing.WindowLengthKey).AsFloat64(); got != 3600 {
+		t.Errorf("Expected a window length of 3600s, got %v", got)
+	}
+
+	lookups := spansNamed(spans, "Handler.cacheLookup")
+	if len(lookups) != 1 || spanAttribute(lookups[0], "hover.cache.result").AsString() != "miss" {
+		t.Fatalf("Expected one cache miss span, got %+v", lookups)
+	}
+
+	flights := spansNamed(spans, "Handler.singleFlight")
+	if len(flights) != 1 || flights[0].Parent.SpanID() != request.SpanContext.SpanID() {
+		t.Fatalf("Expected one single-flight span under the request, got %+v", flights)
+	}
+
+	// The analysis runs under the in-flight group's own context but stays in the request's trace
+	analyses := spansNamed(spans, "LogAnalyzer.AnalyzeLogs")
+	if len(analyses) != 1 {
+		t.Fatalf("Expected 1 analysis span, got %d", len(analyses))
+	}
+	analysis := analyses[0]
+	if analysis.Parent.SpanID() != flights[0].SpanContext.SpanID() {
+		t.Error("Expected the analysis span to be a child of the single-flight span")
+	}
+	templates := spanAttribute(analysis, tracing.TemplateCountKey).AsInt64()
+	if templates == 0 {
+		t.Error("Expected the analysis span to carry the template count")
+	}
+	if got := spanAttribute(request, tracing.TemplateCountKey).AsInt64(); got != templates {
+		t.Errorf("Expected the request span to carry %d templates, got %d", templates, got)
+	}
+
+	roles := make(map[string]int)
+	for _, span := range spansNamed(spans, "Store.GetTemplateCounts") {
+		if span.Parent.SpanID() != analysis.SpanContext.SpanID() {
+			t.Error("Expected store spans to be children of the analysis span")
+		}
+		roles[spanAttribute(span, tracing.WindowRoleKey).AsString()]++
+	}
+	if roles[tracing.RoleBaseline] != 1 || roles[tracing.RoleCurrent] != 1 {
+		t.Errorf("Expected one baseline and one current count query, got %v", roles)
+	}
+	if len(spansNamed(spans, "Store.GetRepresentativeLogs")) != 1 {
+		t.Error("Expected a span for fetching representative logs")
+	}
+
+	// A repeated query is answered from the cache without analyzing again
+	exporter.Reset()
+	w = httptest.NewRecorder()
+	handler.QueryLogs(w, httptest.NewRequest(http.MethodPost, "/query_logs", bytes.NewReader(bodyBytes)))
+	spans = exporter.GetSpans()
+	lookups = spansNamed(spans, "Handler.cacheLookup")
+	if len(lookups) != 1 || spanAttribute(lookups[0], "hover.cache.result").AsString() != "hit" {
+		t.Errorf("Expected one cache hit span, got %+v", lookups)
+	}
+	if len(spansNamed(spans, "LogAnalyzer.AnalyzeLogs")) != 0 {
+		t.Error("Expected no analysis span for a cached result")
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+)
+
+// LogGroupV2 exposes the scoring inputs behind each ranked template
+type LogGroupV2 struct {
+	TemplateID         string   `json:"template_id"`
+	RepresentativeLogs []string `json:"representative_logs"`
+	RelativeChange     float64  `json:"relative_change"`
+	// Change is "new", "vanished", "increased" or "decreased"; new templates are ranked first
+	Change            string   `json:"change"`
+	DivergenceScore   float64  `json:"divergence_score"`
+	Score             float64  `json:"score"`
+	PValue            *float64 `json:"p_value,omitempty"`
+	AdjustedPValue    *float64 `json:"adjusted_p_value,omitempty"`
+	Significant       *bool    `json:"significant,omitempty"`
+	BaselineCount     uint64   `json:"baseline_count"`
+	CurrentCount      uint64   `json:"current_count"`
+	BaselineFrequency float64  `json:"baseline_frequency"`
+	CurrentFrequency  float64  `json:"current_frequency"`
+	// Breakdown is present when the request set group_by
+	Breakdown []analyzer.BreakdownEntry `json:"breakdown,omitempty"`
+	// Series is present when the request set bucket_width
+	Series []analyzer.SeriesPoint `json:"series,omitempty"`
+}
+
+// WindowVolume is a queried time window and the total number of logs in it
+type WindowVolume struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Total uint64    `json:"total"`
+}
+
+// BaselineSummary describes how the baseline distribution was built
+type BaselineSummary struct {
+	Strategy string         `json:"strategy"`
+	Windows  []WindowVolume `json:"windows"`
+	// Total is the volume of the combined baseline (the per-template median for multi-window strategies)
+	Total uint64 `json:"total"`
+}
+
+// SignificanceSummary is the false-discovery-rate control applied to the results
+type SignificanceSummary struct {
+	Mode  string  `json:"mode"`
+	Level float64 `json:"level"`
+}
+
+type QueryLogsV2Response struct {
+	LogGroups []LogGroupV2 `json:"log_groups"`
+	// Scorer is the ranking method that produced Score and PValue
+	Scorer       string              `json:"scorer"`
+	Significance SignificanceSummary `json:"significance"`
+	Baseline     BaselineSummary     `json:"baseline"`
+	Current      WindowVolume        `json:"current"`
+	// Unmapped is set when the metric has no active log streams; Suggestions then lists
+	// streams that may belong to it, best first
+	Unmapped    bool                        `json:"unmapped,omitempty"`
+	Suggestions []analyzer.StreamSuggestion `json:"suggestions,omitempty"`
+	// Mock is set when the log groups are sample data rather than ClickHouse results
+	Mock bool `json:"mock,omitempty"`
+}
+
+// QueryLogsV2 accepts the same request as QueryLogs and returns template IDs,
+// scores and raw counts alongside the representative logs
+func (h *Handler) QueryLogsV2(w http.ResponseWriter, r *http.Request) {
+	h.serveQuery(w, r, "Handler.QueryLogsV2", func(result *analyzer.AnalysisResult, mock bool) interface{} {
+		resp := newQueryLogsV2Response(result)
+		resp.Mock = mock
+		return resp
+	})
+}
+
+func newQueryLogsV2Response(result *analyzer.AnalysisResult) QueryLogsV2Response {
+	logGroups := make([]LogGroupV2, len(result.LogGroups))
+	for i, group := range result.LogGroups {
+		logGroups[i] = LogGroupV2{
+			TemplateID:         group.TemplateID,
+			RepresentativeLogs: group.RepresentativeLogs,
+			RelativeChange:     group.RelativeChange,
+			Change:             string(group.Change),
+			DivergenceScore:    group.KLContribution,
+			Score:              group.Score,
+			PValue:             group.PValue,
+			AdjustedPValue:     group.AdjustedPValue,
+			Significant:        group.Significant,
+			BaselineCount:      group.BaselineCount,
+			CurrentCount:       group.CurrentCount,
+			BaselineFrequency:  group.BaselineFrequency,
+			CurrentFrequency:   group.CurrentFrequency,
+			Breakdown:          group.Breakdown,
+			Series:             group.Series,
+		}
+	}
+
+	baselineWindows := make([]WindowVolume, len(result.BaselineWindows))
+	for i, window := range result.BaselineWindows {
+		baselineWindows[i] = WindowVolume{Start: window.Start, End: window.End}
+		if i < len(result.BaselineWindowTotals) {
+			baselineWindows[i].Total = result.BaselineWindowTotals[i]
+		}
+	}
+
+	return QueryLogsV2Response{
+		LogGroups: logGroups,
+		Scorer:    result.Scorer,
+		Significance: SignificanceSummary{
+			Mode:  string(result.Significance.Mode),
+			Level: result.Significance.Level,
+		},
+		Baseline: BaselineSummary{
+			Strategy: string(result.BaselineStrategy),
+			Windows:  baselineWindows,
+			Total:    result.BaselineTotal,
+		},
+		Current: WindowVolume{
+			Start: result.CurrentWindow.Start,
+			End:   result.CurrentWindow.End,
+			Total: result.CurrentTotal,
+		},
+		Unmapped:    result.Unmapped,
+		Suggestions: result.Suggestions,
+	}
+}
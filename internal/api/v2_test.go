@@ -0,0 +1,373 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/cache"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+func TestQueryLogsV2Response(t *testing.T) {
+	mockStore := clickhouse.NewMockStore()
+	handler := &Handler{
+		analyzer: analyzer.NewLogAnalyzerWithStore(mockStore),
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
+	}
+
+	end := time.Now().Truncate(time.Second)
+	reqBody := QueryLogsRequest{
+		Org:        "test-org",
+		Dashboard:  "test-dashboard",
+		PanelTitle: "CPU Usage",
+		MetricName: "cpu_percent",
+		StartTime:  end.Add(-1 * time.Hour),
+		EndTime:    end,
+	}
+	bodyBytes, _ := json.Marshal(reqBody)
+
+	req := httptest.NewRequest(http.MethodPost, "/v2/query_logs", bytes.NewReader(bodyBytes))
+	w := httptest.NewRecorder()
+	handler.QueryLogsV2(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var resp QueryLogsV2Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if len(resp.LogGroups) == 0 {
+		t.Fatal("Expected log groups from mock store")
+	}
+
+	// Mock store returns the same counts for every window
+	mockCounts, _ := mockStore.GetTemplateCounts(req.Context(), "", "", "", "", time.Time{}, time.Time{})
+	var mockTotal uint64
+	for _, count := range mockCounts {
+		mockTotal += count
+	}
+
+	for _, group := range resp.LogGroups {
+		if group.TemplateID == "" {
+			t.Error("Expected every v2 log group to carry a template_id")
+		}
+		if group.Change != string(analyzer.ChangeDecreased) {
+			t.Errorf("Template %s: expected unchanged share to classify as decreased, got %q", group.TemplateID, group.Change)
+		}
+		if group.CurrentCount != mockCounts[group.TemplateID] || group.BaselineCount != mockCounts[group.TemplateID] {
+			t.Errorf("Template %s: expected counts %d/%d, got baseline %d current %d",
+				group.TemplateID, mockCounts[group.TemplateID], mockCounts[group.TemplateID], group.BaselineCount, group.CurrentCount)
+		}
+		wantFreq := float64(mockCounts[group.TemplateID]) / float64(mockTotal)
+		if group.CurrentFrequency != wantFreq {
+			t.Errorf("Template %s: expected current frequency %v, got %v", group.TemplateID, wantFreq, group.CurrentFrequency)
+		}
+	}
+
+	if resp.Current.Total != mockTotal || resp.Baseline.Total != mockTotal {
+		t.Errorf("Expected window totals of %d, got current %d baseline %d", mockTotal, resp.Current.Total, resp.Baseline.Total)
+	}
+	if !resp.Current.Start.Equal(reqBody.StartTime) || !resp.Current.End.Equal(reqBody.EndTime) {
+		t.Errorf("Expected current window %v-%v, got %v-%v", reqBody.StartTime, reqBody.EndTime, resp.Current.Start, resp.Current.End)
+	}
+	if resp.Baseline.Strategy != string(analyzer.BaselinePreviousWindow) {
+		t.Errorf("Expected default baseline strategy, got %q", resp.Baseline.Strategy)
+	}
+	if len(resp.Baseline.Windows) != 1 || !resp.Baseline.Windows[0].End.Equal(reqBody.StartTime) {
+		t.Errorf("Expected a single baseline window ending at start_time, got %+v", resp.Baseline.Windows)
+	}
+	if resp.Baseline.Windows[0].Total != mockTotal {
+		t.Errorf("Expected baseline window total %d, got %d", mockTotal, resp.Baseline.Windows[0].Total)
+	}
+}
+
+func TestQueryLogsV1ResponseUnchanged(t *testing.T) {
+	handler := &Handler{
+		analyzer: analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore()),
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
+	}
+
+	bodyBytes, _ := json.Marshal(QueryLogsRequest{
+		Org:        "test-org",
+		Dashboard:  "test-dashboard",
+		PanelTitle: "CPU Usage",
+		MetricName: "cpu_percent",
+		StartTime:  time.Now().Add(-1 * time.Hour),
+		EndTime:    time.Now(),
+	})
+	w := httptest.NewRecorder()
+	handler.QueryLogs(w, httptest.NewRequest(http.MethodPost, "/query_logs", bytes.NewReader(bodyBytes)))
+
+	var raw struct {
+		LogGroups []map[string]json.RawMessage `json:"log_groups"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(raw.LogGroups) == 0 {
+		t.Fatal("Expected log groups from mock store")
+	}
+	for key := range raw.LogGroups[0] {
+		if key != "representative_logs" && key != "relative_change" {
+			t.Errorf("Unexpected field %q in v1 log group", key)
+		}
+	}
+}
+
+func TestQueryLogsV2Scorer(t *testing.T) {
+	handler := &Handler{
+		analyzer: analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore()),
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
+	}
+
+	tests := []struct {
+		scorer         string
+		expectedStatus int
+	}{
+		{scorer: "", expectedStatus: http.StatusOK},
+		{scorer: analyzer.ScorerChiSquare, expectedStatus: http.StatusOK},
+		{scorer: "entropy", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scorer, func(t *testing.T) {
+			bodyBytes, _ := json.Marshal(QueryLogsRequest{
+				Org:        "test-org",
+				Dashboard:  "test-dashboard",
+				PanelTitle: "CPU Usage",
+				MetricName: "cpu_percent",
+				StartTime:  time.Now().Add(-1 * time.Hour),
+				EndTime:    time.Now(),
+				Scorer:     tt.scorer,
+			})
+			w := httptest.NewRecorder()
+			handler.QueryLogsV2(w, httptest.NewRequest(http.MethodPost, "/v2/query_logs", bytes.NewReader(bodyBytes)))
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d. Body: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp QueryLogsV2Response
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			want := tt.scorer
+			if want == "" {
+				want = analyzer.ScorerJSDivergence
+			}
+			if resp.Scorer != want {
+				t.Errorf("Expected scorer %q, got %q", want, resp.Scorer)
+			}
+			if want == analyzer.ScorerChiSquare {
+				for _, group := range resp.LogGroups {
+					if group.PValue == nil {
+						t.Errorf("Expected chi-square p-value for %s", group.TemplateID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestQueryLogsSignificanceDropOnQuietWindow(t *testing.T) {
+	handler := &Handler{
+		analyzer:     analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore()),
+		cache:        cache.NewLRU(10),
+		cacheTTL:     10 * time.Second,
+		significance: analyzer.SignificanceOptions{Mode: analyzer.SignificanceFlag},
+	}
+
+	// The mock store returns identical counts for every window, so nothing changed
+	bodyBytes, _ := json.Marshal(QueryLogsRequest{
+		Org:              "test-org",
+		Dashboard:        "test-dashboard",
+		PanelTitle:       "CPU Usage",
+		MetricName:       "cpu_percent",
+		StartTime:        time.Now().Add(-1 * time.Hour),
+		EndTime:          time.Now(),
+		SignificanceMode: string(analyzer.SignificanceDrop),
+	})
+	w := httptest.NewRecorder()
+	handler.QueryLogsV2(w, httptest.NewRequest(http.MethodPost, "/v2/query_logs", bytes.NewReader(bodyBytes)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var resp QueryLogsV2Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(resp.LogGroups) != 0 {
+		t.Errorf("Expected a quiet window to come back empty, got %d log groups", len(resp.LogGroups))
+	}
+	if resp.Significance.Mode != string(analyzer.SignificanceDrop) || resp.Significance.Level != analyzer.DefaultSignificanceLevel {
+		t.Errorf("Expected drop at default level, got %+v", resp.Significance)
+	}
+
+	// An invalid level is a client error
+	bodyBytes, _ = json.Marshal(QueryLogsRequest{
+		Org:               "test-org",
+		Dashboard:         "test-dashboard",
+		PanelTitle:        "CPU Usage",
+		MetricName:        "cpu_percent",
+		StartTime:         time.Now().Add(-1 * time.Hour),
+		EndTime:           time.Now(),
+		SignificanceLevel: 2,
+	})
+	w = httptest.NewRecorder()
+	handler.QueryLogsV2(w, httptest.NewRequest(http.MethodPost, "/v2/query_logs", bytes.NewReader(bodyBytes)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid significance level, got %d", w.Code)
+	}
+}
+
+func TestQueryLogsV2GroupBy(t *testing.T) {
+	handler := &Handler{
+		analyzer: analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore()),
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
+	}
+
+	request := func(groupBy []string) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(QueryLogsRequest{
+			Org:        "test-org",
+			Dashboard:  "test-dashboard",
+			PanelTitle: "CPU Usage",
+			MetricName: "cpu_percent",
+			StartTime:  time.Now().Add(-1 * time.Hour),
+			EndTime:    time.Now(),
+			GroupBy:    groupBy,
+		})
+		w := httptest.NewRecorder()
+		handler.QueryLogsV2(w, httptest.NewRequest(http.MethodPost, "/v2/query_logs", bytes.NewReader(bodyBytes)))
+		return w
+	}
+
+	w := request([]string{"service", "region"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	var resp QueryLogsV2Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	for _, group := range resp.LogGroups {
+		if len(group.Breakdown) == 0 {
+			t.Errorf("Expected a breakdown for %s", group.TemplateID)
+			continue
+		}
+		for _, entry := range group.Breakdown {
+			if entry.Dimensions["service"] == "" || entry.Dimensions["region"] == "" {
+				t.Errorf("Expected service and region in breakdown of %s, got %v", group.TemplateID, entry.Dimensions)
+			}
+		}
+	}
+
+	if w := request([]string{"hostname"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown group_by dimension, got %d", w.Code)
+	}
+}
+
+func TestQueryLogsV2BucketWidth(t *testing.T) {
+	handler := &Handler{
+		analyzer: analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore()),
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
+	}
+
+	end := time.Now().Truncate(time.Minute)
+	request := func(bucketWidth string) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(QueryLogsRequest{
+			Org:         "test-org",
+			Dashboard:   "test-dashboard",
+			PanelTitle:  "CPU Usage",
+			MetricName:  "cpu_percent",
+			StartTime:   end.Add(-1 * time.Hour),
+			EndTime:     end,
+			BucketWidth: bucketWidth,
+		})
+		w := httptest.NewRecorder()
+		handler.QueryLogsV2(w, httptest.NewRequest(http.MethodPost, "/v2/query_logs", bytes.NewReader(bodyBytes)))
+		return w
+	}
+
+	w := request("5m")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	var resp QueryLogsV2Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	for _, group := range resp.LogGroups {
+		if len(group.Series) == 0 {
+			t.Errorf("Expected a series for %s", group.TemplateID)
+		}
+	}
+
+	for _, invalid := range []string{"soon", "1ms", "1s"} {
+		if w := request(invalid); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for bucket_width %q, got %d", invalid, w.Code)
+		}
+	}
+}
+
+func TestQueryLogsV2MetricSeries(t *testing.T) {
+	handler := &Handler{
+		analyzer: analyzer.NewLogAnalyzerWithStore(clickhouse.NewMockStore()),
+		cache:    cache.NewLRU(10),
+		cacheTTL: 10 * time.Second,
+	}
+
+	end := time.Now().Truncate(time.Minute)
+	request := func(series []analyzer.MetricPoint) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(QueryLogsRequest{
+			Org:          "test-org",
+			Dashboard:    "test-dashboard",
+			PanelTitle:   "CPU Usage",
+			MetricName:   "cpu_percent",
+			StartTime:    end.Add(-1 * time.Hour),
+			EndTime:      end,
+			MetricSeries: series,
+		})
+		w := httptest.NewRecorder()
+		handler.QueryLogsV2(w, httptest.NewRequest(http.MethodPost, "/v2/query_logs", bytes.NewReader(bodyBytes)))
+		return w
+	}
+
+	valid := []analyzer.MetricPoint{
+		{Time: end.Add(-50 * time.Minute), Value: 1},
+		{Time: end.Add(-30 * time.Minute), Value: 4},
+		{Time: end.Add(-10 * time.Minute), Value: 2},
+	}
+	if w := request(valid); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	unordered := []analyzer.MetricPoint{valid[1], valid[0]}
+	if w := request(unordered); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unordered metric series, got %d", w.Code)
+	}
+	long := make([]analyzer.MetricPoint, analyzer.MaxMetricSeriesPoints+1)
+	for i := range long {
+		long[i] = analyzer.MetricPoint{Time: end.Add(time.Duration(i-len(long)) * time.Second)}
+	}
+	if w := request(long); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for too many metric points, got %d", w.Code)
+	}
+}
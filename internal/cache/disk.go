@@ -0,0 +1,333 @@
+package cache
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var resultsBucket = []byte("results")
+
+// expiryPrefixLen is the size of the expiry timestamp stored ahead of each encoded result
+const expiryPrefixLen = 8
+
+// DefaultMaxBytes is the disk cache budget used when none is configured
+const DefaultMaxBytes = 64 << 20
+
+var (
+	// disks shares one Disk per path, because bbolt locks its file and Grafana creates a
+	// plugin instance's replacement before disposing of the old one
+	disksMu sync.Mutex
+	disks   = make(map[string]*Disk)
+)
+
+// Disk is a persistent cache in an embedded bbolt database, so results survive plugin
+// restarts. Once the total size passes the byte budget, expired entries are removed
+// first, then the entries closest to expiring.
+type Disk struct {
+	db       *bolt.DB
+	path     string
+	maxBytes int64
+	refs     int
+
+	mu         sync.Mutex // guards totalBytes and onEvict
+	totalBytes int64
+	onEvict    func(evicted int)
+}
+
+// OpenDisk opens (or creates) the cache database at path. Opening a path that is already
+// open returns the same cache, keeping the byte budget it was first opened with.
+func OpenDisk(path string, maxBytes int64) (*Disk, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	path = filepath.Clean(path)
+
+	disksMu.Lock()
+	defer disksMu.Unlock()
+
+	if d, ok := disks[path]; ok {
+		d.refs++
+		return d, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open disk cache %s: %w", path, err)
+	}
+
+	var totalBytes int64
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(resultsBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			totalBytes += int64(len(k) + len(v))
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize disk cache %s: %w", path, err)
+	}
+	d := &Disk{db: db, path: path, maxBytes: maxBytes, refs: 1, totalBytes: totalBytes}
+
+	disks[path] = d
+	return d, nil
+}
+
+// Get implements Cache
+func (d *Disk) Get(key string) (Entry, bool) {
+	var value []byte
+	d.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(resultsBucket).Get([]byte(key)); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if len(value) < expiryPrefixLen {
+		return Entry{}, false
+	}
+
+	if time.Now().After(expiry(value)) {
+		d.deleteExpired(key)
+		return Entry{}, false
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(value[expiryPrefixLen:], &entry); err != nil || entry.Result == nil {
+		// Usually written by an older version; it will be overwritten on the next Set
+		slog.Warn("Disk cache: ignoring undecodable entry", "key", key, "error", err)
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// Set implements Cache
+func (d *Disk) Set(key string, entry Entry, ttl time.Duration) {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("Disk cache: failed to encode result", "key", key, "error", err)
+		return
+	}
+	value := make([]byte, expiryPrefixLen+len(encoded))
+	binary.BigEndian.PutUint64(value, uint64(time.Now().Add(ttl).UnixNano()))
+	copy(value[expiryPrefixLen:], encoded)
+
+	if int64(len(key)+len(value)) > d.maxBytes {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Sizes only change once the transaction commits, so a failed write can't skew them
+	var delta int64
+	evicting, evicted := false, 0
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(resultsBucket)
+		if old := b.Get([]byte(key)); old != nil {
+			delta -= int64(len(key) + len(old))
+		}
+		if err := b.Put([]byte(key), value); err != nil {
+			return err
+		}
+		delta += int64(len(key) + len(value))
+
+		if total := d.totalBytes + delta; total > d.maxBytes {
+			freed, n := d.evict(b, total)
+			delta -= freed
+			evicting, evicted = true, n
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Disk cache: failed to store result", "key", key, "error", err)
+		return
+	}
+	d.totalBytes += delta
+
+	if evicting {
+		slog.Debug("Disk cache: evicted entries to stay within budget", "evicted", evicted, "max_bytes", d.maxBytes)
+		if d.onEvict != nil {
+			d.onEvict(evicted)
+		}
+	}
+}
+
+// evict deletes entries until total, the cache's size within the update, is under the byte
+// budget, and returns the bytes freed and entries deleted. Callers must hold mu inside an
+// update, and count the result only once it commits.
+func (d *Disk) evict(b *bolt.Bucket, total int64) (freed int64, evicted int) {
+	type candidate struct {
+		key       []byte
+		size      int64
+		expiresAt time.Time
+	}
+
+	var candidates []candidate
+	b.ForEach(func(k, v []byte) error {
+		candidates = append(candidates, candidate{
+			key:       append([]byte(nil), k...),
+			size:      int64(len(k) + len(v)),
+			expiresAt: expiry(v),
+		})
+		return nil
+	})
+
+	// Expired entries sort first, since they expire earliest
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].expiresAt.Before(candidates[j].expiresAt)
+	})
+
+	// Leave some headroom so that every Set near the budget doesn't trigger a full scan
+	target := d.maxBytes * 9 / 10
+	for _, c := range candidates {
+		if total-freed <= target {
+			break
+		}
+		if err := b.Delete(c.key); err != nil {
+			continue
+		}
+		freed += c.size
+		evicted++
+	}
+	return freed, evicted
+}
+
+// deleteExpired removes key unless it was refreshed since it was read
+func (d *Disk) deleteExpired(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var freed int64
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(resultsBucket)
+		if old := b.Get([]byte(key)); old != nil && time.Now().After(expiry(old)) {
+			freed = int64(len(key) + len(old))
+			return b.Delete([]byte(key))
+		}
+		return nil
+	})
+	if err == nil {
+		d.totalBytes -= freed
+	}
+}
+
+// RemoveExpired implements Cache
+func (d *Disk) RemoveExpired() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var freed int64
+	expired := 0
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(resultsBucket)
+
+		// Collect first: deleting while iterating would skip entries
+		var keys [][]byte
+		var sizes []int64
+		b.ForEach(func(k, v []byte) error {
+			if now.After(expiry(v)) {
+				keys = append(keys, append([]byte(nil), k...))
+				sizes = append(sizes, int64(len(k)+len(v)))
+			}
+			return nil
+		})
+
+		for i, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			freed += sizes[i]
+			expired++
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Disk cache: failed to remove expired entries", "error", err)
+		return 0
+	}
+	d.totalBytes -= freed
+	return expired
+}
+
+// Clear implements Cache
+func (d *Disk) Clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(resultsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(resultsBucket)
+		return err
+	})
+	if err != nil {
+		slog.Error("Disk cache: failed to clear", "error", err)
+		return
+	}
+	d.totalBytes = 0
+}
+
+// OnEvict implements Cache. The disk cache is shared by every opener of its path, so the
+// last registered function receives all evictions.
+func (d *Disk) OnEvict(fn func(evicted int)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onEvict = fn
+}
+
+// Len implements Cache
+func (d *Disk) Len() int {
+	n := 0
+	d.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(resultsBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Size returns the total bytes of keys and values stored
+func (d *Disk) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.totalBytes
+}
+
+// Close implements Cache. The database is closed once every opener has closed it.
+func (d *Disk) Close() error {
+	disksMu.Lock()
+	defer disksMu.Unlock()
+
+	d.refs--
+	if d.refs > 0 {
+		return nil
+	}
+	delete(disks, d.path)
+	return d.db.Close()
+}
+
+func expiry(value []byte) time.Time {
+	if len(value) < expiryPrefixLen {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value[:expiryPrefixLen])))
+}
+
+// Ensure Disk implements Cache interface
+var _ Cache = (*Disk)(nil)
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestDisk(t *testing.T, maxBytes int64) (*Disk, string) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	d, err := OpenDisk(path, maxBytes)
+	if err != nil {
+		t.Fatalf("OpenDisk failed: %v", err)
+	}
+	return d, path
+}
+
+func TestDiskRoundTrip(t *testing.T) {
+	d, _ := openTestDisk(t, 0)
+	defer d.Close()
+
+	want := testResult("template-1")
+	staleAt := time.Now().Add(30 * time.Second).Round(0)
+	d.Set("key", Entry{Result: want, StaleAt: staleAt}, time.Minute)
+
+	entry, ok := d.Get("key")
+	if !ok {
+		t.Fatal("Expected cache hit")
+	}
+	if !entry.StaleAt.Equal(staleAt) {
+		t.Errorf("Expected StaleAt %v, got %v", staleAt, entry.StaleAt)
+	}
+	got := entry.Result
+	if got.LogGroups[0].TemplateID != "template-1" || got.CurrentTotal != want.CurrentTotal || got.Scorer != want.Scorer {
+		t.Errorf("Decoded result differs: got %+v, want %+v", got, want)
+	}
+	if _, ok := d.Get("missing"); ok {
+		t.Error("Expected miss for unknown key")
+	}
+}
+
+func TestDiskSurvivesReopen(t *testing.T) {
+	d, path := openTestDisk(t, 0)
+	d.Set("historical", Entry{Result: testResult("t")}, time.Hour)
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reopened, err := OpenDisk(path, 0)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+
+	if _, ok := reopened.Get("historical"); !ok {
+		t.Error("Expected entry to survive reopening")
+	}
+	if reopened.Size() == 0 {
+		t.Error("Expected size to be restored from the existing database")
+	}
+}
+
+func TestDiskExpiry(t *testing.T) {
+	d, _ := openTestDisk(t, 0)
+	defer d.Close()
+
+	d.Set("a", Entry{Result: testResult("t")}, 20*time.Millisecond)
+	d.Set("b", Entry{Result: testResult("t")}, 20*time.Millisecond)
+	d.Set("c", Entry{Result: testResult("t")}, time.Minute)
+	time.Sleep(40 * time.Millisecond)
+
+	if _, ok := d.Get("a"); ok {
+		t.Error("Expected expired entry to be a miss")
+	}
+	if removed := d.RemoveExpired(); removed != 1 {
+		t.Errorf("Expected 1 expired entry removed, got %d", removed)
+	}
+	if d.Len() != 1 {
+		t.Errorf("Expected 1 entry left, got %d", d.Len())
+	}
+}
+
+func TestDiskClear(t *testing.T) {
+	d, _ := openTestDisk(t, 0)
+	defer d.Close()
+
+	d.Set("a", Entry{Result: testResult("t")}, time.Minute)
+	d.Set("b", Entry{Result: testResult("t")}, time.Minute)
+	d.Clear()
+
+	if d.Len() != 0 || d.Size() != 0 {
+		t.Errorf("Expected an empty cache after Clear, got %d entries of %d bytes", d.Len(), d.Size())
+	}
+	d.Set("c", Entry{Result: testResult("t")}, time.Minute)
+	if _, ok := d.Get("c"); !ok {
+		t.Error("Expected the cache to be usable after Clear")
+	}
+}
+
+func TestDiskByteBudgetEvictsSoonestToExpire(t *testing.T) {
+	// Measure one entry, then allow roughly four of them
+	probe, _ := openTestDisk(t, 0)
+	probe.Set("key-0", Entry{Result: testResult("t")}, time.Minute)
+	entrySize := probe.Size()
+	probe.Close()
+
+	d, _ := openTestDisk(t, entrySize*4)
+	defer d.Close()
+
+	// Later keys live longer, so the earliest keys should go first
+	for i := 0; i < 8; i++ {
+		d.Set(fmt.Sprintf("key-%d", i), Entry{Result: testResult("t")}, time.Duration(i+1)*time.Minute)
+	}
+
+	if d.Size() > entrySize*4 {
+		t.Errorf("Expected size within budget %d, got %d", entrySize*4, d.Size())
+	}
+	if _, ok := d.Get("key-0"); ok {
+		t.Error("Expected the soonest-expiring entry to be evicted")
+	}
+	if _, ok := d.Get("key-7"); !ok {
+		t.Error("Expected the longest-lived entry to be kept")
+	}
+}
+
+func TestDiskSizeMatchesStoredBytes(t *testing.T) {
+	d, _ := openTestDisk(t, 4096)
+	defer d.Close()
+
+	storedBytes := func() int64 {
+		var n int64
+		d.db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(resultsBucket).ForEach(func(k, v []byte) error {
+				n += int64(len(k) + len(v))
+				return nil
+			})
+		})
+		return n
+	}
+
+	for i := 0; i < 20; i++ {
+		d.Set(fmt.Sprintf("key-%d", i%5), Entry{Result: testResult("t")}, time.Duration(i+1)*time.Minute)
+	}
+	// bbolt rejects the key, so the write rolls back
+	d.Set(strings.Repeat("k", bolt.MaxKeySize+1), Entry{Result: testResult("t")}, time.Minute)
+
+	if got, want := d.Size(), storedBytes(); got != want {
+		t.Errorf("Expected size %d to match the stored bytes %d", got, want)
+	}
+}
+
+func TestDiskSharedAcrossOpens(t *testing.T) {
+	first, path := openTestDisk(t, 0)
+	// A second open of the same path must not block on bbolt's file lock
+	second, err := OpenDisk(path, 0)
+	if err != nil {
+		t.Fatalf("Second open failed: %v", err)
+	}
+	if first != second {
+		t.Fatal("Expected the same cache for the same path")
+	}
+
+	first.Set("key", Entry{Result: testResult("t")}, time.Minute)
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Still open for the second holder
+	if _, ok := second.Get("key"); !ok {
+		t.Error("Expected cache to stay usable until every opener closes it")
+	}
+	if err := second.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
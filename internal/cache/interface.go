@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+)
+
+// Entry is a cached analysis result
+type Entry struct {
+	Result *analyzer.AnalysisResult `json:"result"`
+	// StaleAt is when the result should be refreshed; it may still be served until it expires
+	StaleAt time.Time `json:"stale_at"`
+}
+
+// Stale reports whether the entry is due for a refresh
+func (e Entry) Stale() bool {
+	return time.Now().After(e.StaleAt)
+}
+
+// Cache stores analysis results by request key until they expire
+type Cache interface {
+	// Get returns an unexpired entry, dropping it if it has expired
+	Get(key string) (Entry, bool)
+	// Set stores entry until ttl elapses
+	Set(key string, entry Entry, ttl time.Duration)
+	// RemoveExpired drops every expired entry and returns how many were removed
+	RemoveExpired() int
+	// Clear drops every entry, for when the data behind cached results has changed
+	Clear()
+	Len() int
+	// OnEvict registers fn to be told how many entries were evicted to stay within the
+	// cache's size limit
+	OnEvict(fn func(evicted int))
+	Close() error
+}
+
+// Backend names accepted by the cache.backend setting
+const (
+	BackendMemory = "memory"
+	BackendDisk   = "disk"
+)
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type lruEntry struct {
+	key       string
+	entry     Entry
+	expiresAt time.Time
+}
+
+// LRU is an in-memory cache that evicts the least recently used entry once full
+type LRU struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element // map key to list element
+	order   *list.List               // doubly-linked list for LRU order
+	maxSize int
+	onEvict func(evicted int)
+}
+
+// NewLRU creates an in-memory cache holding at most maxSize entries
+func NewLRU(maxSize int) *LRU {
+	return &LRU{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		maxSize: maxSize,
+	}
+}
+
+// Get implements Cache
+func (c *LRU) Get(key string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[key]
+	if !exists {
+		return Entry{}, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		// Expired, remove it
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return Entry{}, false
+	}
+
+	// Move to front (most recently used) - O(1)
+	c.order.MoveToFront(elem)
+	return entry.entry, true
+}
+
+// Set implements Cache
+func (c *LRU) Set(key string, entry Entry, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		existing := elem.Value.(*lruEntry)
+		existing.entry = entry
+		existing.expiresAt = time.Now().Add(ttl)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	// Evict LRU entry if cache is at max size - O(1)
+	if c.order.Len() >= c.maxSize {
+		if elem := c.order.Back(); elem != nil {
+			c.order.Remove(elem)
+			delete(c.entries, elem.Value.(*lruEntry).key)
+			if c.onEvict != nil {
+				c.onEvict(1)
+			}
+		}
+	}
+
+	// Add to front of list (most recently used) - O(1)
+	c.entries[key] = c.order.PushFront(&lruEntry{
+		key:       key,
+		entry:     entry,
+		expiresAt: time.Now().Add(ttl),
+	})
+}
+
+// RemoveExpired implements Cache. It walks every entry, so it is meant to run periodically.
+func (c *LRU) RemoveExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	expired := 0
+	var next *list.Element
+	for elem := c.order.Front(); elem != nil; elem = next {
+		next = elem.Next()
+		entry := elem.Value.(*lruEntry)
+		if now.After(entry.expiresAt) {
+			c.order.Remove(elem)
+			delete(c.entries, entry.key)
+			expired++
+		}
+	}
+	return expired
+}
+
+// Clear implements Cache
+func (c *LRU) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Len implements Cache
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// MaxSize returns the number of entries kept before evicting
+func (c *LRU) MaxSize() int {
+	return c.maxSize
+}
+
+// OnEvict implements Cache
+func (c *LRU) OnEvict(fn func(evicted int)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onEvict = fn
+}
+
+// Close implements Cache
+func (c *LRU) Close() error {
+	return nil
+}
+
+// Ensure LRU implements Cache interface
+var _ Cache = (*LRU)(nil)
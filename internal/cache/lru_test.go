@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/analyzer"
+)
+
+func testResult(templateID string) *analyzer.AnalysisResult {
+	return &analyzer.AnalysisResult{
+		LogGroups: []analyzer.LogGroup{{
+			TemplateID:         templateID,
+			RepresentativeLogs: []string{"ERROR: connection timeout"},
+			RelativeChange:     150.0,
+			CurrentCount:       25,
+		}},
+		Scorer:        "js_divergence",
+		CurrentTotal:  25,
+		BaselineTotal: 10,
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU(3)
+	for i := 0; i < 3; i++ {
+		c.Set(fmt.Sprintf("key-%d", i), Entry{Result: testResult("t")}, time.Minute)
+	}
+
+	// Touch key-0 so key-1 becomes the least recently used
+	if _, ok := c.Get("key-0"); !ok {
+		t.Fatal("Expected key-0 to be cached")
+	}
+	c.Set("key-3", Entry{Result: testResult("t")}, time.Minute)
+
+	if _, ok := c.Get("key-1"); ok {
+		t.Error("Expected key-1 to be evicted")
+	}
+	for _, key := range []string{"key-0", "key-2", "key-3"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected %s to still be cached", key)
+		}
+	}
+	if c.Len() != 3 {
+		t.Errorf("Expected 3 entries, got %d", c.Len())
+	}
+}
+
+func TestLRUUpdateDoesNotEvict(t *testing.T) {
+	c := NewLRU(2)
+	c.Set("a", Entry{Result: testResult("old")}, time.Minute)
+	c.Set("b", Entry{Result: testResult("t")}, time.Minute)
+	c.Set("a", Entry{Result: testResult("new")}, time.Minute)
+
+	if c.Len() != 2 {
+		t.Fatalf("Expected 2 entries, got %d", c.Len())
+	}
+	entry, ok := c.Get("a")
+	if !ok || entry.Result.LogGroups[0].TemplateID != "new" {
+		t.Errorf("Expected updated result for a, got %+v", entry.Result)
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	c := NewLRU(10)
+	c.Set("short", Entry{Result: testResult("t")}, 20*time.Millisecond)
+	c.Set("long", Entry{Result: testResult("t")}, time.Minute)
+
+	time.Sleep(40 * time.Millisecond)
+
+	if _, ok := c.Get("short"); ok {
+		t.Error("Expected expired entry to be a miss")
+	}
+	c.Set("short2", Entry{Result: testResult("t")}, 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+
+	if removed := c.RemoveExpired(); removed != 1 {
+		t.Errorf("Expected 1 expired entry removed, got %d", removed)
+	}
+	if c.Len() != 1 {
+		t.Errorf("Expected only the long-lived entry to remain, got %d", c.Len())
+	}
+}
+
+func TestLRUClear(t *testing.T) {
+	c := NewLRU(10)
+	c.Set("a", Entry{Result: testResult("t")}, time.Minute)
+	c.Set("b", Entry{Result: testResult("t")}, time.Minute)
+
+	c.Clear()
+	if c.Len() != 0 {
+		t.Errorf("Expected no entries after Clear, got %d", c.Len())
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("Expected a cleared entry to be a miss")
+	}
+	c.Set("c", Entry{Result: testResult("t")}, time.Minute)
+	if _, ok := c.Get("c"); !ok {
+		t.Error("Expected the cache to be usable after Clear")
+	}
+}
@@ -2,10 +2,11 @@ package clickhouse
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 	"time"
 
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
@@ -47,6 +48,14 @@ func NewClient(cfg *config.ClickHouseConfig) (*Client, error) {
 		},
 	}
 
+	if cfg.TLS {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS = tlsConfig
+	}
+
 	db := clickhouse.OpenDB(opts)
 	db.SetMaxIdleConns(5)
 	db.SetMaxOpenConns(10)
@@ -64,73 +73,83 @@ func (c *Client) Close() error {
 	return c.db.Close()
 }
 
-// VerifyTables checks if required tables exist, creating them if missing
+// requiredTables are the tables and views the analyzer, the mapping API and ingestion
+// query directly
+var requiredTables = []string{"logs", "template_examples", "metric_log_hover_mv",
+	"metrics", "log_streams", "metric_log_mappings", "mapping_audit_log", "log_templates"}
+
+// Migrator returns a migrator bound to this client's connection
+func (c *Client) Migrator() (*Migrator, error) {
+	return NewMigrator(c.db)
+}
+
+// VerifyTables applies any pending schema migrations and checks that the
+// required tables exist, logging the applied schema version
 func (c *Client) VerifyTables() error {
-	requiredTables := []string{"logs", "template_examples"}
-	var missingTables []string
+	ctx := context.Background()
 
-	for _, tableName := range requiredTables {
-		query := fmt.Sprintf("SELECT 1 FROM %s LIMIT 0", tableName)
-		_, err := c.db.Query(query)
-		if err != nil {
-			if containsError(err, "UNKNOWN_TABLE") || containsError(err, "doesn't exist") {
-				log.Printf("✗ Table '%s' does not exist", tableName)
-				missingTables = append(missingTables, tableName)
-			} else {
-				return fmt.Errorf("error checking table '%s': %w", tableName, err)
-			}
-		} else {
-			log.Printf("✓ Table '%s' exists", tableName)
-		}
+	migrator, err := c.Migrator()
+	if err != nil {
+		return err
 	}
 
-	if len(missingTables) > 0 {
-		log.Printf("Missing tables: %v. Creating them now...", missingTables)
-		if err := c.createTables(); err != nil {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !status.UpToDate() {
+		slog.Info("ClickHouse schema is out of date, applying migrations", "version", status.Version, "latest", status.Latest)
+		if _, err := migrator.Up(ctx); err != nil {
 			return err
 		}
-
-		// Verify tables were created
-		for _, tableName := range missingTables {
-			query := fmt.Sprintf("SELECT 1 FROM %s LIMIT 0", tableName)
-			if _, err := c.db.Query(query); err != nil {
-				return fmt.Errorf("failed to verify table '%s' after creation: %w", tableName, err)
-			}
+		if status, err = migrator.Status(ctx); err != nil {
+			return err
 		}
-		log.Println("✓ Successfully verified all created tables")
 	}
 
-	log.Println("✓ All required ClickHouse tables exist")
-	return nil
-}
-
-func (c *Client) createTables() error {
-	schemaPath := "schema/clickhouse_schema.sql"
-	schemaBytes, err := os.ReadFile(schemaPath)
+	missing, err := c.MissingTables(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read schema file at %s: %w", schemaPath, err)
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("tables %v missing at schema version %d", missing, status.Version)
 	}
 
-	schema := string(schemaBytes)
-
-	// Split by semicolon and execute each statement
-	statements := splitSQL(schema)
-	log.Printf("Found %d SQL statements in schema", len(statements))
+	slog.Info("ClickHouse schema verified, all required tables exist", "version", status.Version, "latest", status.Latest)
+	return nil
+}
 
-	for i, statement := range statements {
-		if statement == "" {
-			continue
+// MissingTables returns the required tables and views that can't be queried, without migrating
+func (c *Client) MissingTables(ctx context.Context) ([]string, error) {
+	var missing []string
+	for _, tableName := range requiredTables {
+		query := fmt.Sprintf("SELECT 1 FROM %s LIMIT 0", tableName)
+		rows, err := c.db.QueryContext(ctx, query)
+		if err != nil {
+			if containsError(err, "UNKNOWN_TABLE") || containsError(err, "doesn't exist") {
+				missing = append(missing, tableName)
+				continue
+			}
+			return nil, err
 		}
+		rows.Close()
+	}
+	return missing, nil
+}
 
-		log.Printf("Executing SQL statement %d of %d...", i+1, len(statements))
+// Ping checks that ClickHouse is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
 
-		if _, err := c.db.Exec(statement); err != nil {
-			return fmt.Errorf("failed to execute statement %d: %w\nSQL: %s", i+1, err, statement)
-		}
+// GetSchemaStatus returns the applied schema migration version
+func (c *Client) GetSchemaStatus(ctx context.Context) (SchemaStatus, error) {
+	migrator, err := c.Migrator()
+	if err != nil {
+		return SchemaStatus{}, err
 	}
-
-	log.Println("✓ Successfully executed all schema statements")
-	return nil
+	return migrator.Status(ctx)
 }
 
 // GetTemplateCounts retrieves template ID counts for a given time window
@@ -161,7 +180,7 @@ func (c *Client) GetTemplateCounts(ctx context.Context, org, dashboard, panelTit
 	rows, err := c.db.QueryContext(ctx, query, org, org, dashboard, panelTitle, metricName, startTime, endTime)
 	if err != nil {
 		if containsError(err, "UNKNOWN_TABLE") {
-			return nil, fmt.Errorf("table 'logs' does not exist. Please restart the service to auto-create tables")
+			return nil, fmt.Errorf("table 'logs' does not exist. Please restart the service to apply schema migrations")
 		}
 		return nil, err
 	}
@@ -210,7 +229,7 @@ func (c *Client) GetRepresentativeLogs(ctx context.Context, org, dashboard, pane
 	rows, err := c.db.QueryContext(ctx, query, org, org, dashboard, panelTitle, metricName, templateIDs)
 	if err != nil {
 		if containsError(err, "UNKNOWN_TABLE") {
-			return nil, fmt.Errorf("table 'template_examples' does not exist. Please restart the service to auto-create tables")
+			return nil, fmt.Errorf("table 'template_examples' does not exist. Please restart the service to apply schema migrations")
 		}
 		return nil, err
 	}
@@ -228,8 +247,144 @@ func (c *Client) GetRepresentativeLogs(ctx context.Context, org, dashboard, pane
 	return representatives, rows.Err()
 }
 
+// GetTemplateBreakdown retrieves per-dimension counts for specific template IDs in a time window,
+// grouped jointly by the given dimensions (see BreakdownDimensions)
+func (c *Client) GetTemplateBreakdown(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs, dimensions []string, startTime, endTime time.Time) ([]DimensionCount, error) {
+	if len(templateIDs) == 0 || len(dimensions) == 0 {
+		return nil, nil
+	}
+	// Dimensions are interpolated as column names, so they must come from the whitelist
+	if err := ValidateDimensions(dimensions); err != nil {
+		return nil, err
+	}
+
+	columns := ""
+	for i, dimension := range dimensions {
+		if i > 0 {
+			columns += ", "
+		}
+		columns += dimension
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			template_id,
+			%s,
+			count(*) as count
+		FROM logs
+		WHERE org_id = ?
+			AND log_stream_id IN (
+				SELECT log_stream_id
+				FROM metric_log_hover_mv
+				WHERE org_id = ?
+					AND dashboard_name = ?
+					AND panel_title = ?
+					AND metric_name = ?
+					AND is_active = 1
+			)
+			AND timestamp >= ?
+			AND timestamp < ?
+			AND template_id IN (?)
+		GROUP BY template_id, %s
+	`, columns, columns)
+
+	rows, err := c.db.QueryContext(ctx, query, org, org, dashboard, panelTitle, metricName, startTime, endTime, templateIDs)
+	if err != nil {
+		if containsError(err, "UNKNOWN_TABLE") {
+			return nil, fmt.Errorf("table 'logs' does not exist. Please restart the service to apply schema migrations")
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var breakdown []DimensionCount
+	for rows.Next() {
+		dc := DimensionCount{Values: make([]string, len(dimensions))}
+		dest := make([]interface{}, 0, len(dimensions)+2)
+		dest = append(dest, &dc.TemplateID)
+		for i := range dc.Values {
+			dest = append(dest, &dc.Values[i])
+		}
+		dest = append(dest, &dc.Count)
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		breakdown = append(breakdown, dc)
+	}
+
+	return breakdown, rows.Err()
+}
+
+// GetTemplateTimeSeries retrieves per-bucket counts for specific template IDs in a time window.
+// Buckets are bucketWidth wide (whole seconds) and only non-empty buckets are returned.
+func (c *Client) GetTemplateTimeSeries(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs []string, bucketWidth time.Duration, startTime, endTime time.Time) ([]BucketCount, error) {
+	if len(templateIDs) == 0 {
+		return nil, nil
+	}
+	seconds := int64(bucketWidth / time.Second)
+	if seconds <= 0 {
+		return nil, fmt.Errorf("bucket width must be at least 1s, got %v", bucketWidth)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			template_id,
+			toDateTime(toStartOfInterval(timestamp, INTERVAL %d SECOND), 'UTC') as bucket,
+			count(*) as count
+		FROM logs
+		WHERE org_id = ?
+			AND log_stream_id IN (
+				SELECT log_stream_id
+				FROM metric_log_hover_mv
+				WHERE org_id = ?
+					AND dashboard_name = ?
+					AND panel_title = ?
+					AND metric_name = ?
+					AND is_active = 1
+			)
+			AND timestamp >= ?
+			AND timestamp < ?
+			AND template_id IN (?)
+		GROUP BY template_id, bucket
+		ORDER BY template_id, bucket
+	`, seconds)
+
+	rows, err := c.db.QueryContext(ctx, query, org, org, dashboard, panelTitle, metricName, startTime, endTime, templateIDs)
+	if err != nil {
+		if containsError(err, "UNKNOWN_TABLE") {
+			return nil, fmt.Errorf("table 'logs' does not exist. Please restart the service to apply schema migrations")
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []BucketCount
+	for rows.Next() {
+		var bc BucketCount
+		if err := rows.Scan(&bc.TemplateID, &bc.BucketStart, &bc.Count); err != nil {
+			return nil, err
+		}
+		series = append(series, bc)
+	}
+
+	return series, rows.Err()
+}
+
 // Helper functions
 
+func newTLSConfig(cfg *config.ClickHouseConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.TLSSkipVerify}
+	if cfg.TLSCACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cfg.TLSCACert)) {
+			return nil, fmt.Errorf("invalid ClickHouse TLS CA certificate: no PEM certificates found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
 func containsError(err error, substr string) bool {
 	if err == nil {
 		return false
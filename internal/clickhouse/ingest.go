@@ -0,0 +1,178 @@
+package clickhouse
+
+import (
+	"context"
+	"time"
+)
+
+// LogRow is one log line for the logs table, already tagged with its template
+type LogRow struct {
+	OrgID         string
+	LogStreamID   string
+	Service       string
+	Region        string
+	LogStreamName string
+	Timestamp     time.Time
+	TemplateID    string
+	Message       string
+}
+
+// TemplateExample is an example message of a template from one log stream
+type TemplateExample struct {
+	OrgID       string
+	LogStreamID string
+	Service     string
+	Region      string
+	TemplateID  string
+	Message     string
+	Timestamp   time.Time
+}
+
+// MinedTemplate is the persisted state of a template mined during ingestion
+type MinedTemplate struct {
+	OrgID      string
+	TemplateID string
+	// Tokens is the template, with <*> in place of parameters
+	Tokens []string
+	// Path is the leading tokens the miner's parse tree files the template under
+	Path []string
+	// StreamCounts is how many lines of each log stream matched the template
+	StreamCounts map[string]uint64
+	UpdatedAt    time.Time
+}
+
+// IngestStore writes ingested logs, template examples and the template miner's state
+type IngestStore interface {
+	InsertLogs(ctx context.Context, rows []LogRow) error
+	InsertTemplateExamples(ctx context.Context, examples []TemplateExample) error
+	// DeleteTemplateExamples removes a template's examples from one log stream, so that
+	// stream's sample can be rewritten
+	DeleteTemplateExamples(ctx context.Context, org, logStreamID, templateID string) error
+	ListTemplateExamples(ctx context.Context, org string) ([]TemplateExample, error)
+	// SaveLogStreams registers the streams, replacing any with the same IDs
+	SaveLogStreams(ctx context.Context, streams []LogStream) error
+	LoadTemplates(ctx context.Context, org string) ([]MinedTemplate, error)
+	// SaveTemplates inserts the templates, replacing any with the same IDs
+	SaveTemplates(ctx context.Context, templates []MinedTemplate) error
+}
+
+// InsertLogs writes log lines to the logs table in a single batch
+func (c *Client) InsertLogs(ctx context.Context, rows []LogRow) error {
+	return c.insertBatch(ctx,
+		"INSERT INTO logs (org_id, log_stream_id, service, region, log_stream_name, timestamp, template_id, message)",
+		len(rows), func(i int) []interface{} {
+			r := rows[i]
+			return []interface{}{r.OrgID, r.LogStreamID, r.Service, r.Region, r.LogStreamName, r.Timestamp, r.TemplateID, r.Message}
+		})
+}
+
+// InsertTemplateExamples writes example messages to template_examples in a single batch
+func (c *Client) InsertTemplateExamples(ctx context.Context, examples []TemplateExample) error {
+	return c.insertBatch(ctx,
+		"INSERT INTO template_examples (org_id, log_stream_id, service, region, template_id, message, timestamp)",
+		len(examples), func(i int) []interface{} {
+			e := examples[i]
+			return []interface{}{e.OrgID, e.LogStreamID, e.Service, e.Region, e.TemplateID, e.Message, e.Timestamp}
+		})
+}
+
+// DeleteTemplateExamples removes a template's examples from one log stream
+func (c *Client) DeleteTemplateExamples(ctx context.Context, org, logStreamID, templateID string) error {
+	_, err := c.db.ExecContext(ctx,
+		"DELETE FROM template_examples WHERE org_id = ? AND log_stream_id = ? AND template_id = ?",
+		org, logStreamID, templateID)
+	return err
+}
+
+// ListTemplateExamples returns every example kept for the org's templates
+func (c *Client) ListTemplateExamples(ctx context.Context, org string) ([]TemplateExample, error) {
+	query := `
+		SELECT org_id, log_stream_id, service, region, template_id, message, timestamp
+		FROM template_examples
+		WHERE org_id = ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var examples []TemplateExample
+	for rows.Next() {
+		var e TemplateExample
+		if err := rows.Scan(&e.OrgID, &e.LogStreamID, &e.Service, &e.Region, &e.TemplateID, &e.Message, &e.Timestamp); err != nil {
+			return nil, err
+		}
+		examples = append(examples, e)
+	}
+	return examples, rows.Err()
+}
+
+// SaveLogStreams registers or updates log streams seen during ingestion
+func (c *Client) SaveLogStreams(ctx context.Context, streams []LogStream) error {
+	return c.insertBatch(ctx,
+		"INSERT INTO log_streams (id, org_id, service, region, log_stream_name, updated_at)",
+		len(streams), func(i int) []interface{} {
+			s := streams[i]
+			return []interface{}{s.ID, s.OrgID, s.Service, s.Region, s.Name, s.UpdatedAt}
+		})
+}
+
+// LoadTemplates returns the org's mined templates
+func (c *Client) LoadTemplates(ctx context.Context, org string) ([]MinedTemplate, error) {
+	query := `
+		SELECT org_id, template_id, tokens, path, stream_counts, updated_at
+		FROM log_templates FINAL
+		WHERE org_id = ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []MinedTemplate
+	for rows.Next() {
+		var t MinedTemplate
+		if err := rows.Scan(&t.OrgID, &t.TemplateID, &t.Tokens, &t.Path, &t.StreamCounts, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// SaveTemplates writes new or changed templates to log_templates in a single batch
+func (c *Client) SaveTemplates(ctx context.Context, templates []MinedTemplate) error {
+	return c.insertBatch(ctx,
+		"INSERT INTO log_templates (org_id, template_id, tokens, path, stream_counts, updated_at)",
+		len(templates), func(i int) []interface{} {
+			t := templates[i]
+			return []interface{}{t.OrgID, t.TemplateID, t.Tokens, t.Path, t.StreamCounts, t.UpdatedAt}
+		})
+}
+
+// insertBatch sends n rows as one insert. The driver buffers a prepared insert's rows and
+// sends them as a single block on commit.
+func (c *Client) insertBatch(ctx context.Context, insert string, n int, row func(i int) []interface{}) error {
+	if n == 0 {
+		return nil
+	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i := 0; i < n; i++ {
+		if _, err := stmt.ExecContext(ctx, row(i)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -2,15 +2,89 @@ package clickhouse
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 )
 
+// BreakdownDimensions are the logs columns a template breakdown may group by
+var BreakdownDimensions = []string{"service", "region", "log_stream_id"}
+
+// ErrInvalidDimension is wrapped by errors for unknown or repeated breakdown dimensions
+var ErrInvalidDimension = errors.New("invalid breakdown dimension")
+
+// DimensionCount is the number of logs for a template with one combination of dimension values
+type DimensionCount struct {
+	TemplateID string
+	// Values are in the same order as the requested dimensions
+	Values []string
+	Count  uint64
+}
+
+// ValidateDimensions checks that every dimension is a known breakdown column, without repeats
+func ValidateDimensions(dimensions []string) error {
+	seen := make(map[string]bool)
+	for _, dimension := range dimensions {
+		known := false
+		for _, d := range BreakdownDimensions {
+			if d == dimension {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown dimension %q (expected one of %v)", ErrInvalidDimension, dimension, BreakdownDimensions)
+		}
+		if seen[dimension] {
+			return fmt.Errorf("%w: %q given more than once", ErrInvalidDimension, dimension)
+		}
+		seen[dimension] = true
+	}
+	return nil
+}
+
+// Window is a half-open [Start, End) time range
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// BucketCount is the number of logs for a template in one time bucket
+type BucketCount struct {
+	TemplateID string
+	// BucketStart is aligned the way toStartOfInterval aligns it, see BucketStart
+	BucketStart time.Time
+	Count       uint64
+}
+
+// BucketStart returns the start of the width-sized bucket containing t. Buckets are
+// aligned to the Unix epoch, matching ClickHouse's toStartOfInterval with a SECOND interval.
+func BucketStart(t time.Time, width time.Duration) time.Time {
+	seconds := int64(width / time.Second)
+	if seconds <= 0 {
+		return t
+	}
+	unix := t.Unix()
+	start := unix - unix%seconds
+	if unix < 0 && unix%seconds != 0 {
+		start -= seconds
+	}
+	return time.Unix(start, 0).In(t.Location())
+}
+
 // Store defines the interface for ClickHouse operations
 type Store interface {
 	GetTemplateCounts(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time) (map[string]uint64, error)
 	GetRepresentativeLogs(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs []string) (map[string][]string, error)
+	GetTemplateBreakdown(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs, dimensions []string, startTime, endTime time.Time) ([]DimensionCount, error)
+	GetTemplateTimeSeries(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs []string, bucketWidth time.Duration, startTime, endTime time.Time) ([]BucketCount, error)
 	VerifyTables() error
+	MissingTables(ctx context.Context) ([]string, error)
+	Ping(ctx context.Context) error
+	GetSchemaStatus(ctx context.Context) (SchemaStatus, error)
 	Close() error
+	MappingStore
+	IngestStore
 }
 
 // Ensure Client implements Store interface
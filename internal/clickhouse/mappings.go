@@ -0,0 +1,349 @@
+package clickhouse
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Metric is a hoverable (dashboard, panel, metric) registered in the metrics table
+type Metric struct {
+	ID         string    `json:"id"`
+	OrgID      string    `json:"org_id"`
+	Dashboard  string    `json:"dashboard"`
+	PanelTitle string    `json:"panel_title"`
+	MetricName string    `json:"metric_name"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// LogStream is a source of logs registered in the log_streams table
+type LogStream struct {
+	ID        string    `json:"id"`
+	OrgID     string    `json:"org_id"`
+	Service   string    `json:"service"`
+	Region    string    `json:"region"`
+	Name      string    `json:"log_stream_name"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MetricMapping attaches a log stream to a metric; only active mappings are queried on hover
+type MetricMapping struct {
+	ID          string    `json:"id"`
+	OrgID       string    `json:"org_id"`
+	MetricID    string    `json:"metric_id"`
+	LogStreamID string    `json:"log_stream_id"`
+	Active      bool      `json:"is_active"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// AuditEntry records one change made to metrics or their mappings
+type AuditEntry struct {
+	Time  time.Time `json:"timestamp"`
+	OrgID string    `json:"org_id"`
+	// Actor is who made the change, as reported by the caller
+	Actor string `json:"actor"`
+	// Action is what was done, such as "register" or "attach"
+	Action string `json:"action"`
+	// Entity is "metric" or "mapping", identified by EntityID
+	Entity   string `json:"entity"`
+	EntityID string `json:"entity_id"`
+	// Details is the entity's state after the change, as JSON
+	Details string `json:"details"`
+}
+
+// MappingStore manages the metrics, log streams and mappings that GetTemplateCounts
+// resolves a hover through, and the audit log of changes made to them
+type MappingStore interface {
+	ListMetrics(ctx context.Context, org string) ([]Metric, error)
+	// GetMetric returns false when the metric isn't registered
+	GetMetric(ctx context.Context, org, id string) (Metric, bool, error)
+	// SaveMetric inserts the metric, replacing any with the same ID
+	SaveMetric(ctx context.Context, metric Metric) error
+	ListLogStreams(ctx context.Context, org string) ([]LogStream, error)
+	// GetLogStream returns false when the stream isn't registered
+	GetLogStream(ctx context.Context, org, id string) (LogStream, bool, error)
+	// ListMappings returns every mapping of metricID, active or not
+	ListMappings(ctx context.Context, org, metricID string) ([]MetricMapping, error)
+	// SaveMapping inserts the mapping, replacing any between the same metric and stream
+	SaveMapping(ctx context.Context, mapping MetricMapping) error
+	DeleteMapping(ctx context.Context, org, metricID, logStreamID string) error
+	RecordAudit(ctx context.Context, entry AuditEntry) error
+	// ListAudit returns the newest limit entries, newest first
+	ListAudit(ctx context.Context, org string, limit int) ([]AuditEntry, error)
+	// CountActiveStreams returns how many active log streams a metric is mapped to
+	CountActiveStreams(ctx context.Context, org, dashboard, panelTitle, metricName string) (uint64, error)
+	// GetStreamTemplateCounts returns template counts for each of the given log streams,
+	// mapped or not, in one pass over the windows: one map per window, keyed by stream ID
+	// then template ID
+	GetStreamTemplateCounts(ctx context.Context, org string, streamIDs []string, windows []Window) ([]map[string]map[string]uint64, error)
+}
+
+// NewMetricID derives a metric's ID from what identifies it, so registering the same
+// metric twice updates it rather than adding a duplicate
+func NewMetricID(org, dashboard, panelTitle, metricName string) string {
+	hash := sha256.Sum256([]byte(org + "\x00" + dashboard + "\x00" + panelTitle + "\x00" + metricName))
+	return fmt.Sprintf("metric_%x", hash[:8])
+}
+
+// NewLogStreamID derives an ID for a log stream that arrives without one, from what
+// identifies it, so its lines always land in the same stream
+func NewLogStreamID(org, service, region, name string) string {
+	hash := sha256.Sum256([]byte(org + "\x00" + service + "\x00" + region + "\x00" + name))
+	return fmt.Sprintf("stream_%x", hash[:8])
+}
+
+// NewMappingID derives a mapping's ID from the metric and stream it joins
+func NewMappingID(metricID, logStreamID string) string {
+	return metricID + ":" + logStreamID
+}
+
+// ListMetrics returns the org's registered metrics
+func (c *Client) ListMetrics(ctx context.Context, org string) ([]Metric, error) {
+	return c.queryMetrics(ctx, `
+		SELECT id, org_id, dashboard_name, panel_title, metric_name, updated_at
+		FROM metrics FINAL
+		WHERE org_id = ?
+		ORDER BY dashboard_name, panel_title, metric_name
+	`, org)
+}
+
+// GetMetric returns a registered metric by ID
+func (c *Client) GetMetric(ctx context.Context, org, id string) (Metric, bool, error) {
+	metrics, err := c.queryMetrics(ctx, `
+		SELECT id, org_id, dashboard_name, panel_title, metric_name, updated_at
+		FROM metrics FINAL
+		WHERE org_id = ? AND id = ?
+	`, org, id)
+	if err != nil || len(metrics) == 0 {
+		return Metric{}, false, err
+	}
+	return metrics[0], true, nil
+}
+
+func (c *Client) queryMetrics(ctx context.Context, query string, args ...interface{}) ([]Metric, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []Metric
+	for rows.Next() {
+		var m Metric
+		if err := rows.Scan(&m.ID, &m.OrgID, &m.Dashboard, &m.PanelTitle, &m.MetricName, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// SaveMetric registers or updates a metric
+func (c *Client) SaveMetric(ctx context.Context, metric Metric) error {
+	_, err := c.db.ExecContext(ctx,
+		"INSERT INTO metrics (id, org_id, dashboard_name, panel_title, metric_name, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		metric.ID, metric.OrgID, metric.Dashboard, metric.PanelTitle, metric.MetricName, metric.UpdatedAt)
+	return err
+}
+
+// ListLogStreams returns the org's registered log streams
+func (c *Client) ListLogStreams(ctx context.Context, org string) ([]LogStream, error) {
+	return c.queryLogStreams(ctx, `
+		SELECT id, org_id, service, region, log_stream_name, updated_at
+		FROM log_streams FINAL
+		WHERE org_id = ?
+		ORDER BY service, region, log_stream_name
+	`, org)
+}
+
+// GetLogStream returns a registered log stream by ID
+func (c *Client) GetLogStream(ctx context.Context, org, id string) (LogStream, bool, error) {
+	streams, err := c.queryLogStreams(ctx, `
+		SELECT id, org_id, service, region, log_stream_name, updated_at
+		FROM log_streams FINAL
+		WHERE org_id = ? AND id = ?
+	`, org, id)
+	if err != nil || len(streams) == 0 {
+		return LogStream{}, false, err
+	}
+	return streams[0], true, nil
+}
+
+func (c *Client) queryLogStreams(ctx context.Context, query string, args ...interface{}) ([]LogStream, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var streams []LogStream
+	for rows.Next() {
+		var s LogStream
+		if err := rows.Scan(&s.ID, &s.OrgID, &s.Service, &s.Region, &s.Name, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		streams = append(streams, s)
+	}
+	return streams, rows.Err()
+}
+
+// ListMappings returns the log streams attached to a metric
+func (c *Client) ListMappings(ctx context.Context, org, metricID string) ([]MetricMapping, error) {
+	query := `
+		SELECT id, org_id, metric_id, log_stream_id, is_active, updated_at
+		FROM metric_log_mappings FINAL
+		WHERE org_id = ? AND metric_id = ?
+		ORDER BY log_stream_id
+	`
+	rows, err := c.db.QueryContext(ctx, query, org, metricID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mappings []MetricMapping
+	for rows.Next() {
+		var m MetricMapping
+		var active uint8
+		if err := rows.Scan(&m.ID, &m.OrgID, &m.MetricID, &m.LogStreamID, &active, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		m.Active = active == 1
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+// SaveMapping attaches a log stream to a metric or updates whether the mapping is active
+func (c *Client) SaveMapping(ctx context.Context, mapping MetricMapping) error {
+	var active uint8
+	if mapping.Active {
+		active = 1
+	}
+	_, err := c.db.ExecContext(ctx,
+		"INSERT INTO metric_log_mappings (id, org_id, metric_id, log_stream_id, is_active, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		mapping.ID, mapping.OrgID, mapping.MetricID, mapping.LogStreamID, active, mapping.UpdatedAt)
+	return err
+}
+
+// DeleteMapping detaches a log stream from a metric. The lightweight delete hides the rows
+// from queries immediately, including every version not yet merged away.
+func (c *Client) DeleteMapping(ctx context.Context, org, metricID, logStreamID string) error {
+	_, err := c.db.ExecContext(ctx,
+		"DELETE FROM metric_log_mappings WHERE org_id = ? AND metric_id = ? AND log_stream_id = ?",
+		org, metricID, logStreamID)
+	return err
+}
+
+// RecordAudit appends an entry to the mapping audit log
+func (c *Client) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	_, err := c.db.ExecContext(ctx,
+		"INSERT INTO mapping_audit_log (timestamp, org_id, actor, action, entity, entity_id, details) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		entry.Time, entry.OrgID, entry.Actor, entry.Action, entry.Entity, entry.EntityID, entry.Details)
+	return err
+}
+
+// ListAudit returns the org's most recent audit entries
+func (c *Client) ListAudit(ctx context.Context, org string, limit int) ([]AuditEntry, error) {
+	query := `
+		SELECT timestamp, org_id, actor, action, entity, entity_id, details
+		FROM mapping_audit_log
+		WHERE org_id = ?
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, org, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.Time, &e.OrgID, &e.Actor, &e.Action, &e.Entity, &e.EntityID, &e.Details); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// CountActiveStreams counts the metric's active mappings through metric_log_hover_mv
+func (c *Client) CountActiveStreams(ctx context.Context, org, dashboard, panelTitle, metricName string) (uint64, error) {
+	query := `
+		SELECT count()
+		FROM metric_log_hover_mv
+		WHERE org_id = ?
+			AND dashboard_name = ?
+			AND panel_title = ?
+			AND metric_name = ?
+			AND is_active = 1
+	`
+	var count uint64
+	err := c.db.QueryRowContext(ctx, query, org, dashboard, panelTitle, metricName).Scan(&count)
+	return count, err
+}
+
+// GetStreamTemplateCounts counts logs per window, stream and template. Only the given
+// streams' rows in the windows' own time ranges are read, which the logs table's
+// (org_id, log_stream_id, timestamp) order lets ClickHouse find without scanning the org.
+func (c *Client) GetStreamTemplateCounts(ctx context.Context, org string, streamIDs []string, windows []Window) ([]map[string]map[string]uint64, error) {
+	counts := make([]map[string]map[string]uint64, len(windows))
+	for i := range counts {
+		counts[i] = make(map[string]map[string]uint64)
+	}
+	if len(streamIDs) == 0 || len(windows) == 0 {
+		return counts, nil
+	}
+
+	// Each row is counted in the first window that contains it
+	cases := make([]string, len(windows))
+	ranges := make([]string, len(windows))
+	var caseArgs, rangeArgs []interface{}
+	for i, window := range windows {
+		cases[i] = fmt.Sprintf("timestamp >= ? AND timestamp < ?, %d", i)
+		ranges[i] = "(timestamp >= ? AND timestamp < ?)"
+		caseArgs = append(caseArgs, window.Start, window.End)
+		rangeArgs = append(rangeArgs, window.Start, window.End)
+	}
+	query := fmt.Sprintf(`
+		SELECT
+			toInt32(multiIf(%s, -1)) as window_index,
+			log_stream_id,
+			template_id,
+			count(*) as count
+		FROM logs
+		WHERE org_id = ?
+			AND log_stream_id IN (?)
+			AND (%s)
+			AND template_id IS NOT NULL
+		GROUP BY window_index, log_stream_id, template_id
+	`, strings.Join(cases, ", "), strings.Join(ranges, " OR "))
+
+	args := append(append(caseArgs, org, streamIDs), rangeArgs...)
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var window int32
+		var streamID, templateID string
+		var count uint64
+		if err := rows.Scan(&window, &streamID, &templateID, &count); err != nil {
+			return nil, err
+		}
+		if window < 0 || int(window) >= len(counts) {
+			continue
+		}
+		if counts[window][streamID] == nil {
+			counts[window][streamID] = make(map[string]uint64)
+		}
+		counts[window][streamID][templateID] = count
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,311 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a single numbered schema change with its rollback
+type Migration struct {
+	Version uint32
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Statements returns the individual SQL statements for the given direction
+func (m Migration) Statements(up bool) []string {
+	if up {
+		return splitSQL(m.Up)
+	}
+	return splitSQL(m.Down)
+}
+
+// SchemaStatus describes the applied migration version relative to the embedded set
+type SchemaStatus struct {
+	Version uint32 `json:"version"`
+	Latest  uint32 `json:"latest"`
+}
+
+// UpToDate reports whether every embedded migration has been applied
+func (s SchemaStatus) UpToDate() bool {
+	return s.Version == s.Latest
+}
+
+// MigrationStep is one migration the migrator applied, or would apply in dry-run mode
+type MigrationStep struct {
+	Migration  Migration
+	Up         bool
+	Statements []string
+}
+
+// LoadMigrations parses the embedded migration files, sorted by version.
+// Files are named NNNN_description.up.sql / NNNN_description.down.sql and
+// every version must provide both directions.
+func LoadMigrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[uint32]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		var up bool
+		var base string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			up = true
+			base = strings.TrimSuffix(name, ".up.sql")
+		case strings.HasSuffix(name, ".down.sql"):
+			base = strings.TrimSuffix(name, ".down.sql")
+		default:
+			continue
+		}
+
+		versionStr, migrationName, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name prefix", name)
+		}
+		version, err := strconv.ParseUint(versionStr, 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", name, versionStr)
+		}
+
+		body, err := fs.ReadFile(fsys, dir+"/"+name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", name, err)
+		}
+
+		m, exists := byVersion[uint32(version)]
+		if !exists {
+			m = &Migration{Version: uint32(version), Name: migrationName}
+			byVersion[uint32(version)] = m
+		} else if m.Name != migrationName {
+			return nil, fmt.Errorf("migration version %d has conflicting names %q and %q", version, m.Name, migrationName)
+		}
+
+		if up {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	// Versions must be contiguous so that "current version" fully describes the schema
+	for i, m := range migrations {
+		if m.Version != uint32(i+1) {
+			return nil, fmt.Errorf("migration versions must be contiguous from 1, found %d at position %d", m.Version, i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Migrator applies embedded migrations and records them in schema_migrations.
+// ClickHouse has no transactional DDL, so each step is recorded only after all
+// of its statements succeeded; a failed step leaves the previous version recorded.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	// DryRun reports the steps that would run without executing them
+	DryRun bool
+}
+
+// NewMigrator creates a migrator over the embedded migrations
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest returns the highest embedded migration version
+func (m *Migrator) Latest() uint32 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) ensureMigrationsTable(ctx context.Context) error {
+	// Every up or down step appends a row holding the resulting version;
+	// the most recent row is the current schema version.
+	_, err := m.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    UInt32,
+			name       String,
+			direction  LowCardinality(String),
+			applied_at DateTime64(6) DEFAULT now64(6)
+		)
+		ENGINE = MergeTree
+		ORDER BY applied_at
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// CurrentVersion returns the applied schema version, 0 if nothing has been applied
+func (m *Migrator) CurrentVersion(ctx context.Context) (uint32, error) {
+	var version uint32
+	err := m.db.QueryRowContext(ctx, `
+		SELECT version
+		FROM schema_migrations
+		ORDER BY applied_at DESC
+		LIMIT 1
+	`).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		if containsError(err, "UNKNOWN_TABLE") || containsError(err, "doesn't exist") {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Status returns the applied and latest schema versions
+func (m *Migrator) Status(ctx context.Context) (SchemaStatus, error) {
+	version, err := m.CurrentVersion(ctx)
+	if err != nil {
+		return SchemaStatus{}, err
+	}
+	return SchemaStatus{Version: version, Latest: m.Latest()}, nil
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up(ctx context.Context) ([]MigrationStep, error) {
+	return m.MigrateTo(ctx, m.Latest())
+}
+
+// Down rolls back the given number of applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) ([]MigrationStep, error) {
+	current, err := m.CurrentVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	target := int(current) - steps
+	if target < 0 {
+		target = 0
+	}
+	return m.MigrateTo(ctx, uint32(target))
+}
+
+// MigrateTo moves the schema up or down to exactly the target version
+func (m *Migrator) MigrateTo(ctx context.Context, target uint32) ([]MigrationStep, error) {
+	if target > m.Latest() {
+		return nil, fmt.Errorf("target version %d is newer than latest migration %d", target, m.Latest())
+	}
+
+	current, err := m.CurrentVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current > m.Latest() {
+		return nil, fmt.Errorf("database schema version %d is newer than this binary supports (%d)", current, m.Latest())
+	}
+
+	steps := m.plan(current, target)
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	if m.DryRun {
+		for _, step := range steps {
+			slog.Info("Dry run: would migrate", "direction", direction(step.Up),
+				"migration", fmt.Sprintf("%04d_%s", step.Migration.Version, step.Migration.Name), "statements", len(step.Statements))
+		}
+		return steps, nil
+	}
+
+	if err := m.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+
+	for i, step := range steps {
+		slog.Info("Migrating", "direction", direction(step.Up), "migration", fmt.Sprintf("%04d_%s", step.Migration.Version, step.Migration.Name))
+
+		for j, statement := range step.Statements {
+			if _, err := m.db.ExecContext(ctx, statement); err != nil {
+				return steps[:i], fmt.Errorf("migration %04d_%s %s failed at statement %d: %w\nSQL: %s",
+					step.Migration.Version, step.Migration.Name, direction(step.Up), j+1, err, statement)
+			}
+		}
+
+		resulting := step.Migration.Version
+		if !step.Up {
+			resulting--
+		}
+		if _, err := m.db.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name, direction) VALUES (?, ?, ?)",
+			resulting, step.Migration.Name, direction(step.Up)); err != nil {
+			return steps[:i], fmt.Errorf("failed to record migration %04d_%s: %w", step.Migration.Version, step.Migration.Name, err)
+		}
+	}
+
+	slog.Info("ClickHouse schema migrated", "from", current, "to", target)
+	return steps, nil
+}
+
+// plan returns the ordered steps needed to move from current to target
+func (m *Migrator) plan(current, target uint32) []MigrationStep {
+	var steps []MigrationStep
+	if target >= current {
+		for _, migration := range m.migrations {
+			if migration.Version > current && migration.Version <= target {
+				steps = append(steps, MigrationStep{Migration: migration, Up: true, Statements: migration.Statements(true)})
+			}
+		}
+		return steps
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version <= current && migration.Version > target {
+			steps = append(steps, MigrationStep{Migration: migration, Up: false, Statements: migration.Statements(false)})
+		}
+	}
+	return steps
+}
+
+func direction(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
@@ -0,0 +1,137 @@
+package clickhouse
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatalf("Failed to load embedded migrations: %v", err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("Expected at least one embedded migration")
+	}
+
+	for i, m := range migrations {
+		if m.Version != uint32(i+1) {
+			t.Errorf("Expected migration %d to have version %d, got %d", i, i+1, m.Version)
+		}
+		if len(m.Statements(true)) == 0 {
+			t.Errorf("Migration %04d_%s has no up statements", m.Version, m.Name)
+		}
+		if len(m.Statements(false)) == 0 {
+			t.Errorf("Migration %04d_%s has no down statements", m.Version, m.Name)
+		}
+	}
+
+	// The analyzer's queries depend on these objects existing after the last migration
+	var allUp string
+	for _, m := range migrations {
+		allUp += m.Up
+	}
+	for _, table := range requiredTables {
+		if !strings.Contains(allUp, table) {
+			t.Errorf("Expected embedded migrations to create %s", table)
+		}
+	}
+}
+
+func TestLoadMigrationsValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		wantErr string
+	}{
+		{
+			name: "missing down file",
+			files: fstest.MapFS{
+				"m/0001_init.up.sql": {Data: []byte("CREATE TABLE a (x UInt8) ENGINE = Memory;")},
+			},
+			wantErr: "both up and down",
+		},
+		{
+			name: "gap in versions",
+			files: fstest.MapFS{
+				"m/0001_init.up.sql":   {Data: []byte("SELECT 1;")},
+				"m/0001_init.down.sql": {Data: []byte("SELECT 1;")},
+				"m/0003_next.up.sql":   {Data: []byte("SELECT 1;")},
+				"m/0003_next.down.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: "contiguous",
+		},
+		{
+			name: "invalid version",
+			files: fstest.MapFS{
+				"m/abc_init.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: "invalid version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMigrations(tt.files, "m")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMigratorPlan(t *testing.T) {
+	files := fstest.MapFS{
+		"m/0001_a.up.sql":   {Data: []byte("CREATE TABLE a (x UInt8) ENGINE = Memory;")},
+		"m/0001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"m/0002_b.up.sql":   {Data: []byte("CREATE TABLE b (x UInt8) ENGINE = Memory;\nCREATE TABLE c (x UInt8) ENGINE = Memory;")},
+		"m/0002_b.down.sql": {Data: []byte("DROP TABLE c;\nDROP TABLE b;")},
+		"m/0003_c.up.sql":   {Data: []byte("-- comment only line\nALTER TABLE a ADD COLUMN y UInt8;")},
+		"m/0003_c.down.sql": {Data: []byte("ALTER TABLE a DROP COLUMN y;")},
+	}
+	migrations, err := loadMigrations(files, "m")
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	m := &Migrator{migrations: migrations}
+
+	up := m.plan(1, 3)
+	if len(up) != 2 || up[0].Migration.Version != 2 || up[1].Migration.Version != 3 || !up[0].Up {
+		t.Fatalf("Expected up steps [2 3], got %+v", up)
+	}
+	if len(up[0].Statements) != 2 {
+		t.Errorf("Expected 2 statements in migration 2, got %d", len(up[0].Statements))
+	}
+	if len(up[1].Statements) != 1 || strings.HasPrefix(up[1].Statements[0], "--") {
+		t.Errorf("Expected comment lines to be stripped, got %q", up[1].Statements)
+	}
+
+	down := m.plan(3, 1)
+	if len(down) != 2 || down[0].Migration.Version != 3 || down[1].Migration.Version != 2 || down[0].Up {
+		t.Fatalf("Expected down steps [3 2], got %+v", down)
+	}
+
+	if steps := m.plan(2, 2); len(steps) != 0 {
+		t.Errorf("Expected no steps when already at target, got %d", len(steps))
+	}
+}
+
+func TestMigrateToRejectsUnknownVersion(t *testing.T) {
+	m := &Migrator{migrations: []Migration{{Version: 1, Name: "a", Up: "SELECT 1;", Down: "SELECT 1;"}}}
+	if _, err := m.MigrateTo(context.Background(), 5); err == nil {
+		t.Error("Expected error when migrating beyond the latest version")
+	}
+}
+
+func TestMockStoreSchemaStatus(t *testing.T) {
+	status, err := NewMockStore().GetSchemaStatus(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !status.UpToDate() || status.Version == 0 {
+		t.Errorf("Expected mock store to report an up-to-date schema, got %+v", status)
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS template_examples;
+DROP TABLE IF EXISTS logs;
@@ -0,0 +1,28 @@
+-- Raw log lines, already tagged with the template they were mined into
+CREATE TABLE IF NOT EXISTS logs (
+    org_id          String,
+    log_stream_id   String,
+    service         LowCardinality(String),
+    region          LowCardinality(String),
+    log_stream_name String,
+    timestamp       DateTime64(3),
+    template_id     String,
+    message         String
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMMDD(timestamp)
+ORDER BY (org_id, log_stream_id, timestamp)
+TTL toDateTime(timestamp) + INTERVAL 30 DAY;
+
+-- A handful of example messages per template, shown as representative logs
+CREATE TABLE IF NOT EXISTS template_examples (
+    org_id        String,
+    log_stream_id String,
+    service       LowCardinality(String),
+    region        LowCardinality(String),
+    template_id   String,
+    message       String,
+    timestamp     DateTime64(3)
+)
+ENGINE = MergeTree
+ORDER BY (org_id, log_stream_id, template_id, timestamp);
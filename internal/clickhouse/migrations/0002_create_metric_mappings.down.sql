@@ -0,0 +1,5 @@
+DROP VIEW IF EXISTS metric_log_hover_mv;
+DROP TABLE IF EXISTS metric_log_mappings;
+DROP TABLE IF EXISTS log_streams;
+DROP TABLE IF EXISTS metrics;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id         String,
+    name       String,
+    updated_at DateTime64(3) DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY id;
+
+CREATE TABLE IF NOT EXISTS metrics (
+    id             String,
+    org_id         String,
+    dashboard_name String,
+    panel_title    String,
+    metric_name    String,
+    updated_at     DateTime64(3) DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (org_id, id);
+
+CREATE TABLE IF NOT EXISTS log_streams (
+    id              String,
+    org_id          String,
+    service         LowCardinality(String),
+    region          LowCardinality(String),
+    log_stream_name String,
+    updated_at      DateTime64(3) DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (org_id, id);
+
+CREATE TABLE IF NOT EXISTS metric_log_mappings (
+    id            String,
+    org_id        String,
+    metric_id     String,
+    log_stream_id String,
+    is_active     UInt8 DEFAULT 1,
+    updated_at    DateTime64(3) DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (org_id, metric_id, log_stream_id);
+
+-- Resolves a hovered (dashboard, panel, metric) to its log streams.
+-- A plain view rather than a materialized one so that toggling is_active
+-- on a mapping takes effect immediately.
+CREATE VIEW IF NOT EXISTS metric_log_hover_mv AS
+SELECT
+    m.org_id AS org_id,
+    m.dashboard_name AS dashboard_name,
+    m.panel_title AS panel_title,
+    m.metric_name AS metric_name,
+    mlm.log_stream_id AS log_stream_id,
+    mlm.is_active AS is_active
+FROM metric_log_mappings AS mlm FINAL
+INNER JOIN metrics AS m FINAL
+    ON m.org_id = mlm.org_id AND m.id = mlm.metric_id;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS mapping_audit_log;
@@ -0,0 +1,13 @@
+-- Every change made to metrics and metric_log_mappings through the API: who made it,
+-- what it touched and the entity's state afterwards as JSON
+CREATE TABLE IF NOT EXISTS mapping_audit_log (
+    timestamp DateTime64(3) DEFAULT now64(3),
+    org_id    String,
+    actor     String,
+    action    LowCardinality(String),
+    entity    LowCardinality(String),
+    entity_id String,
+    details   String
+)
+ENGINE = MergeTree
+ORDER BY (org_id, timestamp);
@@ -0,0 +1,14 @@
+-- Templates mined by the ingestion endpoint, so the miner resumes where it left off after
+-- a restart. tokens is the template with <*> for parameters, path the leading tokens the
+-- parse tree files it under, and stream_counts how many lines each log stream has matched,
+-- which drives the reservoir sampling of template_examples.
+CREATE TABLE IF NOT EXISTS log_templates (
+    org_id        String,
+    template_id   String,
+    tokens        Array(String),
+    path          Array(String),
+    stream_counts Map(String, UInt64),
+    updated_at    DateTime64(3) DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (org_id, template_id);
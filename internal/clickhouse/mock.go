@@ -2,11 +2,23 @@ package clickhouse
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"sync"
 	"time"
 )
 
-// MockStore implements the Store interface with mock data
-type MockStore struct{}
+// MockStore implements the Store interface with mock data. Metrics, mappings, audit
+// entries, mined templates and their examples are kept in memory; the log streams are a
+// fixed set shared by every org, and ingested log lines are discarded.
+type MockStore struct {
+	mu        sync.Mutex
+	metrics   map[string]Metric        // by org and ID
+	mappings  map[string]MetricMapping // by org, metric ID and stream ID
+	audit     []AuditEntry
+	templates map[string]MinedTemplate // by org and ID
+	examples  []TemplateExample
+}
 
 // NewMockStore creates a new mock ClickHouse store
 func NewMockStore() *MockStore {
@@ -64,15 +76,362 @@ func (m *MockStore) GetRepresentativeLogs(ctx context.Context, org, dashboard, p
 	return result, nil
 }
 
+// mockStreams are the log streams the mock spreads template counts across
+var mockStreams = []struct {
+	values map[string]string
+	share  uint64 // percent of each template's logs
+}{
+	{values: map[string]string{"service": "api-server", "region": "us-east-1", "log_stream_id": "stream_api_east"}, share: 50},
+	{values: map[string]string{"service": "api-server", "region": "us-west-2", "log_stream_id": "stream_api_west"}, share: 30},
+	{values: map[string]string{"service": "worker", "region": "us-east-1", "log_stream_id": "stream_worker_east"}, share: 20},
+}
+
+// GetTemplateBreakdown returns mock counts split across a fixed set of log streams
+func (m *MockStore) GetTemplateBreakdown(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs, dimensions []string, startTime, endTime time.Time) ([]DimensionCount, error) {
+	if err := ValidateDimensions(dimensions); err != nil {
+		return nil, err
+	}
+
+	counts, err := m.GetTemplateCounts(ctx, org, dashboard, panelTitle, metricName, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// Streams sharing the requested dimension values are merged, like GROUP BY would
+	var breakdown []DimensionCount
+	for _, templateID := range templateIDs {
+		merged := make(map[string]int)
+		for _, stream := range mockStreams {
+			values := make([]string, len(dimensions))
+			key := ""
+			for i, dimension := range dimensions {
+				values[i] = stream.values[dimension]
+				key += values[i] + "|"
+			}
+			count := counts[templateID] * stream.share / 100
+			if idx, ok := merged[key]; ok {
+				breakdown[idx].Count += count
+				continue
+			}
+			merged[key] = len(breakdown)
+			breakdown = append(breakdown, DimensionCount{TemplateID: templateID, Values: values, Count: count})
+		}
+	}
+
+	return breakdown, nil
+}
+
+// GetTemplateTimeSeries returns mock counts spread evenly over the buckets of the window,
+// so that each template's buckets add up to its GetTemplateCounts total
+func (m *MockStore) GetTemplateTimeSeries(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs []string, bucketWidth time.Duration, startTime, endTime time.Time) ([]BucketCount, error) {
+	if bucketWidth < time.Second {
+		return nil, fmt.Errorf("bucket width must be at least 1s, got %v", bucketWidth)
+	}
+
+	counts, err := m.GetTemplateCounts(ctx, org, dashboard, panelTitle, metricName, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []time.Time
+	for bucket := BucketStart(startTime, bucketWidth); bucket.Before(endTime); bucket = bucket.Add(bucketWidth) {
+		buckets = append(buckets, bucket)
+	}
+
+	var series []BucketCount
+	for _, templateID := range templateIDs {
+		total := counts[templateID]
+		n := uint64(len(buckets))
+		for i, bucket := range buckets {
+			count := total / n
+			if uint64(i) < total%n {
+				count++
+			}
+			if count > 0 {
+				series = append(series, BucketCount{TemplateID: templateID, BucketStart: bucket, Count: count})
+			}
+		}
+	}
+
+	return series, nil
+}
+
 // VerifyTables always succeeds for mock store
 func (m *MockStore) VerifyTables() error {
 	return nil
 }
 
+// MissingTables reports no missing tables for mock store
+func (m *MockStore) MissingTables(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+// Ping always succeeds for mock store
+func (m *MockStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// GetSchemaStatus reports the mock store as fully migrated
+func (m *MockStore) GetSchemaStatus(ctx context.Context) (SchemaStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return SchemaStatus{}, err
+	}
+	latest := migrations[len(migrations)-1].Version
+	return SchemaStatus{Version: latest, Latest: latest}, nil
+}
+
 // Close is a no-op for mock store
 func (m *MockStore) Close() error {
 	return nil
 }
 
+// ListMetrics returns the metrics registered with the mock store
+func (m *MockStore) ListMetrics(ctx context.Context, org string) ([]Metric, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var metrics []Metric
+	for _, metric := range m.metrics {
+		if metric.OrgID == org {
+			metrics = append(metrics, metric)
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		a, b := metrics[i], metrics[j]
+		if a.Dashboard != b.Dashboard {
+			return a.Dashboard < b.Dashboard
+		}
+		if a.PanelTitle != b.PanelTitle {
+			return a.PanelTitle < b.PanelTitle
+		}
+		return a.MetricName < b.MetricName
+	})
+	return metrics, nil
+}
+
+// GetMetric returns a metric registered with the mock store
+func (m *MockStore) GetMetric(ctx context.Context, org, id string) (Metric, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metric, ok := m.metrics[org+"|"+id]
+	return metric, ok, nil
+}
+
+// SaveMetric registers a metric in memory
+func (m *MockStore) SaveMetric(ctx context.Context, metric Metric) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.metrics == nil {
+		m.metrics = make(map[string]Metric)
+	}
+	m.metrics[metric.OrgID+"|"+metric.ID] = metric
+	return nil
+}
+
+// ListLogStreams returns the mock log streams that template counts are spread across
+func (m *MockStore) ListLogStreams(ctx context.Context, org string) ([]LogStream, error) {
+	streams := make([]LogStream, len(mockStreams))
+	for i, stream := range mockStreams {
+		streams[i] = LogStream{
+			ID:      stream.values["log_stream_id"],
+			OrgID:   org,
+			Service: stream.values["service"],
+			Region:  stream.values["region"],
+			Name:    stream.values["log_stream_id"],
+		}
+	}
+	return streams, nil
+}
+
+// GetLogStream returns one of the mock log streams
+func (m *MockStore) GetLogStream(ctx context.Context, org, id string) (LogStream, bool, error) {
+	streams, _ := m.ListLogStreams(ctx, org)
+	for _, stream := range streams {
+		if stream.ID == id {
+			return stream, true, nil
+		}
+	}
+	return LogStream{}, false, nil
+}
+
+// ListMappings returns the in-memory mappings of a metric
+func (m *MockStore) ListMappings(ctx context.Context, org, metricID string) ([]MetricMapping, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var mappings []MetricMapping
+	for _, mapping := range m.mappings {
+		if mapping.OrgID == org && mapping.MetricID == metricID {
+			mappings = append(mappings, mapping)
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].LogStreamID < mappings[j].LogStreamID
+	})
+	return mappings, nil
+}
+
+// SaveMapping stores a mapping in memory
+func (m *MockStore) SaveMapping(ctx context.Context, mapping MetricMapping) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mappings == nil {
+		m.mappings = make(map[string]MetricMapping)
+	}
+	m.mappings[mapping.OrgID+"|"+mapping.MetricID+"|"+mapping.LogStreamID] = mapping
+	return nil
+}
+
+// DeleteMapping removes a mapping from memory
+func (m *MockStore) DeleteMapping(ctx context.Context, org, metricID, logStreamID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.mappings, org+"|"+metricID+"|"+logStreamID)
+	return nil
+}
+
+// RecordAudit appends an audit entry in memory
+func (m *MockStore) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audit = append(m.audit, entry)
+	return nil
+}
+
+// ListAudit returns the newest in-memory audit entries
+func (m *MockStore) ListAudit(ctx context.Context, org string, limit int) ([]AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []AuditEntry
+	for i := len(m.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		if m.audit[i].OrgID == org {
+			entries = append(entries, m.audit[i])
+		}
+	}
+	return entries, nil
+}
+
+// CountActiveStreams counts the metric's active in-memory mappings
+func (m *MockStore) CountActiveStreams(ctx context.Context, org, dashboard, panelTitle, metricName string) (uint64, error) {
+	metricID := NewMetricID(org, dashboard, panelTitle, metricName)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count uint64
+	for _, mapping := range m.mappings {
+		if mapping.OrgID == org && mapping.MetricID == metricID && mapping.Active {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetStreamTemplateCounts splits the mock template counts across the requested mock log streams
+func (m *MockStore) GetStreamTemplateCounts(ctx context.Context, org string, streamIDs []string, windows []Window) ([]map[string]map[string]uint64, error) {
+	requested := make(map[string]bool, len(streamIDs))
+	for _, id := range streamIDs {
+		requested[id] = true
+	}
+
+	counts := make([]map[string]map[string]uint64, len(windows))
+	for i, window := range windows {
+		totals, err := m.GetTemplateCounts(ctx, org, "", "", "", window.Start, window.End)
+		if err != nil {
+			return nil, err
+		}
+		counts[i] = make(map[string]map[string]uint64)
+		for _, stream := range mockStreams {
+			id := stream.values["log_stream_id"]
+			if !requested[id] {
+				continue
+			}
+			streamCounts := make(map[string]uint64)
+			for templateID, total := range totals {
+				streamCounts[templateID] = total * stream.share / 100
+			}
+			counts[i][id] = streamCounts
+		}
+	}
+	return counts, nil
+}
+
+// InsertLogs discards the log lines, since the mock's template counts are fixed
+func (m *MockStore) InsertLogs(ctx context.Context, rows []LogRow) error {
+	return nil
+}
+
+// InsertTemplateExamples keeps the examples in memory
+func (m *MockStore) InsertTemplateExamples(ctx context.Context, examples []TemplateExample) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.examples = append(m.examples, examples...)
+	return nil
+}
+
+// DeleteTemplateExamples removes a template's in-memory examples from one log stream
+func (m *MockStore) DeleteTemplateExamples(ctx context.Context, org, logStreamID, templateID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.examples[:0]
+	for _, e := range m.examples {
+		if e.OrgID != org || e.LogStreamID != logStreamID || e.TemplateID != templateID {
+			kept = append(kept, e)
+		}
+	}
+	m.examples = kept
+	return nil
+}
+
+// ListTemplateExamples returns the org's in-memory examples
+func (m *MockStore) ListTemplateExamples(ctx context.Context, org string) ([]TemplateExample, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var examples []TemplateExample
+	for _, e := range m.examples {
+		if e.OrgID == org {
+			examples = append(examples, e)
+		}
+	}
+	return examples, nil
+}
+
+// SaveLogStreams is a no-op, since the mock log streams are fixed
+func (m *MockStore) SaveLogStreams(ctx context.Context, streams []LogStream) error {
+	return nil
+}
+
+// LoadTemplates returns the org's in-memory templates
+func (m *MockStore) LoadTemplates(ctx context.Context, org string) ([]MinedTemplate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var templates []MinedTemplate
+	for _, t := range m.templates {
+		if t.OrgID == org {
+			templates = append(templates, t)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].TemplateID < templates[j].TemplateID
+	})
+	return templates, nil
+}
+
+// SaveTemplates keeps the templates in memory
+func (m *MockStore) SaveTemplates(ctx context.Context, templates []MinedTemplate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.templates == nil {
+		m.templates = make(map[string]MinedTemplate)
+	}
+	for _, t := range templates {
+		m.templates[t.OrgID+"|"+t.TemplateID] = t
+	}
+	return nil
+}
+
 // Ensure MockStore implements Store interface
 var _ Store = (*MockStore)(nil)
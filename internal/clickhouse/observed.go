@@ -0,0 +1,339 @@
+package clickhouse
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/tracing"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// QueryObserver is told about every call made through an ObservedStore
+type QueryObserver interface {
+	// ObserveQuery reports a call by query name, with the rows ClickHouse read for it
+	ObserveQuery(query string, duration time.Duration, rowsRead uint64, err error)
+}
+
+// Attributes identifying the mapping entities a call touches, how many rows it writes and
+// how many streams and windows it counts
+const (
+	metricIDKey    = attribute.Key("hover.metric_id")
+	logStreamIDKey = attribute.Key("hover.log_stream_id")
+	templateIDKey  = attribute.Key("hover.template_id")
+	rowCountKey    = attribute.Key("hover.row_count")
+	streamCountKey = attribute.Key("hover.stream_count")
+	windowCountKey = attribute.Key("hover.window_count")
+)
+
+// ObservedStore wraps a Store, records a span for each call and reports its duration, rows
+// read and outcome to the observer
+type ObservedStore struct {
+	store    Store
+	observer QueryObserver
+}
+
+// NewObservedStore reports every call on store to observer, which may be nil to only trace them
+func NewObservedStore(store Store, observer QueryObserver) *ObservedStore {
+	return &ObservedStore{store: store, observer: observer}
+}
+
+// observe runs fn in a span named after method, with a context that collects ClickHouse
+// progress packets
+func (s *ObservedStore) observe(ctx context.Context, method, query string, attrs []attribute.KeyValue, fn func(ctx context.Context) error) error {
+	attrs = append(attrs, attribute.String("db.system", "clickhouse"))
+	ctx, span := tracing.Start(ctx, "Store."+method, attrs...)
+
+	var rowsRead atomic.Uint64
+	ctx = clickhouse.Context(ctx, clickhouse.WithProgress(func(p *clickhouse.Progress) {
+		rowsRead.Add(p.Rows)
+	}))
+
+	start := time.Now()
+	err := fn(ctx)
+	if s.observer != nil {
+		s.observer.ObserveQuery(query, time.Since(start), rowsRead.Load(), err)
+	}
+	span.SetAttributes(attribute.Int64("db.rows_read", int64(rowsRead.Load())))
+	tracing.End(span, err)
+	return err
+}
+
+// GetTemplateCounts implements Store
+func (s *ObservedStore) GetTemplateCounts(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time) (map[string]uint64, error) {
+	var counts map[string]uint64
+	attrs := tracing.QueryAttributes(org, dashboard, panelTitle, metricName, startTime, endTime)
+	err := s.observe(ctx, "GetTemplateCounts", "template_counts", attrs, func(ctx context.Context) (err error) {
+		counts, err = s.store.GetTemplateCounts(ctx, org, dashboard, panelTitle, metricName, startTime, endTime)
+		return err
+	})
+	return counts, err
+}
+
+// GetRepresentativeLogs implements Store
+func (s *ObservedStore) GetRepresentativeLogs(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs []string) (map[string][]string, error) {
+	var logs map[string][]string
+	attrs := append(tracing.PanelAttributes(org, dashboard, panelTitle, metricName), tracing.TemplateCountKey.Int(len(templateIDs)))
+	err := s.observe(ctx, "GetRepresentativeLogs", "representative_logs", attrs, func(ctx context.Context) (err error) {
+		logs, err = s.store.GetRepresentativeLogs(ctx, org, dashboard, panelTitle, metricName, templateIDs)
+		return err
+	})
+	return logs, err
+}
+
+// GetTemplateBreakdown implements Store
+func (s *ObservedStore) GetTemplateBreakdown(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs, dimensions []string, startTime, endTime time.Time) ([]DimensionCount, error) {
+	var breakdown []DimensionCount
+	attrs := append(tracing.QueryAttributes(org, dashboard, panelTitle, metricName, startTime, endTime),
+		tracing.TemplateCountKey.Int(len(templateIDs)), attribute.StringSlice("hover.group_by", dimensions))
+	err := s.observe(ctx, "GetTemplateBreakdown", "template_breakdown", attrs, func(ctx context.Context) (err error) {
+		breakdown, err = s.store.GetTemplateBreakdown(ctx, org, dashboard, panelTitle, metricName, templateIDs, dimensions, startTime, endTime)
+		return err
+	})
+	return breakdown, err
+}
+
+// GetTemplateTimeSeries implements Store
+func (s *ObservedStore) GetTemplateTimeSeries(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs []string, bucketWidth time.Duration, startTime, endTime time.Time) ([]BucketCount, error) {
+	var series []BucketCount
+	attrs := append(tracing.QueryAttributes(org, dashboard, panelTitle, metricName, startTime, endTime),
+		tracing.TemplateCountKey.Int(len(templateIDs)), attribute.Float64("hover.bucket_width_seconds", bucketWidth.Seconds()))
+	err := s.observe(ctx, "GetTemplateTimeSeries", "template_time_series", attrs, func(ctx context.Context) (err error) {
+		series, err = s.store.GetTemplateTimeSeries(ctx, org, dashboard, panelTitle, metricName, templateIDs, bucketWidth, startTime, endTime)
+		return err
+	})
+	return series, err
+}
+
+// VerifyTables implements Store
+func (s *ObservedStore) VerifyTables() error {
+	return s.observe(context.Background(), "VerifyTables", "verify_tables", nil, func(ctx context.Context) error {
+		return s.store.VerifyTables()
+	})
+}
+
+// MissingTables implements Store
+func (s *ObservedStore) MissingTables(ctx context.Context) ([]string, error) {
+	var missing []string
+	err := s.observe(ctx, "MissingTables", "missing_tables", nil, func(ctx context.Context) (err error) {
+		missing, err = s.store.MissingTables(ctx)
+		return err
+	})
+	return missing, err
+}
+
+// Ping implements Store
+func (s *ObservedStore) Ping(ctx context.Context) error {
+	return s.observe(ctx, "Ping", "ping", nil, func(ctx context.Context) error {
+		return s.store.Ping(ctx)
+	})
+}
+
+// GetSchemaStatus implements Store
+func (s *ObservedStore) GetSchemaStatus(ctx context.Context) (SchemaStatus, error) {
+	var status SchemaStatus
+	err := s.observe(ctx, "GetSchemaStatus", "schema_status", nil, func(ctx context.Context) (err error) {
+		status, err = s.store.GetSchemaStatus(ctx)
+		return err
+	})
+	return status, err
+}
+
+// ListMetrics implements Store
+func (s *ObservedStore) ListMetrics(ctx context.Context, org string) ([]Metric, error) {
+	var metrics []Metric
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(org)}
+	err := s.observe(ctx, "ListMetrics", "list_metrics", attrs, func(ctx context.Context) (err error) {
+		metrics, err = s.store.ListMetrics(ctx, org)
+		return err
+	})
+	return metrics, err
+}
+
+// GetMetric implements Store
+func (s *ObservedStore) GetMetric(ctx context.Context, org, id string) (Metric, bool, error) {
+	var metric Metric
+	var ok bool
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(org), metricIDKey.String(id)}
+	err := s.observe(ctx, "GetMetric", "get_metric", attrs, func(ctx context.Context) (err error) {
+		metric, ok, err = s.store.GetMetric(ctx, org, id)
+		return err
+	})
+	return metric, ok, err
+}
+
+// SaveMetric implements Store
+func (s *ObservedStore) SaveMetric(ctx context.Context, metric Metric) error {
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(metric.OrgID), metricIDKey.String(metric.ID)}
+	return s.observe(ctx, "SaveMetric", "save_metric", attrs, func(ctx context.Context) error {
+		return s.store.SaveMetric(ctx, metric)
+	})
+}
+
+// ListLogStreams implements Store
+func (s *ObservedStore) ListLogStreams(ctx context.Context, org string) ([]LogStream, error) {
+	var streams []LogStream
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(org)}
+	err := s.observe(ctx, "ListLogStreams", "list_log_streams", attrs, func(ctx context.Context) (err error) {
+		streams, err = s.store.ListLogStreams(ctx, org)
+		return err
+	})
+	return streams, err
+}
+
+// GetLogStream implements Store
+func (s *ObservedStore) GetLogStream(ctx context.Context, org, id string) (LogStream, bool, error) {
+	var stream LogStream
+	var ok bool
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(org), logStreamIDKey.String(id)}
+	err := s.observe(ctx, "GetLogStream", "get_log_stream", attrs, func(ctx context.Context) (err error) {
+		stream, ok, err = s.store.GetLogStream(ctx, org, id)
+		return err
+	})
+	return stream, ok, err
+}
+
+// ListMappings implements Store
+func (s *ObservedStore) ListMappings(ctx context.Context, org, metricID string) ([]MetricMapping, error) {
+	var mappings []MetricMapping
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(org), metricIDKey.String(metricID)}
+	err := s.observe(ctx, "ListMappings", "list_mappings", attrs, func(ctx context.Context) (err error) {
+		mappings, err = s.store.ListMappings(ctx, org, metricID)
+		return err
+	})
+	return mappings, err
+}
+
+// SaveMapping implements Store
+func (s *ObservedStore) SaveMapping(ctx context.Context, mapping MetricMapping) error {
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(mapping.OrgID),
+		metricIDKey.String(mapping.MetricID), logStreamIDKey.String(mapping.LogStreamID)}
+	return s.observe(ctx, "SaveMapping", "save_mapping", attrs, func(ctx context.Context) error {
+		return s.store.SaveMapping(ctx, mapping)
+	})
+}
+
+// DeleteMapping implements Store
+func (s *ObservedStore) DeleteMapping(ctx context.Context, org, metricID, logStreamID string) error {
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(org), metricIDKey.String(metricID), logStreamIDKey.String(logStreamID)}
+	return s.observe(ctx, "DeleteMapping", "delete_mapping", attrs, func(ctx context.Context) error {
+		return s.store.DeleteMapping(ctx, org, metricID, logStreamID)
+	})
+}
+
+// RecordAudit implements Store
+func (s *ObservedStore) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(entry.OrgID), attribute.String("hover.audit.action", entry.Action)}
+	return s.observe(ctx, "RecordAudit", "record_audit", attrs, func(ctx context.Context) error {
+		return s.store.RecordAudit(ctx, entry)
+	})
+}
+
+// ListAudit implements Store
+func (s *ObservedStore) ListAudit(ctx context.Context, org string, limit int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(org)}
+	err := s.observe(ctx, "ListAudit", "list_audit", attrs, func(ctx context.Context) (err error) {
+		entries, err = s.store.ListAudit(ctx, org, limit)
+		return err
+	})
+	return entries, err
+}
+
+// CountActiveStreams implements Store
+func (s *ObservedStore) CountActiveStreams(ctx context.Context, org, dashboard, panelTitle, metricName string) (uint64, error) {
+	var count uint64
+	attrs := tracing.PanelAttributes(org, dashboard, panelTitle, metricName)
+	err := s.observe(ctx, "CountActiveStreams", "count_active_streams", attrs, func(ctx context.Context) (err error) {
+		count, err = s.store.CountActiveStreams(ctx, org, dashboard, panelTitle, metricName)
+		return err
+	})
+	return count, err
+}
+
+// GetStreamTemplateCounts implements Store
+func (s *ObservedStore) GetStreamTemplateCounts(ctx context.Context, org string, streamIDs []string, windows []Window) ([]map[string]map[string]uint64, error) {
+	var counts []map[string]map[string]uint64
+	attrs := []attribute.KeyValue{
+		tracing.OrgKey.String(org),
+		streamCountKey.Int(len(streamIDs)),
+		windowCountKey.Int(len(windows)),
+	}
+	err := s.observe(ctx, "GetStreamTemplateCounts", "stream_template_counts", attrs, func(ctx context.Context) (err error) {
+		counts, err = s.store.GetStreamTemplateCounts(ctx, org, streamIDs, windows)
+		return err
+	})
+	return counts, err
+}
+
+// InsertLogs implements Store
+func (s *ObservedStore) InsertLogs(ctx context.Context, rows []LogRow) error {
+	attrs := []attribute.KeyValue{rowCountKey.Int(len(rows))}
+	return s.observe(ctx, "InsertLogs", "insert_logs", attrs, func(ctx context.Context) error {
+		return s.store.InsertLogs(ctx, rows)
+	})
+}
+
+// InsertTemplateExamples implements Store
+func (s *ObservedStore) InsertTemplateExamples(ctx context.Context, examples []TemplateExample) error {
+	attrs := []attribute.KeyValue{rowCountKey.Int(len(examples))}
+	return s.observe(ctx, "InsertTemplateExamples", "insert_template_examples", attrs, func(ctx context.Context) error {
+		return s.store.InsertTemplateExamples(ctx, examples)
+	})
+}
+
+// DeleteTemplateExamples implements Store
+func (s *ObservedStore) DeleteTemplateExamples(ctx context.Context, org, logStreamID, templateID string) error {
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(org), logStreamIDKey.String(logStreamID), templateIDKey.String(templateID)}
+	return s.observe(ctx, "DeleteTemplateExamples", "delete_template_examples", attrs, func(ctx context.Context) error {
+		return s.store.DeleteTemplateExamples(ctx, org, logStreamID, templateID)
+	})
+}
+
+// ListTemplateExamples implements Store
+func (s *ObservedStore) ListTemplateExamples(ctx context.Context, org string) ([]TemplateExample, error) {
+	var examples []TemplateExample
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(org)}
+	err := s.observe(ctx, "ListTemplateExamples", "list_template_examples", attrs, func(ctx context.Context) (err error) {
+		examples, err = s.store.ListTemplateExamples(ctx, org)
+		return err
+	})
+	return examples, err
+}
+
+// SaveLogStreams implements Store
+func (s *ObservedStore) SaveLogStreams(ctx context.Context, streams []LogStream) error {
+	attrs := []attribute.KeyValue{rowCountKey.Int(len(streams))}
+	return s.observe(ctx, "SaveLogStreams", "save_log_streams", attrs, func(ctx context.Context) error {
+		return s.store.SaveLogStreams(ctx, streams)
+	})
+}
+
+// LoadTemplates implements Store
+func (s *ObservedStore) LoadTemplates(ctx context.Context, org string) ([]MinedTemplate, error) {
+	var templates []MinedTemplate
+	attrs := []attribute.KeyValue{tracing.OrgKey.String(org)}
+	err := s.observe(ctx, "LoadTemplates", "load_templates", attrs, func(ctx context.Context) (err error) {
+		templates, err = s.store.LoadTemplates(ctx, org)
+		return err
+	})
+	return templates, err
+}
+
+// SaveTemplates implements Store
+func (s *ObservedStore) SaveTemplates(ctx context.Context, templates []MinedTemplate) error {
+	attrs := []attribute.KeyValue{rowCountKey.Int(len(templates))}
+	return s.observe(ctx, "SaveTemplates", "save_templates", attrs, func(ctx context.Context) error {
+		return s.store.SaveTemplates(ctx, templates)
+	})
+}
+
+// Close implements Store
+func (s *ObservedStore) Close() error {
+	return s.store.Close()
+}
+
+// Ensure ObservedStore implements Store interface
+var _ Store = (*ObservedStore)(nil)
@@ -0,0 +1,58 @@
+package clickhouse
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type observation struct {
+	query string
+	err   error
+}
+
+type recordingObserver struct {
+	mu           sync.Mutex
+	observations []observation
+}
+
+func (o *recordingObserver) ObserveQuery(query string, duration time.Duration, rowsRead uint64, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.observations = append(o.observations, observation{query: query, err: err})
+}
+
+func TestObservedStoreReportsEachCall(t *testing.T) {
+	observer := &recordingObserver{}
+	flaky := &flakyStore{}
+	store := NewObservedStore(flaky, observer)
+	ctx := context.Background()
+
+	if _, err := store.GetTemplateCounts(ctx, "1", "d", "p", "m", time.Now().Add(-time.Hour), time.Now()); err != nil {
+		t.Fatalf("GetTemplateCounts failed: %v", err)
+	}
+	if _, err := store.GetRepresentativeLogs(ctx, "1", "d", "p", "m", []string{"template_001"}); err != nil {
+		t.Fatalf("GetRepresentativeLogs failed: %v", err)
+	}
+	flaky.failing.Store(true)
+	if err := store.Ping(ctx); err == nil {
+		t.Fatal("Expected Ping to fail")
+	}
+
+	want := []string{"template_counts", "representative_logs", "ping"}
+	if len(observer.observations) != len(want) {
+		t.Fatalf("Expected %d observations, got %+v", len(want), observer.observations)
+	}
+	for i, query := range want {
+		if observer.observations[i].query != query {
+			t.Errorf("Observation %d: expected %s, got %s", i, query, observer.observations[i].query)
+		}
+	}
+	if observer.observations[2].err == nil {
+		t.Error("Expected the failed ping to be reported with its error")
+	}
+	if observer.observations[0].err != nil {
+		t.Error("Expected the successful call to be reported without an error")
+	}
+}
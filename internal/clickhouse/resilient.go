@@ -0,0 +1,547 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a ResilientStore's circuit breaker
+type CircuitState string
+
+const (
+	// CircuitClosed passes calls through to ClickHouse
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen fails calls immediately until the backoff elapses
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a single probe through to test for recovery
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// ErrCircuitOpen is returned without contacting ClickHouse while the circuit is open
+var ErrCircuitOpen = errors.New("ClickHouse circuit breaker is open")
+
+// probeTimeout bounds the background recovery probe
+const probeTimeout = 5 * time.Second
+
+// ResilienceOptions tune the circuit breaker and reconnect backoff
+type ResilienceOptions struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int
+	// InitialBackoff is how long the circuit stays open the first time; it doubles on every
+	// failed probe up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func (o ResilienceOptions) withDefaults() ResilienceOptions {
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = 5
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = time.Second
+	}
+	if o.MaxBackoff < o.InitialBackoff {
+		o.MaxBackoff = o.InitialBackoff
+	}
+	return o
+}
+
+// ResilienceStatus is a snapshot of a ResilientStore for health reporting
+type ResilienceStatus struct {
+	State               CircuitState `json:"state"`
+	Connected           bool         `json:"connected"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	LastError           string       `json:"lastError,omitempty"`
+	// RetryAt is when the next probe is allowed while the circuit is open
+	RetryAt *time.Time `json:"retryAt,omitempty"`
+}
+
+// ResilientStore wraps a Store with lazy reconnects and a circuit breaker. After
+// FailureThreshold consecutive failures the circuit opens and calls fail fast with
+// ErrCircuitOpen; once the backoff elapses a single probe (a call, or a background
+// ping) is let through, closing the circuit on success and doubling the backoff on failure.
+type ResilientStore struct {
+	connect func() (Store, error)
+	opts    ResilienceOptions
+
+	mu        sync.Mutex
+	store     Store // nil until connected
+	state     CircuitState
+	failures  int
+	backoff   time.Duration
+	retryAt   time.Time
+	lastErr   error
+	probing   bool
+	onRecover []func()
+
+	opened    chan struct{} // signals the background loop that the circuit opened
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewResilientStore connects with connect and starts background recovery. The returned
+// store is usable even when the first connection fails, in which case the error is
+// returned alongside it and the circuit starts open.
+func NewResilientStore(connect func() (Store, error), opts ResilienceOptions) (*ResilientStore, error) {
+	s := &ResilientStore{
+		connect: connect,
+		opts:    opts.withDefaults(),
+		state:   CircuitClosed,
+		opened:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+
+	store, err := connect()
+	if err != nil {
+		s.mu.Lock()
+		s.lastErr = err
+		s.trip()
+		s.mu.Unlock()
+	} else {
+		s.store = store
+	}
+
+	go s.run()
+	return s, err
+}
+
+// OnRecover registers fn to run whenever the circuit closes again after being open
+func (s *ResilientStore) OnRecover(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRecover = append(s.onRecover, fn)
+}
+
+// Status returns the breaker state for health reporting
+func (s *ResilientStore) Status() ResilienceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := ResilienceStatus{
+		State:               s.state,
+		Connected:           s.store != nil,
+		ConsecutiveFailures: s.failures,
+	}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	if s.state == CircuitOpen {
+		retryAt := s.retryAt
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// run probes for recovery in the background whenever the circuit is open, so the
+// store reconnects even when no queries arrive
+func (s *ResilientStore) run() {
+	for {
+		s.mu.Lock()
+		open := s.state == CircuitOpen
+		wait := time.Until(s.retryAt)
+		s.mu.Unlock()
+
+		if !open {
+			select {
+			case <-s.opened:
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		err := s.do(ctx, func(store Store) error {
+			return store.Ping(ctx)
+		})
+		cancel()
+		if err != nil && !errors.Is(err, ErrCircuitOpen) {
+			slog.Warn("ClickHouse recovery probe failed", "error", err)
+		}
+	}
+}
+
+// acquire returns the store to call, connecting if needed, or ErrCircuitOpen
+func (s *ResilientStore) acquire() (Store, error) {
+	s.mu.Lock()
+	switch s.state {
+	case CircuitOpen:
+		if retryAt, lastErr := s.retryAt, s.lastErr; time.Now().Before(retryAt) {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("%w, retrying at %s: %v", ErrCircuitOpen, retryAt.Format(time.RFC3339), lastErr)
+		}
+		// Backoff elapsed: this call becomes the probe
+		s.state = CircuitHalfOpen
+		s.probing = true
+	case CircuitHalfOpen:
+		if s.probing {
+			lastErr := s.lastErr
+			s.mu.Unlock()
+			return nil, fmt.Errorf("%w, recovery probe in progress: %v", ErrCircuitOpen, lastErr)
+		}
+		s.probing = true
+	}
+	store := s.store
+	s.mu.Unlock()
+
+	if store != nil {
+		return store, nil
+	}
+
+	store, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.store == nil {
+		s.store = store
+	} else {
+		// Another caller connected first
+		store.Close()
+		store = s.store
+	}
+	s.mu.Unlock()
+	return store, nil
+}
+
+// do runs fn against the underlying store through the circuit breaker
+func (s *ResilientStore) do(ctx context.Context, fn func(Store) error) error {
+	store, err := s.acquire()
+	if errors.Is(err, ErrCircuitOpen) {
+		return err
+	}
+	if err == nil {
+		err = fn(store)
+	}
+	s.record(ctx, err)
+	return err
+}
+
+// record updates the breaker with the outcome of a call
+func (s *ResilientStore) record(ctx context.Context, err error) {
+	s.mu.Lock()
+	s.probing = false
+
+	if err != nil {
+		// Invalid requests and callers giving up say nothing about ClickHouse's health
+		if errors.Is(err, ErrInvalidDimension) || ctx.Err() == context.Canceled {
+			if s.state == CircuitHalfOpen {
+				s.state = CircuitOpen
+			}
+			s.mu.Unlock()
+			return
+		}
+
+		s.failures++
+		s.lastErr = err
+		if s.state == CircuitHalfOpen || s.failures >= s.opts.FailureThreshold {
+			s.trip()
+		}
+		s.mu.Unlock()
+		return
+	}
+
+	recovered := s.state != CircuitClosed
+	s.state = CircuitClosed
+	s.failures = 0
+	s.backoff = 0
+	s.lastErr = nil
+	callbacks := s.onRecover
+	s.mu.Unlock()
+
+	if recovered {
+		slog.Info("ClickHouse recovered, circuit closed")
+		for _, fn := range callbacks {
+			fn()
+		}
+	}
+}
+
+// trip opens the circuit with the next backoff; callers must hold mu
+func (s *ResilientStore) trip() {
+	if s.backoff == 0 {
+		s.backoff = s.opts.InitialBackoff
+	} else if s.backoff *= 2; s.backoff > s.opts.MaxBackoff {
+		s.backoff = s.opts.MaxBackoff
+	}
+	s.state = CircuitOpen
+	s.retryAt = time.Now().Add(s.backoff)
+	slog.Error("ClickHouse circuit opened", "retry_in", s.backoff, "failures", s.failures, "error", s.lastErr)
+
+	select {
+	case s.opened <- struct{}{}:
+	default:
+	}
+}
+
+// GetTemplateCounts implements Store
+func (s *ResilientStore) GetTemplateCounts(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time) (map[string]uint64, error) {
+	var counts map[string]uint64
+	err := s.do(ctx, func(store Store) (err error) {
+		counts, err = store.GetTemplateCounts(ctx, org, dashboard, panelTitle, metricName, startTime, endTime)
+		return err
+	})
+	return counts, err
+}
+
+// GetRepresentativeLogs implements Store
+func (s *ResilientStore) GetRepresentativeLogs(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs []string) (map[string][]string, error) {
+	var logs map[string][]string
+	err := s.do(ctx, func(store Store) (err error) {
+		logs, err = store.GetRepresentativeLogs(ctx, org, dashboard, panelTitle, metricName, templateIDs)
+		return err
+	})
+	return logs, err
+}
+
+// GetTemplateBreakdown implements Store
+func (s *ResilientStore) GetTemplateBreakdown(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs, dimensions []string, startTime, endTime time.Time) ([]DimensionCount, error) {
+	var breakdown []DimensionCount
+	err := s.do(ctx, func(store Store) (err error) {
+		breakdown, err = store.GetTemplateBreakdown(ctx, org, dashboard, panelTitle, metricName, templateIDs, dimensions, startTime, endTime)
+		return err
+	})
+	return breakdown, err
+}
+
+// GetTemplateTimeSeries implements Store
+func (s *ResilientStore) GetTemplateTimeSeries(ctx context.Context, org, dashboard, panelTitle, metricName string, templateIDs []string, bucketWidth time.Duration, startTime, endTime time.Time) ([]BucketCount, error) {
+	var series []BucketCount
+	err := s.do(ctx, func(store Store) (err error) {
+		series, err = store.GetTemplateTimeSeries(ctx, org, dashboard, panelTitle, metricName, templateIDs, bucketWidth, startTime, endTime)
+		return err
+	})
+	return series, err
+}
+
+// VerifyTables implements Store
+func (s *ResilientStore) VerifyTables() error {
+	return s.do(context.Background(), func(store Store) error {
+		return store.VerifyTables()
+	})
+}
+
+// MissingTables implements Store
+func (s *ResilientStore) MissingTables(ctx context.Context) ([]string, error) {
+	var missing []string
+	err := s.do(ctx, func(store Store) (err error) {
+		missing, err = store.MissingTables(ctx)
+		return err
+	})
+	return missing, err
+}
+
+// Ping implements Store
+func (s *ResilientStore) Ping(ctx context.Context) error {
+	return s.do(ctx, func(store Store) error {
+		return store.Ping(ctx)
+	})
+}
+
+// GetSchemaStatus implements Store
+func (s *ResilientStore) GetSchemaStatus(ctx context.Context) (SchemaStatus, error) {
+	var status SchemaStatus
+	err := s.do(ctx, func(store Store) (err error) {
+		status, err = store.GetSchemaStatus(ctx)
+		return err
+	})
+	return status, err
+}
+
+// ListMetrics implements Store
+func (s *ResilientStore) ListMetrics(ctx context.Context, org string) ([]Metric, error) {
+	var metrics []Metric
+	err := s.do(ctx, func(store Store) (err error) {
+		metrics, err = store.ListMetrics(ctx, org)
+		return err
+	})
+	return metrics, err
+}
+
+// GetMetric implements Store
+func (s *ResilientStore) GetMetric(ctx context.Context, org, id string) (Metric, bool, error) {
+	var metric Metric
+	var ok bool
+	err := s.do(ctx, func(store Store) (err error) {
+		metric, ok, err = store.GetMetric(ctx, org, id)
+		return err
+	})
+	return metric, ok, err
+}
+
+// SaveMetric implements Store
+func (s *ResilientStore) SaveMetric(ctx context.Context, metric Metric) error {
+	return s.do(ctx, func(store Store) error {
+		return store.SaveMetric(ctx, metric)
+	})
+}
+
+// ListLogStreams implements Store
+func (s *ResilientStore) ListLogStreams(ctx context.Context, org string) ([]LogStream, error) {
+	var streams []LogStream
+	err := s.do(ctx, func(store Store) (err error) {
+		streams, err = store.ListLogStreams(ctx, org)
+		return err
+	})
+	return streams, err
+}
+
+// GetLogStream implements Store
+func (s *ResilientStore) GetLogStream(ctx context.Context, org, id string) (LogStream, bool, error) {
+	var stream LogStream
+	var ok bool
+	err := s.do(ctx, func(store Store) (err error) {
+		stream, ok, err = store.GetLogStream(ctx, org, id)
+		return err
+	})
+	return stream, ok, err
+}
+
+// ListMappings implements Store
+func (s *ResilientStore) ListMappings(ctx context.Context, org, metricID string) ([]MetricMapping, error) {
+	var mappings []MetricMapping
+	err := s.do(ctx, func(store Store) (err error) {
+		mappings, err = store.ListMappings(ctx, org, metricID)
+		return err
+	})
+	return mappings, err
+}
+
+// SaveMapping implements Store
+func (s *ResilientStore) SaveMapping(ctx context.Context, mapping MetricMapping) error {
+	return s.do(ctx, func(store Store) error {
+		return store.SaveMapping(ctx, mapping)
+	})
+}
+
+// DeleteMapping implements Store
+func (s *ResilientStore) DeleteMapping(ctx context.Context, org, metricID, logStreamID string) error {
+	return s.do(ctx, func(store Store) error {
+		return store.DeleteMapping(ctx, org, metricID, logStreamID)
+	})
+}
+
+// RecordAudit implements Store
+func (s *ResilientStore) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	return s.do(ctx, func(store Store) error {
+		return store.RecordAudit(ctx, entry)
+	})
+}
+
+// ListAudit implements Store
+func (s *ResilientStore) ListAudit(ctx context.Context, org string, limit int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := s.do(ctx, func(store Store) (err error) {
+		entries, err = store.ListAudit(ctx, org, limit)
+		return err
+	})
+	return entries, err
+}
+
+// CountActiveStreams implements Store
+func (s *ResilientStore) CountActiveStreams(ctx context.Context, org, dashboard, panelTitle, metricName string) (uint64, error) {
+	var count uint64
+	err := s.do(ctx, func(store Store) (err error) {
+		count, err = store.CountActiveStreams(ctx, org, dashboard, panelTitle, metricName)
+		return err
+	})
+	return count, err
+}
+
+// GetStreamTemplateCounts implements Store
+func (s *ResilientStore) GetStreamTemplateCounts(ctx context.Context, org string, streamIDs []string, windows []Window) ([]map[string]map[string]uint64, error) {
+	var counts []map[string]map[string]uint64
+	err := s.do(ctx, func(store Store) (err error) {
+		counts, err = store.GetStreamTemplateCounts(ctx, org, streamIDs, windows)
+		return err
+	})
+	return counts, err
+}
+
+// InsertLogs implements Store
+func (s *ResilientStore) InsertLogs(ctx context.Context, rows []LogRow) error {
+	return s.do(ctx, func(store Store) error {
+		return store.InsertLogs(ctx, rows)
+	})
+}
+
+// InsertTemplateExamples implements Store
+func (s *ResilientStore) InsertTemplateExamples(ctx context.Context, examples []TemplateExample) error {
+	return s.do(ctx, func(store Store) error {
+		return store.InsertTemplateExamples(ctx, examples)
+	})
+}
+
+// DeleteTemplateExamples implements Store
+func (s *ResilientStore) DeleteTemplateExamples(ctx context.Context, org, logStreamID, templateID string) error {
+	return s.do(ctx, func(store Store) error {
+		return store.DeleteTemplateExamples(ctx, org, logStreamID, templateID)
+	})
+}
+
+// ListTemplateExamples implements Store
+func (s *ResilientStore) ListTemplateExamples(ctx context.Context, org string) ([]TemplateExample, error) {
+	var examples []TemplateExample
+	err := s.do(ctx, func(store Store) (err error) {
+		examples, err = store.ListTemplateExamples(ctx, org)
+		return err
+	})
+	return examples, err
+}
+
+// SaveLogStreams implements Store
+func (s *ResilientStore) SaveLogStreams(ctx context.Context, streams []LogStream) error {
+	return s.do(ctx, func(store Store) error {
+		return store.SaveLogStreams(ctx, streams)
+	})
+}
+
+// LoadTemplates implements Store
+func (s *ResilientStore) LoadTemplates(ctx context.Context, org string) ([]MinedTemplate, error) {
+	var templates []MinedTemplate
+	err := s.do(ctx, func(store Store) (err error) {
+		templates, err = store.LoadTemplates(ctx, org)
+		return err
+	})
+	return templates, err
+}
+
+// SaveTemplates implements Store
+func (s *ResilientStore) SaveTemplates(ctx context.Context, templates []MinedTemplate) error {
+	return s.do(ctx, func(store Store) error {
+		return store.SaveTemplates(ctx, templates)
+	})
+}
+
+// Close stops background recovery and closes the underlying store
+func (s *ResilientStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+
+	s.mu.Lock()
+	store := s.store
+	s.store = nil
+	s.mu.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	return store.Close()
+}
+
+// Ensure ResilientStore implements Store interface
+var _ Store = (*ResilientStore)(nil)
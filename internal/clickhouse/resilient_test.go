@@ -0,0 +1,148 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyStore fails every call while failing is set
+type flakyStore struct {
+	MockStore
+	failing atomic.Bool
+	calls   atomic.Int32
+}
+
+func (s *flakyStore) GetTemplateCounts(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time) (map[string]uint64, error) {
+	s.calls.Add(1)
+	if s.failing.Load() {
+		return nil, errors.New("read: connection reset by peer")
+	}
+	return s.MockStore.GetTemplateCounts(ctx, org, dashboard, panelTitle, metricName, startTime, endTime)
+}
+
+func (s *flakyStore) Ping(ctx context.Context) error {
+	if s.failing.Load() {
+		return errors.New("dial tcp: connection refused")
+	}
+	return nil
+}
+
+func countTemplates(s Store) error {
+	_, err := s.GetTemplateCounts(context.Background(), "1", "d", "p", "m", time.Now().Add(-time.Hour), time.Now())
+	return err
+}
+
+func TestResilientStoreOpensAfterThreshold(t *testing.T) {
+	flaky := &flakyStore{}
+	store, err := NewResilientStore(func() (Store, error) { return flaky, nil },
+		ResilienceOptions{FailureThreshold: 3, InitialBackoff: time.Hour})
+	if err != nil {
+		t.Fatalf("NewResilientStore failed: %v", err)
+	}
+	defer store.Close()
+
+	flaky.failing.Store(true)
+	for i := 0; i < 3; i++ {
+		if err := countTemplates(store); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Call %d: expected the underlying error, got %v", i, err)
+		}
+	}
+
+	if status := store.Status(); status.State != CircuitOpen || status.ConsecutiveFailures != 3 || status.RetryAt == nil {
+		t.Fatalf("Expected an open circuit after 3 failures, got %+v", status)
+	}
+
+	// Open circuit fails fast without reaching ClickHouse
+	if err := countTemplates(store); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if calls := flaky.calls.Load(); calls != 3 {
+		t.Errorf("Expected 3 calls to reach the store, got %d", calls)
+	}
+}
+
+func TestResilientStoreHalfOpenProbe(t *testing.T) {
+	flaky := &flakyStore{}
+	flaky.failing.Store(true)
+	store, _ := NewResilientStore(func() (Store, error) { return flaky, nil },
+		ResilienceOptions{FailureThreshold: 1, InitialBackoff: time.Hour, MaxBackoff: 4 * time.Hour})
+	defer store.Close()
+
+	countTemplates(store)
+	if store.Status().State != CircuitOpen {
+		t.Fatal("Expected the circuit to open")
+	}
+
+	// Pretend the backoff has elapsed: the next call is the probe, and it fails again
+	store.mu.Lock()
+	store.retryAt = time.Now()
+	store.mu.Unlock()
+	countTemplates(store)
+	store.mu.Lock()
+	state, backoff := store.state, store.backoff
+	store.mu.Unlock()
+	if state != CircuitOpen || backoff != 2*time.Hour {
+		t.Fatalf("Expected a failed probe to reopen with doubled backoff, got %s (backoff %v)", state, backoff)
+	}
+
+	recovered := make(chan struct{}, 1)
+	store.OnRecover(func() { recovered <- struct{}{} })
+
+	flaky.failing.Store(false)
+	store.mu.Lock()
+	store.retryAt = time.Now()
+	store.mu.Unlock()
+	if err := countTemplates(store); err != nil {
+		t.Fatalf("Expected the probe to succeed, got %v", err)
+	}
+	if status := store.Status(); status.State != CircuitClosed || status.ConsecutiveFailures != 0 {
+		t.Errorf("Expected a closed circuit after a successful probe, got %+v", status)
+	}
+	select {
+	case <-recovered:
+	default:
+		t.Error("Expected the recovery callback to run")
+	}
+}
+
+func TestResilientStoreReconnectsInBackground(t *testing.T) {
+	var attempts atomic.Int32
+	store, err := NewResilientStore(func() (Store, error) {
+		if attempts.Add(1) < 3 {
+			return nil, errors.New("dial tcp: connection refused")
+		}
+		return NewMockStore(), nil
+	}, ResilienceOptions{InitialBackoff: 5 * time.Millisecond})
+	if err == nil {
+		t.Fatal("Expected the first connection attempt to fail")
+	}
+	defer store.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for store.Status().State != CircuitClosed {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for background reconnect, status %+v", store.Status())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !store.Status().Connected {
+		t.Error("Expected the store to be connected")
+	}
+}
+
+func TestResilientStoreIgnoresInvalidRequests(t *testing.T) {
+	store, _ := NewResilientStore(func() (Store, error) { return NewMockStore(), nil },
+		ResilienceOptions{FailureThreshold: 1, InitialBackoff: time.Hour})
+	defer store.Close()
+
+	_, err := store.GetTemplateBreakdown(context.Background(), "1", "d", "p", "m", []string{"t"}, []string{"hostname"}, time.Now().Add(-time.Hour), time.Now())
+	if !errors.Is(err, ErrInvalidDimension) {
+		t.Fatalf("Expected ErrInvalidDimension, got %v", err)
+	}
+	if status := store.Status(); status.State != CircuitClosed || status.ConsecutiveFailures != 0 {
+		t.Errorf("Expected invalid requests not to count as failures, got %+v", status)
+	}
+}
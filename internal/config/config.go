@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -9,22 +11,157 @@ import (
 type ServerConfig struct {
 	Host string `mapstructure:"host"`
 	Port int    `mapstructure:"port"`
+	// Tokens are the API tokens the mapping and ingestion endpoints accept. Without any they
+	// are only served when Host is a loopback address.
+	Tokens []TokenConfig `mapstructure:"tokens"`
+}
+
+// TokenConfig is an API token for the standalone server
+type TokenConfig struct {
+	// Name is who changes made with the token are audited as
+	Name  string `mapstructure:"name"`
+	Token string `mapstructure:"token"`
 }
 
 func (s *ServerConfig) GetAddress() string {
 	return fmt.Sprintf("%s:%d", s.Host, s.Port)
 }
 
+// Loopback reports whether the server only listens on a loopback address
+func (s *ServerConfig) Loopback() bool {
+	if s.Host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(s.Host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// StoreMode selects where log data comes from
+type StoreMode string
+
+const (
+	// StoreModeFailClosed queries ClickHouse and returns errors, never mock data, while it is
+	// unreachable. It is the default.
+	StoreModeFailClosed StoreMode = "fail-closed"
+	// StoreModeClickHouse queries ClickHouse, serving clearly marked mock data while it is unreachable
+	StoreModeClickHouse StoreMode = "clickhouse"
+	// StoreModeMock always serves mock data and never connects to ClickHouse
+	StoreModeMock StoreMode = "mock"
+)
+
+// ParseStoreMode validates a store mode, defaulting to StoreModeFailClosed when empty
+func ParseStoreMode(s string) (StoreMode, error) {
+	switch mode := StoreMode(s); mode {
+	case "":
+		return StoreModeFailClosed, nil
+	case StoreModeClickHouse, StoreModeMock, StoreModeFailClosed:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown store mode %q (expected %s, %s or %s)",
+			s, StoreModeClickHouse, StoreModeMock, StoreModeFailClosed)
+	}
+}
+
 type ClickHouseConfig struct {
-	URL      string `mapstructure:"url"`
-	User     string `mapstructure:"user"`
-	Password string `mapstructure:"password"`
-	Database string `mapstructure:"database"`
+	// Mode is "fail-closed", "clickhouse" or "mock"; mock data is only ever served when
+	// chosen here
+	Mode StoreMode `mapstructure:"mode"`
+	// RetryInterval is the initial reconnect backoff once the circuit breaker opens; it
+	// doubles on every failed attempt up to MaxRetryInterval
+	RetryInterval    time.Duration `mapstructure:"retry_interval"`
+	MaxRetryInterval time.Duration `mapstructure:"max_retry_interval"`
+	// FailureThreshold is the number of consecutive failed queries that opens the circuit breaker
+	FailureThreshold int    `mapstructure:"failure_threshold"`
+	URL              string `mapstructure:"url"`
+	User             string `mapstructure:"user"`
+	Password         string `mapstructure:"password"`
+	Database         string `mapstructure:"database"`
+	// TLS enables TLS on the native protocol connection
+	TLS           bool `mapstructure:"tls"`
+	TLSSkipVerify bool `mapstructure:"tls_skip_verify"`
+	// TLSCACert is a PEM-encoded CA certificate to verify the server with, instead of the system pool
+	TLSCACert string `mapstructure:"tls_ca_cert"`
+}
+
+type AnalysisConfig struct {
+	// SignificanceMode is "off", "flag" or "drop" (the default, so quiet windows return
+	// nothing); requests may override it
+	SignificanceMode  string  `mapstructure:"significance_mode"`
+	SignificanceLevel float64 `mapstructure:"significance_level"`
+}
+
+type CacheConfig struct {
+	// TTL is how long an analysis of a live window is served from the cache
+	TTL time.Duration `mapstructure:"ttl"`
+	// StaleTTL is how much longer a live result is served while it is refreshed in the
+	// background; zero disables stale-while-revalidate
+	StaleTTL time.Duration `mapstructure:"stale_ttl"`
+	// HistoricalAfter is how far in the past a window must end before its results are
+	// treated as final and kept for HistoricalTTL
+	HistoricalAfter time.Duration `mapstructure:"historical_after"`
+	HistoricalTTL   time.Duration `mapstructure:"historical_ttl"`
+	// MaxSize is the number of results kept before the least recently used is evicted
+	MaxSize int `mapstructure:"max_size"`
+	// Quantization snaps query start times down and end times up to this granularity, so
+	// hovers a few seconds apart share a cache entry; zero disables it
+	Quantization time.Duration `mapstructure:"quantization"`
+	// Backend is "memory" for an in-process LRU or "disk" for a persistent store that
+	// survives restarts
+	Backend string `mapstructure:"backend"`
+	// Path is the disk cache file, defaulting to a file under the OS temp directory
+	Path string `mapstructure:"path"`
+	// MaxBytes is the disk cache size budget; entries closest to expiring are evicted past it
+	MaxBytes int64 `mapstructure:"max_bytes"`
+}
+
+type TracingConfig struct {
+	// Exporter is "none" or "otlp"; it applies to the standalone server only, since inside
+	// Grafana the plugin exports spans to the collector set in Grafana's tracing settings
+	Exporter string `mapstructure:"exporter"`
+	// Endpoint is the OTLP gRPC collector address
+	Endpoint string `mapstructure:"endpoint"`
+	Insecure bool   `mapstructure:"insecure"`
+	// SampleRatio is the fraction of new traces recorded; requests that arrive with a sampled
+	// trace are always recorded
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+	ServiceName string  `mapstructure:"service_name"`
+}
+
+type LoggingConfig struct {
+	// Level is "debug", "info", "warn" or "error"
+	Level string `mapstructure:"level"`
+	// Format is "text" or "json"; inside Grafana logs are always written in Grafana's format
+	Format string `mapstructure:"format"`
+}
+
+type IngestConfig struct {
+	// BatchSize is how many ingested lines are buffered before they are inserted
+	BatchSize int `mapstructure:"batch_size"`
+	// FlushInterval is how often smaller batches are inserted
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	// MaxBuffered is how many lines may wait to be inserted, for example while ClickHouse
+	// is down, before ingestion is refused
+	MaxBuffered int `mapstructure:"max_buffered"`
+	// ExamplesPerTemplate is how many example messages of each template are kept per log stream
+	ExamplesPerTemplate int `mapstructure:"examples_per_template"`
+	// SimilarityThreshold is the fraction of tokens a line must share with a template to be
+	// mined into it
+	SimilarityThreshold float64 `mapstructure:"similarity_threshold"`
+	// TreeDepth is the depth of the template miner's parse tree; lines are routed by their
+	// first tree_depth-3 tokens
+	TreeDepth int `mapstructure:"tree_depth"`
+	// MaxChildren caps the branches of each parse tree node
+	MaxChildren int `mapstructure:"max_children"`
 }
 
 type Config struct {
 	Server     ServerConfig     `mapstructure:"server"`
 	ClickHouse ClickHouseConfig `mapstructure:"clickhouse"`
+	Analysis   AnalysisConfig   `mapstructure:"analysis"`
+	Cache      CacheConfig      `mapstructure:"cache"`
+	Tracing    TracingConfig    `mapstructure:"tracing"`
+	Logging    LoggingConfig    `mapstructure:"logging"`
+	Ingest     IngestConfig     `mapstructure:"ingest"`
 }
 
 func Load() (*Config, error) {
@@ -32,15 +169,46 @@ func Load() (*Config, error) {
 	viper.SetConfigType("toml")
 	viper.AddConfigPath(".")
 	viper.AddConfigPath("..")
+	viper.AddConfigPath("/var/lib/grafana/plugins/hover-hover-app")
 	viper.AddConfigPath("/var/lib/grafana/plugins/hover-hover-panel")
 
 	// Set defaults
 	viper.SetDefault("server.host", "127.0.0.1")
 	viper.SetDefault("server.port", 8080)
+	viper.SetDefault("clickhouse.mode", "fail-closed")
+	viper.SetDefault("clickhouse.retry_interval", "1s")
+	viper.SetDefault("clickhouse.max_retry_interval", "1m")
+	viper.SetDefault("clickhouse.failure_threshold", 5)
 	viper.SetDefault("clickhouse.url", "localhost:9000")
 	viper.SetDefault("clickhouse.database", "default")
 	viper.SetDefault("clickhouse.user", "default")
 	viper.SetDefault("clickhouse.password", "")
+	viper.SetDefault("clickhouse.tls", false)
+	viper.SetDefault("cache.ttl", "10s")
+	viper.SetDefault("cache.stale_ttl", "1m")
+	viper.SetDefault("cache.historical_after", "5m")
+	viper.SetDefault("cache.historical_ttl", "24h")
+	viper.SetDefault("cache.max_size", 10)
+	viper.SetDefault("cache.quantization", "0s")
+	viper.SetDefault("cache.backend", "memory")
+	viper.SetDefault("cache.path", "")
+	viper.SetDefault("cache.max_bytes", 64<<20)
+	viper.SetDefault("analysis.significance_mode", "drop")
+	viper.SetDefault("analysis.significance_level", 0.05)
+	viper.SetDefault("logging.level", "info")
+	viper.SetDefault("logging.format", "text")
+	viper.SetDefault("tracing.exporter", "none")
+	viper.SetDefault("tracing.endpoint", "localhost:4317")
+	viper.SetDefault("tracing.insecure", true)
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+	viper.SetDefault("tracing.service_name", "hover")
+	viper.SetDefault("ingest.batch_size", 1000)
+	viper.SetDefault("ingest.flush_interval", "5s")
+	viper.SetDefault("ingest.max_buffered", 100000)
+	viper.SetDefault("ingest.examples_per_template", 5)
+	viper.SetDefault("ingest.similarity_threshold", 0.4)
+	viper.SetDefault("ingest.tree_depth", 4)
+	viper.SetDefault("ingest.max_children", 100)
 
 	if err := viper.ReadInConfig(); err != nil {
 		// If config file not found, use defaults
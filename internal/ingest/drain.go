@@ -0,0 +1,234 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Wildcard stands in for a parameter in a template's tokens
+const Wildcard = "<*>"
+
+// Drain settings used when DrainOptions leaves them unset
+const (
+	defaultDepth               = 4
+	defaultSimilarityThreshold = 0.4
+	defaultMaxChildren         = 100
+)
+
+// DrainOptions tune the template miner
+type DrainOptions struct {
+	// Depth is the depth of the parse tree counting the root, the token count layer and the
+	// leaves, so lines are routed by their token count and then their first Depth-3 tokens
+	Depth int
+	// SimilarityThreshold is the fraction of tokens a line must share with a template, in
+	// the same positions, to be mined into it rather than start a new template
+	SimilarityThreshold float64
+	// MaxChildren caps how many distinct tokens one tree node routes by; lines with any
+	// other token there share a <*> branch
+	MaxChildren int
+}
+
+func (o DrainOptions) withDefaults() DrainOptions {
+	if o.Depth < 3 {
+		o.Depth = defaultDepth
+	}
+	if o.SimilarityThreshold <= 0 || o.SimilarityThreshold > 1 {
+		o.SimilarityThreshold = defaultSimilarityThreshold
+	}
+	if o.MaxChildren <= 0 {
+		o.MaxChildren = defaultMaxChildren
+	}
+	return o
+}
+
+// Template is a group of log lines that differ only in their parameters
+type Template struct {
+	// ID is fixed when the template is first mined, however its tokens generalize later
+	ID string
+	// Tokens are the line's tokens with Wildcard where lines of the template differ
+	Tokens []string
+	// Path is the leading tokens the parse tree files the template under
+	Path []string
+}
+
+// String returns the template as a line, such as "Connected to <*> in <*>"
+func (t *Template) String() string {
+	return strings.Join(t.Tokens, " ")
+}
+
+type node struct {
+	children  map[string]*node
+	templates []*Template
+}
+
+func (n *node) child(key string) *node {
+	if n.children == nil {
+		n.children = make(map[string]*node)
+	}
+	c, ok := n.children[key]
+	if !ok {
+		c = &node{}
+		n.children[key] = c
+	}
+	return c
+}
+
+// Drain mines log lines into templates with the fixed-depth parse tree of He et al.,
+// "Drain: An Online Log Parsing Approach with Fixed Depth Tree" (ICWS 2017). It is not
+// safe for concurrent use.
+type Drain struct {
+	opts DrainOptions
+	// scope is mixed into template IDs so that orgs never share one
+	scope string
+	root  node
+	byID  map[string]*Template
+}
+
+// NewDrain creates an empty miner whose template IDs are unique to scope
+func NewDrain(scope string, opts DrainOptions) *Drain {
+	return &Drain{
+		opts:  opts.withDefaults(),
+		scope: scope,
+		byID:  make(map[string]*Template),
+	}
+}
+
+// Len returns the number of templates mined
+func (d *Drain) Len() int {
+	return len(d.byID)
+}
+
+// Template returns a mined template by ID
+func (d *Drain) Template(id string) (*Template, bool) {
+	t, ok := d.byID[id]
+	return t, ok
+}
+
+// Add mines a line into the most similar template, generalizing it where they differ, or
+// into a new template if none is similar enough. It reports whether a template was created
+// or its tokens changed.
+func (d *Drain) Add(message string) (*Template, bool) {
+	tokens := Tokenize(message)
+	leaf, path := d.route(tokens)
+
+	if t := d.match(leaf.templates, tokens); t != nil {
+		changed := false
+		for i, token := range tokens {
+			if t.Tokens[i] != token && t.Tokens[i] != Wildcard {
+				t.Tokens[i] = Wildcard
+				changed = true
+			}
+		}
+		return t, changed
+	}
+
+	t := &Template{ID: d.newID(tokens), Tokens: tokens, Path: path}
+	leaf.templates = append(leaf.templates, t)
+	d.byID[t.ID] = t
+	return t, true
+}
+
+// Restore adds a previously mined template back under the path it was filed under
+func (d *Drain) Restore(t Template) {
+	if _, ok := d.byID[t.ID]; ok {
+		return
+	}
+	n := d.root.child(strconv.Itoa(len(t.Tokens)))
+	for _, key := range t.Path {
+		n = n.child(key)
+	}
+	restored := &Template{ID: t.ID, Tokens: append([]string(nil), t.Tokens...), Path: append([]string(nil), t.Path...)}
+	n.templates = append(n.templates, restored)
+	d.byID[t.ID] = restored
+}
+
+// route finds the leaf for tokens, creating nodes along the way, and returns the keys taken
+func (d *Drain) route(tokens []string) (*node, []string) {
+	n := d.root.child(strconv.Itoa(len(tokens)))
+	var path []string
+	for i := 0; i < d.opts.Depth-3 && i < len(tokens); i++ {
+		key := tokens[i]
+		if _, ok := n.children[key]; !ok && key != Wildcard && len(n.children) >= d.opts.MaxChildren {
+			key = Wildcard
+		}
+		path = append(path, key)
+		n = n.child(key)
+	}
+	return n, path
+}
+
+// match returns the template most similar to tokens, preferring the more general of equally
+// similar ones, or nil if none reaches the similarity threshold
+func (d *Drain) match(templates []*Template, tokens []string) *Template {
+	var best *Template
+	bestSimilarity, bestWildcards := -1.0, -1
+	for _, t := range templates {
+		similarity, wildcards := similarity(t.Tokens, tokens)
+		if similarity > bestSimilarity || (similarity == bestSimilarity && wildcards > bestWildcards) {
+			best, bestSimilarity, bestWildcards = t, similarity, wildcards
+		}
+	}
+	if best == nil || bestSimilarity < d.opts.SimilarityThreshold {
+		return nil
+	}
+	return best
+}
+
+// similarity returns the fraction of positions where template has the same token as the
+// line, and how many wildcards the template has. Templates and lines in a leaf always have
+// the same length.
+func similarity(template, tokens []string) (float64, int) {
+	if len(tokens) == 0 {
+		return 1, 0
+	}
+	same, wildcards := 0, 0
+	for i, token := range template {
+		switch {
+		case token == Wildcard:
+			wildcards++
+		case token == tokens[i]:
+			same++
+		}
+	}
+	return float64(same) / float64(len(tokens)), wildcards
+}
+
+// newID derives a template's ID from the scope and the first line mined into it, so the
+// same line starts the same template on every instance
+func (d *Drain) newID(tokens []string) string {
+	seed := d.scope + "\x00" + strings.Join(tokens, " ")
+	for attempt := 0; ; attempt++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", seed, attempt)))
+		id := fmt.Sprintf("tpl_%x", hash[:8])
+		if _, taken := d.byID[id]; !taken {
+			return id
+		}
+	}
+}
+
+// parameterPattern matches tokens that are almost certainly parameters: numbers with an
+// optional unit, hex strings and IDs, IP addresses and times
+var parameterPattern = regexp.MustCompile(`^(?:[-+]?\d[\d.,:]*[a-zA-Z%]{0,2}|(?:0[xX])?[0-9a-fA-F]+(?:[-:.][0-9a-fA-F]+)*)$`)
+
+// Tokenize splits a line on whitespace and replaces tokens that look like parameters with
+// Wildcard, so lines differing only in numbers or IDs land in the same template at once
+func Tokenize(message string) []string {
+	tokens := strings.Fields(message)
+	for i, token := range tokens {
+		if isParameter(token) {
+			tokens[i] = Wildcard
+		}
+	}
+	return tokens
+}
+
+func isParameter(token string) bool {
+	core := strings.TrimFunc(token, func(r rune) bool {
+		return unicode.IsPunct(r) && r != '-' && r != '+' && r != '%'
+	})
+	return strings.ContainsAny(core, "0123456789") && parameterPattern.MatchString(core)
+}
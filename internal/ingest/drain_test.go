@@ -0,0 +1,100 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"Connection timeout after 30s to database-01", "Connection timeout after <*> to database-01"},
+		{"High CPU usage detected (95%) on worker-7", "High CPU usage detected <*> on worker-7"},
+		{"request 550e8400-e29b-41d4-a716-446655440000 from 10.0.0.12:8080", "request <*> from <*>"},
+		{"pointer 0x7ffd5c3a and plain words", "pointer <*> and plain words"},
+		{"  spaced\tout  ", "spaced out"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(Tokenize(tt.message), " "); got != tt.want {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestDrainMinesSimilarLinesIntoOneTemplate(t *testing.T) {
+	drain := NewDrain("acme", DrainOptions{})
+
+	first, created := drain.Add("ERROR: Out of memory on node-3 (allocated: 4.2GB, limit: 4GB)")
+	if !created {
+		t.Fatal("Expected the first line to create a template")
+	}
+	id := first.ID
+
+	second, changed := drain.Add("ERROR: Out of memory on node-5 (allocated: 4.5GB, limit: 4GB)")
+	if second.ID != id {
+		t.Fatalf("Expected a similar line to join template %s, got %s", id, second.ID)
+	}
+	if !changed {
+		t.Error("Expected the differing node name to generalize the template")
+	}
+	if got, want := second.String(), "ERROR: Out of memory on <*> (allocated: <*> limit: <*>"; got != want {
+		t.Errorf("Expected template %q, got %q", want, got)
+	}
+
+	if _, changed := drain.Add("ERROR: Out of memory on node-9 (allocated: 5GB, limit: 4GB)"); changed {
+		t.Error("Expected a line matching the generalized template not to change it")
+	}
+
+	other, _ := drain.Add("INFO: Service started successfully on port 8080")
+	shorter, _ := drain.Add("ERROR: Out of memory")
+	if other.ID == id || shorter.ID == id || other.ID == shorter.ID {
+		t.Errorf("Expected unrelated lines to get their own templates, got %s, %s and %s", id, other.ID, shorter.ID)
+	}
+	if drain.Len() != 3 {
+		t.Errorf("Expected 3 templates, got %d", drain.Len())
+	}
+}
+
+func TestDrainTemplateIDs(t *testing.T) {
+	line := "user alice logged in"
+	a, _ := NewDrain("acme", DrainOptions{}).Add(line)
+	b, _ := NewDrain("acme", DrainOptions{}).Add(line)
+	c, _ := NewDrain("globex", DrainOptions{}).Add(line)
+	if a.ID != b.ID {
+		t.Errorf("Expected the same first line to give the same ID, got %s and %s", a.ID, b.ID)
+	}
+	if a.ID == c.ID {
+		t.Error("Expected orgs not to share template IDs")
+	}
+}
+
+func TestDrainRestore(t *testing.T) {
+	drain := NewDrain("acme", DrainOptions{})
+	drain.Add("user alice logged in from web")
+	mined, _ := drain.Add("user bob logged in from web")
+
+	restored := NewDrain("acme", DrainOptions{})
+	restored.Restore(*mined)
+	again, created := restored.Add("user carol logged in from web")
+	if created || again.ID != mined.ID {
+		t.Errorf("Expected a restored template to keep matching lines, got %s (created %v)", again.ID, created)
+	}
+}
+
+func TestDrainMaxChildren(t *testing.T) {
+	drain := NewDrain("acme", DrainOptions{MaxChildren: 2})
+	drain.Add("alpha started job")
+	drain.Add("beta started job")
+
+	// A third first token no longer gets its own branch, but still matches on similarity
+	gamma, _ := drain.Add("gamma started job")
+	delta, _ := drain.Add("delta started job")
+	if gamma.ID != delta.ID {
+		t.Errorf("Expected lines past the branch limit to share a template, got %s and %s", gamma.ID, delta.ID)
+	}
+	if got := strings.Join(gamma.Path, " "); got != Wildcard {
+		t.Errorf("Expected the overflow template filed under <*>, got path %q", got)
+	}
+}
@@ -0,0 +1,544 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+// Ingester settings used when Options leaves them unset
+const (
+	defaultBatchSize           = 1000
+	defaultFlushInterval       = 5 * time.Second
+	defaultMaxBuffered         = 100000
+	defaultExamplesPerTemplate = 5
+)
+
+// flushTimeout bounds one flush to ClickHouse
+const flushTimeout = 30 * time.Second
+
+// ErrUnavailable is wrapped by errors for lines that can't be ingested because there is no
+// store to write them to
+var ErrUnavailable = errors.New("log store unavailable")
+
+// ErrBufferFull is returned when so many lines are waiting to be flushed that more would
+// risk running out of memory, usually because ClickHouse is down
+var ErrBufferFull = errors.New("ingestion buffer is full")
+
+// Options tune batching and sampling
+type Options struct {
+	// BatchSize is how many buffered lines trigger a flush
+	BatchSize int
+	// FlushInterval is how often smaller batches are flushed
+	FlushInterval time.Duration
+	// MaxBuffered is how many lines may wait to be flushed before Ingest refuses more
+	MaxBuffered int
+	// ExamplesPerTemplate is how many examples of each template are kept per log stream
+	ExamplesPerTemplate int
+	Drain               DrainOptions
+}
+
+func (o Options) withDefaults() Options {
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = defaultFlushInterval
+	}
+	if o.MaxBuffered < o.BatchSize {
+		o.MaxBuffered = max(defaultMaxBuffered, o.BatchSize)
+	}
+	if o.ExamplesPerTemplate <= 0 {
+		o.ExamplesPerTemplate = defaultExamplesPerTemplate
+	}
+	o.Drain = o.Drain.withDefaults()
+	return o
+}
+
+// Stream identifies where a batch of lines came from
+type Stream struct {
+	Org     string
+	ID      string
+	Service string
+	Region  string
+	// Name is the stream's log_stream_name, defaulting to its ID
+	Name string
+}
+
+// Line is a raw log line
+type Line struct {
+	// Timestamp defaults to when the line is ingested
+	Timestamp time.Time
+	Message   string
+}
+
+// StoreFunc returns the store to load state from and flush to, or an error wrapping
+// ErrUnavailable when lines must not be accepted
+type StoreFunc func() (clickhouse.Store, error)
+
+// sample is the reservoir of one template's examples from one log stream
+type sample struct {
+	Reservoir
+	stream     Stream
+	templateID string
+	// pending is how many examples were appended since the last flush
+	pending int
+	// rewrite is set once an example already written is replaced, so the whole sample
+	// is written again
+	rewrite bool
+}
+
+// minedTemplate is a template with what is needed to persist it
+type minedTemplate struct {
+	*Template
+	org          string
+	streamCounts map[string]uint64
+	samples      map[string]*sample // by stream ID
+	// dirty is set while the template is in Ingester.dirty, waiting to be saved
+	dirty bool
+}
+
+// orgMiner is the template miner and template state of one org
+type orgMiner struct {
+	drain     *Drain
+	templates map[string]*minedTemplate
+}
+
+// Ingester mines templates from raw log lines and writes the lines, a sample of examples
+// of each template and the miner's state to the store in batches. The state is loaded back
+// from the store the first time an org's lines are ingested, so template IDs survive restarts.
+type Ingester struct {
+	store StoreFunc
+	opts  Options
+
+	mu      sync.Mutex
+	rng     *rand.Rand
+	orgs    map[string]*orgMiner
+	rows    []clickhouse.LogRow
+	streams map[string]Stream // last registered, by org and ID
+	// newStreams are streams seen or changed since the last flush
+	newStreams map[string]Stream
+	// dirty are templates changed since the last flush
+	dirty []*minedTemplate
+
+	// flushMu keeps flushes in order
+	flushMu   sync.Mutex
+	flushNow  chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewIngester starts an ingester that flushes in the background until it is closed
+func NewIngester(store StoreFunc, opts Options) *Ingester {
+	i := &Ingester{
+		store:      store,
+		opts:       opts.withDefaults(),
+		rng:        rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		orgs:       make(map[string]*orgMiner),
+		streams:    make(map[string]Stream),
+		newStreams: make(map[string]Stream),
+		flushNow:   make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go i.run()
+	return i
+}
+
+// Batch is lines from one log stream
+type Batch struct {
+	Stream Stream
+	Lines  []Line
+}
+
+// Ingest mines each line into a template and buffers it to be flushed. It returns the ID
+// of each line's template, in order.
+func (i *Ingester) Ingest(ctx context.Context, stream Stream, lines []Line) ([]string, error) {
+	templateIDs, err := i.IngestBatches(ctx, []Batch{{Stream: stream, Lines: lines}})
+	if err != nil {
+		return nil, err
+	}
+	return templateIDs[0], nil
+}
+
+// IngestBatches ingests lines from several streams, either all of them or, on error, none.
+// It returns the template IDs of each batch's lines.
+func (i *Ingester) IngestBatches(ctx context.Context, batches []Batch) ([][]string, error) {
+	store, err := i.store()
+	if err != nil {
+		return nil, err
+	}
+	miners := make(map[string]*orgMiner)
+	total := 0
+	for _, b := range batches {
+		if _, ok := miners[b.Stream.Org]; !ok {
+			if miners[b.Stream.Org], err = i.miner(ctx, store, b.Stream.Org); err != nil {
+				return nil, err
+			}
+		}
+		total += len(b.Lines)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.rows)+total > i.opts.MaxBuffered {
+		return nil, ErrBufferFull
+	}
+
+	templateIDs := make([][]string, len(batches))
+	for n, b := range batches {
+		templateIDs[n] = i.mine(miners[b.Stream.Org], b.Stream, b.Lines)
+	}
+	if len(i.rows) >= i.opts.BatchSize {
+		select {
+		case i.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return templateIDs, nil
+}
+
+// mine adds lines from one stream to the buffer. The caller holds i.mu.
+func (i *Ingester) mine(miner *orgMiner, stream Stream, lines []Line) []string {
+	if stream.Name == "" {
+		stream.Name = stream.ID
+	}
+	key := stream.Org + "|" + stream.ID
+	if i.streams[key] != stream {
+		i.streams[key] = stream
+		i.newStreams[key] = stream
+	}
+
+	now := time.Now().UTC()
+	templateIDs := make([]string, len(lines))
+	for n, line := range lines {
+		if line.Timestamp.IsZero() {
+			line.Timestamp = now
+		}
+		t, _ := miner.drain.Add(line.Message)
+		mined := miner.templates[t.ID]
+		if mined == nil {
+			mined = &minedTemplate{Template: t, org: stream.Org, streamCounts: make(map[string]uint64), samples: make(map[string]*sample)}
+			miner.templates[t.ID] = mined
+		}
+		// Every line changes the stream counts, so a template touched is saved on the next flush
+		i.markDirty(mined)
+		mined.streamCounts[stream.ID]++
+
+		s := mined.samples[stream.ID]
+		if s == nil {
+			s = &sample{Reservoir: Reservoir{Size: i.opts.ExamplesPerTemplate}, templateID: t.ID}
+			mined.samples[stream.ID] = s
+		}
+		s.stream = stream
+		slot, replaced := s.Offer(i.rng, Example{Message: line.Message, Timestamp: line.Timestamp})
+		switch {
+		case replaced:
+			s.rewrite = true
+		case slot >= 0:
+			s.pending++
+		}
+
+		i.rows = append(i.rows, clickhouse.LogRow{
+			OrgID:         stream.Org,
+			LogStreamID:   stream.ID,
+			Service:       stream.Service,
+			Region:        stream.Region,
+			LogStreamName: stream.Name,
+			Timestamp:     line.Timestamp,
+			TemplateID:    t.ID,
+			Message:       line.Message,
+		})
+		templateIDs[n] = t.ID
+	}
+	return templateIDs
+}
+
+// markDirty queues a template to be saved on the next flush. The caller holds i.mu.
+func (i *Ingester) markDirty(mined *minedTemplate) {
+	if !mined.dirty {
+		mined.dirty = true
+		i.dirty = append(i.dirty, mined)
+	}
+}
+
+// Buffered returns how many lines are waiting to be flushed
+func (i *Ingester) Buffered() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.rows)
+}
+
+// miner returns the org's template miner, loading its templates and examples from the
+// store the first time
+func (i *Ingester) miner(ctx context.Context, store clickhouse.Store, org string) (*orgMiner, error) {
+	i.mu.Lock()
+	miner, ok := i.orgs[org]
+	i.mu.Unlock()
+	if ok {
+		return miner, nil
+	}
+
+	// Load without holding the lock, so other orgs' lines aren't held up
+	templates, err := store.LoadTemplates(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	examples, err := store.ListTemplateExamples(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+
+	miner = &orgMiner{drain: NewDrain(org, i.opts.Drain), templates: make(map[string]*minedTemplate)}
+	for _, persisted := range templates {
+		miner.drain.Restore(Template{ID: persisted.TemplateID, Tokens: persisted.Tokens, Path: persisted.Path})
+		t, _ := miner.drain.Template(persisted.TemplateID)
+		counts := make(map[string]uint64, len(persisted.StreamCounts))
+		for stream, count := range persisted.StreamCounts {
+			counts[stream] = count
+		}
+		miner.templates[t.ID] = &minedTemplate{Template: t, org: org, streamCounts: counts, samples: make(map[string]*sample)}
+	}
+	for _, e := range examples {
+		mined := miner.templates[e.TemplateID]
+		if mined == nil {
+			// Examples written by something other than the ingester
+			continue
+		}
+		s := mined.samples[e.LogStreamID]
+		if s == nil {
+			s = &sample{
+				Reservoir:  Reservoir{Size: i.opts.ExamplesPerTemplate, Seen: mined.streamCounts[e.LogStreamID]},
+				stream:     Stream{Org: org, ID: e.LogStreamID, Service: e.Service, Region: e.Region},
+				templateID: e.TemplateID,
+			}
+			mined.samples[e.LogStreamID] = s
+		}
+		s.Examples = append(s.Examples, Example{Message: e.Message, Timestamp: e.Timestamp})
+	}
+	var truncated []*minedTemplate
+	for _, mined := range miner.templates {
+		for _, s := range mined.samples {
+			if len(s.Examples) > s.Size {
+				// The sample size was lowered since the examples were written
+				s.Examples = s.Examples[:s.Size]
+				s.rewrite = true
+				truncated = append(truncated, mined)
+			}
+			s.Seen = max(s.Seen, uint64(len(s.Examples)))
+		}
+	}
+	slog.InfoContext(ctx, "Loaded template miner state", "org", org, "templates", len(templates), "examples", len(examples))
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if loaded, ok := i.orgs[org]; ok {
+		// Another request loaded it first
+		return loaded, nil
+	}
+	i.orgs[org] = miner
+	for _, mined := range truncated {
+		i.markDirty(mined)
+	}
+	return miner, nil
+}
+
+// flushBatch is what one flush writes
+type flushBatch struct {
+	rows      []clickhouse.LogRow
+	streams   map[string]Stream
+	templates []*minedTemplate
+	snapshot  []clickhouse.MinedTemplate
+	// rewrites are samples to delete before inserting examples
+	rewrites []*sample
+	examples []clickhouse.TemplateExample
+	samples  []*sample
+}
+
+// Flush writes the buffered lines, the examples sampled from them and the state of the
+// templates they were mined into. On failure everything is kept to be retried. With nothing
+// buffered the store isn't called at all, so idle flushes never count as successful calls
+// to a circuit-broken store.
+func (i *Ingester) Flush(ctx context.Context) error {
+	i.flushMu.Lock()
+	defer i.flushMu.Unlock()
+
+	b := i.takeBatch()
+	if b.empty() {
+		return nil
+	}
+	store, err := i.store()
+	if err != nil {
+		i.restoreBatch(b)
+		return err
+	}
+	if err := i.write(ctx, store, b); err != nil {
+		i.restoreBatch(b)
+		return err
+	}
+	if len(b.rows) > 0 {
+		slog.DebugContext(ctx, "Flushed ingested logs", "lines", len(b.rows), "templates", len(b.snapshot), "examples", len(b.examples))
+	}
+	return nil
+}
+
+// empty reports whether a batch has nothing to write
+func (b *flushBatch) empty() bool {
+	return len(b.rows) == 0 && len(b.streams) == 0 && len(b.snapshot) == 0 &&
+		len(b.rewrites) == 0 && len(b.examples) == 0
+}
+
+// takeBatch moves everything waiting to be flushed into a batch
+func (i *Ingester) takeBatch() *flushBatch {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	b := &flushBatch{rows: i.rows, streams: i.newStreams, templates: i.dirty}
+	i.rows = nil
+	i.newStreams = make(map[string]Stream)
+	i.dirty = nil
+
+	now := time.Now().UTC()
+	for _, mined := range b.templates {
+		mined.dirty = false
+		counts := make(map[string]uint64, len(mined.streamCounts))
+		for stream, count := range mined.streamCounts {
+			counts[stream] = count
+		}
+		b.snapshot = append(b.snapshot, clickhouse.MinedTemplate{
+			OrgID:        mined.org,
+			TemplateID:   mined.ID,
+			Tokens:       append([]string(nil), mined.Tokens...),
+			Path:         mined.Path,
+			StreamCounts: counts,
+			UpdatedAt:    now,
+		})
+
+		for _, s := range mined.samples {
+			examples := s.Examples[len(s.Examples)-s.pending:]
+			if s.rewrite {
+				b.rewrites = append(b.rewrites, s)
+				examples = s.Examples
+			}
+			if len(examples) == 0 {
+				continue
+			}
+			b.samples = append(b.samples, s)
+			for _, e := range examples {
+				b.examples = append(b.examples, clickhouse.TemplateExample{
+					OrgID:       mined.org,
+					LogStreamID: s.stream.ID,
+					Service:     s.stream.Service,
+					Region:      s.stream.Region,
+					TemplateID:  mined.ID,
+					Message:     e.Message,
+					Timestamp:   e.Timestamp,
+				})
+			}
+			s.pending = 0
+			s.rewrite = false
+		}
+	}
+	return b
+}
+
+// write stores a batch. Templates are saved before the lines that refer to them, and the
+// lines go last so that a failure never leaves them written twice when the batch is retried.
+func (i *Ingester) write(ctx context.Context, store clickhouse.Store, b *flushBatch) error {
+	if len(b.streams) > 0 {
+		now := time.Now().UTC()
+		streams := make([]clickhouse.LogStream, 0, len(b.streams))
+		for _, s := range b.streams {
+			streams = append(streams, clickhouse.LogStream{
+				ID: s.ID, OrgID: s.Org, Service: s.Service, Region: s.Region, Name: s.Name, UpdatedAt: now,
+			})
+		}
+		if err := store.SaveLogStreams(ctx, streams); err != nil {
+			return err
+		}
+	}
+	if len(b.snapshot) > 0 {
+		if err := store.SaveTemplates(ctx, b.snapshot); err != nil {
+			return err
+		}
+	}
+	for _, s := range b.rewrites {
+		if err := store.DeleteTemplateExamples(ctx, s.stream.Org, s.stream.ID, s.templateID); err != nil {
+			return err
+		}
+	}
+	if len(b.examples) > 0 {
+		if err := store.InsertTemplateExamples(ctx, b.examples); err != nil {
+			return err
+		}
+	}
+	if len(b.rows) == 0 {
+		return nil
+	}
+	return store.InsertLogs(ctx, b.rows)
+}
+
+// restoreBatch puts a batch that failed to write back in front of what was buffered since.
+// Its samples are written in full next time, since some of their examples may have been.
+func (i *Ingester) restoreBatch(b *flushBatch) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.rows = append(b.rows, i.rows...)
+	for key, s := range b.streams {
+		if _, changed := i.newStreams[key]; !changed {
+			i.newStreams[key] = s
+		}
+	}
+	for _, mined := range b.templates {
+		i.markDirty(mined)
+	}
+	for _, s := range append(b.rewrites, b.samples...) {
+		s.rewrite = true
+	}
+}
+
+// run flushes every FlushInterval, or sooner once a batch fills, until the ingester is closed
+func (i *Ingester) run() {
+	defer close(i.done)
+	ticker := time.NewTicker(i.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-i.stop:
+			return
+		case <-ticker.C:
+		case <-i.flushNow:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		if err := i.Flush(ctx); err != nil && !errors.Is(err, ErrUnavailable) {
+			slog.Warn("Failed to flush ingested logs, retrying", "buffered", i.Buffered(), "error", err)
+		}
+		cancel()
+	}
+}
+
+// Close stops background flushing and makes a last attempt to flush what is buffered
+func (i *Ingester) Close() error {
+	i.closeOnce.Do(func() {
+		close(i.stop)
+	})
+	<-i.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if err := i.Flush(ctx); err != nil {
+		if buffered := i.Buffered(); buffered > 0 {
+			return fmt.Errorf("dropping %d ingested lines: %w", buffered, err)
+		}
+	}
+	return nil
+}
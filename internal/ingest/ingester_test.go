@@ -0,0 +1,218 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+)
+
+// recordingStore keeps inserted log lines and can be made to fail inserts
+type recordingStore struct {
+	clickhouse.MockStore
+	mu   sync.Mutex
+	rows []clickhouse.LogRow
+	fail error
+}
+
+func (s *recordingStore) InsertLogs(ctx context.Context, rows []clickhouse.LogRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	s.rows = append(s.rows, rows...)
+	return nil
+}
+
+func (s *recordingStore) inserted() []clickhouse.LogRow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]clickhouse.LogRow(nil), s.rows...)
+}
+
+func (s *recordingStore) setFail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = err
+}
+
+func newTestIngester(store clickhouse.Store, opts Options) *Ingester {
+	// Only explicit flushes in tests
+	opts.FlushInterval = time.Hour
+	return NewIngester(func() (clickhouse.Store, error) { return store, nil }, opts)
+}
+
+var apiStream = Stream{Org: "acme", ID: "stream_api_east", Service: "api-server", Region: "us-east-1"}
+
+func TestTemplatesSurviveRestart(t *testing.T) {
+	store := &recordingStore{}
+	ctx := context.Background()
+
+	ingester := newTestIngester(store, Options{})
+	ids, err := ingester.Ingest(ctx, apiStream, []Line{
+		{Message: "user alice logged in from web"},
+		{Message: "user bob logged in from web"},
+		{Message: "cache warmed in 35ms"},
+	})
+	if err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	if ids[0] != ids[1] || ids[0] == ids[2] {
+		t.Fatalf("Expected the login lines to share a template, got %v", ids)
+	}
+	if err := ingester.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	rows := store.inserted()
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 lines inserted on close, got %d", len(rows))
+	}
+	if rows[0].TemplateID != ids[0] || rows[0].LogStreamName != apiStream.ID || rows[0].Timestamp.IsZero() {
+		t.Errorf("Unexpected row %+v", rows[0])
+	}
+	templates, _ := store.LoadTemplates(ctx, "acme")
+	if len(templates) != 2 {
+		t.Fatalf("Expected 2 templates saved, got %d", len(templates))
+	}
+
+	// A new ingester carries on with the saved templates, even for a line that would have
+	// started a template with a different ID
+	restarted := newTestIngester(store, Options{})
+	defer restarted.Close()
+	again, err := restarted.Ingest(ctx, apiStream, []Line{{Message: "user carol logged in from web"}})
+	if err != nil {
+		t.Fatalf("Ingest after restart failed: %v", err)
+	}
+	if again[0] != ids[0] {
+		t.Errorf("Expected template %s after restarting, got %s", ids[0], again[0])
+	}
+}
+
+func TestExamplesAreSampled(t *testing.T) {
+	store := &recordingStore{}
+	ctx := context.Background()
+	ingester := newTestIngester(store, Options{ExamplesPerTemplate: 3})
+	defer ingester.Close()
+	ingester.rng = rand.New(rand.NewPCG(1, 2))
+
+	other := apiStream
+	other.ID = "stream_api_west"
+	for batch := 0; batch < 10; batch++ {
+		var lines []Line
+		for n := 0; n < 20; n++ {
+			lines = append(lines, Line{Message: fmt.Sprintf("job %d finished", batch*20+n)})
+		}
+		if _, err := ingester.Ingest(ctx, apiStream, lines); err != nil {
+			t.Fatalf("Ingest failed: %v", err)
+		}
+		if _, err := ingester.Ingest(ctx, other, lines[:1]); err != nil {
+			t.Fatalf("Ingest failed: %v", err)
+		}
+		if err := ingester.Flush(ctx); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	examples, _ := store.ListTemplateExamples(ctx, "acme")
+	perStream := make(map[string]int)
+	for _, e := range examples {
+		perStream[e.LogStreamID]++
+	}
+	if perStream[apiStream.ID] != 3 || perStream[other.ID] != 3 {
+		t.Errorf("Expected 3 examples kept per stream, got %v", perStream)
+	}
+
+	templates, _ := store.LoadTemplates(ctx, "acme")
+	if len(templates) != 1 {
+		t.Fatalf("Expected 1 template, got %d", len(templates))
+	}
+	if counts := templates[0].StreamCounts; counts[apiStream.ID] != 200 || counts[other.ID] != 10 {
+		t.Errorf("Expected stream counts 200 and 10, got %v", counts)
+	}
+}
+
+func TestFailedFlushIsRetried(t *testing.T) {
+	store := &recordingStore{}
+	ctx := context.Background()
+	ingester := newTestIngester(store, Options{BatchSize: 2, MaxBuffered: 3})
+	defer ingester.Close()
+
+	store.setFail(errors.New("connection refused"))
+	if _, err := ingester.Ingest(ctx, apiStream, []Line{{Message: "one"}, {Message: "two"}}); err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	if err := ingester.Flush(ctx); err == nil {
+		t.Fatal("Expected the flush to fail")
+	}
+	if ingester.Buffered() != 2 {
+		t.Fatalf("Expected the failed lines to stay buffered, got %d", ingester.Buffered())
+	}
+	if _, err := ingester.Ingest(ctx, apiStream, []Line{{Message: "three"}, {Message: "four"}}); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("Expected ErrBufferFull past MaxBuffered, got %v", err)
+	}
+
+	store.setFail(nil)
+	if _, err := ingester.Ingest(ctx, apiStream, []Line{{Message: "three"}}); err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	if err := ingester.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	rows := store.inserted()
+	if len(rows) != 3 || rows[0].Message != "one" || rows[2].Message != "three" {
+		t.Errorf("Expected the retried lines inserted in order, got %+v", rows)
+	}
+}
+
+func TestIngestRefusedWhileUnavailable(t *testing.T) {
+	unavailable := fmt.Errorf("%w: ClickHouse is down", ErrUnavailable)
+	ingester := NewIngester(func() (clickhouse.Store, error) { return nil, unavailable }, Options{FlushInterval: time.Hour})
+	defer ingester.Close()
+
+	if _, err := ingester.Ingest(context.Background(), apiStream, []Line{{Message: "hello"}}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+}
+
+// queryFailingStore fails every template count query
+type queryFailingStore struct {
+	clickhouse.MockStore
+}
+
+func (s *queryFailingStore) GetTemplateCounts(ctx context.Context, org, dashboard, panelTitle, metricName string, startTime, endTime time.Time) (map[string]uint64, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestIdleFlushLeavesBreakerAlone(t *testing.T) {
+	ctx := context.Background()
+	resilient, err := clickhouse.NewResilientStore(func() (clickhouse.Store, error) {
+		return &queryFailingStore{}, nil
+	}, clickhouse.ResilienceOptions{FailureThreshold: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+	if err != nil {
+		t.Fatalf("NewResilientStore failed: %v", err)
+	}
+	defer resilient.Close()
+
+	if _, err := resilient.GetTemplateCounts(ctx, "acme", "d", "p", "m", time.Now().Add(-time.Hour), time.Now()); err == nil {
+		t.Fatal("Expected the query to fail")
+	}
+	before := resilient.Status()
+
+	ingester := newTestIngester(resilient, Options{})
+	defer ingester.Close()
+	for range 3 {
+		if err := ingester.Flush(ctx); err != nil {
+			t.Fatalf("Idle flush failed: %v", err)
+		}
+	}
+	if after := resilient.Status(); after.ConsecutiveFailures != before.ConsecutiveFailures || after.State != before.State {
+		t.Errorf("Expected idle flushes to leave the breaker at %+v, got %+v", before, after)
+	}
+}
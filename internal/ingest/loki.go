@@ -0,0 +1,340 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Stream labels that give a Loki stream its service and region, in order of preference.
+// Loki looks for the service under the same labels when it discovers service_name.
+var (
+	lokiServiceLabels = []string{"service_name", "service", "app", "application", "app_kubernetes_io_name", "container", "job"}
+	lokiRegionLabels  = []string{"region", "cloud_region", "topology_kubernetes_io_region"}
+)
+
+// LokiStream is one stream of a Loki push request: its labels and its entries
+type LokiStream struct {
+	Labels  map[string]string
+	Entries []Line
+}
+
+// LokiBatches groups the entries of a Loki push request by log stream. A stream's labels
+// give its service and region, see lokiServiceLabels and lokiRegionLabels, and in their
+// canonical form become its log_stream_name, from which its ID is derived. Empty entries
+// are dropped, as Loki drops them, and it returns how many entries were rejected for being
+// longer than MaxMessageLength.
+func LokiBatches(org string, streams []LokiStream) ([]Batch, int64) {
+	var batches []Batch
+	byStream := make(map[string]int)
+	var rejected int64
+
+	for _, s := range streams {
+		service := firstLabel(s.Labels, lokiServiceLabels)
+		if service == "" {
+			service = unknownService
+		}
+		region := firstLabel(s.Labels, lokiRegionLabels)
+		name := FormatLokiLabels(s.Labels)
+		stream := Stream{
+			Org:     org,
+			ID:      clickhouse.NewLogStreamID(org, service, region, name),
+			Service: service,
+			Region:  region,
+			Name:    name,
+		}
+
+		var lines []Line
+		for _, entry := range s.Entries {
+			if entry.Message == "" {
+				continue
+			}
+			if len(entry.Message) > MaxMessageLength {
+				rejected++
+				continue
+			}
+			lines = append(lines, entry)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		// The same label set may be sent as several streams, written in a different order
+		if n, ok := byStream[stream.ID]; ok {
+			batches[n].Lines = append(batches[n].Lines, lines...)
+			continue
+		}
+		byStream[stream.ID] = len(batches)
+		batches = append(batches, Batch{Stream: stream, Lines: lines})
+	}
+	return batches, rejected
+}
+
+// firstLabel returns the value of the first of keys that is set, or ""
+func firstLabel(labels map[string]string, keys []string) string {
+	for _, key := range keys {
+		if value := labels[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// FormatLokiLabels renders labels the way Loki prints a stream selector, sorted by name:
+// {app="api", env="prod"}
+func FormatLokiLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// ParseLokiLabels parses a stream selector of label matchers, as Promtail sends them in
+// protobuf pushes: {app="api", env="prod"}
+func ParseLokiLabels(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("labels %q must be enclosed in braces", s)
+	}
+	rest := strings.TrimSpace(s[1 : len(s)-1])
+
+	labels := make(map[string]string)
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("labels %q: expected name=\"value\"", s)
+		}
+		name := strings.TrimSpace(rest[:eq])
+		if !validLabelName(name) {
+			return nil, fmt.Errorf("labels %q: invalid label name %q", s, name)
+		}
+		rest = strings.TrimSpace(rest[eq+1:])
+
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil || !strings.HasPrefix(quoted, `"`) {
+			return nil, fmt.Errorf("labels %q: value of %s must be a double-quoted string", s, name)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("labels %q: %w", s, err)
+		}
+		labels[name] = value
+
+		rest = strings.TrimSpace(rest[len(quoted):])
+		if rest == "" {
+			break
+		}
+		if rest[0] != ',' {
+			return nil, fmt.Errorf("labels %q: expected a comma after %s", s, name)
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+	return labels, nil
+}
+
+// validLabelName reports whether name is a Prometheus label name
+func validLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// lokiPushJSON is the JSON form of a Loki push request. Each value is a timestamp in Unix
+// nanoseconds as a string, the line and optionally an object of structured metadata, which
+// is ignored.
+type lokiPushJSON struct {
+	Streams []struct {
+		Stream map[string]string   `json:"stream"`
+		Values [][]json.RawMessage `json:"values"`
+	} `json:"streams"`
+}
+
+// ParseLokiPushJSON decodes a Loki push request sent as JSON
+func ParseLokiPushJSON(data []byte) ([]LokiStream, error) {
+	var req lokiPushJSON
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+
+	streams := make([]LokiStream, len(req.Streams))
+	for i, s := range req.Streams {
+		for name := range s.Stream {
+			if !validLabelName(name) {
+				return nil, fmt.Errorf("streams[%d]: invalid label name %q", i, name)
+			}
+		}
+		entries := make([]Line, len(s.Values))
+		for j, value := range s.Values {
+			if len(value) < 2 {
+				return nil, fmt.Errorf("streams[%d].values[%d] must hold a timestamp and a line", i, j)
+			}
+			var ts string
+			if err := json.Unmarshal(value[0], &ts); err != nil {
+				return nil, fmt.Errorf("streams[%d].values[%d]: timestamp must be a string of Unix nanoseconds", i, j)
+			}
+			nanos, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("streams[%d].values[%d]: timestamp must be a string of Unix nanoseconds", i, j)
+			}
+			if err := json.Unmarshal(value[1], &entries[j].Message); err != nil {
+				return nil, fmt.Errorf("streams[%d].values[%d]: line must be a string", i, j)
+			}
+			entries[j].Timestamp = time.Unix(0, nanos).UTC()
+		}
+		streams[i] = LokiStream{Labels: s.Stream, Entries: entries}
+	}
+	return streams, nil
+}
+
+// Field numbers of Loki's push protobuf (logproto.PushRequest)
+const (
+	pushRequestStreams   protowire.Number = 1 // PushRequest.streams
+	streamLabels         protowire.Number = 1 // StreamAdapter.labels
+	streamEntries        protowire.Number = 2 // StreamAdapter.entries
+	entryTimestamp       protowire.Number = 1 // EntryAdapter.timestamp
+	entryLine            protowire.Number = 2 // EntryAdapter.line
+	timestampSeconds     protowire.Number = 1 // google.protobuf.Timestamp.seconds
+	timestampNanoseconds protowire.Number = 2 // google.protobuf.Timestamp.nanos
+)
+
+// ParseLokiPushProto decodes a Loki push request sent as protobuf, once it has been
+// decompressed. Fields other than stream labels and entry timestamps and lines are skipped.
+func ParseLokiPushProto(data []byte) ([]LokiStream, error) {
+	var streams []LokiStream
+	err := protoFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != pushRequestStreams || typ != protowire.BytesType {
+			return nil
+		}
+		stream, err := parseLokiStream(value)
+		if err != nil {
+			return fmt.Errorf("streams[%d]: %w", len(streams), err)
+		}
+		streams = append(streams, stream)
+		return nil
+	})
+	return streams, err
+}
+
+func parseLokiStream(data []byte) (LokiStream, error) {
+	var stream LokiStream
+	var labels string
+	err := protoFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case streamLabels:
+			labels = string(value)
+		case streamEntries:
+			entry, err := parseLokiEntry(value)
+			if err != nil {
+				return err
+			}
+			stream.Entries = append(stream.Entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return LokiStream{}, err
+	}
+	stream.Labels, err = ParseLokiLabels(labels)
+	return stream, err
+}
+
+func parseLokiEntry(data []byte) (Line, error) {
+	var line Line
+	err := protoFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case entryTimestamp:
+			ts, err := parseTimestamp(value)
+			if err != nil {
+				return err
+			}
+			line.Timestamp = ts
+		case entryLine:
+			line.Message = string(value)
+		}
+		return nil
+	})
+	return line, err
+}
+
+func parseTimestamp(data []byte) (time.Time, error) {
+	var seconds, nanos int64
+	err := protoFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.VarintType {
+			return nil
+		}
+		v, _ := protowire.ConsumeVarint(value)
+		switch num {
+		case timestampSeconds:
+			seconds = int64(v)
+		case timestampNanoseconds:
+			nanos = int64(int32(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
+
+// protoFields calls fn with each field of a protobuf message in turn. value holds the
+// contents of length-delimited fields and the encoded value of other fields.
+func protoFields(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		value := data[:n]
+		data = data[n:]
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(value)
+		}
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
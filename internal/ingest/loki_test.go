@@ -0,0 +1,139 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestParseLokiLabels(t *testing.T) {
+	labels, err := ParseLokiLabels(`{app="api", env = "prod",msg="say \"hi\", then {go}"}`)
+	if err != nil {
+		t.Fatalf("ParseLokiLabels failed: %v", err)
+	}
+	if len(labels) != 3 || labels["app"] != "api" || labels["env"] != "prod" || labels["msg"] != `say "hi", then {go}` {
+		t.Errorf("Unexpected labels %v", labels)
+	}
+	if got, want := FormatLokiLabels(labels), `{app="api", env="prod", msg="say \"hi\", then {go}"}`; got != want {
+		t.Errorf("FormatLokiLabels = %s, want %s", got, want)
+	}
+
+	if labels, err := ParseLokiLabels("{}"); err != nil || len(labels) != 0 {
+		t.Errorf("Expected no labels from {}, got %v, %v", labels, err)
+	}
+	for _, bad := range []string{`app="api"`, `{app=api}`, `{1app="api"}`, `{app="api" env="prod"}`, `{app='api'}`} {
+		if _, err := ParseLokiLabels(bad); err == nil {
+			t.Errorf("Expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestParseLokiPushJSON(t *testing.T) {
+	streams, err := ParseLokiPushJSON([]byte(`{"streams":[{
+		"stream":{"service_name":"api-server","region":"us-east-1"},
+		"values":[["1736942400000000000","user alice logged in"],["1736942401000000000","user bob logged in",{"trace_id":"abc"}]]}]}`))
+	if err != nil {
+		t.Fatalf("ParseLokiPushJSON failed: %v", err)
+	}
+	if len(streams) != 1 || len(streams[0].Entries) != 2 || streams[0].Labels["service_name"] != "api-server" {
+		t.Fatalf("Unexpected streams %+v", streams)
+	}
+	if got := streams[0].Entries[1]; got.Message != "user bob logged in" || !got.Timestamp.Equal(time.Unix(1736942401, 0)) {
+		t.Errorf("Unexpected entry %+v", got)
+	}
+
+	for _, bad := range []string{
+		`{"streams":[{"stream":{},"values":[["now","line"]]}]}`,
+		`{"streams":[{"stream":{},"values":[[1736942400000000000,"line"]]}]}`,
+		`{"streams":[{"stream":{},"values":[["1736942400000000000"]]}]}`,
+		`{"streams":[{"stream":{"bad-label":"x"},"values":[]}]}`,
+	} {
+		if _, err := ParseLokiPushJSON([]byte(bad)); err == nil {
+			t.Errorf("Expected %s to be rejected", bad)
+		}
+	}
+}
+
+// appendLokiPush encodes a push request of one stream the way Loki's logproto does
+func appendLokiPush(b []byte, labels string, ts time.Time, lines ...string) []byte {
+	var stream []byte
+	stream = protowire.AppendTag(stream, streamLabels, protowire.BytesType)
+	stream = protowire.AppendString(stream, labels)
+	for _, line := range lines {
+		var timestamp []byte
+		timestamp = protowire.AppendTag(timestamp, timestampSeconds, protowire.VarintType)
+		timestamp = protowire.AppendVarint(timestamp, uint64(ts.Unix()))
+		timestamp = protowire.AppendTag(timestamp, timestampNanoseconds, protowire.VarintType)
+		timestamp = protowire.AppendVarint(timestamp, uint64(ts.Nanosecond()))
+
+		var entry []byte
+		entry = protowire.AppendTag(entry, entryTimestamp, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, timestamp)
+		entry = protowire.AppendTag(entry, entryLine, protowire.BytesType)
+		entry = protowire.AppendString(entry, line)
+		stream = protowire.AppendTag(stream, streamEntries, protowire.BytesType)
+		stream = protowire.AppendBytes(stream, entry)
+	}
+	// StreamAdapter.hash, which is ignored
+	stream = protowire.AppendTag(stream, 3, protowire.VarintType)
+	stream = protowire.AppendVarint(stream, 12345)
+
+	b = protowire.AppendTag(b, pushRequestStreams, protowire.BytesType)
+	return protowire.AppendBytes(b, stream)
+}
+
+func TestParseLokiPushProto(t *testing.T) {
+	ts := time.Date(2025, 1, 15, 12, 0, 0, 500, time.UTC)
+	data := appendLokiPush(nil, `{app="api", region="us-east-1"}`, ts, "user alice logged in", "user bob logged in")
+	data = appendLokiPush(data, `{job="worker"}`, ts, "job done")
+
+	streams, err := ParseLokiPushProto(data)
+	if err != nil {
+		t.Fatalf("ParseLokiPushProto failed: %v", err)
+	}
+	if len(streams) != 2 || len(streams[0].Entries) != 2 || len(streams[1].Entries) != 1 {
+		t.Fatalf("Unexpected streams %+v", streams)
+	}
+	if streams[0].Labels["region"] != "us-east-1" || streams[1].Labels["job"] != "worker" {
+		t.Errorf("Unexpected labels %v and %v", streams[0].Labels, streams[1].Labels)
+	}
+	if got := streams[0].Entries[1]; got.Message != "user bob logged in" || !got.Timestamp.Equal(ts) {
+		t.Errorf("Unexpected entry %+v", got)
+	}
+
+	if _, err := ParseLokiPushProto(data[:len(data)-3]); err == nil {
+		t.Error("Expected a truncated request to be rejected")
+	}
+	if _, err := ParseLokiPushProto(appendLokiPush(nil, `app="api"`, ts, "line")); err == nil {
+		t.Error("Expected malformed labels to be rejected")
+	}
+}
+
+func TestLokiBatches(t *testing.T) {
+	streams := []LokiStream{
+		{Labels: map[string]string{"app": "api", "cloud_region": "us-east-1"}, Entries: []Line{{Message: "started"}, {Message: ""}}},
+		{Labels: map[string]string{"service_name": "billing", "app": "api"}, Entries: []Line{{Message: strings.Repeat("a", MaxMessageLength+1)}}},
+		{Labels: map[string]string{"cloud_region": "us-east-1", "app": "api"}, Entries: []Line{{Message: "stopped"}}},
+		{Labels: map[string]string{"host": "web-1"}, Entries: []Line{{Message: "hello"}}},
+	}
+	batches, rejected := LokiBatches("acme", streams)
+	if rejected != 1 {
+		t.Errorf("Expected the overlong entry rejected, got %d", rejected)
+	}
+	if len(batches) != 2 {
+		t.Fatalf("Expected 2 streams, got %+v", batches)
+	}
+
+	api := batches[0]
+	if api.Stream.Service != "api" || api.Stream.Region != "us-east-1" || api.Stream.Name != `{app="api", cloud_region="us-east-1"}` {
+		t.Errorf("Expected labels mapped onto the stream, got %+v", api.Stream)
+	}
+	if len(api.Lines) != 2 {
+		t.Errorf("Expected both streams with the same labels merged without the empty entry, got %+v", api.Lines)
+	}
+	if other := batches[1].Stream; other.Service != unknownService || other.ID == api.Stream.ID {
+		t.Errorf("Expected a stream without a service label to be its own unknown_service stream, got %+v", other)
+	}
+}
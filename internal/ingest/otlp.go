@@ -0,0 +1,162 @@
+package ingest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/clickhouse"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// MaxMessageLength is the longest line accepted, in bytes
+const MaxMessageLength = 64 << 10
+
+// Resource attributes that identify an OTLP log stream
+const (
+	AttributeServiceName = "service.name"
+	AttributeCloudRegion = "cloud.region"
+)
+
+// unknownService is the service.name OpenTelemetry SDKs report when none is configured
+const unknownService = "unknown_service"
+
+// streamNameAttributes name an OTLP log stream, in order of preference; without any of them
+// the stream is named after its service
+var streamNameAttributes = []string{"aws.log.stream.names", "k8s.container.name", "container.name"}
+
+// OTLPBatches groups the records of an OTLP logs export by log stream. Each resource is a
+// stream: service.name becomes its service, cloud.region its region and the first of
+// streamNameAttributes its log_stream_name, and its ID is derived from the three. It also
+// returns how many records were rejected for an empty or overlong body.
+func OTLPBatches(org string, req *collogspb.ExportLogsServiceRequest) ([]Batch, int64) {
+	var batches []Batch
+	byStream := make(map[string]int)
+	var rejected int64
+
+	for _, resourceLogs := range req.GetResourceLogs() {
+		attrs := resourceLogs.GetResource().GetAttributes()
+		service := attributeString(attrs, AttributeServiceName)
+		if service == "" {
+			service = unknownService
+		}
+		region := attributeString(attrs, AttributeCloudRegion)
+		name := service
+		for _, key := range streamNameAttributes {
+			if value := attributeString(attrs, key); value != "" {
+				name = value
+				break
+			}
+		}
+		stream := Stream{
+			Org:     org,
+			ID:      clickhouse.NewLogStreamID(org, service, region, name),
+			Service: service,
+			Region:  region,
+			Name:    name,
+		}
+
+		var lines []Line
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			for _, record := range scopeLogs.GetLogRecords() {
+				message := anyValueString(record.GetBody())
+				if message == "" || len(message) > MaxMessageLength {
+					rejected++
+					continue
+				}
+				line := Line{Message: message}
+				if ts := record.GetTimeUnixNano(); ts != 0 {
+					line.Timestamp = time.Unix(0, int64(ts)).UTC()
+				} else if ts := record.GetObservedTimeUnixNano(); ts != 0 {
+					line.Timestamp = time.Unix(0, int64(ts)).UTC()
+				}
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		// Exporters may split one resource's records across several ResourceLogs
+		if n, ok := byStream[stream.ID]; ok {
+			batches[n].Lines = append(batches[n].Lines, lines...)
+			continue
+		}
+		byStream[stream.ID] = len(batches)
+		batches = append(batches, Batch{Stream: stream, Lines: lines})
+	}
+	return batches, rejected
+}
+
+// attributeString returns an attribute's value as a string, or the first element of an
+// array value, or "" if it isn't set
+func attributeString(attrs []*commonpb.KeyValue, key string) string {
+	for _, kv := range attrs {
+		if kv.GetKey() != key {
+			continue
+		}
+		if values := kv.GetValue().GetArrayValue().GetValues(); len(values) > 0 {
+			return anyValueString(values[0])
+		}
+		return anyValueString(kv.GetValue())
+	}
+	return ""
+}
+
+// anyValueString renders a log body as a line: strings as they are, scalars in their usual
+// text form and maps and arrays as JSON
+func anyValueString(v *commonpb.AnyValue) string {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(value.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(value.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(value.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(value.BytesValue)
+	case *commonpb.AnyValue_ArrayValue, *commonpb.AnyValue_KvlistValue:
+		encoded, err := json.Marshal(anyValueJSON(v))
+		if err != nil {
+			return ""
+		}
+		return string(encoded)
+	default:
+		return ""
+	}
+}
+
+// anyValueJSON converts a value to what encoding/json renders as the equivalent JSON
+func anyValueJSON(v *commonpb.AnyValue) interface{} {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return value.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return value.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return value.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return value.BytesValue
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]interface{}, len(value.ArrayValue.GetValues()))
+		for i, element := range value.ArrayValue.GetValues() {
+			values[i] = anyValueJSON(element)
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		fields := make(map[string]interface{}, len(value.KvlistValue.GetValues()))
+		for _, kv := range value.KvlistValue.GetValues() {
+			fields[kv.GetKey()] = anyValueJSON(kv.GetValue())
+		}
+		return fields
+	default:
+		return nil
+	}
+}
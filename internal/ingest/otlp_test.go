@@ -0,0 +1,92 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func resourceLogs(attrs []*commonpb.KeyValue, records ...*logspb.LogRecord) *logspb.ResourceLogs {
+	return &logspb.ResourceLogs{
+		Resource:  &resourcepb.Resource{Attributes: attrs},
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: records}},
+	}
+}
+
+func stringRecord(body string) *logspb.LogRecord {
+	return &logspb.LogRecord{Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: body}}}
+}
+
+func TestOTLPBatches(t *testing.T) {
+	api := []*commonpb.KeyValue{
+		stringAttr(AttributeServiceName, "api-server"),
+		stringAttr(AttributeCloudRegion, "us-east-1"),
+		stringAttr("k8s.container.name", "api"),
+	}
+	timed := stringRecord("user alice logged in")
+	timed.TimeUnixNano = uint64(time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC).UnixNano())
+	observed := stringRecord("user bob logged in")
+	observed.ObservedTimeUnixNano = uint64(time.Date(2025, 1, 15, 12, 0, 1, 0, time.UTC).UnixNano())
+
+	req := &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{
+		resourceLogs(api, timed, stringRecord("")),
+		resourceLogs(nil, stringRecord("started"), stringRecord(strings.Repeat("a", MaxMessageLength+1))),
+		resourceLogs(api, observed),
+	}}
+	batches, rejected := OTLPBatches("acme", req)
+	if rejected != 2 {
+		t.Errorf("Expected the empty and overlong records rejected, got %d", rejected)
+	}
+	if len(batches) != 2 {
+		t.Fatalf("Expected records grouped into 2 streams, got %d", len(batches))
+	}
+
+	stream := batches[0].Stream
+	if stream.Service != "api-server" || stream.Region != "us-east-1" || stream.Name != "api" || stream.Org != "acme" {
+		t.Errorf("Expected resource attributes mapped onto the stream, got %+v", stream)
+	}
+	if !strings.HasPrefix(stream.ID, "stream_") {
+		t.Errorf("Expected a derived stream ID, got %q", stream.ID)
+	}
+	lines := batches[0].Lines
+	if len(lines) != 2 || lines[0].Timestamp.Second() != 0 || lines[1].Timestamp.Second() != 1 {
+		t.Errorf("Expected both api-server records with their timestamps, got %+v", lines)
+	}
+
+	if other := batches[1].Stream; other.Service != unknownService || other.Name != unknownService || other.ID == stream.ID {
+		t.Errorf("Expected a resource without attributes to be its own unknown_service stream, got %+v", other)
+	}
+}
+
+func TestAnyValueString(t *testing.T) {
+	kvlist := &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{
+		Values: []*commonpb.KeyValue{
+			stringAttr("msg", "done"),
+			{Key: "ms", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 35}}},
+		},
+	}}}
+	tests := []struct {
+		value *commonpb.AnyValue
+		want  string
+	}{
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "plain"}}, "plain"},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}, "true"},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: 1.5}}, "1.5"},
+		{kvlist, `{"ms":35,"msg":"done"}`},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := anyValueString(tt.value); got != tt.want {
+			t.Errorf("anyValueString(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
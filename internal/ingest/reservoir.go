@@ -0,0 +1,36 @@
+package ingest
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Example is a line kept as an example of its template
+type Example struct {
+	Message   string
+	Timestamp time.Time
+}
+
+// Reservoir keeps a uniform random sample of at most Size of the examples offered to it,
+// however many that is, with Vitter's Algorithm R
+type Reservoir struct {
+	Size int
+	// Seen is how many examples have been offered
+	Seen     uint64
+	Examples []Example
+}
+
+// Offer adds an example to the sample with probability Size/Seen. It returns the slot the
+// example was kept in, or -1, and whether it replaced an earlier example.
+func (r *Reservoir) Offer(rng *rand.Rand, example Example) (slot int, replaced bool) {
+	r.Seen++
+	if len(r.Examples) < r.Size {
+		r.Examples = append(r.Examples, example)
+		return len(r.Examples) - 1, false
+	}
+	if j := rng.Uint64N(r.Seen); j < uint64(r.Size) {
+		r.Examples[j] = example
+		return int(j), true
+	}
+	return -1, false
+}
@@ -0,0 +1,47 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestReservoirKeepsAtMostSize(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	r := Reservoir{Size: 3}
+	for n := 0; n < 3; n++ {
+		if slot, replaced := r.Offer(rng, Example{Message: fmt.Sprint(n)}); slot != n || replaced {
+			t.Errorf("Expected example %d appended to slot %d, got slot %d (replaced %v)", n, n, slot, replaced)
+		}
+	}
+	for n := 3; n < 100; n++ {
+		r.Offer(rng, Example{Message: fmt.Sprint(n)})
+	}
+	if len(r.Examples) != 3 || r.Seen != 100 {
+		t.Errorf("Expected 3 examples of 100 seen, got %d of %d", len(r.Examples), r.Seen)
+	}
+}
+
+func TestReservoirIsUniform(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	const size, offered, runs = 2, 10, 20000
+	kept := make(map[string]int)
+	for run := 0; run < runs; run++ {
+		r := Reservoir{Size: size}
+		for n := 0; n < offered; n++ {
+			r.Offer(rng, Example{Message: fmt.Sprint(n)})
+		}
+		for _, e := range r.Examples {
+			kept[e.Message]++
+		}
+	}
+
+	// Each example should be kept in size/offered of the runs
+	want := float64(runs) * size / offered
+	for n := 0; n < offered; n++ {
+		if got := float64(kept[fmt.Sprint(n)]); math.Abs(got-want)/want > 0.1 {
+			t.Errorf("Example %d kept %v times, expected about %v", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// SDKHandler forwards records to the Grafana plugin SDK logger, so that inside Grafana they
+// are written in Grafana's format alongside its own logs and carry the plugin context
+type SDKHandler struct {
+	logger log.Logger
+	level  slog.Leveler
+	// attrs are the key-value pairs added with WithAttrs, already prefixed with their groups
+	attrs  []any
+	prefix string
+}
+
+// NewSDKHandler forwards records at level or above to logger
+func NewSDKHandler(logger log.Logger, level slog.Leveler) *SDKHandler {
+	return &SDKHandler{logger: logger, level: level}
+}
+
+// NewSDKLogger returns a logger that bridges to the SDK logger at the configured level, with
+// context fields added. Format is ignored, since Grafana decides how its logs are written.
+func NewSDKLogger(logger log.Logger, cfg config.LoggingConfig) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	return slog.New(NewContextHandler(NewSDKHandler(logger, level))), err
+}
+
+// Enabled implements slog.Handler
+func (h *SDKHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle implements slog.Handler
+func (h *SDKHandler) Handle(ctx context.Context, record slog.Record) error {
+	args := append([]any{}, h.attrs...)
+	record.Attrs(func(attr slog.Attr) bool {
+		args = h.appendAttr(args, h.prefix, attr)
+		return true
+	})
+
+	logger := h.logger.FromContext(ctx)
+	switch {
+	case record.Level >= slog.LevelError:
+		logger.Error(record.Message, args...)
+	case record.Level >= slog.LevelWarn:
+		logger.Warn(record.Message, args...)
+	case record.Level >= slog.LevelInfo:
+		logger.Info(record.Message, args...)
+	default:
+		logger.Debug(record.Message, args...)
+	}
+	return nil
+}
+
+// appendAttr flattens attr into key-value pairs, joining group names into dotted keys
+func (h *SDKHandler) appendAttr(args []any, prefix string, attr slog.Attr) []any {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return args
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			args = h.appendAttr(args, prefix, member)
+		}
+		return args
+	}
+	return append(args, prefix+attr.Key, attr.Value.Any())
+}
+
+// WithAttrs implements slog.Handler
+func (h *SDKHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]any{}, h.attrs...)
+	for _, attr := range attrs {
+		clone.attrs = h.appendAttr(clone.attrs, h.prefix, attr)
+	}
+	return &clone
+}
+
+// WithGroup implements slog.Handler
+func (h *SDKHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
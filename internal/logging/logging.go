@@ -0,0 +1,146 @@
+// Package logging sets up the structured logger shared by every package. Code logs through
+// slog's default logger, passing the request context where it has one so that fields
+// attached with With, such as the request ID and org, and the current trace are included.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Formats selectable with config.LoggingConfig.Format
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// RequestIDHeader carries the request ID; an incoming value is kept, otherwise one is generated
+const RequestIDHeader = "X-Request-Id"
+
+// ParseLevel parses "debug", "info", "warn" or "error", defaulting to info when empty
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", s)
+	}
+}
+
+// New creates a logger writing to w in the configured level and format
+func New(cfg config.LoggingConfig, w io.Writer) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch cfg.Format {
+	case "", FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q (expected %s or %s)", cfg.Format, FormatText, FormatJSON)
+	}
+	return slog.New(NewContextHandler(handler)), nil
+}
+
+// Setup makes the configured logger slog's default, falling back to info level text output
+// if cfg is invalid
+func Setup(cfg config.LoggingConfig, w io.Writer) error {
+	logger, err := New(cfg, w)
+	if err != nil {
+		logger, _ = New(config.LoggingConfig{}, w)
+	}
+	slog.SetDefault(logger)
+	return err
+}
+
+type contextFieldsKey struct{}
+
+// With returns a context whose log records carry the given key-value pairs
+func With(ctx context.Context, args ...any) context.Context {
+	inherited, _ := ctx.Value(contextFieldsKey{}).([]slog.Attr)
+	fields := append([]slog.Attr{}, inherited...)
+	// A record parses args the same way the logging calls do
+	record := slog.NewRecord(time.Time{}, 0, "", 0)
+	record.Add(args...)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = append(fields, attr)
+		return true
+	})
+	return context.WithValue(ctx, contextFieldsKey{}, fields)
+}
+
+// ContextHandler adds the fields attached to a record's context with With, and the trace and
+// span IDs of the current span, before passing it on
+type ContextHandler struct {
+	slog.Handler
+}
+
+// NewContextHandler wraps handler to add context fields to every record
+func NewContextHandler(handler slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: handler}
+}
+
+// Handle implements slog.Handler
+func (h *ContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if fields, ok := ctx.Value(contextFieldsKey{}).([]slog.Attr); ok {
+		record.AddAttrs(fields...)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// Middleware tags the request's log records with a request ID, reusing the caller's
+// X-Request-Id if it sent one, and echoes the ID in the response
+func Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next(w, r.WithContext(With(r.Context(), "request_id", id)))
+	}
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
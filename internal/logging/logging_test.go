@@ -0,0 +1,177 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid JSON log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestNewLevelAndFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(config.LoggingConfig{Level: "warn", Format: FormatJSON}, &buf)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	logger.Info("dropped")
+	logger.Warn("kept", "key", "value")
+
+	records := decodeLines(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected only the warning to be logged, got %v", records)
+	}
+	if records[0]["msg"] != "kept" || records[0]["key"] != "value" {
+		t.Errorf("Unexpected record %v", records[0])
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	for _, cfg := range []config.LoggingConfig{{Level: "verbose"}, {Format: "xml"}} {
+		if _, err := New(cfg, &bytes.Buffer{}); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := New(config.LoggingConfig{Level: "debug", Format: FormatJSON}, &buf)
+
+	ctx := With(context.Background(), "request_id", "abc123")
+	ctx = With(ctx, "org", "acme")
+	ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	}))
+	logger.DebugContext(ctx, "cache hit", "key", "k")
+	logger.Debug("no context")
+
+	records := decodeLines(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	want := map[string]any{
+		"request_id": "abc123",
+		"org":        "acme",
+		"key":        "k",
+		"trace_id":   trace.TraceID{1}.String(),
+		"span_id":    trace.SpanID{2}.String(),
+	}
+	for key, value := range want {
+		if records[0][key] != value {
+			t.Errorf("Expected %s=%v, got %v", key, value, records[0][key])
+		}
+	}
+	if _, ok := records[1]["request_id"]; ok {
+		t.Error("Expected a record without a request context to have no request fields")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var gotID string
+	handler := Middleware(func(w http.ResponseWriter, r *http.Request) {
+		fields, _ := r.Context().Value(contextFieldsKey{}).([]slog.Attr)
+		for _, field := range fields {
+			if field.Key == "request_id" {
+				gotID = field.Value.String()
+			}
+		}
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/analyze", nil)
+	r.Header.Set(RequestIDHeader, "from-caller")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if gotID != "from-caller" || w.Header().Get(RequestIDHeader) != "from-caller" {
+		t.Errorf("Expected the caller's request ID to be kept, got %q (header %q)", gotID, w.Header().Get(RequestIDHeader))
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/analyze", nil))
+	if len(gotID) != 16 || gotID == "from-caller" || w.Header().Get(RequestIDHeader) != gotID {
+		t.Errorf("Expected a generated request ID echoed in the response, got %q (header %q)", gotID, w.Header().Get(RequestIDHeader))
+	}
+}
+
+type sdkCall struct {
+	level string
+	msg   string
+	args  []interface{}
+}
+
+// recordingSDKLogger captures what the SDK logger is asked to write
+type recordingSDKLogger struct {
+	calls *[]sdkCall
+}
+
+func (l recordingSDKLogger) record(level, msg string, args []interface{}) {
+	*l.calls = append(*l.calls, sdkCall{level, msg, args})
+}
+
+func (l recordingSDKLogger) Debug(msg string, args ...interface{}) { l.record("debug", msg, args) }
+func (l recordingSDKLogger) Info(msg string, args ...interface{})  { l.record("info", msg, args) }
+func (l recordingSDKLogger) Warn(msg string, args ...interface{})  { l.record("warn", msg, args) }
+func (l recordingSDKLogger) Error(msg string, args ...interface{}) { l.record("error", msg, args) }
+func (l recordingSDKLogger) With(args ...interface{}) log.Logger   { return l }
+func (l recordingSDKLogger) Level() log.Level                      { return log.Debug }
+func (l recordingSDKLogger) FromContext(context.Context) log.Logger {
+	return l
+}
+
+func TestSDKLoggerBridge(t *testing.T) {
+	var calls []sdkCall
+	logger, err := NewSDKLogger(recordingSDKLogger{calls: &calls}, config.LoggingConfig{Level: "info"})
+	if err != nil {
+		t.Fatalf("NewSDKLogger failed: %v", err)
+	}
+
+	ctx := With(context.Background(), "org", "acme")
+	logger.DebugContext(ctx, "dropped")
+	logger.With("component", "cache").WarnContext(ctx, "evicted", "entries", 3)
+	logger.Error("failed", slog.Group("query", "name", "ping"))
+
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 forwarded records, got %+v", calls)
+	}
+	if calls[0].level != "warn" || calls[0].msg != "evicted" {
+		t.Errorf("Unexpected first call %+v", calls[0])
+	}
+	wantArgs := []interface{}{"component", "cache", "entries", int64(3), "org", "acme"}
+	if len(calls[0].args) != len(wantArgs) {
+		t.Fatalf("Expected args %v, got %v", wantArgs, calls[0].args)
+	}
+	for i := range wantArgs {
+		if calls[0].args[i] != wantArgs[i] {
+			t.Errorf("Arg %d: expected %v, got %v", i, wantArgs[i], calls[0].args[i])
+		}
+	}
+	if calls[1].level != "error" || len(calls[1].args) != 2 || calls[1].args[0] != "query.name" {
+		t.Errorf("Expected the group to be flattened into dotted keys, got %+v", calls[1])
+	}
+}
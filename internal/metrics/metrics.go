@@ -0,0 +1,223 @@
+// Package metrics defines the Prometheus metrics exported by the standalone server on
+// /metrics and by the plugin through Grafana's plugin metrics endpoint.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "hover"
+
+// Metrics holds the collectors. A nil *Metrics is valid and records nothing, so code paths
+// built without metrics (such as tests) need no checks.
+type Metrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	cacheHits       *prometheus.CounterVec
+	cacheMisses     prometheus.Counter
+	cacheEvictions  *prometheus.CounterVec
+	singleFlight    prometheus.Counter
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+	rowsRead        *prometheus.CounterVec
+	templates       prometheus.Histogram
+	mockResponses   prometheus.Counter
+}
+
+// New creates the collectors and registers them with reg
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by endpoint and status code.",
+		}, []string{"endpoint", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by endpoint.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"endpoint"}),
+		cacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Analyses served from the result cache, by whether the entry was fresh or stale.",
+		}, []string{"freshness"}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "Analyses not found in the result cache.",
+		}),
+		cacheEvictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_evictions_total",
+			Help:      "Entries removed from the result cache, by reason (capacity or expired).",
+		}, []string{"reason"}),
+		singleFlight: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "singleflight_joins_total",
+			Help:      "Requests that joined an identical analysis already in flight instead of starting one.",
+		}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "clickhouse_query_duration_seconds",
+			Help:      "ClickHouse query latency by query name.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"query"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "clickhouse_query_errors_total",
+			Help:      "Failed ClickHouse queries by query name.",
+		}, []string{"query"}),
+		rowsRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "clickhouse_rows_read_total",
+			Help:      "Rows scanned by ClickHouse, by query name.",
+		}, []string{"query"}),
+		templates: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "analysis_templates",
+			Help:      "Number of templates returned per analysis.",
+			Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100},
+		}),
+		mockResponses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "mock_responses_total",
+			Help:      "Responses served from mock data instead of ClickHouse.",
+		}),
+	}
+
+	reg.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.cacheHits,
+		m.cacheMisses,
+		m.cacheEvictions,
+		m.singleFlight,
+		m.queryDuration,
+		m.queryErrors,
+		m.rowsRead,
+		m.templates,
+		m.mockResponses,
+	)
+	return m
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultMetrics *Metrics
+)
+
+// Default returns the metrics registered with the default Prometheus registry, which the
+// standalone server exposes on /metrics and the plugin SDK reports to Grafana. Plugin
+// instances are recreated when settings change, so registration happens only once.
+func Default() *Metrics {
+	defaultOnce.Do(func() {
+		defaultMetrics = New(prometheus.DefaultRegisterer)
+	})
+	return defaultMetrics
+}
+
+// Instrument wraps next to count its requests and time them under the endpoint label
+func (m *Metrics) Instrument(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	if m == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		m.requests.WithLabelValues(endpoint, strconv.Itoa(rec.status)).Inc()
+		m.requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	}
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// CacheHit counts a result served from the cache
+func (m *Metrics) CacheHit(stale bool) {
+	if m == nil {
+		return
+	}
+	freshness := "fresh"
+	if stale {
+		freshness = "stale"
+	}
+	m.cacheHits.WithLabelValues(freshness).Inc()
+}
+
+// CacheMiss counts a lookup that found nothing cached
+func (m *Metrics) CacheMiss() {
+	if m == nil {
+		return
+	}
+	m.cacheMisses.Inc()
+}
+
+// CacheEvicted counts n entries evicted to make room
+func (m *Metrics) CacheEvicted(n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.cacheEvictions.WithLabelValues("capacity").Add(float64(n))
+}
+
+// CacheExpired counts n expired entries removed
+func (m *Metrics) CacheExpired(n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.cacheEvictions.WithLabelValues("expired").Add(float64(n))
+}
+
+// SingleFlightJoined counts a request that shared an in-flight analysis
+func (m *Metrics) SingleFlightJoined() {
+	if m == nil {
+		return
+	}
+	m.singleFlight.Inc()
+}
+
+// ObserveQuery records a ClickHouse query; it implements clickhouse.QueryObserver
+func (m *Metrics) ObserveQuery(query string, duration time.Duration, rowsRead uint64, err error) {
+	if m == nil {
+		return
+	}
+	m.queryDuration.WithLabelValues(query).Observe(duration.Seconds())
+	if err != nil {
+		m.queryErrors.WithLabelValues(query).Inc()
+	}
+	if rowsRead > 0 {
+		m.rowsRead.WithLabelValues(query).Add(float64(rowsRead))
+	}
+}
+
+// AnalysisCompleted records the number of templates an analysis returned
+func (m *Metrics) AnalysisCompleted(templates int) {
+	if m == nil {
+		return
+	}
+	m.templates.Observe(float64(templates))
+}
+
+// MockResponse counts a response served from mock data
+func (m *Metrics) MockResponse() {
+	if m == nil {
+		return
+	}
+	m.mockResponses.Inc()
+}
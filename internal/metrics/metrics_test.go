@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// sample returns the counter value or histogram sample count of the series matching labels
+func sample(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if !hasLabels(m, labels) {
+				continue
+			}
+			if m.GetHistogram() != nil {
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func hasLabels(m *dto.Metric, labels map[string]string) bool {
+	for name, value := range labels {
+		found := false
+		for _, pair := range m.GetLabel() {
+			if pair.GetName() == name && pair.GetValue() == value {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestInstrument(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := New(reg)
+
+	ok := m.Instrument("/analyze", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	})
+	bad := m.Instrument("/analyze", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	for i := 0; i < 2; i++ {
+		ok(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/analyze", nil))
+	}
+	bad(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/analyze", nil))
+
+	if got := sample(t, reg, "hover_http_requests_total", map[string]string{"endpoint": "/analyze", "code": "200"}); got != 2 {
+		t.Errorf("Expected 2 successful requests, got %v", got)
+	}
+	if got := sample(t, reg, "hover_http_requests_total", map[string]string{"endpoint": "/analyze", "code": "400"}); got != 1 {
+		t.Errorf("Expected 1 bad request, got %v", got)
+	}
+	if got := sample(t, reg, "hover_http_request_duration_seconds", map[string]string{"endpoint": "/analyze"}); got != 3 {
+		t.Errorf("Expected 3 latency observations, got %v", got)
+	}
+}
+
+func TestRecorders(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := New(reg)
+
+	m.CacheHit(false)
+	m.CacheHit(true)
+	m.CacheMiss()
+	m.CacheEvicted(2)
+	m.CacheExpired(3)
+	m.CacheExpired(0)
+	m.SingleFlightJoined()
+	m.ObserveQuery("template_counts", 10*time.Millisecond, 1500, nil)
+	m.ObserveQuery("template_counts", 20*time.Millisecond, 0, errors.New("timeout"))
+	m.AnalysisCompleted(4)
+	m.MockResponse()
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"hover_cache_hits_total", map[string]string{"freshness": "fresh"}, 1},
+		{"hover_cache_hits_total", map[string]string{"freshness": "stale"}, 1},
+		{"hover_cache_misses_total", nil, 1},
+		{"hover_cache_evictions_total", map[string]string{"reason": "capacity"}, 2},
+		{"hover_cache_evictions_total", map[string]string{"reason": "expired"}, 3},
+		{"hover_singleflight_joins_total", nil, 1},
+		{"hover_clickhouse_query_duration_seconds", map[string]string{"query": "template_counts"}, 2},
+		{"hover_clickhouse_query_errors_total", map[string]string{"query": "template_counts"}, 1},
+		{"hover_clickhouse_rows_read_total", map[string]string{"query": "template_counts"}, 1500},
+		{"hover_analysis_templates", nil, 1},
+		{"hover_mock_responses_total", nil, 1},
+	}
+	for _, tt := range tests {
+		if got := sample(t, reg, tt.name, tt.labels); got != tt.want {
+			t.Errorf("%s%v: expected %v, got %v", tt.name, tt.labels, tt.want, got)
+		}
+	}
+}
+
+func TestNilMetricsRecordNothing(t *testing.T) {
+	var m *Metrics
+
+	// None of these may panic
+	m.CacheHit(false)
+	m.CacheMiss()
+	m.CacheEvicted(1)
+	m.CacheExpired(1)
+	m.SingleFlightJoined()
+	m.ObserveQuery("ping", time.Millisecond, 0, nil)
+	m.AnalysisCompleted(1)
+	m.MockResponse()
+
+	called := false
+	m.Instrument("/analyze", func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/analyze", nil))
+	if !called {
+		t.Error("Expected the uninstrumented handler to be called")
+	}
+}
@@ -2,35 +2,118 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/api"
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/logging"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/metrics"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/app"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 )
 
-// Make sure App implements required interfaces
+// ID is the plugin ID from src/plugin.json. The backend belongs to an app plugin, which bundles
+// the Hover panel, because Grafana only sends saved settings to app and data source plugins.
+const ID = "hover-hover-app"
+
+// Make sure App and Service implement required interfaces
 var (
-	_ backend.CallResourceHandler = (*App)(nil)
+	_ backend.CallResourceHandler   = (*App)(nil)
+	_ backend.CheckHealthHandler    = (*App)(nil)
+	_ backend.CallResourceHandler   = (*Service)(nil)
+	_ backend.CheckHealthHandler    = (*Service)(nil)
+	_ instancemgmt.InstanceDisposer = (*App)(nil)
 )
 
+// Service routes calls to the App instance for the caller's plugin settings, which Grafana
+// sends with every call as the app's jsonData and secureJsonData. The SDK's instance manager
+// creates a new App whenever the settings are saved in Grafana and disposes the old one, so
+// connection changes apply without restarting Grafana.
+type Service struct {
+	im instancemgmt.InstanceManager
+}
+
+// NewService creates the plugin service
+func NewService() *Service {
+	return &Service{
+		im: app.NewInstanceManager(NewInstance),
+	}
+}
+
+// CallResource forwards the call to the current App instance
+func (s *Service) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	a, err := s.instance(ctx, req.PluginContext)
+	if err != nil {
+		return err
+	}
+	return a.CallResource(ctx, req, sender)
+}
+
+// CheckHealth reports the health of the App instance for the caller's settings
+func (s *Service) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	a, err := s.instance(ctx, req.PluginContext)
+	if err != nil {
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: fmt.Sprintf("Failed to load plugin settings: %v", err),
+		}, nil
+	}
+	return a.CheckHealth(ctx, req)
+}
+
+func (s *Service) instance(ctx context.Context, pluginContext backend.PluginContext) (*App, error) {
+	instance, err := s.im.Get(ctx, pluginContext)
+	if err != nil {
+		return nil, err
+	}
+	return instance.(*App), nil
+}
+
 // App is the backend plugin implementation
 type App struct {
 	backend.CallResourceHandler
 	handler *api.Handler
 }
 
-// NewPanelApp creates a new instance of the panel plugin (no datasource instance needed)
-func NewPanelApp(ctx context.Context) (*App, error) {
-	log.DefaultLogger.Info("Creating panel plugin instance")
+// NewInstance creates an App from the plugin settings saved in Grafana
+func NewInstance(ctx context.Context, settings backend.AppInstanceSettings) (instancemgmt.Instance, error) {
+	slog.InfoContext(ctx, "Creating app instance", "updated", settings.Updated)
+
+	cfg, err := loadConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the configured level to everything the backend logs through Grafana's logger
+	logger, err := logging.NewSDKLogger(log.DefaultLogger, cfg.Logging)
+	if err != nil {
+		slog.WarnContext(ctx, "Invalid logging config, logging at info level", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	return NewPanelApp(ctx, cfg)
+}
+
+// loadConfig reads config.toml (if present) and applies the plugin settings on top of it
+func loadConfig(settings backend.AppInstanceSettings) (*config.Config, error) {
+	pluginSettings, secure, err := LoadSettings(settings)
+	if err != nil {
+		slog.Error("Failed to parse plugin settings", "error", err)
+		return nil, err
+	}
 
-	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		log.DefaultLogger.Error("Failed to load configuration", "error", err)
+		slog.Error("Failed to load configuration", "error", err)
 		// Don't fail plugin startup, just log the error
 		cfg = &config.Config{
 			Server: config.ServerConfig{
@@ -40,34 +123,110 @@ func NewPanelApp(ctx context.Context) (*App, error) {
 		}
 	}
 
+	pluginSettings.Apply(cfg, secure)
+	return cfg, nil
+}
+
+// NewPanelApp creates a new instance of the panel plugin for the given configuration
+func NewPanelApp(ctx context.Context, cfg *config.Config) (*App, error) {
 	// Create API handler
 	handler := api.NewHandler(cfg)
 
 	// Try to verify tables but don't fail if it doesn't work
 	if err := handler.VerifyTables(); err != nil {
-		log.DefaultLogger.Warn("Failed to verify ClickHouse tables", "error", err)
-		log.DefaultLogger.Info("Plugin will return mock data when ClickHouse is unavailable")
+		slog.WarnContext(ctx, "Failed to verify ClickHouse tables", "error", err)
+	}
+	if handler.UsingMockData() {
+		slog.WarnContext(ctx, "Serving mock data; responses are marked with the "+api.MockDataHeader+" header", "mode", cfg.ClickHouse.Mode)
 	}
 
 	app := &App{
 		handler: handler,
 	}
 
-	// Setup resource handler
+	// Setup resource handler. Metrics go to the default Prometheus registry, which the
+	// SDK serves to Grafana at /api/plugins/hover-hover-app/metrics.
+	m := metrics.Default()
 	mux := http.NewServeMux()
-	mux.HandleFunc("/query_logs", app.handleQueryLogs)
+	mux.HandleFunc("/query_logs", m.Instrument("/query_logs", logging.Middleware(withGrafanaOrg(app.handleQueryLogs))))
+	mux.HandleFunc("/v2/query_logs", m.Instrument("/v2/query_logs", logging.Middleware(withGrafanaOrg(app.handleQueryLogsV2))))
+	for _, route := range handler.MappingRoutes() {
+		mux.HandleFunc(route.Pattern, m.Instrument(route.Pattern, logging.Middleware(withGrafanaOrg(withGrafanaUser(route)))))
+	}
 	app.CallResourceHandler = httpadapter.New(mux)
 
 	return app, nil
 }
 
+// CheckHealth backs Grafana's "Save & test": it pings ClickHouse, checks the schema
+// and required tables, and reports whether mock data is being served
+func (a *App) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	report := a.handler.CheckHealth(ctx)
+
+	details, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+
+	status := backend.HealthStatusOk
+	if !report.Healthy() {
+		status = backend.HealthStatusError
+		slog.WarnContext(ctx, "Health check failed", "problems", report.Problems)
+	}
+
+	return &backend.CheckHealthResult{
+		Status:      status,
+		Message:     report.Message(),
+		JSONDetails: details,
+	}, nil
+}
+
 // Dispose is called when the app instance is being disposed
 func (a *App) Dispose() {
-	log.DefaultLogger.Info("Disposing app instance")
+	slog.Info("Disposing app instance")
+	if err := a.handler.Close(); err != nil {
+		slog.Warn("Failed to close handler", "error", err)
+	}
 }
 
 // handleQueryLogs handles the query_logs resource call
 func (a *App) handleQueryLogs(w http.ResponseWriter, r *http.Request) {
-	log.DefaultLogger.Debug("Handling query_logs request")
+	slog.DebugContext(r.Context(), "Handling query_logs request")
 	a.handler.QueryLogs(w, r)
 }
+
+// handleQueryLogsV2 handles the v2/query_logs resource call
+func (a *App) handleQueryLogsV2(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "Handling v2/query_logs request")
+	a.handler.QueryLogsV2(w, r)
+}
+
+// withGrafanaOrg scopes a request to the caller's Grafana org, whatever org it names itself
+func withGrafanaOrg(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org := strconv.FormatInt(httpadapter.PluginConfigFromContext(r.Context()).OrgID, 10)
+		next(w, r.WithContext(api.WithOrg(r.Context(), org)))
+	}
+}
+
+// withGrafanaUser audits mapping changes as made by the signed-in Grafana user, and only
+// lets Editors and Admins make them
+func withGrafanaUser(route api.Route) http.HandlerFunc {
+	change := !strings.HasPrefix(route.Pattern, http.MethodGet+" ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := httpadapter.UserFromContext(r.Context())
+		if change && (user == nil || !canEdit(user.Role)) {
+			api.WriteError(w, http.StatusForbidden, "Forbidden", "Changing mappings requires the Editor or Admin role")
+			return
+		}
+		if user != nil && user.Login != "" {
+			r = r.WithContext(api.WithActor(r.Context(), user.Login))
+		}
+		route.Handler(w, r)
+	}
+}
+
+// canEdit reports whether a Grafana org role may change mappings
+func canEdit(role string) bool {
+	return role == "Editor" || role == "Admin"
+}
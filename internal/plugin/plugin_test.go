@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestCheckHealthReportsMockData(t *testing.T) {
+	app, err := NewPanelApp(context.Background(), &config.Config{
+		ClickHouse: config.ClickHouseConfig{Mode: config.StoreModeClickHouse, URL: "localhost:9999", User: "default", Database: "default"},
+	})
+	if err != nil {
+		t.Fatalf("NewPanelApp failed: %v", err)
+	}
+	defer app.Dispose()
+
+	result, err := app.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatalf("CheckHealth failed: %v", err)
+	}
+	if result.Status != backend.HealthStatusError {
+		t.Errorf("Expected error status while serving mock data, got %v", result.Status)
+	}
+
+	var details struct {
+		Mock bool `json:"mock"`
+	}
+	if err := json.Unmarshal(result.JSONDetails, &details); err != nil {
+		t.Fatalf("Failed to unmarshal details: %v", err)
+	}
+	if !details.Mock {
+		t.Error("Expected details to report mock data")
+	}
+}
+
+// appPluginContext builds the context Grafana sends an app plugin: the org, the signed-in
+// user and the app's saved settings, with secureJsonData already decrypted
+func appPluginContext(jsonData string, secure map[string]string, updated time.Time) backend.PluginContext {
+	return backend.PluginContext{
+		OrgID:         1,
+		PluginID:      ID,
+		PluginVersion: "1.0.61",
+		User:          &backend.User{Login: "admin", Name: "Admin", Role: "Admin"},
+		AppInstanceSettings: &backend.AppInstanceSettings{
+			JSONData:                []byte(jsonData),
+			DecryptedSecureJSONData: secure,
+			Updated:                 updated,
+		},
+	}
+}
+
+func TestServiceAppliesSavedSettings(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+	saved := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	pluginContext := appPluginContext(`{"mode":"mock"}`, nil, saved)
+	result, err := service.CheckHealth(ctx, &backend.CheckHealthRequest{PluginContext: pluginContext})
+	if err != nil {
+		t.Fatalf("CheckHealth failed: %v", err)
+	}
+	if result.Status != backend.HealthStatusOk || !strings.Contains(result.Message, "mock") {
+		t.Errorf("Expected the saved mock mode to be healthy, got %v: %s", result.Status, result.Message)
+	}
+
+	var status int
+	var headers map[string][]string
+	err = service.CallResource(ctx, &backend.CallResourceRequest{
+		PluginContext: pluginContext,
+		Path:          "query_logs",
+		Method:        http.MethodPost,
+		URL:           "query_logs",
+		Body: []byte(`{"org":"1","dashboard":"API","panel_title":"Errors","metric_name":"error_rate",
+			"start_time":"2025-01-15T11:00:00Z","end_time":"2025-01-15T12:00:00Z"}`),
+	}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+		status, headers = resp.Status, resp.Headers
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("CallResource failed: %v", err)
+	}
+	if status != http.StatusOK || len(headers["X-Hover-Mock-Data"]) == 0 {
+		t.Errorf("Expected marked mock data from the saved settings, got status %d and headers %v", status, headers)
+	}
+
+	// Saving new settings in Grafana bumps Updated, which replaces the instance
+	pluginContext = appPluginContext(`{"mode":"fail-closed","url":"127.0.0.1:1","tls":false}`,
+		map[string]string{"password": "secret"}, saved.Add(time.Minute))
+	result, err = service.CheckHealth(ctx, &backend.CheckHealthRequest{PluginContext: pluginContext})
+	if err != nil {
+		t.Fatalf("CheckHealth failed: %v", err)
+	}
+	if result.Status != backend.HealthStatusError || !strings.Contains(result.Message, "127.0.0.1:1") {
+		t.Errorf("Expected the new settings' unreachable URL to be reported, got %v: %s", result.Status, result.Message)
+	}
+}
+
+func TestMappingChangesNeedEditor(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+	pluginContext := appPluginContext(`{"mode":"mock"}`, nil, time.Now())
+
+	callResource := func(user *backend.User, method, url, body string) (int, []byte) {
+		t.Helper()
+		pluginContext.User = user
+		var status int
+		var respBody []byte
+		err := service.CallResource(ctx, &backend.CallResourceRequest{
+			PluginContext: pluginContext,
+			Path:          strings.SplitN(url, "?", 2)[0],
+			Method:        method,
+			URL:           url,
+			Body:          []byte(body),
+		}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+			status, respBody = resp.Status, resp.Body
+			return nil
+		}))
+		if err != nil {
+			t.Fatalf("CallResource failed: %v", err)
+		}
+		return status, respBody
+	}
+
+	viewer := &backend.User{Login: "viewer", Role: "Viewer"}
+	editor := &backend.User{Login: "editor", Role: "Editor"}
+	metric := `{"dashboard":"API","panel_title":"Errors","metric_name":"error_rate"}`
+
+	if status, _ := callResource(viewer, http.MethodGet, "mappings/log_streams", ""); status != http.StatusOK {
+		t.Errorf("Expected a Viewer to list log streams, got status %d", status)
+	}
+	if status, _ := callResource(viewer, http.MethodPost, "mappings/metrics", metric); status != http.StatusForbidden {
+		t.Errorf("Expected a Viewer's change to be forbidden, got status %d", status)
+	}
+	if status, _ := callResource(nil, http.MethodPost, "mappings/metrics", metric); status != http.StatusForbidden {
+		t.Errorf("Expected a change without a user to be forbidden, got status %d", status)
+	}
+
+	// The org comes from the plugin context, not the request
+	status, body := callResource(editor, http.MethodPost, "mappings/metrics?org=2", metric)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected an Editor to register a metric, got status %d: %s", status, body)
+	}
+	var registered struct {
+		OrgID string `json:"org_id"`
+	}
+	if err := json.Unmarshal(body, &registered); err != nil {
+		t.Fatalf("Failed to unmarshal metric: %v", err)
+	}
+	if registered.OrgID != "1" {
+		t.Errorf("Expected the metric registered in the caller's org 1, got %q", registered.OrgID)
+	}
+
+	_, body = callResource(viewer, http.MethodGet, "mappings/audit?org=2", "")
+	var audit struct {
+		Entries []struct {
+			Actor string `json:"actor"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(body, &audit); err != nil {
+		t.Fatalf("Failed to unmarshal audit log: %v", err)
+	}
+	if len(audit.Entries) != 1 || audit.Entries[0].Actor != "editor" {
+		t.Errorf("Expected org 1's audit log to show the editor's change, got %+v", audit.Entries)
+	}
+}
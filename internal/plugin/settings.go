@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Settings is the plugin configuration saved in Grafana. Connection details live in
+// jsonData; the password and CA certificate live in secureJsonData.
+type Settings struct {
+	// Mode is "fail-closed", "clickhouse" or "mock"
+	Mode          string `json:"mode"`
+	URL           string `json:"url"`
+	Database      string `json:"database"`
+	User          string `json:"user"`
+	TLS           bool   `json:"tls"`
+	TLSSkipVerify bool   `json:"tlsSkipVerify"`
+
+	// saved is true when jsonData holds any settings, so its switches override config.toml
+	// whether they are on or off
+	saved bool
+}
+
+// Keys read from secureJsonData
+const (
+	securePasswordKey  = "password"
+	secureTLSCACertKey = "tlsCACert"
+)
+
+// LoadSettings parses the plugin's jsonData and secureJsonData
+func LoadSettings(settings backend.AppInstanceSettings) (Settings, map[string]string, error) {
+	var s Settings
+	if len(settings.JSONData) > 0 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(settings.JSONData, &fields); err != nil {
+			return Settings{}, nil, fmt.Errorf("invalid plugin jsonData: %w", err)
+		}
+		if err := json.Unmarshal(settings.JSONData, &s); err != nil {
+			return Settings{}, nil, fmt.Errorf("invalid plugin jsonData: %w", err)
+		}
+		s.saved = len(fields) > 0
+	}
+	return s, settings.DecryptedSecureJSONData, nil
+}
+
+// Apply overlays the settings saved in Grafana onto cfg. Text fields left empty in Grafana
+// keep the value from config.toml (or its default), so existing file-based setups keep
+// working. The TLS switches have no empty value: once any jsonData is saved they apply as
+// they are, so they can be turned off as well as on.
+func (s Settings) Apply(cfg *config.Config, secure map[string]string) {
+	if s.Mode != "" {
+		cfg.ClickHouse.Mode = config.StoreMode(s.Mode)
+	}
+	if s.URL != "" {
+		cfg.ClickHouse.URL = s.URL
+	}
+	if s.Database != "" {
+		cfg.ClickHouse.Database = s.Database
+	}
+	if s.User != "" {
+		cfg.ClickHouse.User = s.User
+	}
+	if s.saved {
+		cfg.ClickHouse.TLS = s.TLS
+		cfg.ClickHouse.TLSSkipVerify = s.TLSSkipVerify
+	}
+	if password, ok := secure[securePasswordKey]; ok {
+		cfg.ClickHouse.Password = password
+	}
+	if caCert, ok := secure[secureTLSCACertKey]; ok {
+		cfg.ClickHouse.TLSCACert = caCert
+	}
+}
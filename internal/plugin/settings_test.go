@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestLoadSettings(t *testing.T) {
+	settings, secure, err := LoadSettings(backend.AppInstanceSettings{
+		JSONData:                []byte(`{"mode":"fail-closed","url":"clickhouse:9440","database":"logs","user":"grafana","tls":true,"tlsSkipVerify":true}`),
+		DecryptedSecureJSONData: map[string]string{"password": "secret"},
+	})
+	if err != nil {
+		t.Fatalf("LoadSettings failed: %v", err)
+	}
+
+	cfg := &config.Config{ClickHouse: config.ClickHouseConfig{URL: "localhost:9000", Database: "default", User: "default"}}
+	settings.Apply(cfg, secure)
+
+	want := config.ClickHouseConfig{
+		Mode:          config.StoreModeFailClosed,
+		URL:           "clickhouse:9440",
+		Database:      "logs",
+		User:          "grafana",
+		Password:      "secret",
+		TLS:           true,
+		TLSSkipVerify: true,
+	}
+	if cfg.ClickHouse != want {
+		t.Errorf("Expected %+v, got %+v", want, cfg.ClickHouse)
+	}
+}
+
+func TestLoadSettingsKeepsFileConfig(t *testing.T) {
+	// An app that was enabled but never configured has no jsonData, or an empty object
+	for _, jsonData := range []string{"", "{}"} {
+		settings, secure, err := LoadSettings(backend.AppInstanceSettings{JSONData: []byte(jsonData)})
+		if err != nil {
+			t.Fatalf("LoadSettings failed: %v", err)
+		}
+
+		file := config.ClickHouseConfig{URL: "localhost:9000", Database: "default", User: "default", Password: "from-file", TLS: true}
+		cfg := &config.Config{ClickHouse: file}
+		settings.Apply(cfg, secure)
+
+		if cfg.ClickHouse != file {
+			t.Errorf("Expected jsonData %q to keep %+v, got %+v", jsonData, file, cfg.ClickHouse)
+		}
+	}
+}
+
+func TestLoadSettingsInvalidJSON(t *testing.T) {
+	if _, _, err := LoadSettings(backend.AppInstanceSettings{JSONData: []byte(`{"url":`)}); err == nil {
+		t.Error("Expected malformed jsonData to be rejected")
+	}
+}
+
+func TestLoadSettingsTurnsTLSOff(t *testing.T) {
+	settings, secure, err := LoadSettings(backend.AppInstanceSettings{
+		JSONData: []byte(`{"url":"clickhouse:9000","tls":false,"tlsSkipVerify":false}`),
+	})
+	if err != nil {
+		t.Fatalf("LoadSettings failed: %v", err)
+	}
+
+	cfg := &config.Config{ClickHouse: config.ClickHouseConfig{URL: "localhost:9440", TLS: true, TLSSkipVerify: true}}
+	settings.Apply(cfg, secure)
+	if cfg.ClickHouse.TLS || cfg.ClickHouse.TLSSkipVerify {
+		t.Errorf("Expected saved settings to turn off TLS enabled in config.toml, got %+v", cfg.ClickHouse)
+	}
+}
@@ -0,0 +1,158 @@
+// Package tracing records OpenTelemetry spans for the handler, analyzer and store. Spans go to
+// the global tracer provider: inside Grafana the plugin's main installs one with the SDK's
+// backend.SetupTracer, exporting to Grafana's configured collector, and the standalone
+// server installs its own with Setup.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/StandardRunbook/grafana-hover-plugin"
+
+// Exporters selectable with config.TracingConfig.Exporter
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+// Attribute keys shared by every span that describes a query
+const (
+	OrgKey          = attribute.Key("hover.org")
+	DashboardKey    = attribute.Key("hover.dashboard")
+	PanelKey        = attribute.Key("hover.panel")
+	MetricKey       = attribute.Key("hover.metric")
+	WindowStartKey  = attribute.Key("hover.window.start")
+	WindowEndKey    = attribute.Key("hover.window.end")
+	WindowLengthKey = attribute.Key("hover.window.length_seconds")
+	// WindowRoleKey tells baseline queries apart from current window queries
+	WindowRoleKey    = attribute.Key("hover.window.role")
+	TemplateCountKey = attribute.Key("hover.template_count")
+)
+
+// Window roles for WindowRoleKey
+const (
+	RoleBaseline = "baseline"
+	RoleCurrent  = "current"
+)
+
+// QueryAttributes describes the panel and window a query is for
+func QueryAttributes(org, dashboard, panelTitle, metricName string, start, end time.Time) []attribute.KeyValue {
+	return append(PanelAttributes(org, dashboard, panelTitle, metricName), WindowAttributes(start, end)...)
+}
+
+// PanelAttributes describes the panel a query is for
+func PanelAttributes(org, dashboard, panelTitle, metricName string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		OrgKey.String(org),
+		DashboardKey.String(dashboard),
+		PanelKey.String(panelTitle),
+		MetricKey.String(metricName),
+	}
+}
+
+// WindowAttributes describes a time window
+func WindowAttributes(start, end time.Time) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		WindowStartKey.String(start.UTC().Format(time.RFC3339)),
+		WindowEndKey.String(end.UTC().Format(time.RFC3339)),
+		WindowLengthKey.Float64(end.Sub(start).Seconds()),
+	}
+}
+
+type contextAttributesKey struct{}
+
+// WithAttributes returns a context whose spans, started with Start, all carry attrs. It lets
+// a caller label the spans of code it calls into, such as which window a store query is for.
+func WithAttributes(ctx context.Context, attrs ...attribute.KeyValue) context.Context {
+	inherited, _ := ctx.Value(contextAttributesKey{}).([]attribute.KeyValue)
+	merged := make([]attribute.KeyValue, 0, len(inherited)+len(attrs))
+	merged = append(append(merged, inherited...), attrs...)
+	return context.WithValue(ctx, contextAttributesKey{}, merged)
+}
+
+// Start starts a span named name with attrs plus any added to ctx with WithAttributes
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if inherited, ok := ctx.Value(contextAttributesKey{}).([]attribute.KeyValue); ok {
+		attrs = append(append([]attribute.KeyValue{}, inherited...), attrs...)
+	}
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartRequest starts a server span for r, continuing the trace of the caller if r carries one
+func StartRequest(r *http.Request, name string) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("http.request.method", r.Method), attribute.String("url.path", r.URL.Path)))
+	return r.WithContext(ctx), span
+}
+
+// Fail records err on span and marks it failed
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// End records err on span, if there is one, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		Fail(span, err)
+	}
+	span.End()
+}
+
+// Setup installs a global tracer provider exporting as configured, for the standalone server.
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		var err error
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q (expected %s or %s)", cfg.Exporter, ExporterNone, ExporterOTLP)
+	}
+
+	provider := NewProvider(exporter, cfg)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// NewProvider creates a tracer provider that batches spans to exporter, sampling
+// cfg.SampleRatio of new traces and following the caller's decision for the rest
+func NewProvider(exporter sdktrace.SpanExporter, cfg config.TracingConfig) *sdktrace.TracerProvider {
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "hover"
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+}
@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in memory
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func attributeValue(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestStartInheritsContextAttributes(t *testing.T) {
+	exporter := recordSpans(t)
+
+	ctx := WithAttributes(context.Background(), OrgKey.String("acme"))
+	ctx = WithAttributes(ctx, WindowRoleKey.String(RoleBaseline))
+	_, span := Start(ctx, "child", MetricKey.String("cpu"))
+	End(span, nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	for key, want := range map[attribute.Key]string{OrgKey: "acme", WindowRoleKey: RoleBaseline, MetricKey: "cpu"} {
+		if got, ok := attributeValue(spans[0], key); !ok || got.AsString() != want {
+			t.Errorf("Expected %s=%s, got %v", key, want, got.AsString())
+		}
+	}
+	if spans[0].Status.Code == codes.Error {
+		t.Error("Expected a successful span not to be marked failed")
+	}
+}
+
+func TestEndRecordsError(t *testing.T) {
+	exporter := recordSpans(t)
+
+	_, span := Start(context.Background(), "failing")
+	End(span, errors.New("connection refused"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Status.Code != codes.Error || spans[0].Status.Description != "connection refused" {
+		t.Errorf("Expected an error status, got %+v", spans[0].Status)
+	}
+	if len(spans[0].Events) != 1 || spans[0].Events[0].Name != "exception" {
+		t.Errorf("Expected the error to be recorded as an exception event, got %+v", spans[0].Events)
+	}
+}
+
+func TestStartRequestContinuesCallerTrace(t *testing.T) {
+	exporter := recordSpans(t)
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	r := httptest.NewRequest(http.MethodPost, "/analyze", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := StartRequest(r, "Handler.QueryLogs")
+	span.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	if got := spans[0].SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the caller's trace ID, got %s", got)
+	}
+	if got := spans[0].Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("Expected the caller's span as parent, got %s", got)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		exporter string
+		wantErr  bool
+	}{
+		{"", false},
+		{ExporterNone, false},
+		{"jaeger", true},
+	}
+	for _, tt := range tests {
+		shutdown, err := Setup(context.Background(), config.TracingConfig{Exporter: tt.exporter})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Setup(%q): expected error %v, got %v", tt.exporter, tt.wantErr, err)
+			continue
+		}
+		if err == nil {
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("Setup(%q): shutdown failed: %v", tt.exporter, err)
+			}
+		}
+	}
+}
+
+func TestNewProviderSampleRatio(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := NewProvider(exporter, config.TracingConfig{SampleRatio: 0})
+	_, span := provider.Tracer("test").Start(context.Background(), "unsampled")
+	span.End()
+	provider.Shutdown(context.Background())
+
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("Expected a sample ratio of 0 to record no spans, got %d", len(spans))
+	}
+}
@@ -1,31 +1,41 @@
 package main
 
 import (
-	"context"
+	"log/slog"
 	"os"
 
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/config"
+	"github.com/StandardRunbook/grafana-hover-plugin/internal/logging"
 	"github.com/StandardRunbook/grafana-hover-plugin/internal/plugin"
 
-	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 )
 
 func main() {
-	log.DefaultLogger.Info("Starting hover-hover-panel backend")
+	// Send slog output through Grafana's logger; instances apply the configured level
+	logger, _ := logging.NewSDKLogger(log.DefaultLogger, config.LoggingConfig{})
+	slog.SetDefault(logger)
+	slog.Info("Starting backend", "plugin", plugin.ID)
 
-	// Create a single global instance for panel plugin
-	app, err := plugin.NewPanelApp(context.Background())
-	if err != nil {
-		log.DefaultLogger.Error("Failed to create plugin", "error", err)
-		os.Exit(1)
+	// Export spans to the collector set in Grafana's [tracing.opentelemetry] settings, which
+	// Grafana passes to the plugin's environment. Without it spans go to a no-op provider.
+	backend.SetupPluginEnvironment(plugin.ID)
+	if err := backend.SetupTracer(plugin.ID, tracing.Opts{}); err != nil {
+		slog.Warn("Failed to set up tracing, spans will not be exported", "error", err)
 	}
 
-	// Start listening to requests sent from Grafana
-	// For panel plugins with backend, use datasource.Serve
-	if err := datasource.Serve(datasource.ServeOpts{
-		CallResourceHandler: app,
+	// Instances are created per plugin settings and recreated when they change
+	service := plugin.NewService()
+
+	// Start listening to requests sent from Grafana. Manage, unlike Serve, continues the
+	// traces Grafana sends with each request and flushes spans on exit.
+	if err := backend.Manage(plugin.ID, backend.ServeOpts{
+		CallResourceHandler: service,
+		CheckHealthHandler:  service,
 	}); err != nil {
-		log.DefaultLogger.Error(err.Error())
+		slog.Error("Plugin backend failed", "error", err)
 		os.Exit(1)
 	}
 }
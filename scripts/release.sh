@@ -7,8 +7,9 @@
 set -e
 
 VERSION=$1
-PLUGIN_ID="hover-hover-panel"
-PLUGIN_NAME="hover-hover-panel"
+PLUGIN_ID="hover-hover-app"
+# The backend executable kept the name it had before the panel moved into the app
+EXECUTABLE="gpx_hover-hover-panel"
 REPO="StandardRunbook/grafana-hover-plugin"
 
 # Colors for output
@@ -38,12 +39,13 @@ echo
 echo -e "${YELLOW}📝 Updating version to ${VERSION}...${NC}"
 sed -i.bak "s/\"version\": \".*\"/\"version\": \"${VERSION}\"/" package.json
 sed -i.bak "s/\"version\": \".*\"/\"version\": \"${VERSION}\"/" src/plugin.json
+sed -i.bak "s/\"version\": \".*\"/\"version\": \"${VERSION}\"/" src/panels/hover/plugin.json
 # Note: go_plugin_build_manifest is regenerated by mage build, no need to update version
-rm -f package.json.bak src/plugin.json.bak
+rm -f package.json.bak src/plugin.json.bak src/panels/hover/plugin.json.bak
 
 # Step 2: Commit version changes BEFORE building
 echo -e "${YELLOW}💾 Committing version update...${NC}"
-git add package.json src/plugin.json
+git add package.json src/plugin.json src/panels/hover/plugin.json
 git commit -m "Bump version to ${VERSION}" || echo "No version changes to commit"
 
 # Step 3: Build backend with mage (AFTER committing version)
@@ -60,7 +62,7 @@ go run github.com/magefile/mage@latest build:generateManifestFile
 
 # Step 6: Set executable permissions on binaries
 echo -e "${YELLOW}🔐 Setting executable permissions...${NC}"
-chmod +x dist/gpx_${PLUGIN_NAME}_*
+chmod +x dist/${EXECUTABLE}_*
 
 # Step 7: Sign the plugin
 echo -e "${YELLOW}✍️  Signing plugin...${NC}"
@@ -69,19 +71,19 @@ npx --yes @grafana/sign-plugin@latest --rootUrls http://localhost:3000
 # Step 8: Create plugin package
 echo -e "${YELLOW}📦 Creating plugin package...${NC}"
 rm -rf tmp-package
-mkdir -p tmp-package/${PLUGIN_NAME}
-cp -r dist/* tmp-package/${PLUGIN_NAME}/
+mkdir -p tmp-package/${PLUGIN_ID}
+cp -r dist/* tmp-package/${PLUGIN_ID}/
 cd tmp-package
-zip -r ../${PLUGIN_NAME}-${VERSION}.zip ${PLUGIN_NAME}
+zip -r ../${PLUGIN_ID}-${VERSION}.zip ${PLUGIN_ID}
 cd ..
 rm -rf tmp-package
 
 # Step 9: Generate checksums
 echo -e "${YELLOW}🔒 Generating checksums...${NC}"
-MD5=$(md5sum ${PLUGIN_NAME}-${VERSION}.zip | awk '{print $1}')
-SHA256=$(shasum -a 256 ${PLUGIN_NAME}-${VERSION}.zip | awk '{print $1}')
-echo "$MD5" > ${PLUGIN_NAME}-${VERSION}.zip.md5
-echo "$SHA256" > ${PLUGIN_NAME}-${VERSION}.zip.sha256
+MD5=$(md5sum ${PLUGIN_ID}-${VERSION}.zip | awk '{print $1}')
+SHA256=$(shasum -a 256 ${PLUGIN_ID}-${VERSION}.zip | awk '{print $1}')
+echo "$MD5" > ${PLUGIN_ID}-${VERSION}.zip.md5
+echo "$SHA256" > ${PLUGIN_ID}-${VERSION}.zip.sha256
 
 # Step 10: Tag the release commit
 echo -e "${YELLOW}🏷️  Creating git tag...${NC}"
@@ -99,9 +101,9 @@ git push && git push --tags
 echo -e "${YELLOW}🎉 Creating GitHub release...${NC}"
 gh release create v${VERSION} \
   --target main \
-  ${PLUGIN_NAME}-${VERSION}.zip \
-  ${PLUGIN_NAME}-${VERSION}.zip.md5 \
-  ${PLUGIN_NAME}-${VERSION}.zip.sha256 \
+  ${PLUGIN_ID}-${VERSION}.zip \
+  ${PLUGIN_ID}-${VERSION}.zip.md5 \
+  ${PLUGIN_ID}-${VERSION}.zip.sha256 \
   --title "Hover Panel v${VERSION}" \
   --notes "## Hover Panel v${VERSION}
 
@@ -110,7 +112,7 @@ Grafana plugin for automatic log correlation on hover.
 ### 📦 Installation
 
 \`\`\`bash
-unzip ${PLUGIN_NAME}-${VERSION}.zip -d /var/lib/grafana/plugins/
+unzip ${PLUGIN_ID}-${VERSION}.zip -d /var/lib/grafana/plugins/
 \`\`\`
 
 ### 🔐 Checksums
@@ -135,7 +137,7 @@ echo
 echo "Plugin ID: ${PLUGIN_ID}"
 echo
 echo "Download URL:"
-echo "https://github.com/${REPO}/releases/download/v${VERSION}/${PLUGIN_NAME}-${VERSION}.zip"
+echo "https://github.com/${REPO}/releases/download/v${VERSION}/${PLUGIN_ID}-${VERSION}.zip"
 echo
 echo "MD5 Checksum:"
 echo "${MD5}"
@@ -149,15 +151,15 @@ echo
 
 # Step 11: Run validator before cleanup
 echo -e "${YELLOW}🔍 Running validator...${NC}"
-gh release download v${VERSION} --pattern "${PLUGIN_NAME}-${VERSION}.zip" --clobber
-npx --yes @grafana/plugin-validator@latest -sourceCodeUri https://github.com/${REPO}/tree/v${VERSION} ${PLUGIN_NAME}-${VERSION}.zip || true
+gh release download v${VERSION} --pattern "${PLUGIN_ID}-${VERSION}.zip" --clobber
+npx --yes @grafana/plugin-validator@latest -sourceCodeUri https://github.com/${REPO}/tree/v${VERSION} ${PLUGIN_ID}-${VERSION}.zip || true
 echo
 
 # Step 12: Cleanup release artifacts
 echo -e "${YELLOW}🧹 Cleaning up release artifacts...${NC}"
-rm -f ${PLUGIN_NAME}-${VERSION}.zip
-rm -f ${PLUGIN_NAME}-${VERSION}.zip.md5
-rm -f ${PLUGIN_NAME}-${VERSION}.zip.sha256
+rm -f ${PLUGIN_ID}-${VERSION}.zip
+rm -f ${PLUGIN_ID}-${VERSION}.zip.md5
+rm -f ${PLUGIN_ID}-${VERSION}.zip.sha256
 
 echo -e "${GREEN}✨ Cleanup complete!${NC}"
 echo
@@ -31,15 +31,15 @@ const filesToUpdate = [
       return JSON.stringify(pkg, null, 2) + '\n';
     }
   },
-  {
-    path: 'src/plugin.json',
+  ...['src/plugin.json', 'src/panels/hover/plugin.json'].map((pluginPath) => ({
+    path: pluginPath,
     update: (content) => {
       const plugin = JSON.parse(content);
       plugin.info.version = newVersion;
       plugin.info.updated = new Date().toISOString().split('T')[0];
       return JSON.stringify(plugin, null, 2) + '\n';
     }
-  },
+  })),
   {
     path: 'config.json',
     update: (content) => {
@@ -47,7 +47,7 @@ const filesToUpdate = [
       config.plugin.version = newVersion;
       config.build.version = newVersion;
       config.build.buildDate = new Date().toISOString();
-      config.files.plugin = `hover-hover-panel-${newVersion}.zip`;
+      config.files.plugin = `hover-hover-app-${newVersion}.zip`;
       return JSON.stringify(config, null, 2) + '\n';
     }
   }
@@ -78,5 +78,5 @@ filesToUpdate.forEach(({ path: filePath, update }) => {
 console.log(`\nVersion update complete! Updated ${updatedFiles} files.`);
 console.log(`\nNext steps:`);
 console.log(`1. Build the plugin: npm run build`);
-console.log(`2. Create zip file: zip -r hover-hover-panel-${newVersion}.zip dist/`);
-console.log(`3. Create GitHub release: gh release create v${newVersion} hover-hover-panel-${newVersion}.zip`);
+console.log(`2. Create zip file: zip -r hover-hover-app-${newVersion}.zip dist/`);
+console.log(`3. Create GitHub release: gh release create v${newVersion} hover-hover-app-${newVersion}.zip`);
@@ -69,6 +69,10 @@ export const HoverTrackerPanel: React.FC<Props> = (props) => {
         // org: Get the Grafana organization ID and convert to string
         const orgId = String(grafanaConfig.bootData?.user?.orgId || 1);
 
+        // The hovered series' values, so streams suggested for an unmapped metric can be
+        // correlated with it
+        const metricSeries = extractMetricSeries(metricData, startTime, endTime);
+
         const payload = {
           org: orgId,
           dashboard: dashboardName,
@@ -76,11 +80,12 @@ export const HoverTrackerPanel: React.FC<Props> = (props) => {
           metric_name: metricName,
           start_time: startTime.toISOString(),
           end_time: endTime.toISOString(),
+          ...(metricSeries.length > 0 && { metric_series: metricSeries }),
         };
 
         // Call backend plugin resource endpoint
         const result = await getBackendSrv().post(
-          `/api/plugins/hover-hover-panel/resources/query_logs`,
+          `/api/plugins/hover-hover-app/resources/query_logs`,
           payload
         );
 
@@ -92,6 +97,13 @@ export const HoverTrackerPanel: React.FC<Props> = (props) => {
           const MAX_LOG_LENGTH = options.maxLogLength;
           let totalLogs = 0;
 
+          // The backend is serving sample data, not logs from ClickHouse
+          if (result.mock) {
+            formattedLogs.push(
+              "⚠️ Mock data: ClickHouse is not connected, so these are sample logs, not yours"
+            );
+          }
+
           result.log_groups.forEach((group: any, index: number) => {
             if (totalLogs >= MAX_LOGS) {
               return; // Stop processing if we hit the limit
@@ -439,6 +451,55 @@ export const HoverTrackerPanel: React.FC<Props> = (props) => {
   );
 };
 
+// The backend accepts at most this many metric points per request
+const MAX_METRIC_SERIES_POINTS = 200;
+
+// extractMetricSeries returns the hovered field's values between start and end from its
+// data frame, in time order, thinned out evenly to at most MAX_METRIC_SERIES_POINTS
+const extractMetricSeries = (
+  metricData: HoverEvent["metricData"],
+  start: Date,
+  end: Date
+): Array<{ time: string; value: number }> => {
+  const fields = metricData?.dataFrame?.fields;
+  if (!Array.isArray(fields)) {
+    return [];
+  }
+  const timeField = fields.find((f: any) => f.type === "time");
+  const valueField =
+    fields[metricData?.fieldIndex ?? -1] ??
+    fields.find((f: any) => f.type !== "time");
+  if (!timeField?.values || !valueField?.values || valueField === timeField) {
+    return [];
+  }
+
+  const points: Array<{ time: string; value: number }> = [];
+  let last = -Infinity;
+  for (let i = 0; i < timeField.values.length; i++) {
+    const time = Number(timeField.values[i]);
+    const value = Number(valueField.values[i]);
+    if (
+      time < start.getTime() ||
+      time >= end.getTime() ||
+      time <= last ||
+      !Number.isFinite(value)
+    ) {
+      continue;
+    }
+    points.push({ time: new Date(time).toISOString(), value });
+    last = time;
+  }
+
+  if (points.length <= MAX_METRIC_SERIES_POINTS) {
+    return points;
+  }
+  const stride = points.length / MAX_METRIC_SERIES_POINTS;
+  return Array.from(
+    { length: MAX_METRIC_SERIES_POINTS },
+    (_, i) => points[Math.floor(i * stride)]
+  );
+};
+
 const getStyles = () => {
   return {
     wrapper: css`
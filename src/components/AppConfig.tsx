@@ -0,0 +1,235 @@
+import React, { ChangeEvent, useState } from "react";
+import {
+  AppPluginMeta,
+  GrafanaTheme2,
+  PluginConfigPageProps,
+  PluginMeta,
+} from "@grafana/data";
+import { getBackendSrv } from "@grafana/runtime";
+import {
+  Alert,
+  Button,
+  Field,
+  FieldSet,
+  Input,
+  RadioButtonGroup,
+  SecretInput,
+  SecretTextArea,
+  Switch,
+  useStyles2,
+} from "@grafana/ui";
+import { css } from "@emotion/css";
+
+// AppSettings is the plugin's jsonData; the backend reads it in internal/plugin/settings.go
+export type AppSettings = {
+  mode?: "clickhouse" | "mock" | "fail-closed";
+  url?: string;
+  database?: string;
+  user?: string;
+  tls?: boolean;
+  tlsSkipVerify?: boolean;
+};
+
+type SecureSettings = {
+  password?: string;
+  tlsCACert?: string;
+};
+
+type HealthResult = {
+  status: string;
+  message: string;
+};
+
+const modeOptions = [
+  { label: "ClickHouse", value: "clickhouse" as const },
+  { label: "Fail closed", value: "fail-closed" as const },
+  { label: "Mock data", value: "mock" as const },
+];
+
+export interface AppConfigProps
+  extends PluginConfigPageProps<AppPluginMeta<AppSettings>> {}
+
+// AppConfig lets admins set the ClickHouse connection the backend queries. The password and
+// CA certificate are saved to secureJsonData, which Grafana encrypts and never sends back.
+export const AppConfig = ({ plugin }: AppConfigProps) => {
+  const styles = useStyles2(getStyles);
+  const { enabled, pinned, jsonData, secureJsonFields } = plugin.meta;
+
+  const [settings, setSettings] = useState<AppSettings>({
+    mode: jsonData?.mode ?? "fail-closed",
+    url: jsonData?.url ?? "",
+    database: jsonData?.database ?? "",
+    user: jsonData?.user ?? "",
+    tls: jsonData?.tls ?? false,
+    tlsSkipVerify: jsonData?.tlsSkipVerify ?? false,
+  });
+  const [secure, setSecure] = useState<SecureSettings>({});
+  const [configured, setConfigured] = useState({
+    password: Boolean(secureJsonFields?.password),
+    tlsCACert: Boolean(secureJsonFields?.tlsCACert),
+  });
+  const [saving, setSaving] = useState(false);
+  const [health, setHealth] = useState<HealthResult | null>(null);
+
+  const onChange =
+    (key: "url" | "database" | "user") =>
+    (event: ChangeEvent<HTMLInputElement>) =>
+      setSettings({ ...settings, [key]: event.target.value.trim() });
+
+  const onSave = async () => {
+    setSaving(true);
+    setHealth(null);
+    try {
+      await updatePlugin(plugin.meta.id, {
+        enabled: true,
+        pinned,
+        jsonData: settings,
+        // Only send secrets that changed; omitted keys keep their saved value
+        secureJsonData: secure,
+      });
+      setConfigured({
+        password: configured.password || Boolean(secure.password),
+        tlsCACert: configured.tlsCACert || Boolean(secure.tlsCACert),
+      });
+      setSecure({});
+      // Saving recreates the backend instance, so this checks the new settings
+      setHealth(
+        await getBackendSrv().get(`/api/plugins/${plugin.meta.id}/health`)
+      );
+    } catch (err: any) {
+      setHealth({
+        status: "ERROR",
+        message: err?.data?.message ?? err?.message ?? String(err),
+      });
+    } finally {
+      setSaving(false);
+    }
+  };
+
+  return (
+    <div>
+      {!enabled && (
+        <Alert severity="info" title="The Hover app is disabled">
+          Saving the connection below enables it, which the Hover panel needs
+          to query logs.
+        </Alert>
+      )}
+
+      <FieldSet label="ClickHouse connection">
+        <Field
+          label="Mode"
+          description="Fail closed returns errors while ClickHouse is unreachable. Mock data serves sample logs, for demos only."
+        >
+          <RadioButtonGroup
+            options={modeOptions}
+            value={settings.mode}
+            onChange={(mode) => setSettings({ ...settings, mode })}
+          />
+        </Field>
+        <Field label="URL" description="Native protocol address (host:port)">
+          <Input
+            width={60}
+            value={settings.url}
+            placeholder="clickhouse:9000"
+            onChange={onChange("url")}
+          />
+        </Field>
+        <Field label="Database">
+          <Input
+            width={60}
+            value={settings.database}
+            placeholder="default"
+            onChange={onChange("database")}
+          />
+        </Field>
+        <Field label="User">
+          <Input
+            width={60}
+            value={settings.user}
+            placeholder="default"
+            onChange={onChange("user")}
+          />
+        </Field>
+        <Field label="Password">
+          <SecretInput
+            width={60}
+            isConfigured={configured.password}
+            value={secure.password ?? ""}
+            onChange={(event: ChangeEvent<HTMLInputElement>) =>
+              setSecure({ ...secure, password: event.target.value })
+            }
+            onReset={() => {
+              setConfigured({ ...configured, password: false });
+              setSecure({ ...secure, password: "" });
+            }}
+          />
+        </Field>
+      </FieldSet>
+
+      <FieldSet label="TLS">
+        <Field label="Connect over TLS">
+          <Switch
+            value={settings.tls}
+            onChange={(event) =>
+              setSettings({ ...settings, tls: event.currentTarget.checked })
+            }
+          />
+        </Field>
+        <Field
+          label="Skip certificate verification"
+          description="Accept any server certificate. Only for testing."
+        >
+          <Switch
+            value={settings.tlsSkipVerify}
+            disabled={!settings.tls}
+            onChange={(event) =>
+              setSettings({
+                ...settings,
+                tlsSkipVerify: event.currentTarget.checked,
+              })
+            }
+          />
+        </Field>
+        <Field label="CA certificate" description="PEM certificate used to verify the server">
+          <SecretTextArea
+            cols={60}
+            rows={5}
+            isConfigured={configured.tlsCACert}
+            value={secure.tlsCACert ?? ""}
+            onChange={(event: ChangeEvent<HTMLTextAreaElement>) =>
+              setSecure({ ...secure, tlsCACert: event.target.value })
+            }
+            onReset={() => {
+              setConfigured({ ...configured, tlsCACert: false });
+              setSecure({ ...secure, tlsCACert: "" });
+            }}
+          />
+        </Field>
+      </FieldSet>
+
+      <div className={styles.actions}>
+        <Button onClick={onSave} disabled={saving}>
+          {saving ? "Saving..." : "Save & test"}
+        </Button>
+      </div>
+
+      {health && (
+        <Alert
+          severity={health.status === "OK" ? "success" : "error"}
+          title={health.status === "OK" ? "Connected" : "Connection failed"}
+        >
+          {health.message}
+        </Alert>
+      )}
+    </div>
+  );
+};
+
+const updatePlugin = (pluginId: string, data: Partial<PluginMeta>) =>
+  getBackendSrv().post(`/api/plugins/${pluginId}/settings`, data);
+
+const getStyles = (theme: GrafanaTheme2) => ({
+  actions: css`
+    margin-bottom: ${theme.spacing(2)};
+  `,
+});
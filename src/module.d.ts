@@ -1,3 +1,3 @@
-import { PanelPlugin } from "@grafana/data";
-import { SimpleOptions } from "./types";
-export declare const plugin: PanelPlugin<SimpleOptions, any>;
+import { AppPlugin } from "@grafana/data";
+import { AppSettings } from "./components/AppConfig";
+export declare const plugin: AppPlugin<AppSettings>;
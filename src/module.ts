@@ -1,11 +1,11 @@
-import { PanelPlugin } from "@grafana/data";
-import { SimpleOptions } from "./types";
-import { HoverTrackerPanel } from "./HoverTrackerPanel";
-import { addStandardOptions } from "./HoverTrackerEditor";
+import { AppPlugin } from "@grafana/data";
+import { AppConfig, AppSettings } from "./components/AppConfig";
 
-export const plugin = new PanelPlugin<SimpleOptions>(
-  HoverTrackerPanel
-).setPanelOptions((builder) => {
-  addStandardOptions(builder);
-  return builder;
+// The app carries the backend and its connection settings; the Hover panel is bundled
+// with it as a nested plugin (panels/hover)
+export const plugin = new AppPlugin<AppSettings>().addConfigPage({
+  title: "Configuration",
+  icon: "cog",
+  body: AppConfig,
+  id: "configuration",
 });
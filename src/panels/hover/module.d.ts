@@ -0,0 +1,3 @@
+import { PanelPlugin } from "@grafana/data";
+import { SimpleOptions } from "../../types";
+export declare const plugin: PanelPlugin<SimpleOptions, any>;